@@ -11,7 +11,12 @@ var (
 	HTTPPort      string // 业务端口
 	AdminPort     string // 管理端口
 	InternalPort  string // 内部端口（固定）
+	SSHPort       string // SSH Git 端口（默认为空，不启用）
+	CgroupRoot    string // 沙箱 cgroup v2 子树根目录（为空时不启用）
+	SandboxUID    string // 隔离模式下沙箱在宿主机上的 uid/gid（PotStack 以 root 运行时）
+	DockerSocket  string // Docker Engine API 的 unix socket
 	PotStackToken string // 鉴权令牌
+	SecretKey     string // 密钥库主密钥（base64 编码的 32 字节，为空时使用自动生成的密钥文件）
 )
 
 // 派生路径（基于 DataDir）
@@ -22,6 +27,7 @@ var (
 	KeyFile     string // $DATA_DIR/certs/key.pem
 	HTTPSConfig string // $DATA_DIR/https.yaml
 	RepoDir     string // $DATA_DIR/repo/ (仓库根目录)
+	SSHHostKey  string // $DATA_DIR/ssh/host_ed25519_key
 )
 
 func init() {
@@ -29,7 +35,12 @@ func init() {
 	HTTPPort = getEnv("POTSTACK_HTTP_PORT", "61080")
 	AdminPort = getEnv("POTSTACK_ADMIN_PORT", "61081")
 	InternalPort = "61082" // 固定值
+	SSHPort = getEnv("POTSTACK_SSH_PORT", "")
+	CgroupRoot = getEnv("POTSTACK_CGROUP_ROOT", "/sys/fs/cgroup/potstack")
+	SandboxUID = getEnv("POTSTACK_SANDBOX_UID", "100000")
+	DockerSocket = getEnv("POTSTACK_DOCKER_SOCKET", "/var/run/docker.sock")
 	PotStackToken = os.Getenv("POTSTACK_TOKEN")
+	SecretKey = os.Getenv("POTSTACK_SECRET_KEY")
 
 	// 派生路径
 	LogFile = filepath.Join(DataDir, "log", "potstack.log")
@@ -38,6 +49,7 @@ func init() {
 	KeyFile = filepath.Join(CertsDir, "key.pem")
 	HTTPSConfig = filepath.Join(DataDir, "https.yaml")
 	RepoDir = filepath.Join(DataDir, "repo")
+	SSHHostKey = filepath.Join(DataDir, "ssh", "host_ed25519_key")
 }
 
 func getEnv(key, defaultValue string) string {
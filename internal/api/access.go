@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"potstack/internal/auth"
+	"potstack/internal/db"
+	"potstack/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeUser 校验调用者为 username 本人或拥有 admin 权限，失败时已写入响应
+func authorizeUser(c *gin.Context, username string) bool {
+	if auth.HasScope(c, auth.ScopeAdmin) {
+		return true
+	}
+	if user := auth.CurrentUser(c); user != nil && user.Username == username {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+	return false
+}
+
+// authorizeRepo 校验调用者对仓库至少拥有 need 权限（read/write/admin），失败时已中止请求
+func (s *Server) authorizeRepo(c *gin.Context, owner, repoName, need string) bool {
+	if auth.HasScope(c, auth.ScopeAdmin) {
+		return true
+	}
+
+	user := auth.CurrentUser(c)
+	if user == nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return false
+	}
+
+	perm, err := s.repoService.GetPermission(c.Request.Context(), owner, repoName, user.Username)
+	if err != nil {
+		if errors.Is(err, service.ErrRepoNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "repository not found"})
+		} else {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return false
+	}
+
+	if !db.PermissionAllows(perm, need) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return false
+	}
+	return true
+}
@@ -1,24 +1,57 @@
 package api_test
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"potstack/config"
 	"potstack/internal/api"
 	"potstack/internal/db"
+	"potstack/internal/docker/dockertest"
+	"potstack/internal/git"
+	"potstack/internal/keeper"
+	"potstack/internal/models"
+	"potstack/internal/secret"
 	"potstack/internal/service"
+	"potstack/internal/sshd"
+	"potstack/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/glebarez/go-sqlite" // SQLite 驱动
+	gogit "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
 )
 
+// testToken 测试使用的系统令牌
+const testToken = "test-token"
+
 func setupTestDB(t *testing.T, baseDir string) {
 	// 创建系统仓库目录结构（数据库需要这个路径存在）
 	repoDir := filepath.Join(baseDir, "repo")
@@ -30,39 +63,143 @@ func setupTestDB(t *testing.T, baseDir string) {
 	// 初始化数据库
 	config.DataDir = baseDir
 	config.RepoDir = repoDir
+	config.PotStackToken = testToken
 	if err := db.Init(repoDir); err != nil {
 		t.Fatalf("Failed to init db: %v", err)
 	}
 }
 
+// testSandboxes 是最近一次 setupRouter 创建的 Sandbox 管理器
+var testSandboxes *keeper.SandboxManager
+
 func setupRouter() *gin.Engine {
 	gin.SetMode(gin.TestMode)
 
 	// 初始化 Service (依赖已初始化的 DB)
 	us := service.NewUserService()
 	rs := service.NewRepoService()
-	server := api.NewServer(us, rs)
+	ts := service.NewTokenService()
+	ws := service.NewWebhookService()
+	testSandboxes = keeper.NewManager(config.RepoDir, nil)
+	ss := service.NewSandboxService(testSandboxes)
+	server := api.NewServer(us, rs, ts, ws, ss)
 
 	r := gin.New()
-	v1 := r.Group("/api/v1")
-	{
-		admin := v1.Group("/admin")
-		admin.POST("/users", server.CreateUserHandler)
-		admin.POST("/users/:username/repos", server.CreateRepoHandler)
-		admin.DELETE("/users/:username", server.DeleteUserHandler)
-
-		repos := v1.Group("/repos")
-		repos.GET("/:owner/:repo", server.GetRepoHandler)
-		repos.DELETE("/:owner/:repo", server.DeleteRepoHandler)
-		repos.GET("/:owner/:repo/collaborators", server.ListCollaboratorsHandler)
-		repos.GET("/:owner/:repo/collaborators/:collaborator", server.CheckCollaboratorHandler)
-		repos.PUT("/:owner/:repo/collaborators/:collaborator", server.AddCollaboratorHandler)
-		repos.DELETE("/:owner/:repo/collaborators/:collaborator", server.RemoveCollaboratorHandler)
-	}
+	server.RegisterRoutes(r)
+	server.RegisterGitRoutes(r)
 	r.GET("/health", api.HealthCheckHandler)
 	return r
 }
 
+// newRequest 创建携带系统令牌的请求
+func newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err == nil {
+		req.Header.Set("Authorization", "token "+testToken)
+	}
+	return req, err
+}
+
+// testAuth 以系统令牌访问 Git 仓库
+var testAuth = &githttp.BasicAuth{Username: "git", Password: testToken}
+
+// testServer 是运行在临时目录中的 API 与 Git HTTP 服务
+type testServer struct {
+	*httptest.Server
+	t   *testing.T
+	dir string // 临时目录，测试结束时删除
+}
+
+// newTestServer 初始化数据库并启动服务；测试结束时停止所有沙箱、关闭服务并清理
+func newTestServer(t *testing.T) *testServer {
+	dir, err := os.MkdirTemp("", "potstack_test_*")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	setupTestDB(t, dir)
+	srv := httptest.NewServer(setupRouter())
+	sandboxes := testSandboxes
+	t.Cleanup(func() {
+		sandboxes.Shutdown()
+		srv.Close()
+		db.Reset()
+		os.RemoveAll(dir)
+	})
+	return &testServer{Server: srv, t: t, dir: dir}
+}
+
+// call 以系统令牌发送 JSON 请求，调用方负责关闭响应
+func (ts *testServer) call(method, path string, payload interface{}) *http.Response {
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+	req, _ := newRequest(method, ts.URL+path, &body)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	return resp
+}
+
+// callJSON 发送请求并把响应解码到 out（为 nil 时丢弃），返回状态码
+func (ts *testServer) callJSON(method, path string, payload, out interface{}) int {
+	resp := ts.call(method, path, payload)
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+// issueToken 为用户签发个人访问令牌，返回明文
+func (ts *testServer) issueToken(username string, scopes ...string) string {
+	var token api.AccessToken
+	ts.callJSON("POST", "/api/v1/users/"+username+"/tokens", api.CreateTokenOption{Name: "test", Scopes: scopes}, &token)
+	return token.Token
+}
+
+// createRepo 创建用户（已存在时忽略）与仓库
+func (ts *testServer) createRepo(owner, repo string) {
+	ts.callJSON("POST", "/api/v1/admin/users", api.CreateUserOption{Username: owner}, nil)
+	code := ts.callJSON("POST", "/api/v1/admin/users/"+owner+"/repos", api.CreateRepoOption{Name: repo}, nil)
+	if code != http.StatusCreated && code != http.StatusConflict {
+		ts.t.Fatalf("create repo %s/%s failed: %d", owner, repo, code)
+	}
+}
+
+// clone 以系统令牌把仓库克隆到临时目录
+func (ts *testServer) clone(owner, repo string) (*gogit.Repository, string) {
+	dir, _ := os.MkdirTemp(ts.dir, "clone_*")
+	r, err := gogit.PlainClone(dir, false, &gogit.CloneOptions{URL: ts.URL + "/repo/" + owner + "/" + repo + ".git", Auth: testAuth})
+	if err != nil {
+		ts.t.Fatalf("clone %s/%s failed: %v", owner, repo, err)
+	}
+	return r, dir
+}
+
+// pushPot 把 files 提交并推送到 owner/repo（仓库不存在时创建），返回提交哈希
+func (ts *testServer) pushPot(owner, repo string, files map[string]string) string {
+	ts.createRepo(owner, repo)
+	r, dir := ts.clone(owner, repo)
+	w, _ := r.Worktree()
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0755)
+		w.Add(name)
+	}
+	hash, err := w.Commit("update "+repo, &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		ts.t.Fatalf("commit to %s/%s failed: %v", owner, repo, err)
+	}
+	if err := r.Push(&gogit.PushOptions{Auth: testAuth}); err != nil {
+		ts.t.Fatalf("push to %s/%s failed: %v", owner, repo, err)
+	}
+	return hash.String()
+}
+
 // TestHealthCheck 健康检查接口测试
 func TestHealthCheck(t *testing.T) {
 	r := setupRouter()
@@ -87,7 +224,7 @@ func TestUserCRUD(t *testing.T) {
 	// 1. 创建用户
 	w := httptest.NewRecorder()
 	body, _ := json.Marshal(api.CreateUserOption{Username: "alice", Email: "alice@example.com"})
-	req, _ := http.NewRequest("POST", "/api/v1/admin/users", bytes.NewBuffer(body))
+	req, _ := newRequest("POST", "/api/v1/admin/users", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 
@@ -97,7 +234,7 @@ func TestUserCRUD(t *testing.T) {
 
 	// 2. 重复创建应失败
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("POST", "/api/v1/admin/users", bytes.NewBuffer(body))
+	req, _ = newRequest("POST", "/api/v1/admin/users", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 
@@ -106,7 +243,7 @@ func TestUserCRUD(t *testing.T) {
 
 	// 3. 删除用户
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("DELETE", "/api/v1/admin/users/alice", nil)
+	req, _ = newRequest("DELETE", "/api/v1/admin/users/alice", nil)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
@@ -126,7 +263,7 @@ func TestRepoCRUD(t *testing.T) {
 	// 1. 先创建用户
 	w := httptest.NewRecorder()
 	userBody, _ := json.Marshal(api.CreateUserOption{Username: "bob", Email: "bob@example.com"})
-	req, _ := http.NewRequest("POST", "/api/v1/admin/users", bytes.NewBuffer(userBody))
+	req, _ := newRequest("POST", "/api/v1/admin/users", bytes.NewBuffer(userBody))
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusCreated, w.Code)
@@ -134,7 +271,7 @@ func TestRepoCRUD(t *testing.T) {
 	// 2. 创建仓库
 	w = httptest.NewRecorder()
 	repoBody, _ := json.Marshal(api.CreateRepoOption{Name: "myproject", Description: "Test project"})
-	req, _ = http.NewRequest("POST", "/api/v1/admin/users/bob/repos", bytes.NewBuffer(repoBody))
+	req, _ = newRequest("POST", "/api/v1/admin/users/bob/repos", bytes.NewBuffer(repoBody))
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 
@@ -144,7 +281,7 @@ func TestRepoCRUD(t *testing.T) {
 
 	// 3. 获取仓库信息
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/api/v1/repos/bob/myproject", nil)
+	req, _ = newRequest("GET", "/api/v1/repos/bob/myproject", nil)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -156,7 +293,7 @@ func TestRepoCRUD(t *testing.T) {
 
 	// 4. 删除仓库
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("DELETE", "/api/v1/repos/bob/myproject", nil)
+	req, _ = newRequest("DELETE", "/api/v1/repos/bob/myproject", nil)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
@@ -176,7 +313,7 @@ func TestCollaboratorCRUD(t *testing.T) {
 	// 1. 创建仓库所有者
 	w := httptest.NewRecorder()
 	body, _ := json.Marshal(api.CreateUserOption{Username: "owner1"})
-	req, _ := http.NewRequest("POST", "/api/v1/admin/users", bytes.NewBuffer(body))
+	req, _ := newRequest("POST", "/api/v1/admin/users", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusCreated, w.Code)
@@ -184,7 +321,7 @@ func TestCollaboratorCRUD(t *testing.T) {
 	// 2. 创建仓库
 	w = httptest.NewRecorder()
 	body, _ = json.Marshal(api.CreateRepoOption{Name: "shared-repo"})
-	req, _ = http.NewRequest("POST", "/api/v1/admin/users/owner1/repos", bytes.NewBuffer(body))
+	req, _ = newRequest("POST", "/api/v1/admin/users/owner1/repos", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusCreated, w.Code)
@@ -192,7 +329,7 @@ func TestCollaboratorCRUD(t *testing.T) {
 	// 3. 添加协作者
 	w = httptest.NewRecorder()
 	body, _ = json.Marshal(api.AddCollaboratorOption{Permission: "write"})
-	req, _ = http.NewRequest("PUT", "/api/v1/repos/owner1/shared-repo/collaborators/collab1", bytes.NewBuffer(body))
+	req, _ = newRequest("PUT", "/api/v1/repos/owner1/shared-repo/collaborators/collab1", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 
@@ -201,7 +338,7 @@ func TestCollaboratorCRUD(t *testing.T) {
 
 	// 4. 列出协作者
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/api/v1/repos/owner1/shared-repo/collaborators", nil)
+	req, _ = newRequest("GET", "/api/v1/repos/owner1/shared-repo/collaborators", nil)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -214,7 +351,7 @@ func TestCollaboratorCRUD(t *testing.T) {
 
 	// 5. 检查是否为协作者
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/api/v1/repos/owner1/shared-repo/collaborators/collab1", nil)
+	req, _ = newRequest("GET", "/api/v1/repos/owner1/shared-repo/collaborators/collab1", nil)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
@@ -222,7 +359,7 @@ func TestCollaboratorCRUD(t *testing.T) {
 
 	// 6. 检查非协作者
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/api/v1/repos/owner1/shared-repo/collaborators/unknown", nil)
+	req, _ = newRequest("GET", "/api/v1/repos/owner1/shared-repo/collaborators/unknown", nil)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusNotFound, w.Code)
@@ -230,7 +367,7 @@ func TestCollaboratorCRUD(t *testing.T) {
 
 	// 7. 移除协作者
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("DELETE", "/api/v1/repos/owner1/shared-repo/collaborators/collab1", nil)
+	req, _ = newRequest("DELETE", "/api/v1/repos/owner1/shared-repo/collaborators/collab1", nil)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
@@ -238,7 +375,7 @@ func TestCollaboratorCRUD(t *testing.T) {
 
 	// 8. 确认已移除
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/api/v1/repos/owner1/shared-repo/collaborators", nil)
+	req, _ = newRequest("GET", "/api/v1/repos/owner1/shared-repo/collaborators", nil)
 	r.ServeHTTP(w, req)
 
 	json.Unmarshal(w.Body.Bytes(), &collaborators)
@@ -258,7 +395,7 @@ func TestUserNotFound(t *testing.T) {
 	// 创建仓库时用户不存在
 	w := httptest.NewRecorder()
 	body, _ := json.Marshal(api.CreateRepoOption{Name: "test"})
-	req, _ := http.NewRequest("POST", "/api/v1/admin/users/nonexistent/repos", bytes.NewBuffer(body))
+	req, _ := newRequest("POST", "/api/v1/admin/users/nonexistent/repos", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 
@@ -276,9 +413,1549 @@ func TestRepoNotFound(t *testing.T) {
 	r := setupRouter()
 
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/api/v1/repos/unknown/repo", nil)
+	req, _ := newRequest("GET", "/api/v1/repos/unknown/repo", nil)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusNotFound, w.Code)
 	t.Log("✅ 仓库不存在正确返回 404")
 }
+
+// TestAdminAPIAuth 管理 API 认证测试
+func TestAdminAPIAuth(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "potstack_test_auth_*")
+	defer os.RemoveAll(tmpDir)
+	setupTestDB(t, tmpDir)
+	defer db.Reset()
+
+	oldToken := config.PotStackToken
+	config.PotStackToken = "test-secret"
+	defer func() { config.PotStackToken = oldToken }()
+
+	r := setupRouter()
+
+	// 1. 无认证信息
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/repos/unknown/repo", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+	t.Log("✅ 未认证请求返回 401")
+
+	// 2. 错误的 Token
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/repos/unknown/repo", nil)
+	req.Header.Set("Authorization", "token wrong")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	t.Log("✅ 错误 Token 返回 401")
+
+	// 3. Token 方式
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/repos/unknown/repo", nil)
+	req.Header.Set("Authorization", "token test-secret")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	t.Log("✅ Token 方式认证通过")
+
+	// 4. Basic Auth 方式
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/repos/unknown/repo", nil)
+	req.SetBasicAuth("test-secret", "")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	t.Log("✅ Basic Auth 方式认证通过")
+
+	// 5. 健康检查无需认证
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/health", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	t.Log("✅ 健康检查无需认证")
+}
+
+// TestAdminAPIEndToEnd 通过真实 HTTP 服务走完整管理流程
+func TestAdminAPIEndToEnd(t *testing.T) {
+	ts := newTestServer(t)
+	config.PotStackToken = "e2e-secret"
+
+	do := func(method, path string, payload interface{}) *http.Response {
+		var body bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&body).Encode(payload)
+		}
+		req, _ := http.NewRequest(method, ts.URL+path, &body)
+		req.Header.Set("Authorization", "token e2e-secret")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		return resp
+	}
+
+	// 1. 创建用户
+	resp := do("POST", "/api/v1/admin/users", api.CreateUserOption{Username: "carol", Email: "carol@example.com"})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// 2. 创建仓库
+	resp = do("POST", "/api/v1/admin/users/carol/repos", api.CreateRepoOption{Name: "app"})
+	var created db.Repository
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "carol/app", created.FullName)
+	assert.NotEmpty(t, created.CloneURL)
+
+	// 3. 获取仓库
+	resp = do("GET", "/api/v1/repos/carol/app", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// 4. 添加只读协作者
+	resp = do("PUT", "/api/v1/repos/carol/app/collaborators/dave", api.AddCollaboratorOption{Permission: "read"})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// 5. 非法权限值
+	resp = do("PUT", "/api/v1/repos/carol/app/collaborators/dave", api.AddCollaboratorOption{Permission: "owner"})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 6. 列出协作者
+	resp = do("GET", "/api/v1/repos/carol/app/collaborators", nil)
+	var collaborators []db.CollaboratorResponse
+	json.NewDecoder(resp.Body).Decode(&collaborators)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, collaborators, 1)
+	assert.False(t, collaborators[0].Permissions.Push)
+	assert.True(t, collaborators[0].Permissions.Pull)
+
+	// 7. 移除协作者、删除仓库、删除用户
+	resp = do("DELETE", "/api/v1/repos/carol/app/collaborators/dave", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do("DELETE", "/api/v1/repos/carol/app", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do("GET", "/api/v1/repos/carol/app", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do("DELETE", "/api/v1/admin/users/carol", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.NoDirExists(t, filepath.Join(config.RepoDir, "carol"))
+	t.Log("✅ 管理 API 端到端流程通过")
+}
+
+// TestAccessTokenScopes 个人访问令牌与权限范围测试
+func TestAccessTokenScopes(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "potstack_test_token_*")
+	defer os.RemoveAll(tmpDir)
+	setupTestDB(t, tmpDir)
+	defer db.Reset()
+
+	r := setupRouter()
+
+	call := func(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&body).Encode(payload)
+		}
+		req, _ := http.NewRequest(method, path, &body)
+		req.Header.Set("Authorization", "token "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// 1. 系统令牌准备用户与仓库
+	assert.Equal(t, http.StatusCreated, call(testToken, "POST", "/api/v1/admin/users", api.CreateUserOption{Username: "erin"}).Code)
+	assert.Equal(t, http.StatusCreated, call(testToken, "POST", "/api/v1/admin/users", api.CreateUserOption{Username: "frank"}).Code)
+	assert.Equal(t, http.StatusCreated, call(testToken, "POST", "/api/v1/admin/users/erin/repos", api.CreateRepoOption{Name: "proj"}).Code)
+
+	// 2. 为 erin 签发只读令牌
+	w := call(testToken, "POST", "/api/v1/users/erin/tokens", api.CreateTokenOption{Name: "ci", Scopes: []string{"repo:read"}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var erinToken api.AccessToken
+	json.Unmarshal(w.Body.Bytes(), &erinToken)
+	assert.True(t, strings.HasPrefix(erinToken.Token, "pst_"))
+	t.Log("✅ 签发个人访问令牌成功")
+
+	// 非法范围
+	w = call(testToken, "POST", "/api/v1/users/erin/tokens", api.CreateTokenOption{Name: "bad", Scopes: []string{"everything"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 3. 只读令牌：可读，不可写，不可管理
+	assert.Equal(t, http.StatusOK, call(erinToken.Token, "GET", "/api/v1/repos/erin/proj", nil).Code)
+	assert.Equal(t, http.StatusForbidden, call(erinToken.Token, "DELETE", "/api/v1/repos/erin/proj", nil).Code)
+	assert.Equal(t, http.StatusForbidden, call(erinToken.Token, "POST", "/api/v1/admin/users", api.CreateUserOption{Username: "mallory"}).Code)
+	t.Log("✅ 权限范围生效")
+
+	// 4. 不能签发超出自身范围的令牌，也不能管理他人令牌
+	w = call(erinToken.Token, "POST", "/api/v1/users/erin/tokens", api.CreateTokenOption{Name: "escalate", Scopes: []string{"repo:write"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusForbidden, call(erinToken.Token, "GET", "/api/v1/users/frank/tokens", nil).Code)
+	t.Log("✅ 禁止越权签发")
+
+	// 5. 非协作者无法访问他人仓库，成为协作者后可以
+	w = call(testToken, "POST", "/api/v1/users/frank/tokens", api.CreateTokenOption{Name: "dev", Scopes: []string{"repo:write"}})
+	var frankToken api.AccessToken
+	json.Unmarshal(w.Body.Bytes(), &frankToken)
+	assert.Equal(t, http.StatusForbidden, call(frankToken.Token, "GET", "/api/v1/repos/erin/proj", nil).Code)
+	assert.Equal(t, http.StatusNoContent, call(testToken, "PUT", "/api/v1/repos/erin/proj/collaborators/frank", api.AddCollaboratorOption{Permission: "write"}).Code)
+	assert.Equal(t, http.StatusOK, call(frankToken.Token, "GET", "/api/v1/repos/erin/proj", nil).Code)
+	assert.Equal(t, http.StatusForbidden, call(frankToken.Token, "DELETE", "/api/v1/repos/erin/proj", nil).Code)
+	t.Log("✅ 按调用者校验仓库权限")
+
+	// 6. 列出令牌不返回明文
+	w = call(erinToken.Token, "GET", "/api/v1/users/erin/tokens", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), erinToken.Token)
+	var tokens []db.AccessToken
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	assert.Len(t, tokens, 1)
+	assert.NotNil(t, tokens[0].LastUsedAt)
+
+	// 7. 吊销后令牌失效
+	assert.Equal(t, http.StatusNoContent, call(erinToken.Token, "DELETE", fmt.Sprintf("/api/v1/users/erin/tokens/%d", erinToken.ID), nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, call(erinToken.Token, "GET", "/api/v1/repos/erin/proj", nil).Code)
+	t.Log("✅ 吊销令牌成功")
+}
+
+// TestGitAccessControl Git Smart HTTP 协作者权限测试
+func TestGitAccessControl(t *testing.T) {
+	ts := newTestServer(t)
+
+	// 1. 准备仓库 gina/site 和两个用户的令牌
+	ts.callJSON("POST", "/api/v1/admin/users", api.CreateUserOption{Username: "hank"}, nil)
+	ts.createRepo("gina", "site")
+	ginaToken := ts.issueToken("gina", "repo:write")
+	hankToken := ts.issueToken("hank", "repo:write")
+
+	repoURL := ts.URL + "/repo/gina/site.git"
+	clone := func(token string) (*gogit.Repository, string, error) {
+		dir, _ := os.MkdirTemp(ts.dir, "clone_*")
+		opts := &gogit.CloneOptions{URL: repoURL}
+		if token != "" {
+			opts.Auth = &githttp.BasicAuth{Username: "git", Password: token}
+		}
+		r, err := gogit.PlainClone(dir, false, opts)
+		return r, dir, err
+	}
+	push := func(r *gogit.Repository, dir, token, file string) error {
+		os.WriteFile(filepath.Join(dir, file), []byte(file), 0644)
+		w, _ := r.Worktree()
+		w.Add(file)
+		_, err := w.Commit("add "+file, &gogit.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		if err != nil {
+			return err
+		}
+		return r.Push(&gogit.PushOptions{Auth: &githttp.BasicAuth{Username: "git", Password: token}})
+	}
+
+	// 2. 匿名与非协作者无法拉取
+	_, _, err := clone("")
+	assert.Error(t, err)
+	_, _, err = clone(hankToken)
+	assert.Error(t, err)
+	t.Log("✅ 匿名/非协作者拉取被拒绝")
+
+	// 3. 所有者可以拉取和推送
+	r, dir, err := clone(ginaToken)
+	assert.NoError(t, err)
+	assert.NoError(t, push(r, dir, ginaToken, "owner.txt"))
+	t.Log("✅ 所有者拉取/推送成功")
+
+	// 4. 只读协作者可以拉取，不能推送
+	ts.callJSON("PUT", "/api/v1/repos/gina/site/collaborators/hank", api.AddCollaboratorOption{Permission: "read"}, nil)
+	r, dir, err = clone(hankToken)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "owner.txt"))
+	assert.Error(t, push(r, dir, hankToken, "reader.txt"))
+	t.Log("✅ 只读协作者无法推送")
+
+	// 5. 写协作者可以推送
+	ts.callJSON("PUT", "/api/v1/repos/gina/site/collaborators/hank", api.AddCollaboratorOption{Permission: "write"}, nil)
+	assert.NoError(t, push(r, dir, hankToken, "writer.txt"))
+	t.Log("✅ 写协作者推送成功")
+}
+
+// TestGitReceivePackSemantics 推送时的旧值校验、快进检查、删除与 atomic 语义测试
+func TestGitReceivePackSemantics(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createRepo("ivy", "app")
+
+	repoURL := ts.URL + "/repo/ivy/app.git"
+	commit := func(r *gogit.Repository, dir, file string) {
+		os.WriteFile(filepath.Join(dir, file), []byte(file), 0644)
+		w, _ := r.Worktree()
+		w.Add(file)
+		w.Commit("add "+file, &gogit.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+	}
+	push := func(r *gogit.Repository, atomic bool, specs ...string) error {
+		var refSpecs []gitconfig.RefSpec
+		for _, s := range specs {
+			refSpecs = append(refSpecs, gitconfig.RefSpec(s))
+		}
+		return r.Push(&gogit.PushOptions{Auth: testAuth, RefSpecs: refSpecs, Atomic: atomic})
+	}
+	bare, err := gogit.PlainOpen(filepath.Join(config.RepoDir, "ivy", "app.git"))
+	if err != nil {
+		t.Fatalf("open bare repo failed: %v", err)
+	}
+	head, _ := bare.Head()
+	branch := head.Name().String()
+
+	a, dirA := ts.clone("ivy", "app")
+	b, dirB := ts.clone("ivy", "app")
+
+	// 1. A 正常推送（快进）
+	commit(a, dirA, "a.txt")
+	assert.NoError(t, push(a, false, "refs/heads/*:refs/heads/*"))
+	aHead, _ := a.Head()
+
+	// 2. B 基于旧提交强推，服务端拒绝非快进更新
+	commit(b, dirB, "b.txt")
+	err = push(b, false, "+"+branch+":"+branch)
+	assert.ErrorContains(t, err, "non-fast-forward")
+	ref, _ := bare.Reference(head.Name(), false)
+	assert.Equal(t, aHead.Hash(), ref.Hash())
+	t.Log("✅ 非快进推送被拒绝")
+
+	// 3. 客户端声明的旧值过期时拒绝（比较并交换）
+	upr := packp.NewReferenceUpdateRequest()
+	upr.Capabilities.Set(capability.ReportStatus)
+	upr.Capabilities.Set(capability.DeleteRefs)
+	upr.Commands = []*packp.Command{{Name: head.Name(), Old: head.Hash(), New: plumbing.ZeroHash}}
+	var raw bytes.Buffer
+	upr.Encode(&raw)
+	req, _ := newRequest("POST", repoURL+"/git-receive-pack", &raw)
+	req.Header.Set("Content-Type", "application/x-git-receive-pack-request")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("receive-pack request failed: %v", err)
+	}
+	report := packp.NewReportStatus()
+	assert.NoError(t, report.Decode(resp.Body))
+	resp.Body.Close()
+	if assert.Len(t, report.CommandStatuses, 1) {
+		assert.Equal(t, "fetch first", report.CommandStatuses[0].Status)
+	}
+	t.Log("✅ 旧值过期的更新被拒绝")
+
+	// 4. 新建并删除分支；默认分支不可删除
+	assert.NoError(t, push(a, false, branch+":refs/heads/feature"))
+	assert.NoError(t, push(a, false, ":refs/heads/feature"))
+	_, err = bare.Reference("refs/heads/feature", false)
+	assert.Error(t, err)
+	assert.Error(t, push(a, false, ":"+branch))
+	t.Log("✅ 删除分支成功，删除默认分支被拒绝")
+
+	// 5. atomic：一条失败则全部不生效
+	err = push(b, true, "+"+branch+":"+branch, "HEAD:refs/heads/other")
+	assert.Error(t, err)
+	_, err = bare.Reference("refs/heads/other", false)
+	assert.Error(t, err)
+	ref, _ = bare.Reference(head.Name(), false)
+	assert.Equal(t, aHead.Hash(), ref.Hash())
+	t.Log("✅ atomic 推送整体回滚")
+}
+
+// TestGitFetchNegotiation 拉取时的历史完整性、have 协商与 thin pack 测试
+func TestGitFetchNegotiation(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createRepo("jack", "lib")
+
+	repoURL := ts.URL + "/repo/jack/lib.git"
+	clone := func() *gogit.Repository {
+		r, _ := ts.clone("jack", "lib")
+		return r
+	}
+	content := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 200)
+	commit := func(r *gogit.Repository, n int) plumbing.Hash {
+		w, _ := r.Worktree()
+		os.WriteFile(filepath.Join(w.Filesystem.Root(), "data.txt"), []byte(content+fmt.Sprint(n)), 0644)
+		w.Add("data.txt")
+		h, _ := w.Commit(fmt.Sprintf("commit %d", n), &gogit.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		return h
+	}
+
+	// 1. 多次推送后重新克隆，历史完整
+	a := clone()
+	for i := 1; i <= 3; i++ {
+		commit(a, i)
+		assert.NoError(t, a.Push(&gogit.PushOptions{Auth: testAuth}))
+	}
+	b := clone()
+	bHead, _ := b.Head()
+	iter, err := b.Log(&gogit.LogOptions{From: bHead.Hash()})
+	assert.NoError(t, err)
+	count := 0
+	assert.NoError(t, iter.ForEach(func(*object.Commit) error { count++; return nil }))
+	assert.GreaterOrEqual(t, count, 4)
+	t.Log("✅ 克隆包含完整提交历史")
+
+	// 2. 新提交后，multi_ack_detailed 协商
+	newHead := commit(a, 4)
+	assert.NoError(t, a.Push(&gogit.PushOptions{Auth: testAuth}))
+	unknown := plumbing.NewHash("1234567890123456789012345678901234567890")
+
+	uploadPack := func(caps string, done bool) *http.Response {
+		var raw bytes.Buffer
+		e := pktline.NewEncoder(&raw)
+		e.Encodef("want %s %s\n", newHead, caps)
+		e.Flush()
+		e.Encodef("have %s\n", bHead.Hash())
+		e.Encodef("have %s\n", unknown)
+		if done {
+			e.Encodef("done\n")
+		} else {
+			e.Flush()
+		}
+		req, _ := newRequest("POST", repoURL+"/git-upload-pack", &raw)
+		req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("upload-pack request failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := uploadPack("multi_ack_detailed", false)
+	out, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	var lines []string
+	s := pktline.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		lines = append(lines, strings.TrimSpace(string(s.Bytes())))
+	}
+	assert.Equal(t, []string{
+		fmt.Sprintf("ACK %s common", bHead.Hash()),
+		fmt.Sprintf("ACK %s ready", unknown),
+		"NAK",
+	}, lines)
+	t.Log("✅ multi_ack_detailed 返回 common/ready")
+
+	// 3. done 之后发送只包含新对象的 thin pack
+	resp = uploadPack("multi_ack_detailed thin-pack ofs-delta", true)
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	s = pktline.NewScanner(br)
+	final := fmt.Sprintf("ACK %s", bHead.Hash())
+	for s.Scan() {
+		if strings.TrimSpace(string(s.Bytes())) == final {
+			break
+		}
+	}
+	assert.NoError(t, s.Err())
+
+	pack, _ := io.ReadAll(br)
+	scanner := packfile.NewScanner(bytes.NewReader(pack))
+	_, objects, err := scanner.Header()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), objects) // commit + tree + blob
+	deltas := 0
+	for i := uint32(0); i < objects; i++ {
+		h, err := scanner.NextObjectHeader()
+		if !assert.NoError(t, err) {
+			break
+		}
+		if h.Type == plumbing.REFDeltaObject {
+			deltas++
+		}
+	}
+	assert.Greater(t, deltas, 0)
+
+	parser, err := packfile.NewParserWithStorage(packfile.NewScanner(bytes.NewReader(pack)), b.Storer)
+	assert.NoError(t, err)
+	_, err = parser.Parse()
+	assert.NoError(t, err)
+	_, err = b.CommitObject(newHead)
+	assert.NoError(t, err)
+	t.Log("✅ 增量拉取仅传输新对象（thin pack）")
+
+	// 4. want 只接受从引用可达的对象：历史中的提交可以取回，已删除分支上的提交不行
+	wantOnly := func(want plumbing.Hash) string {
+		var raw bytes.Buffer
+		e := pktline.NewEncoder(&raw)
+		e.Encodef("want %s\n", want)
+		e.Flush()
+		e.Encodef("done\n")
+		req, _ := newRequest("POST", repoURL+"/git-upload-pack", &raw)
+		req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("upload-pack request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		s := pktline.NewScanner(resp.Body)
+		s.Scan()
+		return strings.TrimSpace(string(s.Bytes()))
+	}
+	assert.Equal(t, "NAK", wantOnly(bHead.Hash()))
+
+	dropped := commit(a, 5)
+	aHead, _ := a.Head()
+	assert.NoError(t, a.Push(&gogit.PushOptions{Auth: testAuth, RefSpecs: []gitconfig.RefSpec{
+		gitconfig.RefSpec(aHead.Name().String() + ":refs/heads/tmp"),
+	}}))
+	assert.Equal(t, "NAK", wantOnly(dropped))
+	assert.NoError(t, a.Push(&gogit.PushOptions{Auth: testAuth, RefSpecs: []gitconfig.RefSpec{":refs/heads/tmp"}}))
+	assert.Equal(t, fmt.Sprintf("ERR upload-pack: not our ref %s", dropped), wantOnly(dropped))
+	t.Log("✅ 不可达对象的 want 被拒绝")
+}
+
+// TestGitShallowAndPartialClone 浅克隆与部分克隆测试
+func TestGitShallowAndPartialClone(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createRepo("kate", "pot")
+
+	repoURL := ts.URL + "/repo/kate/pot.git"
+	a, dir := ts.clone("kate", "pot")
+	w, _ := a.Worktree()
+	for i := 1; i <= 3; i++ {
+		os.WriteFile(filepath.Join(dir, "pot.exe"), bytes.Repeat([]byte{byte(i)}, 4096), 0644)
+		w.Add("pot.exe")
+		w.Commit(fmt.Sprintf("build %d", i), &gogit.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+	}
+	assert.NoError(t, a.Push(&gogit.PushOptions{Auth: testAuth}))
+	head, _ := a.Head()
+
+	// 1. depth=1 浅克隆只包含最新提交
+	dir, _ = os.MkdirTemp(ts.dir, "shallow_*")
+	b, err := gogit.PlainClone(dir, false, &gogit.CloneOptions{URL: repoURL, Auth: testAuth, Depth: 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+	shallows, _ := b.Storer.Shallow()
+	assert.Equal(t, []plumbing.Hash{head.Hash()}, shallows)
+	commit, err := b.CommitObject(head.Hash())
+	assert.NoError(t, err)
+	_, err = b.CommitObject(commit.ParentHashes[0])
+	assert.Error(t, err)
+	assert.FileExists(t, filepath.Join(dir, "pot.exe"))
+	t.Log("✅ depth=1 浅克隆")
+
+	// 2. filter blob:none / blob:limit 不发送（大）文件内容
+	fetchPack := func(filter string) *memory.Storage {
+		var raw bytes.Buffer
+		e := pktline.NewEncoder(&raw)
+		e.Encodef("want %s filter\n", head.Hash())
+		e.Encodef("filter %s\n", filter)
+		e.Flush()
+		e.Encodef("done\n")
+		req, _ := newRequest("POST", repoURL+"/git-upload-pack", &raw)
+		req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("upload-pack request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		br := bufio.NewReader(resp.Body)
+		s := pktline.NewScanner(br)
+		assert.True(t, s.Scan()) // NAK
+		st := memory.NewStorage()
+		parser, err := packfile.NewParserWithStorage(packfile.NewScanner(br), st)
+		assert.NoError(t, err)
+		_, err = parser.Parse()
+		assert.NoError(t, err)
+		return st
+	}
+	blobSizes := func(st *memory.Storage) []int64 {
+		var sizes []int64
+		iter, _ := st.IterEncodedObjects(plumbing.BlobObject)
+		iter.ForEach(func(o plumbing.EncodedObject) error {
+			sizes = append(sizes, o.Size())
+			return nil
+		})
+		return sizes
+	}
+
+	st := fetchPack("blob:none")
+	assert.Empty(t, blobSizes(st))
+	_, err = st.EncodedObject(plumbing.CommitObject, commit.ParentHashes[0])
+	assert.NoError(t, err)
+	for _, size := range blobSizes(fetchPack("blob:limit=1k")) {
+		assert.LessOrEqual(t, size, int64(1024))
+	}
+	t.Log("✅ 部分克隆按 filter 省略 blob")
+}
+
+// TestGitProtocolV2 协议 v2（ls-refs / fetch）测试
+func TestGitProtocolV2(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createRepo("liam", "tags")
+
+	// 1. 推送一个分支和多个标签
+	repoURL := ts.URL + "/repo/liam/tags.git"
+	r, _ := ts.clone("liam", "tags")
+	head, _ := r.Head()
+	for i := 1; i <= 5; i++ {
+		r.CreateTag(fmt.Sprintf("v%d", i), head.Hash(), nil)
+	}
+	assert.NoError(t, r.Push(&gogit.PushOptions{Auth: testAuth, RefSpecs: []gitconfig.RefSpec{"refs/tags/*:refs/tags/*"}}))
+
+	post := func(service string, lines ...string) *http.Response {
+		var raw bytes.Buffer
+		for _, line := range lines {
+			if line == "0001" || line == "0000" {
+				raw.WriteString(line)
+			} else {
+				fmt.Fprintf(&raw, "%04x%s\n", len(line)+5, line)
+			}
+		}
+		req, _ := newRequest("POST", repoURL+"/"+service, &raw)
+		req.Header.Set("Git-Protocol", "version=2")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", service, err)
+		}
+		return resp
+	}
+	readLines := func(r io.Reader) []string {
+		var lines []string
+		s := pktline.NewScanner(r)
+		for s.Scan() && len(s.Bytes()) > 0 {
+			lines = append(lines, strings.TrimSpace(string(s.Bytes())))
+		}
+		return lines
+	}
+
+	// 2. info/refs 返回 v2 能力
+	req, _ := newRequest("GET", repoURL+"/info/refs?service=git-upload-pack", nil)
+	req.Header.Set("Git-Protocol", "version=2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("info/refs failed: %v", err)
+	}
+	caps := readLines(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "version 2", caps[0])
+	assert.Contains(t, caps, "ls-refs=unborn")
+	t.Log("✅ v2 能力广告")
+
+	// 3. ls-refs 按 ref-prefix 过滤
+	resp = post("git-upload-pack", "command=ls-refs", "0001", "symrefs", "ref-prefix HEAD", "ref-prefix refs/heads/", "0000")
+	refs := readLines(resp.Body)
+	resp.Body.Close()
+	assert.Len(t, refs, 2)
+	assert.Equal(t, fmt.Sprintf("%s HEAD symref-target:%s", head.Hash(), head.Name()), refs[0])
+	assert.Equal(t, fmt.Sprintf("%s %s", head.Hash(), head.Name()), refs[1])
+
+	resp = post("git-upload-pack", "command=ls-refs", "0001", "ref-prefix refs/tags/v3", "0000")
+	refs = readLines(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, []string{fmt.Sprintf("%s refs/tags/v3", head.Hash())}, refs)
+	t.Log("✅ ls-refs 按前缀过滤")
+
+	// 4. fetch：packfile 段通过 side-band 返回数据与进度
+	resp = post("git-upload-pack", "command=fetch", "server-option=trace", "0001", "ofs-delta", "want "+head.Hash().String(), "done", "0000")
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	section := pktline.NewScanner(br)
+	assert.True(t, section.Scan())
+	assert.Equal(t, "packfile\n", string(section.Bytes()))
+
+	demux := sideband.NewDemuxer(sideband.Sideband64k, br)
+	var progress bytes.Buffer
+	demux.Progress = &progress
+	st := memory.NewStorage()
+	parser, err := packfile.NewParserWithStorage(packfile.NewScanner(demux), st)
+	assert.NoError(t, err)
+	_, err = parser.Parse()
+	assert.NoError(t, err)
+	_, err = st.EncodedObject(plumbing.CommitObject, head.Hash())
+	assert.NoError(t, err)
+	io.Copy(io.Discard, demux)
+	assert.Contains(t, progress.String(), "Enumerating objects")
+	t.Log("✅ v2 fetch 与 side-band 进度")
+}
+
+func TestGitReceiveHooks(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.createRepo("kate", "hooked")
+	token := ts.issueToken("kate", "repo:write")
+
+	// 钩子是全局注册的，只处理本测试的仓库
+	var received []git.RefUpdate
+	var pusher string
+	git.RegisterPreReceiveHook(func(hc *git.HookContext, updates []git.RefUpdate) error {
+		if hc.Owner != "kate" {
+			return nil
+		}
+		for _, u := range updates {
+			if u.Name == "refs/heads/frozen" {
+				return fmt.Errorf("branch frozen is read-only")
+			}
+		}
+		return nil
+	})
+	git.RegisterUpdateHook(func(hc *git.HookContext, u git.RefUpdate) error {
+		if hc.Owner == "kate" && u.Name == "refs/heads/locked" {
+			return fmt.Errorf("branch locked is protected")
+		}
+		return nil
+	})
+	git.RegisterPostReceiveHook(func(hc *git.HookContext, updates []git.RefUpdate) {
+		if hc.Owner == "kate" {
+			received = append(received, updates...)
+			pusher = hc.Pusher
+		}
+	})
+
+	repoURL := ts.URL + "/repo/kate/hooked.git"
+	auth := &githttp.BasicAuth{Username: "kate", Password: token}
+	dir, _ := os.MkdirTemp(ts.dir, "clone_*")
+	local, err := gogit.PlainClone(dir, false, &gogit.CloneOptions{URL: repoURL, Auth: auth})
+	if err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	w, _ := local.Worktree()
+	w.Add("a.txt")
+	w.Commit("add a.txt", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	head, _ := local.Head()
+	branch := head.Name().String()
+	push := func(progress io.Writer, specs ...string) error {
+		var refSpecs []gitconfig.RefSpec
+		for _, s := range specs {
+			refSpecs = append(refSpecs, gitconfig.RefSpec(s))
+		}
+		return local.Push(&gogit.PushOptions{Auth: auth, RefSpecs: refSpecs, Progress: progress})
+	}
+	bare, _ := gogit.PlainOpen(filepath.Join(config.RepoDir, "kate", "hooked.git"))
+
+	// 1. pre-receive 拒绝时整个推送失败，拒绝原因回显给客户端
+	var progress bytes.Buffer
+	err = push(&progress, branch+":"+branch, branch+":refs/heads/frozen")
+	assert.ErrorContains(t, err, "pre-receive hook declined")
+	assert.Contains(t, progress.String(), "branch frozen is read-only")
+	ref, _ := bare.Reference(head.Name(), false)
+	assert.NotEqual(t, head.Hash(), ref.Hash())
+	assert.Empty(t, received)
+	t.Log("✅ pre-receive 拒绝整个推送")
+
+	// 2. update 只拒绝对应的引用，其余引用照常更新
+	progress.Reset()
+	err = push(&progress, branch+":"+branch, branch+":refs/heads/locked")
+	assert.ErrorContains(t, err, "hook declined")
+	ref, _ = bare.Reference(head.Name(), false)
+	assert.Equal(t, head.Hash(), ref.Hash())
+	_, err = bare.Reference("refs/heads/locked", false)
+	assert.Error(t, err)
+	t.Log("✅ update 钩子只拒绝单个引用")
+
+	// 3. post-receive 只收到成功的更新，并携带推送者
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, head.Name(), received[0].Name)
+		assert.Equal(t, head.Hash(), received[0].New)
+	}
+	assert.Equal(t, "kate", pusher)
+	t.Log("✅ post-receive 收到已更新的引用")
+
+	// 4. 仓库 hooks/ 目录下的可执行脚本同样生效
+	if runtime.GOOS == "windows" {
+		return
+	}
+	hooksDir := filepath.Join(config.RepoDir, "kate", "hooked.git", "hooks")
+	os.MkdirAll(hooksDir, 0755)
+	script := "#!/bin/sh\nif grep -q refs/heads/scripted; then echo \"scripted pushes disabled for $POTSTACK_PUSHER\" >&2; exit 1; fi\n"
+	os.WriteFile(filepath.Join(hooksDir, "pre-receive"), []byte(script), 0755)
+	progress.Reset()
+	err = push(&progress, branch+":refs/heads/scripted")
+	assert.ErrorContains(t, err, "pre-receive hook declined")
+	assert.Contains(t, progress.String(), "scripted pushes disabled for kate")
+	assert.NoError(t, push(nil, branch+":refs/heads/feature"))
+	t.Log("✅ hooks/pre-receive 脚本生效")
+}
+
+func TestWebhooks(t *testing.T) {
+	ts := newTestServer(t)
+
+	delays := webhook.RetryDelays
+	webhook.RetryDelays = []time.Duration{10 * time.Millisecond, 10 * time.Millisecond}
+	defer func() { webhook.RetryDelays = delays }()
+
+	// 本地接收端：记录收到的事件，failing 为 true 时返回 500
+	type received struct {
+		event, signature string
+		body             []byte
+	}
+	var mu sync.Mutex
+	var got []received
+	failing := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, received{r.Header.Get(webhook.HeaderEvent), r.Header.Get(webhook.HeaderSignature), body})
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("thanks"))
+	}))
+	defer receiver.Close()
+	waitFor := func(event, action string) map[string]interface{} {
+		var payload map[string]interface{}
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, r := range got {
+				var p map[string]interface{}
+				json.Unmarshal(r.body, &p)
+				if r.event == event && p["action"] == action {
+					assert.Equal(t, webhook.Sign("s3cret", r.body), r.signature)
+					payload = p
+					return true
+				}
+			}
+			return false
+		}, 5*time.Second, 10*time.Millisecond, "event %s/%s not delivered", event, action)
+		return payload
+	}
+
+	// 1. 创建全局 webhook 与仓库 webhook，参数校验
+	ts.callJSON("POST", "/api/v1/admin/users", api.CreateUserOption{Username: "lena"}, nil)
+	ts.callJSON("POST", "/api/v1/admin/users/lena/repos", api.CreateRepoOption{Name: "svc"}, nil)
+	assert.Equal(t, http.StatusBadRequest, ts.callJSON("POST", "/api/v1/admin/hooks",
+		api.CreateWebhookOption{URL: "ftp://example.com"}, nil))
+	assert.Equal(t, http.StatusBadRequest, ts.callJSON("POST", "/api/v1/admin/hooks",
+		api.CreateWebhookOption{URL: receiver.URL, Events: []string{"nope"}}, nil))
+
+	var global, repoHook db.Webhook
+	assert.Equal(t, http.StatusCreated, ts.callJSON("POST", "/api/v1/admin/hooks", api.CreateWebhookOption{
+		URL: receiver.URL, Secret: "s3cret", Events: []string{webhook.EventRepository},
+	}, &global))
+	// 仓库 webhook 不能指向本机或内网地址（全局 webhook 可以）
+	for _, u := range []string{receiver.URL, "http://localhost:61082/repo", "http://169.254.169.254/latest/meta-data", "http://[::1]/"} {
+		assert.Equal(t, http.StatusBadRequest, ts.callJSON("POST", "/api/v1/repos/lena/svc/hooks", api.CreateWebhookOption{URL: u}, nil), u)
+	}
+	webhook.AllowPrivateTargets = true // 接收端在本机
+	defer func() { webhook.AllowPrivateTargets = false }()
+	assert.Equal(t, http.StatusCreated, ts.callJSON("POST", "/api/v1/repos/lena/svc/hooks", api.CreateWebhookOption{
+		URL: receiver.URL, Secret: "s3cret", Events: []string{webhook.EventPush, webhook.EventCollaborator},
+	}, &repoHook))
+	assert.True(t, repoHook.HasSecret)
+	var hooks []db.Webhook
+	ts.callJSON("GET", "/api/v1/repos/lena/svc/hooks", nil, &hooks)
+	assert.Len(t, hooks, 1)
+	t.Log("✅ 创建 webhook")
+
+	// 2. 仓库事件投递给全局 webhook，协作者事件投递给仓库 webhook
+	ts.callJSON("POST", "/api/v1/admin/users/lena/repos", api.CreateRepoOption{Name: "other"}, nil)
+	p := waitFor(webhook.EventRepository, "created")
+	assert.Equal(t, "lena/other", p["repository"].(map[string]interface{})["full_name"])
+	ts.callJSON("PUT", "/api/v1/repos/lena/svc/collaborators/max", api.AddCollaboratorOption{Permission: "read"}, nil)
+	p = waitFor(webhook.EventCollaborator, "added")
+	assert.Equal(t, "max", p["data"].(map[string]interface{})["user"])
+	t.Log("✅ 仓库与协作者事件")
+
+	// 3. 推送事件（post-receive 钩子）
+	git.RegisterPostReceiveHook(webhook.PushHook)
+	local, dir := ts.clone("lena", "svc")
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	w, _ := local.Worktree()
+	w.Add("a.txt")
+	hash, _ := w.Commit("add a.txt", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	assert.NoError(t, local.Push(&gogit.PushOptions{Auth: testAuth}))
+	var push map[string]interface{}
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, r := range got {
+			if r.event == webhook.EventPush {
+				json.Unmarshal(r.body, &push)
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	if push != nil {
+		assert.Equal(t, hash.String(), push["data"].(map[string]interface{})["after"])
+	}
+	t.Log("✅ 推送事件")
+
+	// 4. 接收端失败时按退避重试，用尽后记录为 failed
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	ts.callJSON("DELETE", "/api/v1/repos/lena/svc/collaborators/max", nil, nil)
+	var deliveries []db.WebhookDelivery
+	assert.Eventually(t, func() bool {
+		ts.callJSON("GET", fmt.Sprintf("/api/v1/repos/lena/svc/hooks/%d/deliveries", repoHook.ID), nil, &deliveries)
+		return len(deliveries) > 0 && deliveries[0].Status == db.DeliveryFailed
+	}, 5*time.Second, 20*time.Millisecond)
+	failed := deliveries[0]
+	assert.Equal(t, webhook.EventCollaborator, failed.Event)
+	assert.Equal(t, 3, failed.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, failed.ResponseStatus)
+	t.Log("✅ 失败重试并记录响应码")
+
+	// 5. 重新投递生成新的记录
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	var redelivery db.WebhookDelivery
+	assert.Equal(t, http.StatusAccepted, ts.callJSON("POST",
+		fmt.Sprintf("/api/v1/repos/lena/svc/hooks/%d/deliveries/%d/redeliver", repoHook.ID, failed.ID), nil, &redelivery))
+	assert.NotEqual(t, failed.ID, redelivery.ID)
+	assert.Eventually(t, func() bool {
+		ts.callJSON("GET", fmt.Sprintf("/api/v1/repos/lena/svc/hooks/%d/deliveries", repoHook.ID), nil, &deliveries)
+		return deliveries[0].ID == redelivery.ID && deliveries[0].Status == db.DeliverySucceeded
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, http.StatusOK, deliveries[0].ResponseStatus)
+	assert.Empty(t, deliveries[0].ResponseBody) // 仓库 webhook 不保存响应体
+	assert.JSONEq(t, string(failed.Payload), string(deliveries[0].Payload))
+	assert.Equal(t, http.StatusNotFound, ts.callJSON("POST",
+		fmt.Sprintf("/api/v1/admin/hooks/%d/deliveries/%d/redeliver", global.ID, failed.ID), nil, nil))
+	t.Log("✅ 重新投递")
+
+	// 6. 退出时中断等待重试的投递，记录保持 pending；重启后继续投递，已停用的 webhook 记为 failed
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	webhook.RetryDelays = []time.Duration{time.Hour}
+	ts.callJSON("PUT", "/api/v1/repos/lena/svc/collaborators/max", api.AddCollaboratorOption{Permission: "read"}, nil)
+	assert.Eventually(t, func() bool {
+		ts.callJSON("GET", fmt.Sprintf("/api/v1/repos/lena/svc/hooks/%d/deliveries", repoHook.ID), nil, &deliveries)
+		return deliveries[0].ID != redelivery.ID && deliveries[0].Attempts == 1
+	}, 5*time.Second, 20*time.Millisecond)
+	interrupted := deliveries[0]
+	stopped := time.Now()
+	webhook.Stop()
+	assert.Less(t, time.Since(stopped), time.Second)
+
+	inactive, _ := db.CreateWebhook(0, receiver.URL, "", nil, false)
+	orphan, _ := db.CreateWebhookDelivery(inactive.ID, "orphan", webhook.EventSandbox, []byte("{}"))
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	webhook.RetryDelays = []time.Duration{10 * time.Millisecond}
+	webhook.Start()
+	assert.Eventually(t, func() bool {
+		ts.callJSON("GET", fmt.Sprintf("/api/v1/repos/lena/svc/hooks/%d/deliveries", repoHook.ID), nil, &deliveries)
+		return deliveries[0].ID == interrupted.ID && deliveries[0].Status == db.DeliverySucceeded
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	d, _ := db.GetWebhookDelivery(inactive.ID, orphan.ID)
+	assert.Equal(t, db.DeliveryFailed, d.Status)
+	t.Log("✅ 重启后继续未完成的投递")
+
+	// 7. 删除 webhook
+	assert.Equal(t, http.StatusNoContent, ts.callJSON("DELETE", fmt.Sprintf("/api/v1/admin/hooks/%d", global.ID), nil, nil))
+	assert.Equal(t, http.StatusNotFound, ts.callJSON("GET", fmt.Sprintf("/api/v1/admin/hooks/%d", global.ID), nil, nil))
+}
+
+// TestSSHGitTransport SSH 公钥管理与 Git over SSH 的认证、授权测试
+func TestSSHGitTransport(t *testing.T) {
+	ts := newTestServer(t)
+
+	newKey := func(comment string) (ssh.Signer, string) {
+		_, priv, _ := ed25519.GenerateKey(rand.Reader)
+		signer, _ := ssh.NewSignerFromKey(priv)
+		line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+		return signer, line + " " + comment
+	}
+
+	// 1. 准备仓库 mia/app，登记 mia 和 nick 的公钥
+	ts.createRepo("mia", "app")
+	ts.callJSON("POST", "/api/v1/admin/users", api.CreateUserOption{Username: "nick"}, nil)
+	miaKey, miaPub := newKey("mia@laptop")
+	nickKey, nickPub := newKey("nick@laptop")
+	strangerKey, _ := newKey("stranger")
+
+	assert.Equal(t, http.StatusCreated, ts.callJSON("POST", "/api/v1/users/mia/keys", api.CreateSSHKeyOption{Key: miaPub}, nil))
+	assert.Equal(t, http.StatusCreated, ts.callJSON("POST", "/api/v1/users/nick/keys", api.CreateSSHKeyOption{Title: "work", Key: nickPub}, nil))
+	assert.Equal(t, http.StatusConflict, ts.callJSON("POST", "/api/v1/users/nick/keys", api.CreateSSHKeyOption{Title: "again", Key: miaPub}, nil))
+	assert.Equal(t, http.StatusBadRequest, ts.callJSON("POST", "/api/v1/users/nick/keys", api.CreateSSHKeyOption{Key: "ssh-ed25519 not-a-key"}, nil))
+
+	var keys []db.SSHKey
+	ts.callJSON("GET", "/api/v1/users/mia/keys", nil, &keys)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, "mia@laptop", keys[0].Title)
+		assert.Equal(t, ssh.FingerprintSHA256(miaKey.PublicKey()), keys[0].Fingerprint)
+	}
+	t.Log("✅ SSH 公钥登记成功，重复/非法公钥被拒绝")
+
+	// 2. 启动 SSH 服务
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, _ := ssh.NewSignerFromKey(hostPriv)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	go sshd.NewServer(service.NewRepoService(), hostKey).Serve(l)
+
+	repoURL := fmt.Sprintf("ssh://git@%s/mia/app.git", l.Addr())
+	authFor := func(signer ssh.Signer) *gitssh.PublicKeys {
+		return &gitssh.PublicKeys{
+			User:   "git",
+			Signer: signer,
+			HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+		}
+	}
+	clone := func(signer ssh.Signer) (*gogit.Repository, string, error) {
+		dir, _ := os.MkdirTemp(ts.dir, "clone_*")
+		r, err := gogit.PlainClone(dir, false, &gogit.CloneOptions{URL: repoURL, Auth: authFor(signer)})
+		return r, dir, err
+	}
+	push := func(r *gogit.Repository, dir string, signer ssh.Signer, file string) error {
+		os.WriteFile(filepath.Join(dir, file), []byte(file), 0644)
+		w, _ := r.Worktree()
+		w.Add(file)
+		_, err := w.Commit("add "+file, &gogit.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		if err != nil {
+			return err
+		}
+		return r.Push(&gogit.PushOptions{Auth: authFor(signer)})
+	}
+
+	// 3. 未登记的公钥无法认证，非协作者无法拉取
+	_, _, err = clone(strangerKey)
+	assert.Error(t, err)
+	_, _, err = clone(nickKey)
+	assert.ErrorContains(t, err, "permission denied")
+	t.Log("✅ 未知公钥/非协作者被拒绝")
+
+	// 4. 所有者通过 SSH 拉取和推送
+	mia, miaDir, err := clone(miaKey)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, push(mia, miaDir, miaKey, "owner.txt"))
+	head, _ := mia.Head()
+	bare, _ := gogit.PlainOpen(filepath.Join(config.RepoDir, "mia", "app.git"))
+	ref, _ := bare.Reference(head.Name(), false)
+	assert.Equal(t, head.Hash(), ref.Hash())
+	t.Log("✅ 所有者 SSH 拉取/推送成功")
+
+	// 5. 只读协作者可以拉取（含增量拉取），不能推送
+	ts.callJSON("PUT", "/api/v1/repos/mia/app/collaborators/nick", api.AddCollaboratorOption{Permission: "read"}, nil)
+	nick, nickDir, err := clone(nickKey)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.FileExists(t, filepath.Join(nickDir, "owner.txt"))
+	assert.NoError(t, push(mia, miaDir, miaKey, "second.txt"))
+	w, _ := nick.Worktree()
+	assert.NoError(t, w.Pull(&gogit.PullOptions{Auth: authFor(nickKey)}))
+	assert.FileExists(t, filepath.Join(nickDir, "second.txt"))
+	assert.ErrorContains(t, push(nick, nickDir, nickKey, "reader.txt"), "permission denied")
+	t.Log("✅ 只读协作者可拉取、不能推送")
+
+	// 6. 删除公钥后无法再认证
+	assert.Equal(t, http.StatusNoContent, ts.callJSON("DELETE", fmt.Sprintf("/api/v1/users/mia/keys/%d", keys[0].ID), nil, nil))
+	_, _, err = clone(miaKey)
+	assert.Error(t, err)
+	t.Log("✅ 删除公钥后认证失败")
+}
+
+func TestSandboxLogs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe is a shell script")
+	}
+	ts := newTestServer(t)
+
+	// pot 先输出两行，DATA_PATH 下出现 poke 文件后再输出一行
+	ts.pushPot("quinn", "chatty", map[string]string{
+		"pot.yml": "title: chatty\ntype: exe\nstop_timeout: 100ms\n",
+		"pot.exe": "#!/bin/sh\necho hello\necho oops >&2\nuntil [ -f \"$DATA_PATH/poke\" ]; do sleep 0.05; done\necho again\nwhile true; do sleep 0.05; done\n",
+	})
+	testSandboxes.SignalUpdate("quinn", "chatty")
+
+	// 1. 最后 N 行，带时间戳和流标记
+	var logs struct {
+		Lines []string `json:"lines"`
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		assert.Equal(t, http.StatusOK, ts.callJSON("GET", "/api/v1/admin/sandboxes/quinn/chatty/logs?lines=10", nil, &logs))
+		if len(logs.Lines) == 2 {
+			break
+		}
+	}
+	if assert.Len(t, logs.Lines, 2) {
+		joined := strings.Join(logs.Lines, "\n")
+		assert.Contains(t, joined, "[stdout] hello")
+		assert.Contains(t, joined, "[stderr] oops")
+	}
+	ts.callJSON("GET", "/api/v1/admin/sandboxes/quinn/chatty/logs?lines=1", nil, &logs)
+	assert.Len(t, logs.Lines, 1)
+	assert.Equal(t, http.StatusNotFound, ts.callJSON("GET", "/api/v1/admin/sandboxes/quinn/nope/logs", nil, nil))
+	t.Log("✅ 返回最后 N 行日志")
+
+	// 2. follow=true 以 SSE 推送历史与新行
+	resp := ts.call("GET", "/api/v1/admin/sandboxes/quinn/chatty/logs?lines=1&follow=true", nil)
+	defer resp.Body.Close()
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/event-stream")
+	os.WriteFile(filepath.Join(config.RepoDir, "quinn", "chatty.git", "data", "faaspot", "data", "poke"), nil, 0644)
+
+	var events []string
+	sc := bufio.NewScanner(resp.Body)
+	for len(events) < 2 && sc.Scan() {
+		if data, ok := strings.CutPrefix(sc.Text(), "data:"); ok {
+			events = append(events, data)
+		}
+	}
+	if assert.Len(t, events, 2) {
+		assert.Contains(t, events[1], "[stdout] again")
+	}
+	t.Log("✅ SSE 实时推送新日志")
+}
+
+// potList 是测试用的 keeper.PotProvider
+type potList []keeper.PotURI
+
+func (p potList) GetInstalledPots() []keeper.PotURI { return p }
+
+func TestSandboxControl(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe is a shell script")
+	}
+	ts := newTestServer(t)
+	control := func(action, repo string) (int, keeper.SandboxStatus) {
+		var st keeper.SandboxStatus
+		code := ts.callJSON("POST", "/api/v1/admin/sandboxes/rita/"+repo+"/"+action, nil, &st)
+		return code, st
+	}
+	commit := ts.pushPot("rita", "svc", map[string]string{
+		"pot.yml": "title: svc\ntype: exe\nstop_timeout: 100ms\n",
+		"pot.exe": "#!/bin/sh\nwhile true; do sleep 0.05; done\n",
+	})
+	ts.pushPot("rita", "site", map[string]string{"pot.yml": "title: site\ntype: static\n", "index.html": "hi"})
+	ts.pushPot("rita", "plain", map[string]string{"README.md": "not a pot"})
+
+	// 1. Loader 完成前 Keeper 不可用
+	assert.Equal(t, http.StatusServiceUnavailable, ts.callJSON("GET", "/api/v1/admin/sandboxes", nil, nil))
+	testSandboxes.SetPotProvider(potList{{Org: "rita", Name: "svc"}, {Org: "rita", Name: "site"}, {Org: "rita", Name: "plain"}})
+
+	// 2. 列表只包含有 pot.yml 的仓库
+	var list []keeper.SandboxStatus
+	ts.callJSON("GET", "/api/v1/admin/sandboxes", nil, &list)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "svc", list[0].Name)
+		assert.Equal(t, "stopped", string(list[0].State))
+		assert.Equal(t, "site", list[1].Name)
+		assert.Equal(t, "static", list[1].Type)
+	}
+	var st keeper.SandboxStatus
+	assert.Equal(t, http.StatusOK, ts.callJSON("GET", "/api/v1/repos/rita/svc/sandbox", nil, &st))
+	assert.Equal(t, "exe", st.Type)
+	assert.Equal(t, http.StatusNotFound, ts.callJSON("GET", "/api/v1/repos/rita/plain/sandbox", nil, nil))
+	t.Log("✅ 列出已安装的 pot")
+
+	// 3. 部署后启动，重复启动冲突
+	code, st := control("redeploy", "svc")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "running", string(st.State))
+	assert.Equal(t, commit, st.Commit)
+	assert.NotZero(t, st.Pid)
+	code, _ = control("start", "svc")
+	assert.Equal(t, http.StatusConflict, code)
+	t.Log("✅ redeploy 并显示当前提交")
+
+	// 4. restart 换一个进程，stop 之后 target_status 也变为 stopped
+	pid := st.Pid
+	code, st = control("restart", "svc")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEqual(t, pid, st.Pid)
+
+	// sandbox:control 令牌可以启停沙箱，但不能执行其他管理操作
+	oncall := ts.issueToken("rita", "sandbox:control")
+	asOncall := func(method, path string, payload interface{}) int {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(payload)
+		req, _ := http.NewRequest(method, ts.URL+path, &body)
+		req.Header.Set("Authorization", "token "+oncall)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, asOncall("POST", "/api/v1/admin/sandboxes/rita/svc/restart", nil))
+	assert.Equal(t, http.StatusForbidden, asOncall("POST", "/api/v1/admin/users", api.CreateUserOption{Username: "mallory"}))
+	assert.Equal(t, http.StatusForbidden, asOncall("GET", "/api/v1/admin/sandboxes/rita/svc/secrets", nil))
+	t.Log("✅ sandbox:control 令牌只能控制沙箱")
+	code, st = control("stop", "svc")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "stopped", string(st.State))
+	assert.Equal(t, "stopped", string(st.TargetStatus))
+	assert.Zero(t, st.Pid)
+	code, st = control("start", "svc")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "running", string(st.State))
+	t.Log("✅ start / stop / restart")
+
+	// 5. static pot 只能 redeploy，没有 pot.yml 的仓库不是 sandbox
+	code, _ = control("stop", "site")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = control("redeploy", "site")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = control("start", "plain")
+	assert.Equal(t, http.StatusNotFound, code)
+	t.Log("✅ static 与普通仓库")
+
+	// 6. resources 配置错误时不启动
+	ts.pushPot("rita", "hog", map[string]string{
+		"pot.yml": "title: hog\ntype: exe\nresources:\n  memory: lots\n",
+		"pot.exe": "#!/bin/sh\nwhile true; do sleep 0.05; done\n",
+	})
+	resp := ts.call("POST", "/api/v1/admin/sandboxes/rita/hog/redeploy", nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), `invalid memory \"lots\"`)
+	var hog keeper.SandboxStatus
+	ts.callJSON("GET", "/api/v1/admin/sandboxes/rita/hog", nil, &hog)
+	assert.Equal(t, "stopped", string(hog.State))
+	assert.Zero(t, hog.Pid)
+	t.Log("✅ 资源限制校验")
+}
+
+func TestSandboxIsolation(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("isolation requires Linux namespaces")
+	}
+	ts := newTestServer(t)
+	os.Chmod(ts.dir, 0755)
+
+	// pot 把在沙箱内看到的环境写入 $DATA_PATH/report
+	script := `#!/bin/sh
+{
+  echo "uid=$(id -u)"
+  echo "init=$(tr -d '\0' < /proc/1/cmdline)"
+  echo "data=$DATA_PATH"
+  touch /program/x 2>/dev/null && echo "program=rw" || echo "program=ro"
+  [ -e "` + ts.dir + `" ] && echo "host=visible" || echo "host=hidden"
+  [ -n "$POTSTACK_TOKEN" ] && echo "token=leaked" || echo "token=none"
+  echo "ifaces=$(grep -c : /proc/net/dev)"
+} > "$DATA_PATH/report.tmp"
+mv "$DATA_PATH/report.tmp" "$DATA_PATH/report"
+while true; do sleep 0.05; done
+`
+	// deploy 推送 pot 并重新部署，返回状态码与响应
+	deploy := func(repo, potYml string) (int, string) {
+		ts.pushPot("sam", repo, map[string]string{
+			"pot.yml": "title: " + repo + "\ntype: exe\nstop_timeout: 100ms\n" + potYml,
+			"pot.exe": script,
+		})
+		resp := ts.call("POST", "/api/v1/admin/sandboxes/sam/"+repo+"/redeploy", nil)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(body)
+	}
+	// report 等待并解析 pot 写入的 report
+	report := func(repo string) map[string]string {
+		path := filepath.Join(config.RepoDir, "sam", repo+".git", "data", "faaspot", "data", "report")
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			kv := map[string]string{}
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				if k, v, ok := strings.Cut(line, "="); ok {
+					kv[k] = v
+				}
+			}
+			return kv
+		}
+		lines, _ := testSandboxes.TailLog("sam", repo, 10)
+		if strings.Contains(strings.Join(lines, "\n"), "failed to set up sandbox") {
+			t.Skipf("namespaces unavailable: %v", lines)
+		}
+		t.Fatalf("%s wrote no report, logs: %v", repo, lines)
+		return nil
+	}
+
+	// 1. 独立的 pid 命名空间与根目录，program 只读，不继承 PotStack 的环境变量
+	code, body := deploy("box", "isolation:\n  enabled: true\n")
+	if !assert.Equal(t, http.StatusOK, code, body) {
+		return
+	}
+	box := report("box")
+	assert.Equal(t, "0", box["uid"])
+	assert.Equal(t, "potstack-sandbox-init", box["init"]) // 独立的 pid 命名空间
+	assert.Equal(t, "/data", box["data"])
+	assert.Equal(t, "ro", box["program"])
+	assert.Equal(t, "hidden", box["host"])
+	assert.Equal(t, "none", box["token"])
+	t.Log("✅ 命名空间与文件系统隔离")
+
+	// 2. 宿主机上以沙箱 uid 运行
+	st, _ := testSandboxes.Status("sam", "box")
+	if assert.NotNil(t, st) && os.Geteuid() == 0 {
+		status, _ := os.ReadFile(fmt.Sprintf("/proc/%d/status", st.Pid))
+		assert.Regexp(t, `(?m)^Uid:\s+`+config.SandboxUID+`\s`, string(status))
+	}
+	t.Log("✅ 以沙箱 uid 运行")
+
+	// 3. network: none 只有回环接口
+	code, body = deploy("cell", "isolation:\n  enabled: true\n  network: none\n")
+	if assert.Equal(t, http.StatusOK, code, body) {
+		assert.Equal(t, "1", report("cell")["ifaces"])
+	}
+	t.Log("✅ 独立网络命名空间")
+
+	// 4. 隔离模式不允许 exec 探针
+	code, body = deploy("probe", "isolation:\n  enabled: true\nliveness:\n  type: exec\n  command: [\"true\"]\n")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, body, "exec probes are not supported")
+	t.Log("✅ 拒绝 exec 探针")
+}
+
+func TestSandboxDocker(t *testing.T) {
+	ts := newTestServer(t)
+	oldSocket := config.DockerSocket
+	config.DockerSocket = filepath.Join(ts.dir, "docker.sock")
+	defer func() { config.DockerSocket = oldSocket }()
+	engine := dockertest.NewEngine(t, config.DockerSocket)
+
+	control := func(action string) (int, keeper.SandboxStatus, string) {
+		resp := ts.call("POST", "/api/v1/admin/sandboxes/tess/web/"+action, nil)
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		var st keeper.SandboxStatus
+		json.Unmarshal(data, &st)
+		return resp.StatusCode, st, string(data)
+	}
+	waitLog := func(want string) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			lines, _ := testSandboxes.TailLog("tess", "web", 50)
+			if strings.Contains(strings.Join(lines, "\n"), want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	push := func(image string) {
+		ts.pushPot("tess", "web", map[string]string{
+			"pot.yml": "title: web\ntype: exe\ndocker: " + image + "\nstop_timeout: 2s\n" +
+				"env:\n  - name: GREETING\n    value: hi\n" +
+				"restart:\n  backoff: 10ms\n" +
+				"resources:\n  memory: 64M\n  cpus: 0.5\n",
+		})
+	}
+	push("nginx:1.25")
+	testSandboxes.SetPotProvider(potList{{Org: "tess", Name: "web"}})
+	defer testSandboxes.Stop("tess", "web")
+
+	// 1. redeploy 通过 Engine API 拉取镜像、创建并启动容器
+	code, st, _ := control("redeploy")
+	if !assert.Equal(t, http.StatusOK, code) {
+		return
+	}
+	assert.Equal(t, "running", string(st.State))
+	assert.Equal(t, "potstack-tess-web", st.Container)
+	assert.Equal(t, 4242, st.Pid)
+	assert.True(t, waitLog("[stdout] container up"))
+	assert.True(t, waitLog("[docker] layer1: Pull complete"))
+	out := engine.Calls()
+	assert.Contains(t, out, "POST /images/create?fromImage=nginx&tag=1.25\n")
+	assert.Contains(t, out, "POST /images/nginx:1.25/tag?repo=potstack%2Ftess%2Fweb&tag=latest\n")
+	assert.Contains(t, out, "DELETE /containers/potstack-tess-web?force=true\n")
+	assert.Contains(t, out, "POST /containers/create?name=potstack-tess-web\n")
+	c := engine.Container("potstack-tess-web")
+	if !assert.NotNil(t, c) {
+		return
+	}
+	assert.Equal(t, "potstack/tess/web:latest", c.Config["Image"])
+	env := fmt.Sprint(c.Config["Env"])
+	assert.Contains(t, env, "DATA_PATH=/data")
+	assert.Contains(t, env, fmt.Sprintf("SU_SERVER_ADDR=0.0.0.0:%d", st.Port))
+	assert.Contains(t, env, "GREETING=hi")
+	hostCfg, _ := c.Config["HostConfig"].(map[string]interface{})
+	dataDir := filepath.Join(config.RepoDir, "tess", "web.git", "data", "faaspot", "data")
+	absData, _ := filepath.Abs(dataDir)
+	assert.Contains(t, fmt.Sprint(hostCfg["Binds"]), absData+":/data")
+	assert.Equal(t, float64(64<<20), hostCfg["Memory"])
+	assert.Equal(t, float64(5e8), hostCfg["NanoCpus"])
+	assert.Contains(t, fmt.Sprint(hostCfg["PortBindings"]), fmt.Sprintf("%d/tcp:[map[HostIp:127.0.0.1 HostPort:%d]]", st.Port, st.Port))
+	t.Log("✅ 镜像拉取与容器配置")
+
+	// 2. 容器退出按重启策略重启，退出的容器被删除
+	first := c.ID
+	assert.True(t, engine.Crash("potstack-tess-web", 3))
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if cur, _ := testSandboxes.Status("tess", "web"); cur.Restarts == 1 && cur.State == "running" {
+			st = *cur
+			break
+		}
+	}
+	assert.Equal(t, 1, st.Restarts)
+	if assert.NotNil(t, st.LastExitCode) {
+		assert.Equal(t, 3, *st.LastExitCode)
+	}
+	assert.True(t, waitLog("[stderr] container crashed"))
+	assert.Contains(t, engine.Calls(), "DELETE /containers/"+first+"?force=true\n")
+	if c = engine.Container("potstack-tess-web"); assert.NotNil(t, c) {
+		assert.NotEqual(t, first, c.ID)
+	}
+	t.Log("✅ 退出后重启")
+
+	// 3. stop 向容器发送 SIGTERM
+	code, st, _ = control("stop")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "stopped", string(st.State))
+	assert.True(t, waitLog("container stopped"))
+	assert.Contains(t, engine.Calls(), "/kill?signal=SIGTERM\n")
+	assert.Nil(t, engine.Container("potstack-tess-web"))
+	t.Log("✅ 停止容器")
+
+	// 4. 拉取失败（消息流中的错误）时 redeploy 失败，不启动容器
+	push("missing:1")
+	code, _, body := control("redeploy")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, body, "manifest unknown")
+	assert.Nil(t, engine.Container("potstack-tess-web"))
+	t.Log("✅ 拉取失败")
+}
+
+func TestSandboxJobs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe is a shell script")
+	}
+	ts := newTestServer(t)
+	ts.pushPot("yuki", "backup", map[string]string{
+		"pot.yml": "title: backup\ntype: job\n",
+		"pot.exe": "#!/bin/sh\necho hello\necho oops >&2\nsleep 0.3\n",
+	})
+	testSandboxes.SignalUpdate("yuki", "backup")
+	jobs := "/api/v1/admin/sandboxes/yuki/backup/jobs"
+
+	// 1. 部署后不运行；exe 的生命周期操作不适用于 job
+	var st keeper.SandboxStatus
+	assert.Equal(t, http.StatusOK, ts.callJSON("GET", "/api/v1/admin/sandboxes/yuki/backup", nil, &st))
+	assert.Equal(t, "job", st.Type)
+	assert.Equal(t, models.RunStateStopped, st.State)
+	var runs []keeper.JobRun
+	assert.Equal(t, http.StatusOK, ts.callJSON("GET", jobs, nil, &runs))
+	assert.Empty(t, runs)
+	assert.Equal(t, http.StatusBadRequest, ts.callJSON("POST", "/api/v1/admin/sandboxes/yuki/backup/start", nil, nil))
+	t.Log("✅ 部署 job，不自动运行")
+
+	// 2. 手动执行立即返回，执行中再次触发返回 409
+	var run keeper.JobRun
+	assert.Equal(t, http.StatusAccepted, ts.callJSON("POST", jobs, nil, &run))
+	assert.Equal(t, keeper.JobStateRunning, run.State)
+	assert.Equal(t, keeper.JobTriggerManual, run.Trigger)
+	assert.NotZero(t, run.Pid)
+	assert.Equal(t, http.StatusConflict, ts.callJSON("POST", jobs, nil, nil))
+	t.Log("✅ 手动执行，不允许重叠")
+
+	// 3. 查询执行记录与输出
+	var done keeper.JobRun
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		done = keeper.JobRun{}
+		assert.Equal(t, http.StatusOK, ts.callJSON("GET", jobs+"/"+run.ID, nil, &done))
+		if done.State != keeper.JobStateRunning {
+			break
+		}
+	}
+	assert.Equal(t, keeper.JobStateSucceeded, done.State)
+	var output struct {
+		Lines []string `json:"lines"`
+	}
+	assert.Equal(t, http.StatusOK, ts.callJSON("GET", jobs+"/"+run.ID+"/output", nil, &output))
+	if assert.Len(t, output.Lines, 2) {
+		all := strings.Join(output.Lines, "\n")
+		assert.Contains(t, all, "[stdout] hello")
+		assert.Contains(t, all, "[stderr] oops")
+	}
+	runs = nil
+	ts.callJSON("GET", jobs, nil, &runs)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, http.StatusNotFound, ts.callJSON("GET", jobs+"/nope", nil, nil))
+	t.Log("✅ 执行记录与输出")
+}
+
+func TestSandboxSecrets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe is a shell script")
+	}
+	ts := newTestServer(t)
+	readBody := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+	secrets := "/api/v1/admin/sandboxes/amos/vault/secrets"
+	ts.createRepo("amos", "vault")
+
+	// 1. 设置与轮换，响应不含值
+	resp := ts.call("PUT", secrets+"/DB_PASSWORD", map[string]string{"value": "hunter2"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	body := readBody(resp)
+	assert.Contains(t, body, `"version":1`)
+	assert.NotContains(t, body, "hunter2")
+	resp = ts.call("PUT", secrets+"/DB_PASSWORD", map[string]string{"value": "s3cret"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, readBody(resp), `"version":2`)
+	assert.Equal(t, http.StatusCreated, ts.callJSON("PUT", secrets+"/API_TOKEN", map[string]string{"value": "tok-1"}, nil))
+
+	var list []map[string]interface{}
+	assert.Equal(t, http.StatusOK, ts.callJSON("GET", secrets, nil, &list))
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "API_TOKEN", list[0]["name"])
+		assert.Equal(t, "DB_PASSWORD", list[1]["name"])
+		assert.NotContains(t, list[1], "value")
+	}
+	t.Log("✅ 设置与轮换密钥，不返回值")
+
+	// 2. 磁盘上只有密文，主密钥文件为 0600
+	stored, err := os.ReadFile(filepath.Join(config.RepoDir, "amos", "vault.git", "data", "secrets.yml"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(stored), "s3cret")
+	if info, err := os.Stat(secret.KeyPath(config.RepoDir)); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	t.Log("✅ 加密保存")
+
+	// 3. 参数校验
+	assert.Equal(t, http.StatusBadRequest, ts.callJSON("PUT", secrets+"/bad-name", map[string]string{"value": "x"}, nil))
+	assert.Equal(t, http.StatusBadRequest, ts.callJSON("PUT", secrets+"/EMPTY", map[string]string{}, nil))
+	assert.Equal(t, http.StatusNotFound, ts.callJSON("PUT", "/api/v1/admin/sandboxes/amos/missing/secrets/X", map[string]string{"value": "x"}, nil))
+	assert.Equal(t, http.StatusNotFound, ts.callJSON("DELETE", secrets+"/NOPE", nil, nil))
+	t.Log("✅ 参数校验")
+
+	// 4. 引用的密钥被删除后不能启动
+	ts.pushPot("amos", "vault", map[string]string{
+		"pot.yml": "title: vault\ntype: exe\nenv:\n  - name: TOKEN\n    value: secret:API_TOKEN\n",
+		"pot.exe": "#!/bin/sh\nwhile true; do sleep 0.05; done\n",
+	})
+	assert.Equal(t, http.StatusNoContent, ts.callJSON("DELETE", secrets+"/API_TOKEN", nil, nil))
+	resp = ts.call("POST", "/api/v1/admin/sandboxes/amos/vault/start", nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Contains(t, readBody(resp), "secret API_TOKEN is not set")
+	t.Log("✅ 缺少密钥时拒绝启动")
+}
@@ -18,6 +18,9 @@ type AddCollaboratorOption struct {
 func (s *Server) ListCollaboratorsHandler(c *gin.Context) {
 	owner := c.Param("owner")
 	repoName := c.Param("repo")
+	if !s.authorizeRepo(c, owner, repoName, "read") {
+		return
+	}
 
 	collabs, err := s.repoService.ListCollaborators(c.Request.Context(), owner, repoName)
 	if err != nil {
@@ -36,6 +39,9 @@ func (s *Server) ListCollaboratorsHandler(c *gin.Context) {
 func (s *Server) CheckCollaboratorHandler(c *gin.Context) {
 	owner := c.Param("owner")
 	repoName := c.Param("repo")
+	if !s.authorizeRepo(c, owner, repoName, "read") {
+		return
+	}
 	collaborator := c.Param("collaborator")
 
 	isCollab, err := s.repoService.IsCollaborator(c.Request.Context(), owner, repoName, collaborator)
@@ -60,6 +66,9 @@ func (s *Server) CheckCollaboratorHandler(c *gin.Context) {
 func (s *Server) AddCollaboratorHandler(c *gin.Context) {
 	owner := c.Param("owner")
 	repoName := c.Param("repo")
+	if !s.authorizeRepo(c, owner, repoName, "admin") {
+		return
+	}
 	collaborator := c.Param("collaborator")
 
 	// 解析请求参数
@@ -94,6 +103,9 @@ func (s *Server) AddCollaboratorHandler(c *gin.Context) {
 func (s *Server) RemoveCollaboratorHandler(c *gin.Context) {
 	owner := c.Param("owner")
 	repoName := c.Param("repo")
+	if !s.authorizeRepo(c, owner, repoName, "admin") {
+		return
+	}
 	collaborator := c.Param("collaborator")
 
 	if err := s.repoService.RemoveCollaborator(c.Request.Context(), owner, repoName, collaborator); err != nil {
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"potstack/internal/auth"
+	"potstack/internal/git"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterGitRoutes 挂载需要认证的 Git Smart HTTP 服务：/repo/{owner}/{name}.git/*
+// 内部端口的 Git 服务不经过这里，仍然无认证
+func (s *Server) RegisterGitRoutes(r gin.IRouter) {
+	r.Any("/repo/:owner/:reponame/*action",
+		auth.TokenAuthMiddleware(),
+		s.GitAccessMiddleware(),
+		git.SmartHTTPServer(),
+	)
+}
+
+// GitAccessMiddleware 按协作者权限校验 Git 操作
+// 拉取（git-upload-pack）需要 read，推送（git-receive-pack）需要 write，所有者隐含 admin
+func (s *Server) GitAccessMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		owner := c.Param("owner")
+		repoName := strings.TrimSuffix(c.Param("reponame"), ".git")
+
+		need, scope := "read", auth.ScopeRepoRead
+		if isReceivePack(c) {
+			need, scope = "write", auth.ScopeRepoWrite
+		}
+
+		if !auth.HasScope(c, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token lacks scope: " + scope})
+			return
+		}
+		if !s.authorizeRepo(c, owner, repoName, need) {
+			return
+		}
+		// 推送者传递给 receive-pack 钩子
+		if user := auth.CurrentUser(c); user != nil {
+			c.Request = c.Request.WithContext(git.WithPusher(c.Request.Context(), user.Username))
+		}
+		c.Next()
+	}
+}
+
+// isReceivePack 判断请求是否属于推送流程（含 info/refs 广告阶段）
+func isReceivePack(c *gin.Context) bool {
+	action := c.Param("action")
+	if strings.HasSuffix(action, "/git-receive-pack") {
+		return true
+	}
+	return strings.HasSuffix(action, "/info/refs") && c.Query("service") == "git-receive-pack"
+}
@@ -1,5 +1,7 @@
 package api
 
+import "potstack/internal/db"
+
 // CreateRepoOption 代表创建仓库的选项
 type CreateRepoOption struct {
 	Name        string `json:"name" binding:"required"`
@@ -32,3 +34,34 @@ type Repository struct {
 	CloneURL    string `json:"clone_url"`
 	UUID        string `json:"uuid"`
 }
+
+// CreateTokenOption 代表创建个人访问令牌的选项
+type CreateTokenOption struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+}
+
+// AccessToken 代表新建的个人访问令牌（明文仅在创建时返回一次）
+type AccessToken struct {
+	*db.AccessToken
+	Token string `json:"token"`
+}
+
+// CreateSSHKeyOption 代表添加 SSH 公钥的选项
+type CreateSSHKeyOption struct {
+	Title string `json:"title"` // 为空时使用公钥注释
+	Key   string `json:"key" binding:"required"`
+}
+
+// SetSecretOption 代表设置 pot 密钥的选项
+type SetSecretOption struct {
+	Value *string `json:"value" binding:"required"` // 可以为空字符串
+}
+
+// CreateWebhookOption 代表创建 webhook 的选项
+type CreateWebhookOption struct {
+	URL    string   `json:"url" binding:"required"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"` // 为空表示订阅全部事件
+	Active *bool    `json:"active"` // 默认 true
+}
@@ -36,7 +36,7 @@ func (s *Server) CreateRepoHandler(c *gin.Context) {
 	if c.Request.TLS != nil {
 		scheme = "https"
 	}
-	repo.CloneURL = fmt.Sprintf("%s://%s/%s/%s.git", scheme, c.Request.Host, username, opt.Name)
+	repo.CloneURL = fmt.Sprintf("%s://%s/repo/%s/%s.git", scheme, c.Request.Host, username, opt.Name)
 
 	c.JSON(http.StatusCreated, repo)
 }
@@ -45,6 +45,9 @@ func (s *Server) CreateRepoHandler(c *gin.Context) {
 func (s *Server) DeleteRepoHandler(c *gin.Context) {
 	owner := c.Param("owner")
 	repoName := c.Param("repo")
+	if !s.authorizeRepo(c, owner, repoName, "admin") {
+		return
+	}
 
 	if err := s.repoService.DeleteRepo(c.Request.Context(), owner, repoName); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -58,6 +61,9 @@ func (s *Server) DeleteRepoHandler(c *gin.Context) {
 func (s *Server) GetRepoHandler(c *gin.Context) {
 	owner := c.Param("owner")
 	repoName := c.Param("repo")
+	if !s.authorizeRepo(c, owner, repoName, "read") {
+		return
+	}
 
 	repo, err := s.repoService.GetRepo(c.Request.Context(), owner, repoName)
 	if err != nil {
@@ -74,7 +80,7 @@ func (s *Server) GetRepoHandler(c *gin.Context) {
 	if c.Request.TLS != nil {
 		scheme = "https"
 	}
-	repo.CloneURL = fmt.Sprintf("%s://%s/%s/%s.git", scheme, c.Request.Host, owner, repoName)
+	repo.CloneURL = fmt.Sprintf("%s://%s/repo/%s/%s.git", scheme, c.Request.Host, owner, repoName)
 
 	c.JSON(http.StatusOK, repo)
 }
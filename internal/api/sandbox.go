@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"potstack/internal/keeper"
+	"potstack/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSandboxHandler 处理 GET /api/v1/repos/:owner/:repo/sandbox 请求
+func (s *Server) GetSandboxHandler(c *gin.Context) {
+	owner := c.Param("owner")
+	repoName := c.Param("repo")
+	if !s.authorizeRepo(c, owner, repoName, "read") {
+		return
+	}
+
+	st, err := s.sandboxService.GetSandbox(c.Request.Context(), owner, repoName)
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, st)
+}
+
+// ListSandboxesHandler 处理 GET /api/v1/admin/sandboxes 请求
+func (s *Server) ListSandboxesHandler(c *gin.Context) {
+	list, err := s.sandboxService.ListSandboxes(c.Request.Context())
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// StartSandboxHandler 处理 POST /api/v1/admin/sandboxes/:owner/:repo/start 请求
+func (s *Server) StartSandboxHandler(c *gin.Context) {
+	s.controlSandbox(c, s.sandboxService.StartSandbox)
+}
+
+// StopSandboxHandler 处理 POST /api/v1/admin/sandboxes/:owner/:repo/stop 请求
+func (s *Server) StopSandboxHandler(c *gin.Context) {
+	s.controlSandbox(c, s.sandboxService.StopSandbox)
+}
+
+// RestartSandboxHandler 处理 POST /api/v1/admin/sandboxes/:owner/:repo/restart 请求
+func (s *Server) RestartSandboxHandler(c *gin.Context) {
+	s.controlSandbox(c, s.sandboxService.RestartSandbox)
+}
+
+// RedeploySandboxHandler 处理 POST /api/v1/admin/sandboxes/:owner/:repo/redeploy 请求
+func (s *Server) RedeploySandboxHandler(c *gin.Context) {
+	s.controlSandbox(c, s.sandboxService.RedeploySandbox)
+}
+
+func (s *Server) controlSandbox(c *gin.Context, op func(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error)) {
+	st, err := op(c.Request.Context(), c.Param("owner"), c.Param("repo"))
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, st)
+}
+
+// defaultLogLines 未指定 lines 时返回的日志行数
+const defaultLogLines = 100
+
+// SandboxLogsHandler 处理 GET /api/v1/admin/sandboxes/:owner/:repo/logs 请求
+// 返回控制台日志的最后 lines 行；follow=true 时以 SSE 推送这些行以及之后的新行
+func (s *Server) SandboxLogsHandler(c *gin.Context) {
+	owner := c.Param("owner")
+	repoName := c.Param("repo")
+
+	lines := defaultLogLines
+	if v := c.Query("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lines"})
+			return
+		}
+		lines = n
+	}
+	follow, _ := strconv.ParseBool(c.Query("follow"))
+
+	ctx := c.Request.Context()
+	var live <-chan string
+	if follow {
+		// 先订阅再读取历史，避免两者之间的日志丢失
+		ch, err := s.sandboxService.FollowLogs(ctx, owner, repoName)
+		if err != nil {
+			writeSandboxError(c, err)
+			return
+		}
+		live = ch
+	}
+
+	tail, err := s.sandboxService.TailLogs(ctx, owner, repoName, lines)
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+	if !follow {
+		c.JSON(http.StatusOK, gin.H{"lines": tail})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for _, line := range tail {
+		c.SSEvent("log", line)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case line, ok := <-live:
+			if !ok {
+				return false
+			}
+			c.SSEvent("log", line)
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case <-ctx.Done():
+			return false
+		}
+		return true
+	})
+}
+
+// RunJobHandler 处理 POST /api/v1/admin/sandboxes/:owner/:repo/jobs 请求
+// 立即执行一次 job pot，不等待结束
+func (s *Server) RunJobHandler(c *gin.Context) {
+	run, err := s.sandboxService.RunJob(c.Request.Context(), c.Param("owner"), c.Param("repo"))
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// ListJobRunsHandler 处理 GET /api/v1/admin/sandboxes/:owner/:repo/jobs 请求
+func (s *Server) ListJobRunsHandler(c *gin.Context) {
+	runs, err := s.sandboxService.ListJobRuns(c.Request.Context(), c.Param("owner"), c.Param("repo"))
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, runs)
+}
+
+// GetJobRunHandler 处理 GET /api/v1/admin/sandboxes/:owner/:repo/jobs/:id 请求
+func (s *Server) GetJobRunHandler(c *gin.Context) {
+	run, err := s.sandboxService.GetJobRun(c.Request.Context(), c.Param("owner"), c.Param("repo"), c.Param("id"))
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// JobOutputHandler 处理 GET /api/v1/admin/sandboxes/:owner/:repo/jobs/:id/output 请求
+func (s *Server) JobOutputHandler(c *gin.Context) {
+	lines, err := s.sandboxService.JobOutput(c.Request.Context(), c.Param("owner"), c.Param("repo"), c.Param("id"))
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lines": lines})
+}
+
+// ListSecretsHandler 处理 GET /api/v1/admin/sandboxes/:owner/:repo/secrets 请求
+// 只返回名称与版本，不返回值
+func (s *Server) ListSecretsHandler(c *gin.Context) {
+	list, err := s.sandboxService.ListSecrets(c.Request.Context(), c.Param("owner"), c.Param("repo"))
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// SetSecretHandler 处理 PUT /api/v1/admin/sandboxes/:owner/:repo/secrets/:name 请求
+// 新建时返回 201，轮换已有的密钥时返回 200
+func (s *Server) SetSecretHandler(c *gin.Context) {
+	var opt SetSecretOption
+	if err := c.ShouldBindJSON(&opt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sec, created, err := s.sandboxService.SetSecret(c.Request.Context(), c.Param("owner"), c.Param("repo"), c.Param("name"), *opt.Value)
+	if err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	if created {
+		c.JSON(http.StatusCreated, sec)
+		return
+	}
+	c.JSON(http.StatusOK, sec)
+}
+
+// DeleteSecretHandler 处理 DELETE /api/v1/admin/sandboxes/:owner/:repo/secrets/:name 请求
+func (s *Server) DeleteSecretHandler(c *gin.Context) {
+	if err := s.sandboxService.DeleteSecret(c.Request.Context(), c.Param("owner"), c.Param("repo"), c.Param("name")); err != nil {
+		writeSandboxError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func writeSandboxError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrSandboxNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "sandbox not found"})
+	case errors.Is(err, service.ErrJobRunNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "job run not found"})
+	case errors.Is(err, service.ErrRepoNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "repository not found"})
+	case errors.Is(err, service.ErrSecretNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "secret not found"})
+	case errors.Is(err, service.ErrInvalidParam):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSandboxRunning), errors.Is(err, service.ErrJobRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSandboxUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,17 +1,89 @@
 package api
 
 import (
+	"potstack/internal/auth"
 	"potstack/internal/service"
+
+	"github.com/gin-gonic/gin"
 )
 
 type Server struct {
-	userService service.IUserService
-	repoService service.IRepoService
+	userService    service.IUserService
+	repoService    service.IRepoService
+	tokenService   service.ITokenService
+	webhookService service.IWebhookService
+	sandboxService service.ISandboxService
 }
 
-func NewServer(us service.IUserService, rs service.IRepoService) *Server {
+func NewServer(us service.IUserService, rs service.IRepoService, ts service.ITokenService, ws service.IWebhookService, ss service.ISandboxService) *Server {
 	return &Server{
-		userService: us,
-		repoService: rs,
+		userService:    us,
+		repoService:    rs,
+		tokenService:   ts,
+		webhookService: ws,
+		sandboxService: ss,
 	}
 }
+
+// RegisterRoutes 挂载 /api/v1 管理接口（全部经过 Token 认证）
+func (s *Server) RegisterRoutes(r gin.IRouter) {
+	v1 := r.Group("/api/v1", auth.TokenAuthMiddleware())
+
+	// 用户与证书管理
+	admin := v1.Group("/admin", auth.RequireScope(auth.ScopeAdmin))
+	admin.POST("/users", s.CreateUserHandler)
+	admin.DELETE("/users/:username", s.DeleteUserHandler)
+	admin.POST("/users/:username/repos", s.CreateRepoHandler)
+	admin.GET("/certs/info", CertInfoHandler)
+	admin.POST("/certs/renew", CertRenewHandler)
+	admin.POST("/hooks", s.CreateWebhookHandler)
+	admin.GET("/hooks", s.ListWebhooksHandler)
+	admin.GET("/hooks/:id", s.GetWebhookHandler)
+	admin.DELETE("/hooks/:id", s.DeleteWebhookHandler)
+	admin.GET("/hooks/:id/deliveries", s.ListDeliveriesHandler)
+	admin.POST("/hooks/:id/deliveries/:delivery/redeliver", s.RedeliverHandler)
+	admin.GET("/sandboxes", s.ListSandboxesHandler)
+	admin.GET("/sandboxes/:owner/:repo", s.GetSandboxHandler)
+	admin.GET("/sandboxes/:owner/:repo/logs", s.SandboxLogsHandler)
+	admin.GET("/sandboxes/:owner/:repo/jobs", s.ListJobRunsHandler)
+	admin.GET("/sandboxes/:owner/:repo/jobs/:id", s.GetJobRunHandler)
+	admin.GET("/sandboxes/:owner/:repo/jobs/:id/output", s.JobOutputHandler)
+	admin.GET("/sandboxes/:owner/:repo/secrets", s.ListSecretsHandler)
+	admin.PUT("/sandboxes/:owner/:repo/secrets/:name", s.SetSecretHandler)
+	admin.DELETE("/sandboxes/:owner/:repo/secrets/:name", s.DeleteSecretHandler)
+
+	// 沙箱启停、重新部署与手动执行 job（sandbox:control，admin 包含该范围）
+	control := v1.Group("/admin/sandboxes", auth.RequireScope(auth.ScopeSandboxControl))
+	control.POST("/:owner/:repo/start", s.StartSandboxHandler)
+	control.POST("/:owner/:repo/stop", s.StopSandboxHandler)
+	control.POST("/:owner/:repo/restart", s.RestartSandboxHandler)
+	control.POST("/:owner/:repo/redeploy", s.RedeploySandboxHandler)
+	control.POST("/:owner/:repo/jobs", s.RunJobHandler)
+
+	// 个人访问令牌与 SSH 公钥（本人或 admin）
+	users := v1.Group("/users")
+	users.POST("/:username/tokens", s.CreateTokenHandler)
+	users.GET("/:username/tokens", s.ListTokensHandler)
+	users.DELETE("/:username/tokens/:id", s.DeleteTokenHandler)
+	users.POST("/:username/keys", s.CreateSSHKeyHandler)
+	users.GET("/:username/keys", s.ListSSHKeysHandler)
+	users.DELETE("/:username/keys/:id", s.DeleteSSHKeyHandler)
+
+	// 仓库与协作者管理（除令牌范围外，处理函数还会校验调用者对仓库的权限）
+	read := auth.RequireScope(auth.ScopeRepoRead)
+	write := auth.RequireScope(auth.ScopeRepoWrite)
+	repos := v1.Group("/repos")
+	repos.GET("/:owner/:repo", read, s.GetRepoHandler)
+	repos.DELETE("/:owner/:repo", write, s.DeleteRepoHandler)
+	repos.GET("/:owner/:repo/collaborators", read, s.ListCollaboratorsHandler)
+	repos.GET("/:owner/:repo/collaborators/:collaborator", read, s.CheckCollaboratorHandler)
+	repos.PUT("/:owner/:repo/collaborators/:collaborator", write, s.AddCollaboratorHandler)
+	repos.DELETE("/:owner/:repo/collaborators/:collaborator", write, s.RemoveCollaboratorHandler)
+	repos.POST("/:owner/:repo/hooks", write, s.CreateWebhookHandler)
+	repos.GET("/:owner/:repo/hooks", read, s.ListWebhooksHandler)
+	repos.GET("/:owner/:repo/hooks/:id", read, s.GetWebhookHandler)
+	repos.DELETE("/:owner/:repo/hooks/:id", write, s.DeleteWebhookHandler)
+	repos.GET("/:owner/:repo/hooks/:id/deliveries", read, s.ListDeliveriesHandler)
+	repos.POST("/:owner/:repo/hooks/:id/deliveries/:delivery/redeliver", write, s.RedeliverHandler)
+	repos.GET("/:owner/:repo/sandbox", read, s.GetSandboxHandler)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"potstack/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateSSHKeyHandler 处理 POST /api/v1/users/:username/keys 请求
+func (s *Server) CreateSSHKeyHandler(c *gin.Context) {
+	username := c.Param("username")
+	if !authorizeUser(c, username) {
+		return
+	}
+
+	var opt CreateSSHKeyOption
+	if err := c.ShouldBindJSON(&opt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := s.userService.AddSSHKey(c.Request.Context(), username, opt.Title, opt.Key)
+	if err != nil {
+		writeSSHKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+// ListSSHKeysHandler 处理 GET /api/v1/users/:username/keys 请求
+func (s *Server) ListSSHKeysHandler(c *gin.Context) {
+	username := c.Param("username")
+	if !authorizeUser(c, username) {
+		return
+	}
+
+	keys, err := s.userService.ListSSHKeys(c.Request.Context(), username)
+	if err != nil {
+		writeSSHKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// DeleteSSHKeyHandler 处理 DELETE /api/v1/users/:username/keys/:id 请求
+func (s *Server) DeleteSSHKeyHandler(c *gin.Context) {
+	username := c.Param("username")
+	if !authorizeUser(c, username) {
+		return
+	}
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := s.userService.DeleteSSHKey(c.Request.Context(), username, id); err != nil {
+		writeSSHKeyError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func writeSSHKeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound), errors.Is(err, service.ErrSSHKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSSHKeyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidParam):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
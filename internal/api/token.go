@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"potstack/internal/auth"
+	"potstack/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateTokenHandler 处理 POST /api/v1/users/:username/tokens 请求
+func (s *Server) CreateTokenHandler(c *gin.Context) {
+	username := c.Param("username")
+	if !authorizeUser(c, username) {
+		return
+	}
+
+	var opt CreateTokenOption
+	if err := c.ShouldBindJSON(&opt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 不允许签发超出调用者自身权限的令牌
+	for _, scope := range opt.Scopes {
+		if auth.IsValidScope(scope) && !auth.HasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "cannot grant scope: " + scope})
+			return
+		}
+	}
+
+	token, plain, err := s.tokenService.CreateToken(c.Request.Context(), username, opt.Name, opt.Scopes)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		} else if errors.Is(err, service.ErrInvalidParam) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, AccessToken{AccessToken: token, Token: plain})
+}
+
+// ListTokensHandler 处理 GET /api/v1/users/:username/tokens 请求
+func (s *Server) ListTokensHandler(c *gin.Context) {
+	username := c.Param("username")
+	if !authorizeUser(c, username) {
+		return
+	}
+
+	tokens, err := s.tokenService.ListTokens(c.Request.Context(), username)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// DeleteTokenHandler 处理 DELETE /api/v1/users/:username/tokens/:id 请求
+func (s *Server) DeleteTokenHandler(c *gin.Context) {
+	username := c.Param("username")
+	if !authorizeUser(c, username) {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+		return
+	}
+
+	if err := s.tokenService.DeleteToken(c.Request.Context(), username, id); err != nil {
+		if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
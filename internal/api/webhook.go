@@ -0,0 +1,172 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"potstack/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 同一组处理函数同时服务于两类路由：
+//   /api/v1/repos/:owner/:repo/hooks  仓库 webhook（需要仓库 admin 权限）
+//   /api/v1/admin/hooks               全局 webhook（需要 admin 令牌，路由上无 owner 参数）
+
+// CreateWebhookHandler 处理 POST .../hooks 请求
+func (s *Server) CreateWebhookHandler(c *gin.Context) {
+	owner, repoName, ok := s.webhookScope(c)
+	if !ok {
+		return
+	}
+
+	var opt CreateWebhookOption
+	if err := c.ShouldBindJSON(&opt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	active := true
+	if opt.Active != nil {
+		active = *opt.Active
+	}
+
+	hook, err := s.webhookService.CreateWebhook(c.Request.Context(), owner, repoName, opt.URL, opt.Secret, opt.Events, active)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, hook)
+}
+
+// ListWebhooksHandler 处理 GET .../hooks 请求
+func (s *Server) ListWebhooksHandler(c *gin.Context) {
+	owner, repoName, ok := s.webhookScope(c)
+	if !ok {
+		return
+	}
+
+	hooks, err := s.webhookService.ListWebhooks(c.Request.Context(), owner, repoName)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hooks)
+}
+
+// GetWebhookHandler 处理 GET .../hooks/:id 请求
+func (s *Server) GetWebhookHandler(c *gin.Context) {
+	owner, repoName, ok := s.webhookScope(c)
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	hook, err := s.webhookService.GetWebhook(c.Request.Context(), owner, repoName, id)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// DeleteWebhookHandler 处理 DELETE .../hooks/:id 请求
+func (s *Server) DeleteWebhookHandler(c *gin.Context) {
+	owner, repoName, ok := s.webhookScope(c)
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := s.webhookService.DeleteWebhook(c.Request.Context(), owner, repoName, id); err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveriesHandler 处理 GET .../hooks/:id/deliveries 请求
+func (s *Server) ListDeliveriesHandler(c *gin.Context) {
+	owner, repoName, ok := s.webhookScope(c)
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	deliveries, err := s.webhookService.ListDeliveries(c.Request.Context(), owner, repoName, id)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// RedeliverHandler 处理 POST .../hooks/:id/deliveries/:delivery/redeliver 请求
+func (s *Server) RedeliverHandler(c *gin.Context) {
+	owner, repoName, ok := s.webhookScope(c)
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+	deliveryID, ok := parseIDParam(c, "delivery")
+	if !ok {
+		return
+	}
+
+	delivery, err := s.webhookService.Redeliver(c.Request.Context(), owner, repoName, id, deliveryID)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// webhookScope 返回路由对应的仓库（全局路由为空），并校验调用者对仓库的 admin 权限
+func (s *Server) webhookScope(c *gin.Context) (string, string, bool) {
+	owner := c.Param("owner")
+	repoName := c.Param("repo")
+	if owner != "" && !s.authorizeRepo(c, owner, repoName, "admin") {
+		return "", "", false
+	}
+	return owner, repoName, true
+}
+
+func parseIDParam(c *gin.Context, name string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + " id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func writeWebhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrRepoNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "repository not found"})
+	case errors.Is(err, service.ErrWebhookNotFound), errors.Is(err, service.ErrDeliveryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidParam):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,45 +1,142 @@
 package auth
 
 import (
+	"crypto/subtle"
+	"log"
 	"net/http"
 	"strings"
 
 	"potstack/config"
+	"potstack/internal/db"
 
 	"github.com/gin-gonic/gin"
 )
 
+// gin.Context 中保存调用者信息的键
+const (
+	ContextUserKey   = "potstack.user"
+	ContextScopesKey = "potstack.scopes"
+)
+
+// SystemUsername 系统令牌（POTSTACK_TOKEN）对应的调用者
+const SystemUsername = "potstack"
+
 // TokenAuthMiddleware 令牌认证中间件
 // 支持两种认证方式：
 // 1. Token 方式: Authorization: token <TOKEN>
-// 2. Basic Auth 方式: Authorization: Basic base64(TOKEN:) 或 base64(:TOKEN)
+// 2. Basic Auth 方式: Authorization: Basic base64(TOKEN:) 或 base64(:TOKEN) 或 base64(user:TOKEN)
+//
+// TOKEN 可以是系统令牌 POTSTACK_TOKEN（拥有全部权限），也可以是用户的个人访问令牌。
+// 认证成功后调用者用户与权限范围写入 gin.Context，见 CurrentUser / HasScope。
 func TokenAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if config.PotStackToken == "" {
-			// 如果未配置令牌，允许所有请求（仅用于开发）
-			c.Next()
-			return
-		}
-
-		// 尝试 Token 方式认证
-		authHeader := c.GetHeader("Authorization")
-		if strings.HasPrefix(authHeader, "token ") {
-			token := strings.TrimPrefix(authHeader, "token ")
-			if token == config.PotStackToken {
+		for _, token := range credentials(c.Request) {
+			if user, scopes, ok := resolveToken(token); ok {
+				c.Set(ContextUserKey, user)
+				c.Set(ContextScopesKey, scopes)
 				c.Next()
 				return
 			}
 		}
 
-		// 尝试 Basic Auth 方式认证
-		user, password, hasAuth := c.Request.BasicAuth()
-		if hasAuth && (user == config.PotStackToken || password == config.PotStackToken) {
-			c.Next()
-			return
-		}
-
 		// 认证失败
 		c.Header("WWW-Authenticate", `Basic realm="PotStack"`)
 		c.AbortWithStatus(http.StatusUnauthorized)
 	}
 }
+
+// RequireScope 要求调用者拥有指定权限范围（需在 TokenAuthMiddleware 之后使用）
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasScope(c, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token lacks scope: " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentUser 返回当前调用者，未认证时为 nil
+func CurrentUser(c *gin.Context) *db.User {
+	if v, ok := c.Get(ContextUserKey); ok {
+		if user, ok := v.(*db.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// HasScope 判断当前调用者是否拥有指定权限范围
+func HasScope(c *gin.Context, scope string) bool {
+	if v, ok := c.Get(ContextScopesKey); ok {
+		if scopes, ok := v.([]string); ok {
+			return ScopesAllow(scopes, scope)
+		}
+	}
+	return false
+}
+
+// credentials 从请求中提取候选令牌
+func credentials(r *http.Request) []string {
+	var tokens []string
+
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "token ") {
+		tokens = append(tokens, strings.TrimPrefix(authHeader, "token "))
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		if password != "" {
+			tokens = append(tokens, password)
+		}
+		if user != "" {
+			tokens = append(tokens, user)
+		}
+	}
+	return tokens
+}
+
+// resolveToken 将令牌解析为调用者和权限范围
+func resolveToken(token string) (*db.User, []string, bool) {
+	if token == "" {
+		return nil, nil, false
+	}
+
+	// 1. 系统令牌
+	if config.PotStackToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(config.PotStackToken)) == 1 {
+		return systemUser(), []string{ScopeAdmin}, true
+	}
+
+	// 2. 个人访问令牌
+	if !strings.HasPrefix(token, TokenPrefix) || !db.IsReady() {
+		return nil, nil, false
+	}
+	pat, err := db.GetAccessTokenByHash(HashToken(token))
+	if err != nil {
+		log.Printf("Failed to look up access token: %v", err)
+		return nil, nil, false
+	}
+	if pat == nil {
+		return nil, nil, false
+	}
+	user, err := db.GetUserByID(pat.UserID)
+	if err != nil || user == nil {
+		return nil, nil, false
+	}
+	if err := db.TouchAccessToken(pat.ID); err != nil {
+		log.Printf("Failed to update access token usage: %v", err)
+	}
+	return user, pat.Scopes, true
+}
+
+// systemUser 返回系统令牌对应的用户（数据库中不存在时返回内置管理员）
+func systemUser() *db.User {
+	if db.IsReady() {
+		if user, err := db.GetUserByUsername(SystemUsername); err == nil && user != nil {
+			user.IsAdmin = true
+			return user
+		}
+	}
+	return &db.User{Username: SystemUsername, Login: SystemUsername, IsAdmin: true}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// 令牌权限范围
+const (
+	ScopeRepoRead       = "repo:read"       // 读取仓库信息、拉取代码
+	ScopeRepoWrite      = "repo:write"      // 修改仓库、推送代码（包含 repo:read）
+	ScopeAdmin          = "admin"           // 系统管理（包含所有权限）
+	ScopeSandboxControl = "sandbox:control" // 控制沙箱启停
+)
+
+// TokenPrefix 个人访问令牌前缀，便于识别与泄露扫描
+const TokenPrefix = "pst_"
+
+// AllScopes 返回所有合法的权限范围
+func AllScopes() []string {
+	return []string{ScopeRepoRead, ScopeRepoWrite, ScopeAdmin, ScopeSandboxControl}
+}
+
+// IsValidScope 判断权限范围是否合法
+func IsValidScope(scope string) bool {
+	for _, s := range AllScopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopesAllow 判断已授予的范围是否满足所需范围
+// admin 包含所有权限，repo:write 包含 repo:read
+func ScopesAllow(granted []string, required string) bool {
+	for _, s := range granted {
+		switch {
+		case s == required:
+			return true
+		case s == ScopeAdmin:
+			return true
+		case s == ScopeRepoWrite && required == ScopeRepoRead:
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateToken 生成新的个人访问令牌明文
+func GenerateToken() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return TokenPrefix + hex.EncodeToString(b), nil
+}
+
+// HashToken 计算令牌哈希（数据库只保存哈希）
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// AccessToken 个人访问令牌模型（只保存哈希，不保存明文）
+type AccessToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"-"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreateAccessToken 创建访问令牌
+func CreateAccessToken(userID int64, name, tokenHash string, scopes []string) (*AccessToken, error) {
+	result, err := db.Exec(
+		`INSERT INTO access_token (user_id, name, token_hash, scopes) VALUES (?, ?, ?, ?)`,
+		userID, name, tokenHash, strings.Join(scopes, ","),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	return GetAccessTokenByID(id)
+}
+
+// GetAccessTokenByID 根据 ID 获取令牌
+func GetAccessTokenByID(id int64) (*AccessToken, error) {
+	return scanAccessToken(db.QueryRow(
+		`SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at
+		 FROM access_token WHERE id = ?`, id,
+	))
+}
+
+// GetAccessTokenByHash 根据令牌哈希获取令牌
+func GetAccessTokenByHash(tokenHash string) (*AccessToken, error) {
+	return scanAccessToken(db.QueryRow(
+		`SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at
+		 FROM access_token WHERE token_hash = ?`, tokenHash,
+	))
+}
+
+// GetAccessTokensByUser 获取用户的所有令牌
+func GetAccessTokensByUser(userID int64) ([]*AccessToken, error) {
+	rows, err := db.Query(
+		`SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at
+		 FROM access_token WHERE user_id = ? ORDER BY id`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*AccessToken
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// CountAccessTokens 返回所有用户的令牌总数
+func CountAccessTokens() (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM access_token`).Scan(&n)
+	return n, err
+}
+
+// DeleteAccessToken 吊销令牌，返回是否存在
+func DeleteAccessToken(userID, id int64) (bool, error) {
+	result, err := db.Exec(`DELETE FROM access_token WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// TouchAccessToken 更新令牌最后使用时间
+func TouchAccessToken(id int64) error {
+	_, err := db.Exec(`UPDATE access_token SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAccessToken(row rowScanner) (*AccessToken, error) {
+	token := &AccessToken{}
+	var scopes string
+	var lastUsed sql.NullTime
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.TokenHash,
+		&scopes, &token.CreatedAt, &lastUsed)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = []string{}
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsed.Valid {
+		token.LastUsedAt = &lastUsed.Time
+	}
+	return token, nil
+}
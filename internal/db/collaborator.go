@@ -39,6 +39,22 @@ func PermissionToPermissions(permission string) *Permissions {
 	}
 }
 
+// permissionLevels 权限等级（数值越大权限越高）
+var permissionLevels = map[string]int{
+	"read":  1,
+	"write": 2,
+	"admin": 3,
+}
+
+// PermissionAllows 判断已有权限是否满足所需权限（admin > write > read）
+func PermissionAllows(granted, required string) bool {
+	g, ok := permissionLevels[granted]
+	if !ok {
+		return false
+	}
+	return g >= permissionLevels[required]
+}
+
 // AddCollaborator 添加协作者
 func AddCollaborator(repoID, userID int64, permission string) error {
 	if permission == "" {
@@ -133,6 +133,64 @@ func initTables() error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_collaborator_repo_id ON collaborator(repo_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_collaborator_user_id ON collaborator(user_id)`,
+
+		// 个人访问令牌表
+		`CREATE TABLE IF NOT EXISTS access_token (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id      INTEGER NOT NULL,
+			name         TEXT NOT NULL,
+			token_hash   TEXT NOT NULL UNIQUE,
+			scopes       TEXT DEFAULT '',
+			created_at   DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_access_token_user_id ON access_token(user_id)`,
+
+		// SSH 公钥表（用于 SSH Git 传输的认证，同一把公钥只能属于一个用户）
+		`CREATE TABLE IF NOT EXISTS ssh_key (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id      INTEGER NOT NULL,
+			title        TEXT NOT NULL,
+			content      TEXT NOT NULL,
+			fingerprint  TEXT NOT NULL UNIQUE,
+			created_at   DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_ssh_key_user_id ON ssh_key(user_id)`,
+
+		// Webhook 表（repo_id 为 NULL 表示全局 webhook）
+		`CREATE TABLE IF NOT EXISTS webhook (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			repo_id     INTEGER,
+			url         TEXT NOT NULL,
+			secret      TEXT DEFAULT '',
+			events      TEXT DEFAULT '',
+			active      INTEGER DEFAULT 1,
+			created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (repo_id) REFERENCES repository(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_repo_id ON webhook(repo_id)`,
+
+		// Webhook 投递记录表
+		`CREATE TABLE IF NOT EXISTS webhook_delivery (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id      INTEGER NOT NULL,
+			guid            TEXT NOT NULL,
+			event           TEXT NOT NULL,
+			payload         TEXT NOT NULL,
+			status          TEXT DEFAULT 'pending',
+			attempts        INTEGER DEFAULT 0,
+			response_status INTEGER DEFAULT 0,
+			response_body   TEXT DEFAULT '',
+			error           TEXT DEFAULT '',
+			created_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
+			delivered_at    DATETIME,
+			FOREIGN KEY (webhook_id) REFERENCES webhook(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_delivery_webhook_id ON webhook_delivery(webhook_id)`,
 	}
 
 	for _, schema := range schemas {
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SSHKey 用户的 SSH 公钥（authorized_keys 格式）
+type SSHKey struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"-"`
+	Title       string     `json:"title"`
+	Content     string     `json:"key"`
+	Fingerprint string     `json:"fingerprint"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreateSSHKey 添加 SSH 公钥
+func CreateSSHKey(userID int64, title, content, fingerprint string) (*SSHKey, error) {
+	result, err := db.Exec(
+		`INSERT INTO ssh_key (user_id, title, content, fingerprint) VALUES (?, ?, ?, ?)`,
+		userID, title, content, fingerprint,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	return scanSSHKey(db.QueryRow(
+		`SELECT id, user_id, title, content, fingerprint, created_at, last_used_at
+		 FROM ssh_key WHERE id = ?`, id,
+	))
+}
+
+// GetSSHKeyByFingerprint 根据指纹（SHA256:...）获取公钥
+func GetSSHKeyByFingerprint(fingerprint string) (*SSHKey, error) {
+	return scanSSHKey(db.QueryRow(
+		`SELECT id, user_id, title, content, fingerprint, created_at, last_used_at
+		 FROM ssh_key WHERE fingerprint = ?`, fingerprint,
+	))
+}
+
+// GetSSHKeysByUser 获取用户的所有公钥
+func GetSSHKeysByUser(userID int64) ([]*SSHKey, error) {
+	rows, err := db.Query(
+		`SELECT id, user_id, title, content, fingerprint, created_at, last_used_at
+		 FROM ssh_key WHERE user_id = ? ORDER BY id`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*SSHKey
+	for rows.Next() {
+		key, err := scanSSHKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// DeleteSSHKey 删除公钥，返回是否存在
+func DeleteSSHKey(userID, id int64) (bool, error) {
+	result, err := db.Exec(`DELETE FROM ssh_key WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// TouchSSHKey 更新公钥最后使用时间
+func TouchSSHKey(id int64) error {
+	_, err := db.Exec(`UPDATE ssh_key SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
+
+func scanSSHKey(row rowScanner) (*SSHKey, error) {
+	key := &SSHKey{}
+	var lastUsed sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Title, &key.Content,
+		&key.Fingerprint, &key.CreatedAt, &lastUsed)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	return key, nil
+}
@@ -0,0 +1,255 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Webhook 投递状态
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook 模型，RepoID 为 0 表示全局 webhook（接收所有仓库及系统事件）
+type Webhook struct {
+	ID        int64     `json:"id"`
+	RepoID    int64     `json:"-"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	HasSecret bool      `json:"has_secret"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subscribes 判断 webhook 是否订阅了事件（未指定事件表示订阅全部）
+func (w *Webhook) Subscribes(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery 一次事件投递（含重试）的记录
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	GUID           string          `json:"guid"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status"`
+	ResponseBody   string          `json:"response_body"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// CreateWebhook 创建 webhook，repoID 为 0 表示全局
+func CreateWebhook(repoID int64, url, secret string, events []string, active bool) (*Webhook, error) {
+	result, err := db.Exec(
+		`INSERT INTO webhook (repo_id, url, secret, events, active) VALUES (?, ?, ?, ?, ?)`,
+		nullableID(repoID), url, secret, strings.Join(events, ","), active,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	return GetWebhookByID(id)
+}
+
+// GetWebhookByID 根据 ID 获取 webhook
+func GetWebhookByID(id int64) (*Webhook, error) {
+	return scanWebhook(db.QueryRow(
+		`SELECT id, repo_id, url, secret, events, active, created_at, updated_at
+		 FROM webhook WHERE id = ?`, id,
+	))
+}
+
+// GetWebhooksByRepo 获取仓库的 webhook，repoID 为 0 时获取全局 webhook
+func GetWebhooksByRepo(repoID int64) ([]*Webhook, error) {
+	return queryWebhooks(
+		`SELECT id, repo_id, url, secret, events, active, created_at, updated_at
+		 FROM webhook WHERE IFNULL(repo_id, 0) = ? ORDER BY id`, repoID,
+	)
+}
+
+// GetActiveWebhooks 获取应接收仓库事件的 webhook（仓库自身的 + 全局的）
+// repoID 为 0 时只返回全局 webhook
+func GetActiveWebhooks(repoID int64) ([]*Webhook, error) {
+	return queryWebhooks(
+		`SELECT id, repo_id, url, secret, events, active, created_at, updated_at
+		 FROM webhook WHERE active = 1 AND (repo_id IS NULL OR repo_id = ?) ORDER BY id`, repoID,
+	)
+}
+
+// DeleteWebhook 删除 webhook（连同投递记录），返回是否存在
+func DeleteWebhook(repoID, id int64) (bool, error) {
+	result, err := db.Exec(`DELETE FROM webhook WHERE id = ? AND IFNULL(repo_id, 0) = ?`, id, repoID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// CreateWebhookDelivery 创建待投递记录
+func CreateWebhookDelivery(webhookID int64, guid, event string, payload []byte) (*WebhookDelivery, error) {
+	result, err := db.Exec(
+		`INSERT INTO webhook_delivery (webhook_id, guid, event, payload) VALUES (?, ?, ?, ?)`,
+		webhookID, guid, event, string(payload),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	return GetWebhookDelivery(webhookID, id)
+}
+
+// GetWebhookDelivery 获取 webhook 的一条投递记录
+func GetWebhookDelivery(webhookID, id int64) (*WebhookDelivery, error) {
+	return scanWebhookDelivery(db.QueryRow(
+		`SELECT id, webhook_id, guid, event, payload, status, attempts, response_status,
+		        response_body, error, created_at, delivered_at
+		 FROM webhook_delivery WHERE id = ? AND webhook_id = ?`, id, webhookID,
+	))
+}
+
+// GetWebhookDeliveries 获取 webhook 最近的投递记录（新的在前）
+func GetWebhookDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	rows, err := db.Query(
+		`SELECT id, webhook_id, guid, event, payload, status, attempts, response_status,
+		        response_body, error, created_at, delivered_at
+		 FROM webhook_delivery WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`, webhookID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// GetPendingWebhookDeliveries 获取所有未完成（投递中或等待重试）的投递记录，按创建顺序
+func GetPendingWebhookDeliveries() ([]*WebhookDelivery, error) {
+	rows, err := db.Query(
+		`SELECT id, webhook_id, guid, event, payload, status, attempts, response_status,
+		        response_body, error, created_at, delivered_at
+		 FROM webhook_delivery WHERE status = ? ORDER BY id`, DeliveryPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery 保存一次投递尝试的结果
+func UpdateWebhookDelivery(d *WebhookDelivery) error {
+	_, err := db.Exec(
+		`UPDATE webhook_delivery
+		 SET status = ?, attempts = ?, response_status = ?, response_body = ?, error = ?, delivered_at = ?
+		 WHERE id = ?`,
+		d.Status, d.Attempts, d.ResponseStatus, d.ResponseBody, d.Error, d.DeliveredAt, d.ID,
+	)
+	return err
+}
+
+func nullableID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func queryWebhooks(query string, args ...interface{}) ([]*Webhook, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []*Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	hook := &Webhook{}
+	var repoID sql.NullInt64
+	var events string
+	err := row.Scan(&hook.ID, &repoID, &hook.URL, &hook.Secret, &events,
+		&hook.Active, &hook.CreatedAt, &hook.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hook.RepoID = repoID.Int64
+	hook.HasSecret = hook.Secret != ""
+	hook.Events = []string{}
+	if events != "" {
+		hook.Events = strings.Split(events, ",")
+	}
+	return hook, nil
+}
+
+func scanWebhookDelivery(row rowScanner) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	var payload string
+	var deliveredAt sql.NullTime
+	err := row.Scan(&d.ID, &d.WebhookID, &d.GUID, &d.Event, &payload, &d.Status, &d.Attempts,
+		&d.ResponseStatus, &d.ResponseBody, &d.Error, &d.CreatedAt, &deliveredAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d.Payload = json.RawMessage(payload)
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return d, nil
+}
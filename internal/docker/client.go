@@ -1,36 +1,50 @@
 package docker
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
+
+	"potstack/config"
 )
 
+// Default 返回连接 POTSTACK_DOCKER_SOCKET 的客户端
+func Default() *Client {
+	return NewClient(config.DockerSocket)
+}
+
 // PullAndTag 拉取远程镜像并打本地 Tag
 func PullAndTag(remoteImage, localTag string) error {
-	// 拉取
-	pullCmd := exec.Command("docker", "pull", remoteImage)
-	var stderr bytes.Buffer
-	pullCmd.Stderr = &stderr
-	if err := pullCmd.Run(); err != nil {
-		return fmt.Errorf("docker pull %s failed: %w, stderr: %s", remoteImage, err, stderr.String())
-	}
+	return Default().PullAndTag(context.Background(), remoteImage, localTag, nil)
+}
 
-	// 打 Tag
-	tagCmd := exec.Command("docker", "tag", remoteImage, localTag)
-	tagCmd.Stderr = &stderr
-	if err := tagCmd.Run(); err != nil {
-		return fmt.Errorf("docker tag failed: %w, stderr: %s", err, stderr.String())
+// PullAndTag 拉取远程镜像并打本地 Tag，progress 非 nil 时回调拉取进度
+func (c *Client) PullAndTag(ctx context.Context, remoteImage, localTag string, progress func(PullProgress)) error {
+	if err := c.ImagePull(ctx, remoteImage, progress); err != nil {
+		return fmt.Errorf("docker pull %s failed: %w", remoteImage, err)
+	}
+	if err := c.ImageTag(ctx, remoteImage, localTag); err != nil {
+		return fmt.Errorf("docker tag failed: %w", err)
 	}
 	return nil
 }
 
 // RemoveTag 删除本地 Tag
 func RemoveTag(localTag string) error {
-	return exec.Command("docker", "rmi", localTag).Run()
+	return Default().ImageRemove(context.Background(), localTag)
 }
 
 // ImageExists 检查本地镜像是否存在
 func ImageExists(tag string) bool {
-	return exec.Command("docker", "image", "inspect", tag).Run() == nil
+	_, err := Default().ImageInspect(context.Background(), tag)
+	return err == nil
+}
+
+// RemoveContainer 强制删除容器（运行中时先结束），容器不存在时不报错
+func RemoveContainer(name string) error {
+	err := Default().ContainerRemove(context.Background(), name, true)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("docker rm %s failed: %w", name, err)
+	}
+	return nil
 }
@@ -0,0 +1,266 @@
+// Package dockertest 提供测试用的假 Docker Engine API（在 unix socket 上运行）
+package dockertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Engine 是假 Docker Engine API：记录收到的请求，容器只保存在内存中
+//
+//	拉取 fromImage=missing 的镜像时在消息流中返回错误
+//	容器启动时输出一行 "container up"，收到 SIGTERM 时输出 "container stopped" 并以 0 退出，其他信号以 137 退出
+type Engine struct {
+	mu         sync.Mutex
+	calls      []string // "METHOD /path?query"（去掉 API 版本前缀）
+	containers map[string]*Container
+	nextID     int
+	hold       chan struct{} // 不为 nil 时创建容器的请求等待它关闭
+}
+
+// Container 是假 Engine 中的容器
+type Container struct {
+	ID, Name string
+	Config   map[string]interface{} // 创建时的请求体
+
+	logs   chan []byte // 多路复用帧，容器退出时关闭
+	exited chan struct{}
+	code   int
+}
+
+// NewEngine 在 socket 上启动假 Engine，测试结束时关闭
+func NewEngine(t testing.TB, socket string) *Engine {
+	e := &Engine{containers: make(map[string]*Container)}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen %s failed: %v", socket, err)
+	}
+	srv := &http.Server{Handler: e}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return e
+}
+
+// Calls 返回收到的请求，每行一个
+func (e *Engine) Calls() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return strings.Join(e.calls, "\n") + "\n"
+}
+
+// HoldCreate 让之后创建容器的请求阻塞，直到调用返回的函数
+func (e *Engine) HoldCreate() (release func()) {
+	hold := make(chan struct{})
+	e.mu.Lock()
+	e.hold = hold
+	e.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			e.mu.Lock()
+			e.hold = nil
+			e.mu.Unlock()
+			close(hold)
+		})
+	}
+}
+
+// Crash 让容器输出一行 stderr 后以 code 退出，容器不存在时返回 false
+func (e *Engine) Crash(name string, code int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := e.lookup(name)
+	if c == nil {
+		return false
+	}
+	c.output(2, "container crashed")
+	c.exit(code)
+	return true
+}
+
+// Container 按 ID 或名称返回容器，不存在时为 nil
+func (e *Engine) Container(ref string) *Container {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lookup(ref)
+}
+
+// lookup 按 ID 或名称查找容器（调用方持有 e.mu）
+func (e *Engine) lookup(ref string) *Container {
+	if c, ok := e.containers[ref]; ok {
+		return c
+	}
+	for _, c := range e.containers {
+		if c.Name == ref {
+			return c
+		}
+	}
+	return nil
+}
+
+// output 以 Docker 多路复用格式输出一行日志（stream 1 = stdout，2 = stderr；调用方持有 e.mu）
+func (c *Container) output(stream byte, line string) {
+	select {
+	case <-c.exited:
+		return
+	default:
+	}
+	frame := []byte{stream, 0, 0, 0, 0, 0, 0, byte(len(line) + 1)}
+	c.logs <- append(frame, line+"\n"...)
+}
+
+// exit 结束容器（调用方持有 e.mu）
+func (c *Container) exit(code int) {
+	select {
+	case <-c.exited:
+		return
+	default:
+	}
+	c.code = code
+	close(c.logs)
+	close(c.exited)
+}
+
+func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/v1.") {
+		path = path[strings.Index(path[1:], "/")+1:]
+	}
+	call := r.Method + " " + path
+	if r.URL.RawQuery != "" {
+		call += "?" + r.URL.RawQuery
+	}
+	e.mu.Lock()
+	e.calls = append(e.calls, call)
+	e.mu.Unlock()
+
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"message": msg})
+	}
+	q := r.URL.Query()
+	switch {
+	case path == "/_ping":
+		w.Write([]byte("OK"))
+
+	case path == "/images/create":
+		w.Header().Set("Content-Type", "application/json")
+		if q.Get("fromImage") == "missing" {
+			fmt.Fprintln(w, `{"status":"Pulling from library/missing"}`)
+			fmt.Fprintln(w, `{"error":"manifest unknown","errorDetail":{"message":"manifest unknown"}}`)
+			return
+		}
+		fmt.Fprintf(w, "{\"status\":\"Pulling from library/%s\",\"id\":\"%s\"}\n", q.Get("fromImage"), q.Get("tag"))
+		fmt.Fprintln(w, `{"status":"Downloading","progress":"[=>   ]","progressDetail":{"current":1,"total":5},"id":"layer1"}`)
+		fmt.Fprintln(w, `{"status":"Pull complete","id":"layer1"}`)
+		fmt.Fprintf(w, "{\"status\":\"Status: Downloaded newer image for %s:%s\"}\n", q.Get("fromImage"), q.Get("tag"))
+
+	case strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/tag"):
+		w.WriteHeader(http.StatusCreated)
+
+	case path == "/containers/create":
+		e.mu.Lock()
+		hold := e.hold
+		e.mu.Unlock()
+		if hold != nil {
+			select {
+			case <-hold:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		var cfg map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&cfg)
+		e.mu.Lock()
+		if e.lookup(q.Get("name")) != nil {
+			e.mu.Unlock()
+			fail(http.StatusConflict, "container name already in use")
+			return
+		}
+		e.nextID++
+		c := &Container{
+			ID:     fmt.Sprintf("c%d", e.nextID),
+			Name:   q.Get("name"),
+			Config: cfg,
+			logs:   make(chan []byte, 64),
+			exited: make(chan struct{}),
+		}
+		e.containers[c.ID] = c
+		e.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"Id": c.ID})
+
+	case strings.HasPrefix(path, "/containers/"):
+		ref, action, _ := strings.Cut(strings.TrimPrefix(path, "/containers/"), "/")
+		e.mu.Lock()
+		c := e.lookup(ref)
+		if c == nil {
+			e.mu.Unlock()
+			fail(http.StatusNotFound, "No such container: "+ref)
+			return
+		}
+		switch {
+		case r.Method == "DELETE":
+			c.exit(137)
+			delete(e.containers, c.ID)
+			e.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		case action == "start":
+			c.output(1, "container up")
+			e.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		case action == "json":
+			state := map[string]interface{}{"Status": "running", "Running": true, "Pid": 4242}
+			select {
+			case <-c.exited:
+				state = map[string]interface{}{"Status": "exited", "ExitCode": c.code}
+			default:
+			}
+			e.mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Id": c.ID, "Name": "/" + c.Name, "Image": c.Config["Image"], "State": state,
+			})
+		case action == "kill":
+			select {
+			case <-c.exited:
+				e.mu.Unlock()
+				fail(http.StatusConflict, "container is not running")
+				return
+			default:
+			}
+			if q.Get("signal") == "SIGTERM" {
+				c.output(1, "container stopped")
+				c.exit(0)
+			} else {
+				c.exit(137)
+			}
+			e.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		case action == "logs":
+			e.mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+			for frame := range c.logs {
+				w.Write(frame)
+				w.(http.Flusher).Flush()
+			}
+		case action == "wait":
+			e.mu.Unlock()
+			select {
+			case <-c.exited:
+				json.NewEncoder(w).Encode(map[string]int{"StatusCode": c.code})
+			case <-r.Context().Done():
+			}
+		default:
+			e.mu.Unlock()
+			fail(http.StatusNotFound, "page not found")
+		}
+
+	default:
+		fail(http.StatusNotFound, "page not found")
+	}
+}
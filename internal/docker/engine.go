@@ -0,0 +1,372 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion 请求的 Engine API 版本（Docker 20.10+）
+const apiVersion = "v1.41"
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+// APIError 是 Engine API 返回的错误
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// Unwrap 使 errors.Is(err, ErrNotFound) / errors.Is(err, ErrConflict) 可用
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	}
+	return nil
+}
+
+// Client 是通过 unix socket 访问 Docker Engine API 的客户端
+type Client struct {
+	socket string
+	http   *http.Client
+}
+
+// NewClient 创建连接到 socket（如 /var/run/docker.sock）的客户端
+func NewClient(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Client{socket: socket, http: &http.Client{Transport: transport}}
+}
+
+// do 发送请求，非 2xx 响应转换为 *APIError；调用方负责关闭返回的 Body
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	u := "http://docker/" + apiVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker: %w", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &msg) == nil && msg.Message != "" {
+		apiErr.Message = msg.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	return nil, apiErr
+}
+
+// call 发送请求并把 JSON 响应解码到 out（out 为 nil 时丢弃）
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Ping 检查 Docker 是否可用
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// ========== 镜像 ==========
+
+// PullProgress 是拉取镜像时的一条进度消息
+type PullProgress struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Progress       string `json:"progress"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// ImagePull 拉取镜像，progress 非 nil 时逐条回调进度；取消 ctx 会中止拉取
+func (c *Client) ImagePull(ctx context.Context, ref string, progress func(PullProgress)) error {
+	image, tag := splitRef(ref)
+	q := url.Values{"fromImage": {image}}
+	if tag != "" {
+		q.Set("tag", tag)
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/images/create", q, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 错误在 200 响应的消息流中返回
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var p PullProgress
+		if err := dec.Decode(&p); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("docker: pull %s: %w", ref, err)
+		}
+		if p.Error != "" {
+			return fmt.Errorf("docker: pull %s: %s", ref, p.Error)
+		}
+		if progress != nil {
+			progress(p)
+		}
+	}
+}
+
+// ImageTag 为 source 打上 target（repo[:tag]）
+func (c *Client) ImageTag(ctx context.Context, source, target string) error {
+	repo, tag := splitRef(target)
+	q := url.Values{"repo": {repo}}
+	if tag != "" {
+		q.Set("tag", tag)
+	}
+	return c.call(ctx, http.MethodPost, "/images/"+source+"/tag", q, nil, nil)
+}
+
+// ImageInspect 是镜像的基本信息
+type ImageInspect struct {
+	ID       string   `json:"Id"`
+	RepoTags []string `json:"RepoTags"`
+	Created  string   `json:"Created"`
+	Size     int64    `json:"Size"`
+}
+
+// ImageInspect 返回镜像信息，镜像不存在时返回 ErrNotFound
+func (c *Client) ImageInspect(ctx context.Context, name string) (*ImageInspect, error) {
+	var img ImageInspect
+	if err := c.call(ctx, http.MethodGet, "/images/"+name+"/json", nil, nil, &img); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// ImageRemove 删除镜像（或其中一个 tag）
+func (c *Client) ImageRemove(ctx context.Context, name string) error {
+	return c.call(ctx, http.MethodDelete, "/images/"+name, nil, nil, nil)
+}
+
+// splitRef 把 name[:tag] 拆成 name 和 tag，registry 端口中的冒号不算 tag；
+// 没有 tag 时为 latest（只传 fromImage 会拉取所有 tag），带 digest 时原样返回、tag 为空
+func splitRef(ref string) (string, string) {
+	if strings.Contains(ref, "@") {
+		return ref, ""
+	}
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || strings.Contains(ref[i+1:], "/") {
+		return ref, "latest"
+	}
+	return ref[:i], ref[i+1:]
+}
+
+// ========== 容器 ==========
+
+// ContainerConfig 是创建容器的参数（Engine API 字段的子集）
+type ContainerConfig struct {
+	Image        string              `json:"Image"`
+	Env          []string            `json:"Env,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   HostConfig          `json:"HostConfig"`
+}
+
+// HostConfig 是容器的宿主机相关配置
+type HostConfig struct {
+	Binds        []string                 `json:"Binds,omitempty"`
+	PortBindings map[string][]PortBinding `json:"PortBindings,omitempty"`
+	ExtraHosts   []string                 `json:"ExtraHosts,omitempty"`
+	Memory       int64                    `json:"Memory,omitempty"`
+	NanoCPUs     int64                    `json:"NanoCpus,omitempty"`
+	CPUShares    int64                    `json:"CpuShares,omitempty"`
+	PidsLimit    int64                    `json:"PidsLimit,omitempty"`
+}
+
+// PortBinding 把容器端口发布到宿主机
+type PortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// ContainerCreate 创建容器，返回容器 ID
+func (c *Client) ContainerCreate(ctx context.Context, name string, cfg *ContainerConfig) (string, error) {
+	var q url.Values
+	if name != "" {
+		q = url.Values{"name": {name}}
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/containers/create", q, cfg, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// ContainerStart 启动容器（已在运行时不报错）
+func (c *Client) ContainerStart(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+}
+
+// ContainerStop 发送 SIGTERM，timeout 后由 Docker 发送 SIGKILL（已停止时不报错）
+func (c *Client) ContainerStop(ctx context.Context, id string, timeout time.Duration) error {
+	q := url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+	return c.call(ctx, http.MethodPost, "/containers/"+id+"/stop", q, nil, nil)
+}
+
+// ContainerKill 向容器的主进程发送信号（如 SIGTERM、SIGKILL）
+func (c *Client) ContainerKill(ctx context.Context, id, signal string) error {
+	return c.call(ctx, http.MethodPost, "/containers/"+id+"/kill", url.Values{"signal": {signal}}, nil, nil)
+}
+
+// ContainerRemove 删除容器，force 时先结束运行中的容器
+func (c *Client) ContainerRemove(ctx context.Context, id string, force bool) error {
+	return c.call(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {strconv.FormatBool(force)}}, nil, nil)
+}
+
+// ContainerState 是容器的运行状态
+type ContainerState struct {
+	Status   string `json:"Status"` // created / running / exited ...
+	Running  bool   `json:"Running"`
+	Pid      int    `json:"Pid"`
+	ExitCode int    `json:"ExitCode"`
+}
+
+// ContainerInspect 是容器的基本信息
+type ContainerInspect struct {
+	ID    string         `json:"Id"`
+	Name  string         `json:"Name"`
+	Image string         `json:"Image"`
+	State ContainerState `json:"State"`
+}
+
+// ContainerInspect 返回容器信息，容器不存在时返回 ErrNotFound
+func (c *Client) ContainerInspect(ctx context.Context, id string) (*ContainerInspect, error) {
+	var info ContainerInspect
+	if err := c.call(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ContainerWait 等待容器停止运行，返回退出码；取消 ctx 时返回 ctx 的错误
+func (c *Client) ContainerWait(ctx context.Context, id string) (int, error) {
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	err := c.call(ctx, http.MethodPost, "/containers/"+id+"/wait", url.Values{"condition": {"not-running"}}, nil, &result)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, err
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return result.StatusCode, fmt.Errorf("docker: wait %s: %s", id, result.Error.Message)
+	}
+	return result.StatusCode, nil
+}
+
+// ContainerLogs 把容器（非 TTY）的 stdout / stderr 分别写入 stdout / stderr
+// follow 时持续输出直到容器停止或 ctx 被取消
+func (c *Client) ContainerLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	q := url.Values{"stdout": {"true"}, "stderr": {"true"}, "follow": {strconv.FormatBool(follow)}}
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/logs", q, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = demux(bufio.NewReader(resp.Body), stdout, stderr)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// demux 解析 Docker 的多路复用流：每帧 8 字节头（流类型、3 字节填充、4 字节大端长度）加数据
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	var hdr [8]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if hdr[0] == 2 {
+			w = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
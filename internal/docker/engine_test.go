@@ -0,0 +1,153 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"potstack/internal/docker/dockertest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClient 启动假 Engine 并返回连接到它的客户端
+func newTestClient(t *testing.T) (*Client, *dockertest.Engine) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	engine := dockertest.NewEngine(t, socket)
+	return NewClient(socket), engine
+}
+
+func TestSplitRef(t *testing.T) {
+	for _, tc := range []struct {
+		ref, name, tag string
+	}{
+		{"nginx", "nginx", "latest"},
+		{"nginx:1.25", "nginx", "1.25"},
+		{"library/nginx", "library/nginx", "latest"},
+		{"registry:5000/app", "registry:5000/app", "latest"},
+		{"registry:5000/app:v1", "registry:5000/app", "v1"},
+		{"app@sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac", "app@sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac", ""},
+	} {
+		name, tag := splitRef(tc.ref)
+		assert.Equal(t, tc.name, name, tc.ref)
+		assert.Equal(t, tc.tag, tag, tc.ref)
+	}
+}
+
+func TestImagePull(t *testing.T) {
+	c, engine := newTestClient(t)
+	ctx := context.Background()
+
+	// 没有 tag 时只拉取 latest，带 digest 时不传 tag
+	for _, ref := range []string{"nginx", "registry:5000/app:v1", "app@sha256:abc"} {
+		assert.NoError(t, c.ImagePull(ctx, ref, nil), ref)
+	}
+	assert.Equal(t, "POST /images/create?fromImage=nginx&tag=latest\n"+
+		"POST /images/create?fromImage=registry%3A5000%2Fapp&tag=v1\n"+
+		"POST /images/create?fromImage=app%40sha256%3Aabc\n", engine.Calls())
+
+	// 逐条回调进度
+	var progress []PullProgress
+	assert.NoError(t, c.ImagePull(ctx, "nginx:1.25", func(p PullProgress) { progress = append(progress, p) }))
+	if assert.Len(t, progress, 4) {
+		assert.Equal(t, "Pulling from library/nginx", progress[0].Status)
+		assert.Equal(t, "layer1", progress[1].ID)
+		assert.Equal(t, int64(5), progress[1].ProgressDetail.Total)
+		assert.Equal(t, "Status: Downloaded newer image for nginx:1.25", progress[3].Status)
+	}
+
+	// 错误在消息流中返回
+	progress = nil
+	err := c.ImagePull(ctx, "missing", func(p PullProgress) { progress = append(progress, p) })
+	assert.EqualError(t, err, "docker: pull missing: manifest unknown")
+	assert.Len(t, progress, 1)
+
+	// 打 tag 时拆分 repo 与 tag
+	assert.NoError(t, c.ImageTag(ctx, "nginx:1.25", "potstack/ann-web:latest"))
+	assert.Contains(t, engine.Calls(), "POST /images/nginx:1.25/tag?repo=potstack%2Fann-web&tag=latest\n")
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	c, engine := newTestClient(t)
+	ctx := context.Background()
+	assert.NoError(t, c.Ping(ctx))
+
+	cfg := &ContainerConfig{
+		Image: "nginx",
+		Env:   []string{"PORT=80"},
+		HostConfig: HostConfig{
+			Memory:       64 << 20,
+			PortBindings: map[string][]PortBinding{"80/tcp": {{HostIP: "127.0.0.1", HostPort: "61000"}}},
+		},
+	}
+	id, err := c.ContainerCreate(ctx, "potstack-ann-web-0", cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	created := engine.Container(id)
+	if assert.NotNil(t, created) {
+		assert.Equal(t, "potstack-ann-web-0", created.Name)
+		assert.Equal(t, []interface{}{"PORT=80"}, created.Config["Env"])
+		host := created.Config["HostConfig"].(map[string]interface{})
+		assert.Equal(t, float64(64<<20), host["Memory"])
+		assert.NotContains(t, host, "NanoCpus") // 未设置的字段不发送
+	}
+
+	// 同名容器已存在
+	_, err = c.ContainerCreate(ctx, "potstack-ann-web-0", cfg)
+	assert.ErrorIs(t, err, ErrConflict)
+	var apiErr *APIError
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, "docker: container name already in use (HTTP 409)", apiErr.Error())
+	}
+
+	assert.NoError(t, c.ContainerStart(ctx, id))
+	info, err := c.ContainerInspect(ctx, "potstack-ann-web-0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, id, info.ID)
+		assert.True(t, info.State.Running)
+		assert.Equal(t, 4242, info.State.Pid)
+	}
+
+	// 日志按流拆分，wait 在容器退出后返回退出码
+	var stdout, stderr bytes.Buffer
+	logsDone := make(chan error, 1)
+	go func() { logsDone <- c.ContainerLogs(ctx, id, true, &stdout, &stderr) }()
+	waitDone := make(chan int, 1)
+	go func() {
+		code, err := c.ContainerWait(ctx, id)
+		assert.NoError(t, err)
+		waitDone <- code
+	}()
+	assert.True(t, engine.Crash(id, 3))
+	assert.Equal(t, 3, <-waitDone)
+	assert.NoError(t, <-logsDone)
+	assert.Equal(t, "container up\n", stdout.String())
+	assert.Equal(t, "container crashed\n", stderr.String())
+
+	info, err = c.ContainerInspect(ctx, id)
+	if assert.NoError(t, err) {
+		assert.False(t, info.State.Running)
+		assert.Equal(t, 3, info.State.ExitCode)
+	}
+	// 已停止的容器不能再发送信号
+	assert.ErrorIs(t, c.ContainerKill(ctx, id, "SIGTERM"), ErrConflict)
+
+	assert.NoError(t, c.ContainerRemove(ctx, id, true))
+	_, err = c.ContainerInspect(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, c.ContainerRemove(ctx, id, true), ErrNotFound)
+}
+
+func TestContainerWaitCanceled(t *testing.T) {
+	c, _ := newTestClient(t)
+	id, err := c.ContainerCreate(context.Background(), "potstack-ann-web-0", &ContainerConfig{Image: "nginx"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.ContainerWait(ctx, id)
+	assert.ErrorIs(t, err, context.Canceled)
+}
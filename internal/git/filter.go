@@ -0,0 +1,61 @@
+package git
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// -------------------- partial clone filter --------------------
+
+// objectFilter 部分克隆的对象过滤条件
+//
+//	blob:none          不发送任何 blob
+//	blob:limit=<n>     不发送大于 n 字节的 blob（支持 k/m/g 后缀）
+type objectFilter struct {
+	noBlobs   bool
+	blobLimit int64
+}
+
+func parseObjectFilter(spec string) (*objectFilter, error) {
+	switch {
+	case spec == "blob:none":
+		return &objectFilter{noBlobs: true}, nil
+	case strings.HasPrefix(spec, "blob:limit="):
+		limit, err := parseSize(strings.TrimPrefix(spec, "blob:limit="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter-spec %q", spec)
+		}
+		return &objectFilter{blobLimit: limit}, nil
+	default:
+		return nil, fmt.Errorf("unsupported filter-spec %q", spec)
+	}
+}
+
+// allowsBlob 判断大小为 size 的 blob 是否需要发送（nil 表示不过滤）
+func (f *objectFilter) allowsBlob(size func() int64) bool {
+	if f == nil {
+		return true
+	}
+	if f.noBlobs {
+		return false
+	}
+	return size() <= f.blobLimit
+}
+
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1<<10, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		mult, s = 1<<20, strings.TrimSuffix(s, "m")
+	case strings.HasSuffix(s, "g"):
+		mult, s = 1<<30, strings.TrimSuffix(s, "g")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
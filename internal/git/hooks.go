@@ -0,0 +1,212 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// -------------------- hooks --------------------
+//
+// 推送（receive-pack）过程中的三个钩子阶段，语义与 git 一致：
+//
+//	pre-receive   引用更新前调用一次，返回错误则拒绝本次推送的全部引用
+//	update        每条引用更新前调用，返回错误只拒绝该引用（atomic 推送时拒绝全部）
+//	post-receive  引用更新完成后调用，只能输出信息，不影响推送结果
+//
+// 钩子有两种来源：进程内通过 Register*Hook 注册的钩子（先执行），
+// 以及裸仓库 hooks/ 目录下的同名可执行脚本（后执行）。
+// 钩子的输出通过 side-band 通道 2 回显给客户端（"remote: ..."）。
+
+// 钩子拒绝时回报给客户端的原因
+const (
+	reasonPreReceiveDeclined = "pre-receive hook declined"
+	reasonUpdateDeclined     = "hook declined"
+)
+
+// RefUpdate 一条引用更新，Old 为零值表示创建，New 为零值表示删除
+type RefUpdate struct {
+	Name plumbing.ReferenceName
+	Old  plumbing.Hash
+	New  plumbing.Hash
+}
+
+// IsDelete 判断是否为删除引用
+func (u RefUpdate) IsDelete() bool {
+	return u.New.IsZero()
+}
+
+func (u RefUpdate) String() string {
+	return fmt.Sprintf("%s %s %s", u.Old, u.New, u.Name)
+}
+
+// HookContext 钩子执行时的上下文
+type HookContext struct {
+	context.Context
+
+	// Owner / Repo 仓库所有者与仓库名（不含 .git）
+	Owner string
+	Repo  string
+	// RepoPath 裸仓库的绝对路径
+	RepoPath   string
+	Repository *git.Repository
+	// Pusher 推送者用户名，内部端口推送时为空
+	Pusher string
+	// Output 写入的内容会显示在客户端（side-band 通道 2），不支持 side-band 时丢弃
+	Output io.Writer
+}
+
+// PreReceiveHook 返回错误时拒绝整个推送
+type PreReceiveHook func(hc *HookContext, updates []RefUpdate) error
+
+// UpdateHook 返回错误时拒绝该引用
+type UpdateHook func(hc *HookContext, update RefUpdate) error
+
+// PostReceiveHook 在引用更新后调用，updates 只包含成功的更新
+type PostReceiveHook func(hc *HookContext, updates []RefUpdate)
+
+var hookRegistry struct {
+	mu          sync.RWMutex
+	preReceive  []PreReceiveHook
+	update      []UpdateHook
+	postReceive []PostReceiveHook
+}
+
+// RegisterPreReceiveHook 注册 pre-receive 钩子，按注册顺序执行
+func RegisterPreReceiveHook(fn PreReceiveHook) {
+	hookRegistry.mu.Lock()
+	defer hookRegistry.mu.Unlock()
+	hookRegistry.preReceive = append(hookRegistry.preReceive, fn)
+}
+
+// RegisterUpdateHook 注册 update 钩子，按注册顺序执行
+func RegisterUpdateHook(fn UpdateHook) {
+	hookRegistry.mu.Lock()
+	defer hookRegistry.mu.Unlock()
+	hookRegistry.update = append(hookRegistry.update, fn)
+}
+
+// RegisterPostReceiveHook 注册 post-receive 钩子，按注册顺序执行
+func RegisterPostReceiveHook(fn PostReceiveHook) {
+	hookRegistry.mu.Lock()
+	defer hookRegistry.mu.Unlock()
+	hookRegistry.postReceive = append(hookRegistry.postReceive, fn)
+}
+
+// runPreReceive 依次执行 pre-receive 钩子，第一个错误即拒绝
+func runPreReceive(hc *HookContext, updates []RefUpdate) error {
+	hookRegistry.mu.RLock()
+	hooks := hookRegistry.preReceive
+	hookRegistry.mu.RUnlock()
+
+	for _, h := range hooks {
+		if err := h(hc, updates); err != nil {
+			return err
+		}
+	}
+	return runHookScript(hc, "pre-receive", nil, updates)
+}
+
+// runUpdate 依次执行 update 钩子，第一个错误即拒绝
+func runUpdate(hc *HookContext, u RefUpdate) error {
+	hookRegistry.mu.RLock()
+	hooks := hookRegistry.update
+	hookRegistry.mu.RUnlock()
+
+	for _, h := range hooks {
+		if err := h(hc, u); err != nil {
+			return err
+		}
+	}
+	return runHookScript(hc, "update", []string{u.Name.String(), u.Old.String(), u.New.String()}, nil)
+}
+
+// runPostReceive 执行全部 post-receive 钩子，单个钩子 panic 不影响其他钩子
+func runPostReceive(hc *HookContext, updates []RefUpdate) {
+	if len(updates) == 0 {
+		return
+	}
+
+	hookRegistry.mu.RLock()
+	hooks := hookRegistry.postReceive
+	hookRegistry.mu.RUnlock()
+
+	for _, h := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("post-receive hook for %s/%s panicked: %v", hc.Owner, hc.Repo, r)
+				}
+			}()
+			h(hc, updates)
+		}()
+	}
+	if err := runHookScript(hc, "post-receive", nil, updates); err != nil {
+		log.Printf("post-receive script for %s/%s failed: %v", hc.Owner, hc.Repo, err)
+	}
+}
+
+// runHookScript 执行裸仓库 hooks/<name> 脚本（不存在或不可执行时跳过）
+// stdin 为 "<old> <new> <ref>" 行，退出码非 0 视为拒绝
+func runHookScript(hc *HookContext, name string, args []string, updates []RefUpdate) error {
+	script := filepath.Join(hc.RepoPath, "hooks", name)
+	info, err := os.Stat(script)
+	if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return nil
+	}
+
+	var stdin bytes.Buffer
+	for _, u := range updates {
+		stdin.WriteString(u.String() + "\n")
+	}
+
+	output := hc.Output
+	if output == nil {
+		output = io.Discard
+	}
+
+	cmd := exec.CommandContext(hc, script, args...)
+	cmd.Dir = hc.RepoPath
+	cmd.Env = append(os.Environ(),
+		"GIT_DIR="+hc.RepoPath,
+		"POTSTACK_OWNER="+hc.Owner,
+		"POTSTACK_REPO="+hc.Repo,
+		"POTSTACK_PUSHER="+hc.Pusher,
+	)
+	cmd.Stdin = &stdin
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hooks/%s: %w", name, err)
+	}
+	return nil
+}
+
+// -------------------- pusher --------------------
+
+type pusherKey struct{}
+
+// WithPusher 在请求上下文中记录推送者，供钩子读取
+func WithPusher(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, pusherKey{}, username)
+}
+
+func pusherFrom(ctx context.Context) string {
+	name, _ := ctx.Value(pusherKey{}).(string)
+	return name
+}
+
+// declineMessage 将钩子错误格式化为回显给客户端的单行信息
+func declineMessage(err error) string {
+	return "error: " + strings.TrimSpace(singleLine(err.Error())) + "\n"
+}
@@ -1,7 +1,7 @@
 package git
 
 import (
-	"context"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"log"
@@ -15,11 +15,7 @@ import (
 	"github.com/gin-gonic/gin"
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/filemode"
-	"github.com/go-git/go-git/v5/plumbing/format/packfile"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
-	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 )
 
 // -------------------- HTTP Entry --------------------
@@ -74,39 +70,62 @@ func handleInfoRefs(c *gin.Context, repoPath string) {
 	c.Header("Cache-Control", "no-cache")
 	c.Status(http.StatusOK)
 
-	pkt := func(s string) string {
-		if s == "" {
-			return "0000"
+	// 协议 v2 只广告能力，引用由 ls-refs 按需列出（push 仍使用 v0）
+	if service == "git-upload-pack" && isProtocolV2(c.Request) {
+		if err := writeV2Advertisement(c.Writer); err != nil {
+			log.Println("git advertisement error:", err)
 		}
-		return fmt.Sprintf("%04x%s", len(s)+4, s)
+		return
 	}
 
-	c.Writer.WriteString(pkt(fmt.Sprintf("# service=%s\n", service)))
-	c.Writer.WriteString("0000")
+	e := pktline.NewEncoder(c.Writer)
+	e.EncodeString(fmt.Sprintf("# service=%s\n", service))
+	e.Flush()
 
-	refs, _ := repo.References()
+	if err := writeRefAdvertisement(c.Writer, repo, service); err != nil {
+		log.Println("git advertisement error:", err)
+	}
+}
+
+// writeRefAdvertisement 写出 v0 引用广告（首行带能力列表，以 flush 结束）
+func writeRefAdvertisement(w io.Writer, repo *git.Repository, service string) error {
 	var refList []string
-	refs.ForEach(func(r *plumbing.Reference) error {
-		if r.Type() == plumbing.HashReference {
-			refList = append(refList, fmt.Sprintf("%s %s", r.Hash(), r.Name()))
+
+	// HEAD 必须最先广告，客户端 clone 时据此确定默认分支
+	caps := "side-band-64k side-band ofs-delta object-format=sha1 agent=go-git"
+	if service == "git-receive-pack" {
+		caps = "report-status delete-refs atomic " + caps
+	}
+	if service == "git-upload-pack" {
+		caps = "multi_ack multi_ack_detailed no-done thin-pack shallow deepen-since deepen-relative filter " +
+			"no-progress include-tag allow-tip-sha1-in-want allow-reachable-sha1-in-want " + caps
+		if head, err := repo.Head(); err == nil {
+			refList = append(refList, fmt.Sprintf("%s HEAD", head.Hash()))
+			caps = fmt.Sprintf("%s symref=HEAD:%s", caps, head.Name())
 		}
-		return nil
-	})
+	}
 
-	// 暂时禁用 side-band-64k 以避免 receive-pack 协议错误
-	// caps := "side-band-64k ofs-delta object-format=sha1 agent=go-git"
-	caps := "ofs-delta object-format=sha1 agent=go-git"
+	for _, r := range sortedRefs(repo) {
+		refList = append(refList, fmt.Sprintf("%s %s", r.Hash(), r.Name()))
+		// 附注标签同时广告其指向的对象
+		if tag, err := repo.TagObject(r.Hash()); err == nil && service == "git-upload-pack" {
+			refList = append(refList, fmt.Sprintf("%s %s^{}", tag.Target, r.Name()))
+		}
+	}
 
+	e := pktline.NewEncoder(w)
 	if len(refList) == 0 {
-		c.Writer.WriteString(pkt(fmt.Sprintf("%040d\x00%s\n", 0, caps)))
-	} else {
-		c.Writer.WriteString(pkt(fmt.Sprintf("%s\x00%s\n", refList[0], caps)))
-		for i := 1; i < len(refList); i++ {
-			c.Writer.WriteString(pkt(refList[i] + "\n"))
+		refList = []string{fmt.Sprintf("%s capabilities^{}", plumbing.ZeroHash)}
+	}
+	if err := e.EncodeString(fmt.Sprintf("%s\x00%s\n", refList[0], caps)); err != nil {
+		return err
+	}
+	for _, ref := range refList[1:] {
+		if err := e.EncodeString(ref + "\n"); err != nil {
+			return err
 		}
 	}
-
-	c.Writer.WriteString("0000")
+	return e.Flush()
 }
 
 // -------------------- Service Dispatcher --------------------
@@ -119,141 +138,40 @@ func handleService(c *gin.Context, repoPath, service string) {
 		return
 	}
 
-	c.Header("Content-Type", fmt.Sprintf("application/x-git-%s-result", service))
-	c.Header("Cache-Control", "no-cache")
-	c.Status(http.StatusOK)
-
-	if service == "upload-pack" {
-		err = handleDirectUploadPack(c.Request.Context(), repo, c.Request.Body, c.Writer)
-	} else {
-		err = handleDirectReceivePack(c.Request.Context(), repo, c.Request.Body, c.Writer)
-	}
-
-	if err != nil {
-		log.Println("git service error:", err)
-	}
-}
-
-// -------------------- upload-pack (go-git client only) --------------------
-
-func handleDirectUploadPack(
-	ctx context.Context,
-	repo *git.Repository,
-	req io.Reader,
-	res io.Writer,
-) error {
-
-	upr := packp.NewUploadPackRequest()
-	if err := upr.Decode(req); err != nil {
-		return err
-	}
-
-	fmt.Fprint(res, "0008NAK\n")
-
-	writer := sideband.NewMuxer(sideband.Sideband64k, res)
-
-	seen := map[plumbing.Hash]struct{}{}
-	var objs []plumbing.Hash
-
-	for _, want := range upr.Wants {
-		commit, err := repo.CommitObject(want)
+	// git 客户端在请求体较大时使用 gzip 压缩
+	var body io.Reader = c.Request.Body
+	if c.GetHeader("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(c.Request.Body)
 		if err != nil {
-			return err
-		}
-		addHash(commit.Hash, &objs, seen)
-
-		tree, _ := commit.Tree()
-		if err := collectTree(repo, tree, &objs, seen); err != nil {
-			return err
-		}
-	}
-
-	enc := packfile.NewEncoder(writer, repo.Storer, false)
-	_, err := enc.Encode(objs, 0)
-	if err != nil {
-		return err
-	}
-
-	_, _ = res.Write([]byte("0000"))
-	return nil
-}
-
-func collectTree(
-	repo *git.Repository,
-	tree *object.Tree,
-	out *[]plumbing.Hash,
-	seen map[plumbing.Hash]struct{},
-) error {
-
-	addHash(tree.Hash, out, seen)
-
-	for _, e := range tree.Entries {
-		switch e.Mode {
-		case filemode.Dir:
-			sub, err := repo.TreeObject(e.Hash)
-			if err != nil {
-				return err
-			}
-			if err := collectTree(repo, sub, out, seen); err != nil {
-				return err
-			}
-		default:
-			addHash(e.Hash, out, seen)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
 		}
+		defer gz.Close()
+		body = gz
 	}
-	return nil
-}
-
-func addHash(h plumbing.Hash, out *[]plumbing.Hash, seen map[plumbing.Hash]struct{}) {
-	if _, ok := seen[h]; !ok {
-		seen[h] = struct{}{}
-		*out = append(*out, h)
-	}
-}
-
-// -------------------- receive-pack --------------------
-
-func handleDirectReceivePack(
-	ctx context.Context,
-	repo *git.Repository,
-	req io.Reader,
-	res io.Writer,
-) error {
 
-	upr := packp.NewReferenceUpdateRequest()
-	if err := upr.Decode(req); err != nil {
-		return err
-	}
+	c.Header("Content-Type", fmt.Sprintf("application/x-git-%s-result", service))
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
 
-	if upr.Packfile != nil {
-		parser, _ := packfile.NewParserWithStorage(
-			packfile.NewScanner(upr.Packfile),
-			repo.Storer,
-		)
-		_, err := parser.Parse()
-		if err != nil {
-			return err
+	switch {
+	case service == "upload-pack" && isProtocolV2(c.Request):
+		err = handleUploadPackV2(c.Request.Context(), repo, body, c.Writer)
+	case service == "upload-pack":
+		err = handleDirectUploadPack(c.Request.Context(), repo, body, c.Writer)
+	default:
+		hc := &HookContext{
+			Context:    c.Request.Context(),
+			Owner:      c.Param("owner"),
+			Repo:       strings.TrimSuffix(c.Param("reponame"), ".git"),
+			RepoPath:   abs,
+			Repository: repo,
+			Pusher:     pusherFrom(c.Request.Context()),
 		}
+		err = handleDirectReceivePack(hc, body, c.Writer)
 	}
 
-	for _, cmd := range upr.Commands {
-		ref := plumbing.NewHashReference(cmd.Name, cmd.New)
-		repo.Storer.SetReference(ref)
-	}
-
-	status := packp.NewReportStatus()
-	status.UnpackStatus = "ok"
-	for _, c := range upr.Commands {
-		status.CommandStatuses = append(status.CommandStatuses, &packp.CommandStatus{
-			ReferenceName: c.Name,
-			Status:        "ok",
-		})
-	}
-
-	// The report status should be sent directly, not through the side-band muxer.
-	if err := status.Encode(res); err != nil {
-		return err
+	if err != nil {
+		log.Println("git service error:", err)
 	}
-	_, _ = res.Write([]byte("0000"))
-	return nil
 }
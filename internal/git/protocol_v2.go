@@ -0,0 +1,336 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+)
+
+// -------------------- protocol v2 --------------------
+
+// v2Capabilities 协议 v2 的能力广告（info/refs 响应）
+var v2Capabilities = []string{
+	"version 2",
+	"agent=go-git",
+	"ls-refs=unborn",
+	"fetch=shallow filter",
+	"server-option",
+	"object-format=sha1",
+}
+
+// isProtocolV2 判断客户端是否通过 Git-Protocol 头请求了协议 v2
+func isProtocolV2(r *http.Request) bool {
+	for _, param := range strings.Split(r.Header.Get("Git-Protocol"), ":") {
+		if strings.TrimSpace(param) == "version=2" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeV2Advertisement(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	for _, line := range v2Capabilities {
+		if err := e.EncodeString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return e.Flush()
+}
+
+// commandRequest 协议 v2 的命令请求
+//
+//	command=<name>
+//	<capability>...
+//	0001
+//	<argument>...
+//	0000
+type commandRequest struct {
+	command       string
+	serverOptions []string
+	args          [][]byte
+}
+
+func decodeCommandRequest(r io.Reader) (*commandRequest, error) {
+	req := &commandRequest{}
+	br := bufio.NewReader(r)
+
+	inArgs := false
+	for {
+		kind, line, err := readPkt(br)
+		if err == io.EOF && req.command == "" {
+			return nil, fmt.Errorf("empty request")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case pktFlush:
+			return req, nil
+		case pktDelim:
+			inArgs = true
+			continue
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if inArgs {
+			req.args = append(req.args, append([]byte(nil), line...))
+			continue
+		}
+		key, value, _ := bytes.Cut(line, []byte("="))
+		switch string(key) {
+		case "command":
+			req.command = string(value)
+		case "server-option":
+			req.serverOptions = append(req.serverOptions, string(value))
+		}
+	}
+}
+
+// handleUploadPackV2 处理协议 v2 的 upload-pack 命令（ls-refs / fetch）
+func handleUploadPackV2(
+	ctx context.Context,
+	repo *git.Repository,
+	req io.Reader,
+	res io.Writer,
+) error {
+
+	e := pktline.NewEncoder(res)
+
+	cmd, err := decodeCommandRequest(req)
+	if err != nil {
+		_ = e.Encodef("ERR %s\n", err)
+		return err
+	}
+	if len(cmd.serverOptions) > 0 {
+		log.Printf("git %s server-options: %v", cmd.command, cmd.serverOptions)
+	}
+
+	switch cmd.command {
+	case "ls-refs":
+		return handleLsRefs(repo, cmd, e)
+	case "fetch":
+		return handleFetchV2(repo, cmd, res)
+	default:
+		_ = e.Encodef("ERR unknown command %q\n", cmd.command)
+		return fmt.Errorf("unknown command %q", cmd.command)
+	}
+}
+
+// -------------------- ls-refs --------------------
+
+// handleLsRefs 列出引用，支持 symrefs / peel / unborn / ref-prefix
+func handleLsRefs(repo *git.Repository, cmd *commandRequest, e *pktline.Encoder) error {
+	var symrefs, peel, unborn bool
+	var prefixes []string
+	for _, arg := range cmd.args {
+		switch {
+		case bytes.Equal(arg, []byte("symrefs")):
+			symrefs = true
+		case bytes.Equal(arg, []byte("peel")):
+			peel = true
+		case bytes.Equal(arg, []byte("unborn")):
+			unborn = true
+		case bytes.HasPrefix(arg, []byte("ref-prefix ")):
+			prefixes = append(prefixes, string(arg[len("ref-prefix "):]))
+		}
+	}
+
+	matches := func(name string) bool {
+		if len(prefixes) == 0 {
+			return true
+		}
+		for _, p := range prefixes {
+			if strings.HasPrefix(name, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// HEAD 最先输出
+	if matches("HEAD") {
+		if head, err := repo.Storer.Reference(plumbing.HEAD); err == nil {
+			line := ""
+			if resolved, err := repo.Reference(plumbing.HEAD, true); err == nil {
+				line = fmt.Sprintf("%s HEAD", resolved.Hash())
+			} else if unborn {
+				line = "unborn HEAD"
+			}
+			if line != "" {
+				if symrefs && head.Type() == plumbing.SymbolicReference {
+					line += " symref-target:" + head.Target().String()
+				}
+				if err := e.EncodeString(line + "\n"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, ref := range sortedRefs(repo) {
+		if !matches(ref.Name().String()) {
+			continue
+		}
+		line := fmt.Sprintf("%s %s", ref.Hash(), ref.Name())
+		if peel {
+			if tag, err := repo.TagObject(ref.Hash()); err == nil {
+				if target, err := peelToCommit(repo, tag.Hash); err == nil {
+					line += " peeled:" + target.Hash.String()
+				} else {
+					line += " peeled:" + tag.Target.String()
+				}
+			}
+		}
+		if err := e.EncodeString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return e.Flush()
+}
+
+// sortedRefs 返回按名称排序的直接引用（不含 HEAD）
+func sortedRefs(repo *git.Repository) []*plumbing.Reference {
+	var refs []*plumbing.Reference
+	iter, err := repo.Storer.IterReferences()
+	if err != nil {
+		return nil
+	}
+	_ = iter.ForEach(func(r *plumbing.Reference) error {
+		if r.Type() == plumbing.HashReference && r.Name() != plumbing.HEAD {
+			refs = append(refs, r)
+		}
+		return nil
+	})
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name() < refs[j].Name() })
+	return refs
+}
+
+// -------------------- fetch --------------------
+
+// v2 fetch 参数中以独立行出现的能力
+var fetchFlags = map[string]capability.Capability{
+	"thin-pack":       capability.ThinPack,
+	"ofs-delta":       capability.OFSDelta,
+	"no-progress":     capability.NoProgress,
+	"include-tag":     capability.IncludeTag,
+	"deepen-relative": capability.DeepenRelative,
+}
+
+func handleFetchV2(repo *git.Repository, cmd *commandRequest, res io.Writer) error {
+	e := pktline.NewEncoder(res)
+
+	upr := &uploadRequest{capabilities: capability.NewList(), negotiating: true}
+	for _, arg := range cmd.args {
+		if c, ok := fetchFlags[string(arg)]; ok {
+			_ = upr.capabilities.Set(c)
+			continue
+		}
+		if err := upr.decodeLine(arg); err != nil {
+			_ = e.Encodef("ERR fetch: %s\n", err)
+			return err
+		}
+	}
+	if err := upr.validate(); err != nil {
+		_ = e.Encodef("ERR fetch: %s\n", err)
+		return err
+	}
+
+	shallow, err := prepareUpload(repo, upr)
+	if err != nil {
+		_ = e.Encodef("ERR fetch: %s\n", err)
+		return err
+	}
+
+	n := newNegotiator(repo, upr)
+	if upr.done {
+		n.absorb()
+	} else {
+		// 未发送 done：返回协商结果，未就绪时由客户端继续发送 have
+		ready, err := n.acknowledge(e)
+		if err != nil || !ready {
+			if err == nil {
+				err = e.Flush()
+			}
+			return err
+		}
+		if err := writeDelim(res); err != nil {
+			return err
+		}
+	}
+
+	if upr.deepening() || len(upr.shallows) > 0 {
+		if err := e.EncodeString("shallow-info\n"); err != nil {
+			return err
+		}
+		if err := shallow.encodeLines(e); err != nil {
+			return err
+		}
+		if err := writeDelim(res); err != nil {
+			return err
+		}
+	}
+
+	if err := e.EncodeString("packfile\n"); err != nil {
+		return err
+	}
+	return sendMultiplexedPack(res, sideband.Sideband64k, repo, upr, n.common, shallow)
+}
+
+// -------------------- pkt-line --------------------
+
+const (
+	pktData = iota
+	pktFlush
+	pktDelim
+	pktResponseEnd
+)
+
+// readPkt 读取一个 pkt-line，支持 v2 的 delim（0001）与 response-end（0002）
+func readPkt(r io.Reader) (int, []byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, pktline.ErrInvalidPktLen
+		}
+		return 0, nil, err
+	}
+	n, err := strconv.ParseUint(string(head[:]), 16, 16)
+	if err != nil {
+		return 0, nil, pktline.ErrInvalidPktLen
+	}
+	switch n {
+	case 0:
+		return pktFlush, nil, nil
+	case 1:
+		return pktDelim, nil, nil
+	case 2:
+		return pktResponseEnd, nil, nil
+	case 3:
+		return 0, nil, pktline.ErrInvalidPktLen
+	}
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return pktData, payload, nil
+}
+
+func writeDelim(w io.Writer) error {
+	_, err := w.Write([]byte("0001"))
+	return err
+}
@@ -0,0 +1,377 @@
+package git
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+)
+
+// 推送失败时回报给客户端的原因（出现在 "ng <ref> <reason>" 中）
+const (
+	reasonUnpackerError   = "unpacker error"
+	reasonStaleInfo       = "fetch first"
+	reasonNonFastForward  = "non-fast-forward"
+	reasonMissingObjects  = "missing necessary objects"
+	reasonDeleteDenied    = "deletion prohibited"
+	reasonDeleteCurrent   = "deletion of the current branch prohibited"
+	reasonInvalidRefName  = "funny refname"
+	reasonUpdateFailed    = "failed to update ref"
+	reasonAtomicRejected  = "atomic push failed"
+	statusOK              = "ok"
+	unpackStatusOKMessage = "ok"
+)
+
+// repoLocks 每个仓库一把锁，保证 "检查旧值 + 写入新值" 在进程内是原子的
+var repoLocks sync.Map // abs repo path -> *sync.Mutex
+
+func lockRepo(repoPath string) func() {
+	v, _ := repoLocks.LoadOrStore(repoPath, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// receivePolicy 推送策略，读取自裸仓库的 config
+//
+//	[receive]
+//	    denyNonFastForwards = true   # 默认 true：拒绝非快进推送
+//	    denyDeletes = false          # 默认 false：允许删除引用
+//	    denyDeleteCurrent = true     # 默认 true：禁止删除 HEAD 指向的分支
+//	[potstack]
+//	    allowForcePush = refs/heads/sandbox/*   # 可多值，允许强推的引用（glob）
+type receivePolicy struct {
+	denyNonFastForwards bool
+	denyDeletes         bool
+	denyDeleteCurrent   bool
+	allowForcePush      []string
+}
+
+func loadReceivePolicy(repo *git.Repository) *receivePolicy {
+	p := &receivePolicy{
+		denyNonFastForwards: true,
+		denyDeleteCurrent:   true,
+	}
+
+	cfg, err := repo.Config()
+	if err != nil || cfg.Raw == nil {
+		return p
+	}
+
+	receive := cfg.Raw.Section("receive")
+	p.denyNonFastForwards = parseBool(receive.Options.Get("denyNonFastForwards"), p.denyNonFastForwards)
+	p.denyDeletes = parseBool(receive.Options.Get("denyDeletes"), p.denyDeletes)
+	p.denyDeleteCurrent = parseBool(receive.Options.Get("denyDeleteCurrent"), p.denyDeleteCurrent)
+	p.allowForcePush = cfg.Raw.Section("potstack").Options.GetAll("allowForcePush")
+	return p
+}
+
+// allowsForce 判断引用是否允许非快进更新
+func (p *receivePolicy) allowsForce(name plumbing.ReferenceName) bool {
+	if !p.denyNonFastForwards {
+		return true
+	}
+	for _, pattern := range p.allowForcePush {
+		if ok, _ := path.Match(pattern, name.String()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBool(v string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "true", "yes", "on", "1":
+		return true
+	case "false", "no", "off", "0":
+		return false
+	default:
+		return def
+	}
+}
+
+// -------------------- receive-pack --------------------
+
+func handleDirectReceivePack(
+	hc *HookContext,
+	req io.Reader,
+	res io.Writer,
+) error {
+
+	upr := packp.NewReferenceUpdateRequest()
+	if err := upr.Decode(req); err != nil {
+		return err
+	}
+
+	status := packp.NewReportStatus()
+	status.UnpackStatus = unpackStatusOKMessage
+
+	// 客户端请求了 side-band 时，钩子输出走通道 2，report-status 走通道 1
+	var mux *sideband.Muxer
+	switch {
+	case upr.Capabilities.Supports(capability.Sideband64k):
+		mux = sideband.NewMuxer(sideband.Sideband64k, res)
+	case upr.Capabilities.Supports(capability.Sideband):
+		mux = sideband.NewMuxer(sideband.Sideband, res)
+	}
+	hc.Output = io.Discard
+	if mux != nil {
+		hc.Output = progressWriter{mux}
+	}
+
+	// 只有删除操作时客户端不会发送 packfile
+	if needsPackfile(upr.Commands) {
+		if err := unpack(hc.Repository, upr.Packfile); err != nil {
+			status.UnpackStatus = singleLine(err.Error())
+		}
+	}
+
+	results := make([]string, len(upr.Commands))
+	if status.UnpackStatus != unpackStatusOKMessage {
+		for i := range results {
+			results[i] = reasonUnpackerError
+		}
+	} else {
+		unlock := lockRepo(hc.RepoPath)
+		results = updateReferences(hc, upr.Commands, upr.Capabilities.Supports(capability.Atomic))
+		unlock()
+	}
+
+	var applied []RefUpdate
+	for i, cmd := range upr.Commands {
+		status.CommandStatuses = append(status.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: cmd.Name,
+			Status:        results[i],
+		})
+		if results[i] == statusOK {
+			applied = append(applied, refUpdate(cmd))
+		}
+	}
+
+	if !upr.Capabilities.Supports(capability.ReportStatus) {
+		runPostReceive(hc, applied)
+		return status.Error()
+	}
+
+	if mux == nil {
+		if err := status.Encode(res); err != nil {
+			return err
+		}
+		runPostReceive(hc, applied)
+		return nil
+	}
+
+	// post-receive 在回报结果之后执行，其输出仍可经通道 2 显示，最后写出 flush
+	if err := status.Encode(mux); err != nil {
+		return err
+	}
+	runPostReceive(hc, applied)
+	_, err := res.Write([]byte("0000"))
+	return err
+}
+
+// updateReferences 校验并执行引用更新，返回每条命令的结果（"ok" 或失败原因）
+// 顺序为：内置校验 -> pre-receive -> update -> 写入引用
+// 调用方必须持有仓库锁
+func updateReferences(hc *HookContext, cmds []*packp.Command, atomic bool) []string {
+	repo := hc.Repository
+	policy := loadReceivePolicy(repo)
+	results := make([]string, len(cmds))
+	failed := false
+	for i, cmd := range cmds {
+		results[i] = checkCommand(repo, policy, cmd)
+		if results[i] != statusOK {
+			failed = true
+		}
+	}
+
+	// atomic：任何一条失败则全部拒绝
+	if atomic && failed {
+		return rejectAtomic(results)
+	}
+
+	// pre-receive 只看到通过内置校验的更新，拒绝时全部失败
+	var updates []RefUpdate
+	for i, cmd := range cmds {
+		if results[i] == statusOK {
+			updates = append(updates, refUpdate(cmd))
+		}
+	}
+	if len(updates) > 0 {
+		if err := runPreReceive(hc, updates); err != nil {
+			_, _ = io.WriteString(hc.Output, declineMessage(err))
+			for i := range results {
+				if results[i] == statusOK {
+					results[i] = reasonPreReceiveDeclined
+				}
+			}
+			return results
+		}
+	}
+
+	// update 逐条执行，只拒绝对应的引用
+	for i, cmd := range cmds {
+		if results[i] != statusOK {
+			continue
+		}
+		if err := runUpdate(hc, refUpdate(cmd)); err != nil {
+			_, _ = io.WriteString(hc.Output, declineMessage(err))
+			results[i] = reasonUpdateDeclined
+			if atomic {
+				return rejectAtomic(results)
+			}
+		}
+	}
+
+	var applied []*packp.Command
+	for i, cmd := range cmds {
+		if results[i] != statusOK {
+			continue
+		}
+		if err := applyCommand(repo, cmd); err != nil {
+			results[i] = reasonUpdateFailed
+			if atomic {
+				rollback(repo, applied)
+				for j := range results {
+					if j != i {
+						results[j] = reasonAtomicRejected
+					}
+				}
+				return results
+			}
+			continue
+		}
+		applied = append(applied, cmd)
+	}
+	return results
+}
+
+func rejectAtomic(results []string) []string {
+	for i := range results {
+		if results[i] == statusOK {
+			results[i] = reasonAtomicRejected
+		}
+	}
+	return results
+}
+
+func refUpdate(cmd *packp.Command) RefUpdate {
+	return RefUpdate{Name: cmd.Name, Old: cmd.Old, New: cmd.New}
+}
+
+// checkCommand 校验单条引用更新命令
+func checkCommand(repo *git.Repository, policy *receivePolicy, cmd *packp.Command) string {
+	if !strings.HasPrefix(cmd.Name.String(), "refs/") || strings.Contains(cmd.Name.String(), "..") {
+		return reasonInvalidRefName
+	}
+
+	// 比较并交换：客户端声明的旧值必须与当前值一致
+	current := plumbing.ZeroHash
+	if ref, err := repo.Storer.Reference(cmd.Name); err == nil {
+		current = ref.Hash()
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return reasonUpdateFailed
+	}
+	if current != cmd.Old {
+		return reasonStaleInfo
+	}
+
+	switch cmd.Action() {
+	case packp.Delete:
+		if policy.denyDeletes {
+			return reasonDeleteDenied
+		}
+		if policy.denyDeleteCurrent {
+			if head, err := repo.Storer.Reference(plumbing.HEAD); err == nil && head.Target() == cmd.Name {
+				return reasonDeleteCurrent
+			}
+		}
+		return statusOK
+
+	case packp.Create:
+		if _, err := repo.Storer.EncodedObject(plumbing.AnyObject, cmd.New); err != nil {
+			return reasonMissingObjects
+		}
+		return statusOK
+
+	default: // Update
+		if _, err := repo.Storer.EncodedObject(plumbing.AnyObject, cmd.New); err != nil {
+			return reasonMissingObjects
+		}
+		if !policy.allowsForce(cmd.Name) && !isFastForward(repo, cmd.Old, cmd.New) {
+			return reasonNonFastForward
+		}
+		return statusOK
+	}
+}
+
+// isFastForward 判断 old 是否为 new 的祖先（非 commit 对象一律视为非快进）
+func isFastForward(repo *git.Repository, old, new plumbing.Hash) bool {
+	oldCommit, err := repo.CommitObject(old)
+	if err != nil {
+		return false
+	}
+	newCommit, err := repo.CommitObject(new)
+	if err != nil {
+		return false
+	}
+	ok, err := oldCommit.IsAncestor(newCommit)
+	return err == nil && ok
+}
+
+func applyCommand(repo *git.Repository, cmd *packp.Command) error {
+	if cmd.Action() == packp.Delete {
+		return repo.Storer.RemoveReference(cmd.Name)
+	}
+
+	var old *plumbing.Reference
+	if cmd.Action() == packp.Update {
+		old = plumbing.NewHashReference(cmd.Name, cmd.Old)
+	}
+	return repo.Storer.CheckAndSetReference(plumbing.NewHashReference(cmd.Name, cmd.New), old)
+}
+
+// rollback 尽力恢复 atomic 推送中已写入的引用
+func rollback(repo *git.Repository, applied []*packp.Command) {
+	for i := len(applied) - 1; i >= 0; i-- {
+		cmd := applied[i]
+		if cmd.Old == plumbing.ZeroHash {
+			_ = repo.Storer.RemoveReference(cmd.Name)
+		} else {
+			_ = repo.Storer.SetReference(plumbing.NewHashReference(cmd.Name, cmd.Old))
+		}
+	}
+}
+
+func needsPackfile(cmds []*packp.Command) bool {
+	for _, cmd := range cmds {
+		if cmd.Action() != packp.Delete {
+			return true
+		}
+	}
+	return false
+}
+
+func unpack(repo *git.Repository, r io.Reader) error {
+	if r == nil {
+		return errors.New("missing packfile")
+	}
+	parser, err := packfile.NewParserWithStorage(packfile.NewScanner(r), repo.Storer)
+	if err != nil {
+		return err
+	}
+	_, err = parser.Parse()
+	return err
+}
+
+func singleLine(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "\n", " ")
+}
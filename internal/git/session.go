@@ -0,0 +1,159 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+)
+
+// -------------------- stateful session --------------------
+//
+// SSH 等全双工传输上的有状态会话：同一连接内先广告引用，再完成全部协商轮次。
+// HTTP 是无状态的，每轮协商都是独立请求（见 handleDirectUploadPack）。
+
+// ServeUploadPack 在一个全双工连接上提供 git-upload-pack
+// protocolV2 为 true 时（客户端设置了 GIT_PROTOCOL=version=2）使用协议 v2
+func ServeUploadPack(ctx context.Context, repoPath string, protocolV2 bool, in io.Reader, out io.Writer) error {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(in)
+
+	if protocolV2 {
+		if err := writeV2Advertisement(out); err != nil {
+			return err
+		}
+		// 客户端可以发送多条命令（ls-refs、多轮 fetch），以 flush 或关闭连接结束
+		for {
+			head, err := br.Peek(4)
+			if err == io.EOF || bytes.Equal(head, []byte("0000")) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := handleUploadPackV2(ctx, repo, br, out); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writeRefAdvertisement(out, repo, "git-upload-pack"); err != nil {
+		return err
+	}
+
+	// want 段，客户端不需要任何对象时只发送 flush（或直接断开）
+	upr := &uploadRequest{capabilities: capability.NewList()}
+	if err := readUploadSection(br, upr); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if len(upr.wants) == 0 {
+		return nil
+	}
+
+	e := pktline.NewEncoder(out)
+	if err := upr.validate(); err != nil {
+		_ = e.Encodef("ERR upload-pack: %s\n", err)
+		return err
+	}
+	shallow, err := prepareUpload(repo, upr)
+	if err != nil {
+		_ = e.Encodef("ERR upload-pack: %s\n", err)
+		return err
+	}
+	if upr.deepening() {
+		if err := shallow.encode(e); err != nil {
+			return err
+		}
+	}
+
+	// have 段：每轮以 flush 或 done 结束，直到可以发送 packfile
+	upr.negotiating = true
+	n := newNegotiator(repo, upr)
+	for {
+		upr.haves = nil
+		if err := readUploadSection(br, upr); err != nil {
+			if err == io.EOF {
+				return nil // 浅克隆更新等场景下客户端可能不再继续
+			}
+			return err
+		}
+		ready, err := n.negotiate(e)
+		if err != nil {
+			return err
+		}
+		if ready {
+			break
+		}
+	}
+
+	switch {
+	case upr.capabilities.Supports(capability.Sideband64k):
+		return sendMultiplexedPack(out, sideband.Sideband64k, repo, upr, n.common, shallow)
+	case upr.capabilities.Supports(capability.Sideband):
+		return sendMultiplexedPack(out, sideband.Sideband, repo, upr, n.common, shallow)
+	default:
+		return sendPack(out, io.Discard, repo, upr, n.common, shallow)
+	}
+}
+
+// readUploadSection 读取请求行直到 flush 或 done
+func readUploadSection(r io.Reader, upr *uploadRequest) error {
+	for {
+		kind, line, err := readPkt(r)
+		if err != nil {
+			return err
+		}
+		if kind != pktData {
+			return nil
+		}
+		if err := upr.decodeLine(bytes.TrimSuffix(line, []byte("\n"))); err != nil {
+			return err
+		}
+		if upr.done {
+			return nil
+		}
+	}
+}
+
+// ServeReceivePack 在一个全双工连接上提供 git-receive-pack，pusher 为推送者用户名
+func ServeReceivePack(ctx context.Context, owner, repoName, repoPath, pusher string, in io.Reader, out io.Writer) error {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return err
+	}
+	if err := writeRefAdvertisement(out, repo, "git-receive-pack"); err != nil {
+		return err
+	}
+
+	// 没有需要更新的引用时客户端只发送 flush
+	br := bufio.NewReader(in)
+	head, err := br.Peek(4)
+	if err == io.EOF || bytes.Equal(head, []byte("0000")) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read update request: %w", err)
+	}
+
+	hc := &HookContext{
+		Context:    ctx,
+		Owner:      owner,
+		Repo:       repoName,
+		RepoPath:   repoPath,
+		Repository: repo,
+		Pusher:     pusher,
+	}
+	return handleDirectReceivePack(hc, br, out)
+}
@@ -0,0 +1,163 @@
+package git
+
+import (
+	"fmt"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+)
+
+// -------------------- shallow --------------------
+
+// shallowInfo 浅克隆（deepen / deepen-since）的计算结果
+type shallowInfo struct {
+	// shallow 新的边界提交，需告知客户端
+	shallow []plumbing.Hash
+	// unshallow 客户端原有的边界提交，本次将补全其历史
+	unshallow []plumbing.Hash
+	// boundary 遍历对象时不再进入父提交的提交
+	boundary map[plumbing.Hash]bool
+	// parents 被 unshallow 的提交的父提交，需额外发送
+	parents []plumbing.Hash
+}
+
+// computeShallow 根据请求中的 shallow / deepen / deepen-since 计算新的边界
+func computeShallow(repo *git.Repository, req *uploadRequest) (*shallowInfo, error) {
+	info := &shallowInfo{boundary: map[plumbing.Hash]bool{}}
+	theirs := map[plumbing.Hash]bool{}
+	for _, h := range req.shallows {
+		theirs[h] = true
+		info.boundary[h] = true
+	}
+
+	if !req.deepening() {
+		return info, nil
+	}
+
+	// deepen-relative：从客户端当前的边界继续加深，而不是从 want 开始计算
+	starts, limit := req.wants, req.depth
+	if req.depth > 0 && req.capabilities.Supports(capability.DeepenRelative) && len(req.shallows) > 0 {
+		starts, limit = req.shallows, req.depth+1
+	}
+
+	// keep 判断提交是否落在本次请求的深度范围内
+	keep := func(c *object.Commit, depth int) bool {
+		if limit > 0 {
+			return depth <= limit
+		}
+		return depth == 1 || !c.Committer.When.Before(req.deepenSince)
+	}
+
+	var queue []*object.Commit
+	depths := map[plumbing.Hash]int{}
+	for _, want := range starts {
+		c, err := peelToCommit(repo, want)
+		if err != nil {
+			continue
+		}
+		if _, ok := depths[c.Hash]; !ok {
+			depths[c.Hash] = 1
+			queue = append(queue, c)
+		}
+	}
+
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		depth := depths[c.Hash]
+
+		var parents []*object.Commit
+		isBoundary := false
+		for _, ph := range c.ParentHashes {
+			p, err := repo.CommitObject(ph)
+			if err != nil || !keep(p, depth+1) {
+				isBoundary = true
+				continue
+			}
+			parents = append(parents, p)
+		}
+
+		if isBoundary && len(c.ParentHashes) > 0 {
+			info.boundary[c.Hash] = true
+			if !theirs[c.Hash] {
+				info.shallow = append(info.shallow, c.Hash)
+			}
+			continue
+		}
+
+		delete(info.boundary, c.Hash)
+		if theirs[c.Hash] {
+			info.unshallow = append(info.unshallow, c.Hash)
+			info.parents = append(info.parents, c.ParentHashes...)
+		}
+		for _, p := range parents {
+			if _, ok := depths[p.Hash]; !ok {
+				depths[p.Hash] = depth + 1
+				queue = append(queue, p)
+			}
+		}
+	}
+
+	if len(depths) == 0 {
+		return nil, fmt.Errorf("no commits selected for shallow requests")
+	}
+	return info, nil
+}
+
+// encode 写出 shallow-update 段落（以 flush 结束）
+func (info *shallowInfo) encode(e *pktline.Encoder) error {
+	if err := info.encodeLines(e); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+func (info *shallowInfo) encodeLines(e *pktline.Encoder) error {
+	for _, h := range info.shallow {
+		if err := e.Encodef("shallow %s\n", h); err != nil {
+			return err
+		}
+	}
+	for _, h := range info.unshallow {
+		if err := e.Encodef("unshallow %s\n", h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// peelToCommit 将 tag 解引用到提交
+func peelToCommit(repo *git.Repository, h plumbing.Hash) (*object.Commit, error) {
+	for i := 0; i < 10; i++ {
+		obj, err := repo.Storer.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return nil, err
+		}
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			return object.DecodeCommit(repo.Storer, obj)
+		case plumbing.TagObject:
+			tag, err := object.DecodeTag(repo.Storer, obj)
+			if err != nil {
+				return nil, err
+			}
+			h = tag.Target
+		default:
+			return nil, plumbing.ErrObjectNotFound
+		}
+	}
+	return nil, plumbing.ErrObjectNotFound
+}
+
+// parseDeepenSince 解析 deepen-since 的 Unix 时间戳
+func parseDeepenSince(s string) (time.Time, error) {
+	var ts int64
+	if _, err := fmt.Sscanf(s, "%d", &ts); err != nil {
+		return time.Time{}, fmt.Errorf("invalid deepen-since: %s", s)
+	}
+	return time.Unix(ts, 0), nil
+}
@@ -0,0 +1,1052 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"container/heap"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// packWindow delta 压缩时比较的候选对象数量
+const packWindow = 10
+
+// thinBaseCommits 构造 thin pack 时最多参考的共同提交数量
+const thinBaseCommits = 4
+
+// reachSlop checkWants 按提交时间截止遍历时容忍的时钟偏差
+const reachSlop = 24 * time.Hour
+
+// -------------------- upload-pack --------------------
+
+// uploadRequest upload-pack 请求（协议 v0/v1）
+type uploadRequest struct {
+	wants        []plumbing.Hash
+	capabilities *capability.List
+	shallows     []plumbing.Hash
+	depth        int
+	deepenSince  time.Time
+	filter       *objectFilter
+	haves        []plumbing.Hash
+	done         bool
+
+	// negotiating 请求是否包含 have 段（无状态 HTTP 下，浅克隆的首轮请求只有 want 段）
+	negotiating bool
+}
+
+// deepening 是否请求了 deepen / deepen-since
+func (r *uploadRequest) deepening() bool {
+	return r.depth > 0 || !r.deepenSince.IsZero()
+}
+
+// decodeUploadRequest 解析 upload-pack 请求
+//
+//	want <oid> <capabilities>
+//	want <oid>
+//	shallow <oid>
+//	deepen <depth> | deepen-since <timestamp>
+//	filter <filter-spec>
+//	0000
+//	have <oid>
+//	...
+//	0000 | done
+func decodeUploadRequest(r io.Reader) (*uploadRequest, error) {
+	req := &uploadRequest{capabilities: capability.NewList()}
+	s := pktline.NewScanner(r)
+
+	flushes := 0
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), []byte("\n"))
+		if len(line) == 0 {
+			flushes++
+			if flushes > 1 {
+				break
+			}
+			continue
+		}
+		if flushes > 0 {
+			req.negotiating = true
+		}
+
+		if err := req.decodeLine(line); err != nil {
+			return nil, err
+		}
+		if req.done {
+			break
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return req, req.validate()
+}
+
+// decodeLine 解析 v0 与 v2 fetch 共用的请求行
+func (req *uploadRequest) decodeLine(line []byte) error {
+	cmd, arg, _ := bytes.Cut(line, []byte(" "))
+	switch string(cmd) {
+	case "want":
+		// v0 的能力列表跟在第一个 want 之后
+		if hash, caps, ok := bytes.Cut(arg, []byte(" ")); ok && len(req.wants) == 0 {
+			if err := req.capabilities.Decode(caps); err != nil {
+				return err
+			}
+			arg = hash
+		}
+		req.wants = append(req.wants, plumbing.NewHash(string(arg)))
+	case "shallow":
+		req.shallows = append(req.shallows, plumbing.NewHash(string(arg)))
+	case "deepen":
+		depth, err := strconv.Atoi(string(arg))
+		if err != nil || depth <= 0 {
+			return fmt.Errorf("invalid deepen: %s", arg)
+		}
+		req.depth = depth
+	case "deepen-since":
+		since, err := parseDeepenSince(string(arg))
+		if err != nil {
+			return err
+		}
+		req.deepenSince = since
+	case "filter":
+		filter, err := parseObjectFilter(string(arg))
+		if err != nil {
+			return err
+		}
+		req.filter = filter
+	case "have":
+		req.haves = append(req.haves, plumbing.NewHash(string(arg)))
+	case "done":
+		req.done = true
+	}
+	return nil
+}
+
+func (req *uploadRequest) validate() error {
+	if len(req.wants) == 0 {
+		return fmt.Errorf("no wants")
+	}
+	if req.depth > 0 && !req.deepenSince.IsZero() {
+		return fmt.Errorf("deepen and deepen-since cannot be used together")
+	}
+	return nil
+}
+
+func handleDirectUploadPack(
+	ctx context.Context,
+	repo *git.Repository,
+	req io.Reader,
+	res io.Writer,
+) error {
+
+	e := pktline.NewEncoder(res)
+
+	upr, err := decodeUploadRequest(req)
+	if err != nil {
+		_ = e.Encodef("ERR upload-pack: %s\n", err)
+		return err
+	}
+
+	shallow, err := prepareUpload(repo, upr)
+	if err != nil {
+		_ = e.Encodef("ERR upload-pack: %s\n", err)
+		return err
+	}
+	if upr.deepening() {
+		if err := shallow.encode(e); err != nil {
+			return err
+		}
+		if !upr.negotiating {
+			return nil
+		}
+	}
+
+	n := newNegotiator(repo, upr)
+	ready, err := n.negotiate(e)
+	if err != nil || !ready {
+		// 无状态 HTTP：本轮协商结束，客户端会带着更多 have 再次请求
+		return err
+	}
+
+	// 仅当客户端请求了 side-band 时才复用通道，否则直接写 packfile
+	switch {
+	case upr.capabilities.Supports(capability.Sideband64k):
+		return sendMultiplexedPack(res, sideband.Sideband64k, repo, upr, n.common, shallow)
+	case upr.capabilities.Supports(capability.Sideband):
+		return sendMultiplexedPack(res, sideband.Sideband, repo, upr, n.common, shallow)
+	default:
+		return sendPack(res, io.Discard, repo, upr, n.common, shallow)
+	}
+}
+
+// prepareUpload 校验 want 并计算浅克隆边界
+func prepareUpload(repo *git.Repository, upr *uploadRequest) (*shallowInfo, error) {
+	if err := checkWants(repo, upr.wants); err != nil {
+		return nil, err
+	}
+	return computeShallow(repo, upr)
+}
+
+// checkWants 只接受从引用可达的对象（allow-tip-sha1-in-want / allow-reachable-sha1-in-want），
+// 删除分支或强制推送后不再可达的对象不能被取回
+func checkWants(repo *git.Repository, wants []plumbing.Hash) error {
+	tips := refTips(repo)
+	remaining := map[plumbing.Hash]bool{}
+	var others []plumbing.Hash
+	for _, want := range wants {
+		if !tips[want] && !remaining[want] {
+			remaining[want] = true
+			others = append(others, want)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+
+	// 有不是引用的 want 时才遍历历史：从引用按提交时间从新到旧遍历，全部找到即停止；
+	// 只请求提交时，比最早的 want 还早（减去 reachSlop）的提交不再遍历
+	var cutoff time.Time
+	onlyCommits := true
+	for _, want := range others {
+		obj, err := repo.Storer.EncodedObject(plumbing.AnyObject, want)
+		if err != nil {
+			return fmt.Errorf("not our ref %s", want)
+		}
+		if obj.Type() != plumbing.CommitObject {
+			onlyCommits = false
+			continue
+		}
+		commit, err := object.DecodeCommit(repo.Storer, obj)
+		if err != nil {
+			return err
+		}
+		if cutoff.IsZero() || commit.Committer.When.Before(cutoff) {
+			cutoff = commit.Committer.When
+		}
+	}
+	cutoff = cutoff.Add(-reachSlop)
+
+	seen := map[plumbing.Hash]bool{}
+	var markTree func(h plumbing.Hash)
+	markTree = func(h plumbing.Hash) {
+		if seen[h] {
+			return
+		}
+		seen[h] = true
+		delete(remaining, h)
+		tree, err := repo.TreeObject(h)
+		if err != nil {
+			return
+		}
+		for _, e := range tree.Entries {
+			switch e.Mode {
+			case filemode.Dir:
+				markTree(e.Hash)
+			case filemode.Submodule:
+			default:
+				delete(remaining, e.Hash)
+			}
+		}
+	}
+
+	queue := &commitQueue{}
+	push := func(h plumbing.Hash) {
+		if seen[h] {
+			return
+		}
+		seen[h] = true
+		if commit, err := repo.CommitObject(h); err == nil {
+			heap.Push(queue, &walkNode{commit: commit})
+		} else if !onlyCommits {
+			markTree(h) // 引用直接指向树
+		}
+	}
+	for h := range tips {
+		push(h)
+	}
+	for queue.Len() > 0 && len(remaining) > 0 {
+		commit := heap.Pop(queue).(*walkNode).commit
+		if onlyCommits && commit.Committer.When.Before(cutoff) {
+			break
+		}
+		delete(remaining, commit.Hash)
+		if !onlyCommits {
+			markTree(commit.TreeHash)
+		}
+		for _, p := range commit.ParentHashes {
+			push(p)
+		}
+	}
+	for _, want := range others {
+		if remaining[want] {
+			return fmt.Errorf("not our ref %s", want)
+		}
+	}
+	return nil
+}
+
+// refTips 返回 HEAD 与所有引用指向的对象（附注标签同时包含其指向的对象）
+func refTips(repo *git.Repository) map[plumbing.Hash]bool {
+	tips := map[plumbing.Hash]bool{}
+	if head, err := repo.Head(); err == nil {
+		tips[head.Hash()] = true
+	}
+	for _, r := range sortedRefs(repo) {
+		tips[r.Hash()] = true
+		if tag, err := repo.TagObject(r.Hash()); err == nil {
+			tips[tag.Target] = true
+		}
+	}
+	return tips
+}
+
+// sendMultiplexedPack 通过 side-band 发送 packfile（通道 1）与进度信息（通道 2），最后写出 flush
+func sendMultiplexedPack(
+	res io.Writer,
+	t sideband.Type,
+	repo *git.Repository,
+	upr *uploadRequest,
+	common []plumbing.Hash,
+	shallow *shallowInfo,
+) error {
+
+	mux := sideband.NewMuxer(t, res)
+	var progress io.Writer = io.Discard
+	if !upr.capabilities.Supports(capability.NoProgress) {
+		progress = progressWriter{mux}
+	}
+	if err := sendPack(mux, progress, repo, upr, common, shallow); err != nil {
+		_, _ = mux.WriteChannel(sideband.ErrorMessage, []byte(err.Error()+"\n"))
+		return err
+	}
+	_, err := res.Write([]byte("0000"))
+	return err
+}
+
+// sendPack 计算需要发送的对象并写出 packfile
+func sendPack(
+	w, progress io.Writer,
+	repo *git.Repository,
+	upr *uploadRequest,
+	common []plumbing.Hash,
+	shallow *shallowInfo,
+) error {
+
+	wants := append(append([]plumbing.Hash{}, upr.wants...), shallow.parents...)
+	objs, err := collectObjects(repo, wants, common, upr.shallows, shallow.boundary, upr.filter)
+	if err != nil {
+		return err
+	}
+	if upr.capabilities.Supports(capability.IncludeTag) {
+		objs = includeTags(repo, objs)
+	}
+	fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(objs))
+
+	if upr.capabilities.Supports(capability.ThinPack) && len(common) > 0 {
+		err = writeThinPack(w, repo, objs, thinBases(repo, objs, common, upr.filter))
+	} else {
+		useRefDeltas := !upr.capabilities.Supports(capability.OFSDelta)
+		_, err = packfile.NewEncoder(w, repo.Storer, useRefDeltas).Encode(objs, packWindow)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(progress, "Total %d, done.\n", len(objs))
+	return nil
+}
+
+// progressWriter 将进度信息写入 side-band 通道 2
+type progressWriter struct {
+	mux *sideband.Muxer
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	return p.mux.WriteChannel(sideband.ProgressMessage, b)
+}
+
+// includeTags 附带指向已发送对象的附注标签（include-tag）
+func includeTags(repo *git.Repository, objs []plumbing.Hash) []plumbing.Hash {
+	sending := make(map[plumbing.Hash]bool, len(objs))
+	for _, h := range objs {
+		sending[h] = true
+	}
+
+	tags, err := repo.Storer.IterReferences()
+	if err != nil {
+		return objs
+	}
+	_ = tags.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsTag() || ref.Type() != plumbing.HashReference || sending[ref.Hash()] {
+			return nil
+		}
+		tag, err := repo.TagObject(ref.Hash())
+		if err != nil {
+			return nil // 轻量标签
+		}
+		if sending[tag.Target] {
+			sending[tag.Hash] = true
+			objs = append(objs, tag.Hash)
+		}
+		return nil
+	})
+	return objs
+}
+
+// -------------------- object walk --------------------
+
+// collectObjects 计算需要发送的对象：从 wants 可达、客户端又没有的对象
+// theirShallow 为客户端当前的浅克隆边界，boundary 为本次发送的边界，
+// 边界提交本身会发送，但不再进入其父提交
+// 与 git 相同，只排除边界上客户端已有的提交（待发送提交的父提交）的树，不遍历客户端的全部历史
+func collectObjects(
+	repo *git.Repository,
+	wants, haves, theirShallow []plumbing.Hash,
+	boundary map[plumbing.Hash]bool,
+	filter *objectFilter,
+) ([]plumbing.Hash, error) {
+
+	seen := map[plumbing.Hash]bool{}
+	want := &objectWalker{repo: repo, seen: seen, boundary: boundary, filter: filter}
+
+	// 1. 剥离 want 中的标签：提交参与历史遍历，树与 blob 直接发送
+	var commits, others []plumbing.Hash
+	for _, h := range wants {
+		for {
+			obj, err := repo.Storer.EncodedObject(plumbing.AnyObject, h)
+			if err != nil {
+				return nil, err
+			}
+			if obj.Type() == plumbing.CommitObject {
+				commits = append(commits, h)
+				break
+			}
+			if obj.Type() != plumbing.TagObject {
+				others = append(others, h)
+				break
+			}
+			tag, err := object.DecodeTag(repo.Storer, obj)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[h] {
+				want.add(h)
+			}
+			h = tag.Target
+		}
+	}
+
+	// 2. 客户端缺少的提交，以及边界上客户端已有的提交
+	send, edges, err := walkCommits(repo, commits, haves, theirShallow, boundary)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. 边界提交的树客户端已有
+	have := &objectWalker{repo: repo, seen: seen, allowMissing: true}
+	for _, commit := range edges {
+		if err := have.walkTree(commit.TreeHash); err != nil {
+			return nil, err
+		}
+	}
+
+	// 4. 客户端缺少的对象
+	for _, commit := range send {
+		want.add(commit.Hash)
+		if err := want.walkTree(commit.TreeHash); err != nil {
+			return nil, err
+		}
+	}
+	if err := want.walk(others); err != nil {
+		return nil, err
+	}
+	return want.out, nil
+}
+
+// walkNode 是历史遍历中的提交
+type walkNode struct {
+	commit        *object.Commit
+	uninteresting bool // 客户端已有（从 haves 可达）
+	done          bool // 已从队列取出
+}
+
+// commitQueue 按提交时间从新到旧取出提交（container/heap）
+type commitQueue []*walkNode
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].commit.Committer.When.After(q[j].commit.Committer.When)
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(*walkNode)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// walkCommits 按提交时间从新到旧同时遍历 wants 与 haves（即 rev-list wants ^haves），
+// 队列中只剩客户端已有的提交时停止；返回客户端缺少的提交，以及它们的父提交中客户端已有的（边界）
+// 时钟偏差可能让少量客户端已有的提交被当作缺少而多发送，但不会漏发
+func walkCommits(
+	repo *git.Repository,
+	wants, haves, theirShallow []plumbing.Hash,
+	boundary map[plumbing.Hash]bool,
+) (send, edges []*object.Commit, err error) {
+
+	theirBoundary := map[plumbing.Hash]bool{}
+	for _, h := range theirShallow {
+		theirBoundary[h] = true
+	}
+	nodes := map[plumbing.Hash]*walkNode{}
+	queue := &commitQueue{}
+	interesting := 0 // 队列中客户端缺少的提交数
+
+	// markUninteresting 把提交及已遍历到的祖先标记为客户端已有
+	markUninteresting := func(n *walkNode) {
+		stack := []*walkNode{n}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if n.uninteresting {
+				continue
+			}
+			n.uninteresting = true
+			if !n.done {
+				interesting--
+				continue
+			}
+			if theirBoundary[n.commit.Hash] {
+				continue
+			}
+			for _, p := range n.commit.ParentHashes {
+				if pn := nodes[p]; pn != nil {
+					stack = append(stack, pn)
+				}
+			}
+		}
+	}
+	push := func(h plumbing.Hash, uninteresting bool) error {
+		if n := nodes[h]; n != nil {
+			if uninteresting {
+				markUninteresting(n)
+			}
+			return nil
+		}
+		commit, err := repo.CommitObject(h)
+		if err != nil {
+			if uninteresting && err == plumbing.ErrObjectNotFound {
+				return nil
+			}
+			return err
+		}
+		nodes[h] = &walkNode{commit: commit, uninteresting: uninteresting}
+		heap.Push(queue, nodes[h])
+		if !uninteresting {
+			interesting++
+		}
+		return nil
+	}
+
+	for _, h := range wants {
+		if err := push(h, false); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, h := range haves {
+		if err := push(h, true); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var order []*walkNode
+	for interesting > 0 {
+		n := heap.Pop(queue).(*walkNode)
+		n.done = true
+		h := n.commit.Hash
+		if n.uninteresting {
+			if theirBoundary[h] {
+				continue
+			}
+		} else {
+			interesting--
+			order = append(order, n)
+			if boundary[h] {
+				continue
+			}
+		}
+		for _, p := range n.commit.ParentHashes {
+			if err := push(p, n.uninteresting); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	isEdge := map[plumbing.Hash]bool{}
+	for _, n := range order {
+		if n.uninteresting {
+			continue // 之后才发现客户端已有
+		}
+		send = append(send, n.commit)
+		for _, p := range n.commit.ParentHashes {
+			if pn := nodes[p]; pn != nil && pn.uninteresting && !isEdge[p] {
+				isEdge[p] = true
+				edges = append(edges, pn.commit)
+			}
+		}
+	}
+	return send, edges, nil
+}
+
+// objectWalker 沿提交历史遍历对象
+type objectWalker struct {
+	repo         *git.Repository
+	seen         map[plumbing.Hash]bool
+	boundary     map[plumbing.Hash]bool
+	filter       *objectFilter
+	allowMissing bool
+	out          []plumbing.Hash
+}
+
+func (w *objectWalker) walk(starts []plumbing.Hash) error {
+	pending := append([]plumbing.Hash{}, starts...)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if w.seen[h] {
+			continue
+		}
+
+		obj, err := w.repo.Storer.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			if w.allowMissing && err == plumbing.ErrObjectNotFound {
+				continue
+			}
+			return err
+		}
+
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			w.add(h)
+			commit, err := object.DecodeCommit(w.repo.Storer, obj)
+			if err != nil {
+				return err
+			}
+			if err := w.walkTree(commit.TreeHash); err != nil {
+				return err
+			}
+			if !w.boundary[h] {
+				pending = append(pending, commit.ParentHashes...)
+			}
+		case plumbing.TagObject:
+			w.add(h)
+			tag, err := object.DecodeTag(w.repo.Storer, obj)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, tag.Target)
+		case plumbing.TreeObject:
+			if err := w.walkTree(h); err != nil {
+				return err
+			}
+		default:
+			// 显式请求的 blob 不受过滤条件限制
+			w.add(h)
+		}
+	}
+	return nil
+}
+
+func (w *objectWalker) walkTree(h plumbing.Hash) error {
+	if w.seen[h] {
+		return nil
+	}
+	tree, err := w.repo.TreeObject(h)
+	if err != nil {
+		if w.allowMissing && err == plumbing.ErrObjectNotFound {
+			return nil
+		}
+		return err
+	}
+	w.add(h)
+
+	for _, e := range tree.Entries {
+		switch e.Mode {
+		case filemode.Dir:
+			if err := w.walkTree(e.Hash); err != nil {
+				return err
+			}
+		case filemode.Submodule:
+			// 子模块提交不在本仓库中
+		default:
+			if w.seen[e.Hash] {
+				continue
+			}
+			size := func() int64 {
+				if obj, err := w.repo.Storer.EncodedObject(plumbing.BlobObject, e.Hash); err == nil {
+					return obj.Size()
+				}
+				return 0
+			}
+			if w.filter.allowsBlob(size) {
+				w.add(e.Hash)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *objectWalker) add(h plumbing.Hash) {
+	w.seen[h] = true
+	w.out = append(w.out, h)
+}
+
+// -------------------- negotiation --------------------
+
+// negotiator 按 git upload-pack 的规则处理 have 并回复 ACK/NAK
+type negotiator struct {
+	repo     *git.Repository
+	req      *uploadRequest
+	multiAck bool
+	detailed bool
+
+	// common 客户端已拥有的提交（发送 packfile 时作为排除起点）
+	common []plumbing.Hash
+	// last 最近确认的共同对象（有状态会话中跨轮次保留）
+	last      plumbing.Hash
+	theyHave  map[plumbing.Hash]bool
+	reachable map[plumbing.Hash]bool
+}
+
+func newNegotiator(repo *git.Repository, req *uploadRequest) *negotiator {
+	caps := req.capabilities
+	return &negotiator{
+		repo:      repo,
+		req:       req,
+		multiAck:  caps.Supports(capability.MultiACK) || caps.Supports(capability.MultiACKDetailed),
+		detailed:  caps.Supports(capability.MultiACKDetailed),
+		theyHave:  map[plumbing.Hash]bool{},
+		reachable: map[plumbing.Hash]bool{},
+	}
+}
+
+// negotiate 处理本轮的 have 行，返回是否应当发送 packfile
+func (n *negotiator) negotiate(e *pktline.Encoder) (bool, error) {
+	gotCommon, gotOther, sentReady := false, false, false
+
+	for _, have := range n.req.haves {
+		ok, isNew := n.gotObject(have)
+		if !ok {
+			// 客户端有而我们没有的对象
+			gotOther = true
+			if n.multiAck && n.okToGiveUp() {
+				if n.detailed {
+					sentReady = true
+					if err := e.Encodef("ACK %s ready\n", have); err != nil {
+						return false, err
+					}
+				} else if err := e.Encodef("ACK %s continue\n", have); err != nil {
+					return false, err
+				}
+			}
+			continue
+		}
+
+		gotCommon = true
+		n.last = have
+		var err error
+		switch {
+		case n.detailed:
+			err = e.Encodef("ACK %s common\n", have)
+		case n.multiAck:
+			err = e.Encodef("ACK %s continue\n", have)
+		case isNew && len(n.theyHave) == 1:
+			err = e.Encodef("ACK %s\n", have)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if n.req.done {
+		if len(n.theyHave) > 0 {
+			if n.multiAck {
+				return true, e.Encodef("ACK %s\n", n.last)
+			}
+			return true, nil
+		}
+		return true, e.Encodef("NAK\n")
+	}
+
+	// 本轮以 flush 结束
+	if n.detailed && gotCommon && !gotOther && n.okToGiveUp() {
+		sentReady = true
+		if err := e.Encodef("ACK %s ready\n", n.last); err != nil {
+			return false, err
+		}
+	}
+	if len(n.theyHave) == 0 || n.multiAck {
+		if err := e.Encodef("NAK\n"); err != nil {
+			return false, err
+		}
+	}
+	if n.req.capabilities.Supports(capability.NoDone) && sentReady {
+		return true, e.Encodef("ACK %s\n", n.last)
+	}
+	return false, nil
+}
+
+// acknowledge 协议 v2 的 acknowledgments 段，返回是否已就绪（ready）
+func (n *negotiator) acknowledge(e *pktline.Encoder) (bool, error) {
+	if err := e.EncodeString("acknowledgments\n"); err != nil {
+		return false, err
+	}
+	acked := false
+	for _, have := range n.req.haves {
+		if ok, _ := n.gotObject(have); ok {
+			acked = true
+			if err := e.Encodef("ACK %s\n", have); err != nil {
+				return false, err
+			}
+		}
+	}
+	if !acked {
+		if err := e.EncodeString("NAK\n"); err != nil {
+			return false, err
+		}
+	}
+	if !n.okToGiveUp() {
+		return false, nil
+	}
+	return true, e.EncodeString("ready\n")
+}
+
+// absorb 记录客户端的 have（客户端已发送 done，无需回复）
+func (n *negotiator) absorb() {
+	for _, have := range n.req.haves {
+		n.gotObject(have)
+	}
+}
+
+// gotObject 记录客户端拥有的对象，返回服务端是否也拥有它，以及是否首次出现
+func (n *negotiator) gotObject(h plumbing.Hash) (bool, bool) {
+	if n.theyHave[h] {
+		return true, false
+	}
+	obj, err := n.repo.Storer.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return false, false
+	}
+	n.theyHave[h] = true
+	if obj.Type() == plumbing.CommitObject {
+		n.common = append(n.common, h)
+	}
+	return true, true
+}
+
+// okToGiveUp 每个 want 都能回溯到客户端已有的提交时，协商即可结束
+func (n *negotiator) okToGiveUp() bool {
+	if len(n.common) == 0 {
+		return false
+	}
+	for _, want := range n.req.wants {
+		if !n.reachesCommon(want) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *negotiator) reachesCommon(want plumbing.Hash) bool {
+	if n.reachable[want] {
+		return true
+	}
+	commit, err := n.repo.CommitObject(want)
+	if err != nil {
+		// 非提交对象（如 tag 指向的 blob）不参与协商
+		return true
+	}
+
+	found := false
+	iter := object.NewCommitPreorderIter(commit, nil, nil)
+	_ = iter.ForEach(func(c *object.Commit) error {
+		if n.theyHave[c.Hash] {
+			found = true
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if found {
+		n.reachable[want] = true
+	}
+	return found
+}
+
+// -------------------- thin pack --------------------
+
+// thinBases 为待发送的 blob/tree 查找客户端已有的同路径对象，作为 delta 基
+// 请求了 filter 时客户端可能没有共同提交中的 blob（部分克隆），只以树作为 delta 基
+func thinBases(repo *git.Repository, objs, common []plumbing.Hash, filter *objectFilter) map[plumbing.Hash]plumbing.Hash {
+	sending := make(map[plumbing.Hash]bool, len(objs))
+	for _, h := range objs {
+		sending[h] = true
+	}
+
+	// 客户端一侧：路径 -> 对象
+	theirs := map[string]plumbing.Hash{}
+	for i, h := range common {
+		if i >= thinBaseCommits {
+			break
+		}
+		commit, err := repo.CommitObject(h)
+		if err != nil {
+			continue
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			continue
+		}
+		walkTree(repo, tree, "", func(p string, e object.TreeEntry) bool {
+			if filter != nil && e.Mode != filemode.Dir {
+				return true
+			}
+			if _, ok := theirs[p]; !ok {
+				theirs[p] = e.Hash
+			}
+			return true
+		})
+	}
+
+	// 发送一侧：只进入本次需要发送的子树
+	bases := map[plumbing.Hash]plumbing.Hash{}
+	for _, h := range objs {
+		commit, err := repo.CommitObject(h)
+		if err != nil {
+			continue
+		}
+		tree, err := commit.Tree()
+		if err != nil || !sending[tree.Hash] {
+			continue
+		}
+		walkTree(repo, tree, "", func(p string, e object.TreeEntry) bool {
+			if !sending[e.Hash] {
+				return false
+			}
+			if base, ok := theirs[p]; ok && base != e.Hash {
+				if _, exists := bases[e.Hash]; !exists {
+					bases[e.Hash] = base
+				}
+			}
+			return true
+		})
+	}
+	return bases
+}
+
+// walkTree 深度优先遍历树，fn 返回 false 时不进入该子树
+func walkTree(repo *git.Repository, tree *object.Tree, prefix string, fn func(string, object.TreeEntry) bool) {
+	for _, e := range tree.Entries {
+		p := path.Join(prefix, e.Name)
+		if !fn(p, e) || e.Mode != filemode.Dir {
+			continue
+		}
+		sub, err := repo.TreeObject(e.Hash)
+		if err != nil {
+			continue
+		}
+		walkTree(repo, sub, p, fn)
+	}
+}
+
+// writeThinPack 写出 packfile，bases 中的对象以 REF_DELTA 形式引用客户端已有的对象
+func writeThinPack(w io.Writer, repo *git.Repository, objs []plumbing.Hash, bases map[plumbing.Hash]plumbing.Hash) error {
+	sum := sha1.New()
+	out := io.MultiWriter(w, sum)
+
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(objs)))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	for _, h := range objs {
+		obj, err := repo.Storer.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return err
+		}
+		content, err := readObject(obj)
+		if err != nil {
+			return err
+		}
+
+		if base, ok := bases[h]; ok {
+			if baseObj, err := repo.Storer.EncodedObject(plumbing.AnyObject, base); err == nil {
+				if baseContent, err := readObject(baseObj); err == nil {
+					delta := packfile.DiffDelta(baseContent, content)
+					if len(delta) < len(content)/2 {
+						if err := writePackEntry(out, plumbing.REFDeltaObject, base[:], delta); err != nil {
+							return err
+						}
+						continue
+					}
+				}
+			}
+		}
+
+		if err := writePackEntry(out, obj.Type(), nil, content); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(sum.Sum(nil))
+	return err
+}
+
+func writePackEntry(w io.Writer, t plumbing.ObjectType, base, data []byte) error {
+	// 类型与长度的变长编码
+	size := len(data)
+	head := []byte{byte(t)<<4 | byte(size&0x0f)}
+	size >>= 4
+	for size > 0 {
+		head[len(head)-1] |= 0x80
+		head = append(head, byte(size&0x7f))
+		size >>= 7
+	}
+	head = append(head, base...)
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+
+	zw := zlib.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func readObject(obj plumbing.EncodedObject) ([]byte, error) {
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,265 @@
+package git
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStorage 记录读取过的对象
+type countingStorage struct {
+	*memory.Storage
+	read map[plumbing.Hash]bool
+}
+
+func (s *countingStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	s.read[h] = true
+	return s.Storage.EncodedObject(t, h)
+}
+
+// testRepo 在内存中构造提交历史
+type testRepo struct {
+	t    *testing.T
+	st   *countingStorage
+	repo *git.Repository
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	st := &countingStorage{Storage: memory.NewStorage(), read: map[plumbing.Hash]bool{}}
+	repo, err := git.Init(st, nil)
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	return &testRepo{t: t, st: st, repo: repo}
+}
+
+func (r *testRepo) store(o interface {
+	Encode(plumbing.EncodedObject) error
+}) plumbing.Hash {
+	obj := r.st.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		r.t.Fatalf("encode failed: %v", err)
+	}
+	h, err := r.st.SetEncodedObject(obj)
+	if err != nil {
+		r.t.Fatalf("store failed: %v", err)
+	}
+	return h
+}
+
+func (r *testRepo) blob(content string) plumbing.Hash {
+	obj := r.st.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, _ := obj.Writer()
+	w.Write([]byte(content))
+	w.Close()
+	h, _ := r.st.SetEncodedObject(obj)
+	return h
+}
+
+// commit 提交只有一层目录的 files（文件名 -> 内容）
+func (r *testRepo) commit(when time.Time, files map[string]string, parents ...plumbing.Hash) plumbing.Hash {
+	tree := &object.Tree{}
+	for name, content := range files {
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: r.blob(content)})
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool { return tree.Entries[i].Name < tree.Entries[j].Name })
+	sig := object.Signature{Name: "test", Email: "test@example.com", When: when}
+	return r.store(&object.Commit{Author: sig, Committer: sig, Message: "test", TreeHash: r.store(tree), ParentHashes: parents})
+}
+
+// linear 提交 n 个线性提交，第 i 个提交新增 f{i}.txt，提交间隔一天
+func (r *testRepo) linear(n int) []plumbing.Hash {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := map[string]string{}
+	var commits []plumbing.Hash
+	for i := 0; i < n; i++ {
+		files[name(i)] = name(i) + " content\n"
+		var parents []plumbing.Hash
+		if i > 0 {
+			parents = commits[i-1:]
+		}
+		commits = append(commits, r.commit(base.AddDate(0, 0, i), files, parents...))
+	}
+	return commits
+}
+
+func name(i int) string {
+	return "f" + string(rune('a'+i/26)) + string(rune('a'+i%26)) + ".txt"
+}
+
+// reachable 返回从 starts 可达的全部对象
+func (r *testRepo) reachable(starts ...plumbing.Hash) map[plumbing.Hash]bool {
+	w := &objectWalker{repo: r.repo, seen: map[plumbing.Hash]bool{}, boundary: map[plumbing.Hash]bool{}}
+	if err := w.walk(starts); err != nil {
+		r.t.Fatalf("walk failed: %v", err)
+	}
+	return w.seen
+}
+
+func TestCollectObjects(t *testing.T) {
+	r := newTestRepo(t)
+	commits := r.linear(50)
+	tip, common := commits[49], commits[47]
+
+	// 只发送共同提交之后的提交与新增的对象
+	clear(r.st.read)
+	objs, err := collectObjects(r.repo, []plumbing.Hash{tip}, []plumbing.Hash{common}, nil, nil, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	c48, _ := r.repo.CommitObject(commits[48])
+	c49, _ := r.repo.CommitObject(tip)
+	assert.ElementsMatch(t, []plumbing.Hash{
+		tip, c49.TreeHash, r.blob(name(49) + " content\n"),
+		commits[48], c48.TreeHash, r.blob(name(48) + " content\n"),
+	}, objs)
+
+	// 不遍历共同提交之前的历史
+	for _, h := range commits[:47] {
+		assert.False(t, r.st.read[h], "commit %s should not be read", h)
+	}
+
+	// 过滤 blob 时只发送提交与树
+	objs, _ = collectObjects(r.repo, []plumbing.Hash{tip}, []plumbing.Hash{common}, nil, nil, &objectFilter{noBlobs: true})
+	assert.ElementsMatch(t, []plumbing.Hash{tip, c49.TreeHash, commits[48], c48.TreeHash}, objs)
+
+	// 没有共同提交时发送全部对象
+	objs, _ = collectObjects(r.repo, []plumbing.Hash{tip}, nil, nil, nil, nil)
+	assert.Len(t, objs, len(r.reachable(tip)))
+
+	// want 已是共同提交时不发送
+	objs, _ = collectObjects(r.repo, []plumbing.Hash{common}, []plumbing.Hash{tip}, nil, nil, nil)
+	assert.Empty(t, objs)
+}
+
+func TestCollectObjectsBranches(t *testing.T) {
+	r := newTestRepo(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return base.AddDate(0, 0, d) }
+
+	root := r.commit(day(0), map[string]string{"a": "1"})
+	main1 := r.commit(day(1), map[string]string{"a": "2"}, root)
+	// 时钟偏差：分支上的提交早于它的父提交
+	side1 := r.commit(day(-5), map[string]string{"a": "1", "b": "side"}, root)
+	side2 := r.commit(day(3), map[string]string{"a": "1", "b": "side2"}, side1)
+	merge := r.commit(day(4), map[string]string{"a": "2", "b": "side2"}, main1, side2)
+	main2 := r.commit(day(5), map[string]string{"a": "3", "b": "side2"}, merge)
+	other := r.commit(day(6), map[string]string{"c": "x"})
+
+	for _, tc := range []struct {
+		name         string
+		wants, haves []plumbing.Hash
+	}{
+		{"合并后的提交", []plumbing.Hash{main2}, []plumbing.Hash{main1}},
+		{"客户端有分支", []plumbing.Hash{main2}, []plumbing.Hash{side1}},
+		{"客户端有较新的分支", []plumbing.Hash{main1}, []plumbing.Hash{side2}},
+		{"多个共同提交", []plumbing.Hash{main2}, []plumbing.Hash{main1, side2}},
+		{"无关的历史", []plumbing.Hash{main2, other}, []plumbing.Hash{main1}},
+	} {
+		objs, err := collectObjects(r.repo, tc.wants, tc.haves, nil, nil, nil)
+		if !assert.NoError(t, err, tc.name) {
+			continue
+		}
+		// 客户端缺少的对象都要发送，可达的客户端已有提交不发送
+		sending := map[plumbing.Hash]bool{}
+		for _, h := range objs {
+			assert.False(t, sending[h], "%s: %s sent twice", tc.name, h)
+			sending[h] = true
+		}
+		theirs := r.reachable(tc.haves...)
+		for h := range r.reachable(tc.wants...) {
+			if !theirs[h] {
+				assert.True(t, sending[h], "%s: missing %s", tc.name, h)
+			}
+		}
+		for _, h := range tc.haves {
+			assert.False(t, sending[h], "%s: sent common commit %s", tc.name, h)
+		}
+	}
+}
+
+func TestCheckWants(t *testing.T) {
+	r := newTestRepo(t)
+	commits := r.linear(50)
+	r.st.SetReference(plumbing.NewHashReference("refs/heads/main", commits[49]))
+	// 不可达的提交（如强制推送前的分支）
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	dangling := r.commit(base.AddDate(0, 0, 45), map[string]string{"secret": "leaked"}, commits[44])
+
+	assert.NoError(t, checkWants(r.repo, []plumbing.Hash{commits[49]}))
+	assert.NoError(t, checkWants(r.repo, []plumbing.Hash{commits[49], commits[10]}))
+	old, _ := r.repo.CommitObject(commits[3])
+	assert.NoError(t, checkWants(r.repo, []plumbing.Hash{old.TreeHash, r.blob(name(3) + " content\n")}))
+
+	// 不可达的提交在截止时间之前停止遍历
+	clear(r.st.read)
+	assert.EqualError(t, checkWants(r.repo, []plumbing.Hash{dangling}), "not our ref "+dangling.String())
+	for _, h := range commits[:40] {
+		assert.False(t, r.st.read[h], "commit %s should not be read", h)
+	}
+
+	assert.EqualError(t, checkWants(r.repo, []plumbing.Hash{r.blob("leaked")}), "not our ref "+r.blob("leaked").String())
+	missing := plumbing.NewHash("1111111111111111111111111111111111111111")
+	assert.EqualError(t, checkWants(r.repo, []plumbing.Hash{missing}), "not our ref "+missing.String())
+}
+
+func TestThinPackWithFilter(t *testing.T) {
+	r := newTestRepo(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// data.txt 从 2000 字节改为 1400 字节：新版本在 blob:limit=1500 内，旧版本不在
+	big := strings.Repeat("0123456789\n", 200)
+	old := r.commit(base, map[string]string{"data.txt": big, "readme": "hello\n"})
+	tip := r.commit(base.AddDate(0, 0, 1), map[string]string{"data.txt": big[:1400], "readme": "hello\n"}, old)
+
+	fetch := func(filter *objectFilter) (*memory.Storage, error) {
+		// 客户端按同样的过滤条件克隆了 old，没有超过限制的 blob
+		client := memory.NewStorage()
+		for h := range r.reachable(old) {
+			obj, _ := r.st.EncodedObject(plumbing.AnyObject, h)
+			if obj.Type() == plumbing.BlobObject && !filter.allowsBlob(obj.Size) {
+				continue
+			}
+			client.SetEncodedObject(obj)
+		}
+
+		caps := capability.NewList()
+		caps.Set(capability.ThinPack)
+		caps.Set(capability.OFSDelta)
+		upr := &uploadRequest{wants: []plumbing.Hash{tip}, capabilities: caps, filter: filter}
+		var pack bytes.Buffer
+		if err := sendPack(&pack, io.Discard, r.repo, upr, []plumbing.Hash{old}, &shallowInfo{}); err != nil {
+			return nil, err
+		}
+		return client, packfile.UpdateObjectStorage(client, &pack)
+	}
+
+	// 过滤时不以客户端可能没有的 blob 作为 delta 基
+	for _, filter := range []*objectFilter{{blobLimit: 1500}, {noBlobs: true}} {
+		client, err := fetch(filter)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		_, err = client.EncodedObject(plumbing.CommitObject, tip)
+		assert.NoError(t, err)
+		_, err = client.EncodedObject(plumbing.BlobObject, r.blob(big[:1400]))
+		assert.Equal(t, filter.blobLimit > 0, err == nil)
+	}
+
+	// 不过滤时客户端有旧版本，可以作为 delta 基
+	c, _ := r.repo.CommitObject(tip)
+	bases := thinBases(r.repo, []plumbing.Hash{tip, c.TreeHash, r.blob(big[:1400])}, []plumbing.Hash{old}, nil)
+	assert.Equal(t, r.blob(big), bases[r.blob(big[:1400])])
+}
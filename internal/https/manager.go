@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"potstack/config"
+	"potstack/internal/webhook"
 
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -241,6 +242,7 @@ func (m *Manager) ForceRenew() (string, error) {
 	m.mu.Unlock()
 
 	log.Println("Certificate force renewed successfully")
+	m.emitRenewed(cfg.ACME.Domain, true)
 	return archiveDir, nil
 }
 
@@ -295,6 +297,20 @@ func (m *Manager) doRenew(withBackup bool) {
 	m.mu.Unlock()
 
 	log.Println("Certificate renewed successfully")
+	m.emitRenewed(cfg.ACME.Domain, false)
+}
+
+// emitRenewed 通知全局 webhook 证书已续签
+func (m *Manager) emitRenewed(domain string, forced bool) {
+	data := map[string]interface{}{"domain": domain, "forced": forced}
+	if cert, err := m.parseCertFile(); err == nil {
+		data["not_after"] = cert.NotAfter.Format(time.RFC3339)
+	}
+	webhook.Emit(&webhook.Payload{
+		Event:  webhook.EventCertificate,
+		Action: "renewed",
+		Data:   data,
+	})
 }
 
 // archiveCurrent 备份当前证书
@@ -0,0 +1,120 @@
+package keeper
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"potstack/config"
+	"potstack/internal/models"
+)
+
+// cpuPeriod cpu.max 的周期（微秒）
+const cpuPeriod = 100000
+
+// ResourceUsage 是从沙箱 cgroup 读取的资源使用情况
+type ResourceUsage struct {
+	MemoryCurrent int64 `json:"memory_current"`       // 字节
+	MemoryMax     int64 `json:"memory_max,omitempty"` // 字节，0 表示不限
+	OOMKills      int64 `json:"oom_kills"`
+	CPUUsageUsec  int64 `json:"cpu_usage_usec"`
+	ThrottledUsec int64 `json:"cpu_throttled_usec"`
+	PidsCurrent   int64 `json:"pids_current"`
+	PidsMax       int64 `json:"pids_max,omitempty"` // 0 表示不限
+}
+
+// cgroupSetting 是写入 cgroup 接口文件的一项设置
+type cgroupSetting struct {
+	file     string
+	value    string
+	required bool // pot.yml 中配置了该项，写入失败时不能启动
+}
+
+// cgroupDir 返回沙箱的 cgroup 目录：{CgroupRoot}/{org}/{name}
+func cgroupDir(org, name string) string {
+	return filepath.Join(config.CgroupRoot, org, name)
+}
+
+// cgroupSettings 校验资源限制并转换为 cgroup 接口文件的值，配置错误时返回 ErrInvalidResources
+// 未配置的项写入默认值，使 pot.yml 去掉限制后重启即生效
+func cgroupSettings(res *models.Resources) ([]cgroupSetting, error) {
+	var r models.Resources
+	if res != nil {
+		r = *res
+	}
+
+	memory := cgroupSetting{file: "memory.max", value: "max"}
+	if r.Memory != "" {
+		n, err := parseBytes(r.Memory)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%w: invalid memory %q", ErrInvalidResources, r.Memory)
+		}
+		memory = cgroupSetting{file: "memory.max", value: strconv.FormatInt(n, 10), required: true}
+	}
+
+	cpu := cgroupSetting{file: "cpu.max", value: fmt.Sprintf("max %d", cpuPeriod)}
+	if r.CPUs < 0 {
+		return nil, fmt.Errorf("%w: invalid cpus %v", ErrInvalidResources, r.CPUs)
+	}
+	if r.CPUs > 0 {
+		quota := int64(r.CPUs * cpuPeriod)
+		if quota < 1000 {
+			return nil, fmt.Errorf("%w: cpus %v is too small", ErrInvalidResources, r.CPUs)
+		}
+		cpu = cgroupSetting{file: "cpu.max", value: fmt.Sprintf("%d %d", quota, cpuPeriod), required: true}
+	}
+
+	weight := cgroupSetting{file: "cpu.weight", value: "100"}
+	if r.CPUWeight != 0 {
+		if r.CPUWeight < 1 || r.CPUWeight > 10000 {
+			return nil, fmt.Errorf("%w: cpu_weight must be between 1 and 10000", ErrInvalidResources)
+		}
+		weight = cgroupSetting{file: "cpu.weight", value: strconv.Itoa(r.CPUWeight), required: true}
+	}
+
+	pids := cgroupSetting{file: "pids.max", value: "max"}
+	if r.Pids != 0 {
+		if r.Pids < 1 {
+			return nil, fmt.Errorf("%w: invalid pids %d", ErrInvalidResources, r.Pids)
+		}
+		pids = cgroupSetting{file: "pids.max", value: strconv.Itoa(r.Pids), required: true}
+	}
+
+	io := cgroupSetting{file: "io.weight", value: "default 100"}
+	if r.IOWeight != 0 {
+		if r.IOWeight < 1 || r.IOWeight > 10000 {
+			return nil, fmt.Errorf("%w: io_weight must be between 1 and 10000", ErrInvalidResources)
+		}
+		io = cgroupSetting{file: "io.weight", value: fmt.Sprintf("default %d", r.IOWeight), required: true}
+	}
+
+	return []cgroupSetting{memory, cpu, weight, pids, io}, nil
+}
+
+// parseBytes 解析 512M、1G、1.5Gi、1048576 这样的大小（1024 进制）
+func parseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "i")
+	mult := float64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K', 'k':
+			mult = 1 << 10
+		case 'M', 'm':
+			mult = 1 << 20
+		case 'G', 'g':
+			mult = 1 << 30
+		case 'T', 't':
+			mult = 1 << 40
+		}
+		if mult != 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v * mult), nil
+}
@@ -0,0 +1,173 @@
+//go:build linux
+
+package keeper
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"potstack/config"
+	"potstack/internal/models"
+)
+
+// cgroup2Magic 是 cgroup v2 文件系统的 statfs 类型
+const cgroup2Magic = 0x63677270
+
+// cgroupControllers 沙箱 cgroup 需要启用的控制器
+var cgroupControllers = []string{"cpu", "memory", "pids", "io"}
+
+// setupCgroup 创建（或更新）沙箱的 cgroup 并写入资源限制，通过 CgroupFD 让进程在 exec 前就加入该 cgroup
+// 返回的函数在 cmd.Start 之后调用。cgroup 不可用时：配置了 resources 返回错误，否则不放入 cgroup
+func setupCgroup(org, name string, res *models.Resources, cmd *exec.Cmd) (func(), error) {
+	settings, err := cgroupSettings(res)
+	if err != nil {
+		return nil, err
+	}
+	release, err := placeInCgroup(org, name, settings, cmd)
+	if err != nil {
+		if res != nil {
+			return nil, fmt.Errorf("resource limits require cgroup v2: %w", err)
+		}
+		return func() {}, nil
+	}
+	return release, nil
+}
+
+func placeInCgroup(org, name string, settings []cgroupSetting, cmd *exec.Cmd) (func(), error) {
+	if err := cgroupAvailable(); err != nil {
+		return nil, err
+	}
+
+	// {root} 与 {root}/{org} 只用于组织子树，需要为下一级启用控制器
+	dir := cgroupDir(org, name)
+	for _, d := range []string{config.CgroupRoot, filepath.Dir(dir)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+		enableControllers(d)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	for _, st := range settings {
+		err := os.WriteFile(filepath.Join(dir, st.file), []byte(st.value), 0644)
+		if err != nil && st.required {
+			return nil, fmt.Errorf("failed to set %s: %w", st.file, err)
+		}
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	return func() { f.Close() }, nil
+}
+
+// cgroupAvailable 检查 CgroupRoot 是否位于 cgroup v2 文件系统中
+func cgroupAvailable() error {
+	if config.CgroupRoot == "" {
+		return fmt.Errorf("POTSTACK_CGROUP_ROOT is empty")
+	}
+	dir := config.CgroupRoot
+	if _, err := os.Stat(dir); err != nil {
+		dir = filepath.Dir(dir)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return err
+	}
+	if st.Type != cgroup2Magic {
+		return fmt.Errorf("%s is not on a cgroup v2 mount", dir)
+	}
+	return nil
+}
+
+// enableControllers 在 dir 的 cgroup.subtree_control 中启用可用的控制器
+func enableControllers(dir string) {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return
+	}
+	available := strings.Fields(string(data))
+	for _, c := range cgroupControllers {
+		for _, a := range available {
+			if a == c {
+				// 逐个写入，某个控制器失败不影响其他
+				if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+c), 0644); err != nil {
+					log.Printf("Failed to enable cgroup controller %s in %s: %v", c, dir, err)
+				}
+			}
+		}
+	}
+}
+
+// killCgroup 结束 cgroup 中剩余的进程（脱离了进程组的子孙进程）
+func killCgroup(org, name string) {
+	if config.CgroupRoot == "" {
+		return
+	}
+	os.WriteFile(filepath.Join(cgroupDir(org, name), "cgroup.kill"), []byte("1"), 0644)
+}
+
+// readCgroupUsage 读取沙箱 cgroup 的资源使用情况，cgroup 不存在时返回 nil
+func readCgroupUsage(org, name string) *ResourceUsage {
+	if config.CgroupRoot == "" {
+		return nil
+	}
+	dir := cgroupDir(org, name)
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.procs")); err != nil {
+		return nil
+	}
+
+	u := &ResourceUsage{
+		MemoryCurrent: readCgroupInt(dir, "memory.current"),
+		MemoryMax:     readCgroupInt(dir, "memory.max"),
+		PidsCurrent:   readCgroupInt(dir, "pids.current"),
+		PidsMax:       readCgroupInt(dir, "pids.max"),
+	}
+	u.OOMKills = readCgroupKey(dir, "memory.events", "oom_kill")
+	u.CPUUsageUsec = readCgroupKey(dir, "cpu.stat", "usage_usec")
+	u.ThrottledUsec = readCgroupKey(dir, "cpu.stat", "throttled_usec")
+	return u
+}
+
+// readCgroupInt 读取单值文件，"max" 或读取失败时返回 0
+func readCgroupInt(dir, file string) int64 {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return n
+}
+
+// readCgroupKey 读取 "key value" 格式文件中的一项
+func readCgroupKey(dir, file, key string) int64 {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
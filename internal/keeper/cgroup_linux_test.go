@@ -0,0 +1,50 @@
+//go:build linux
+
+package keeper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"potstack/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCgroupUsage(t *testing.T) {
+	root := config.CgroupRoot
+	config.CgroupRoot = t.TempDir()
+	defer func() { config.CgroupRoot = root }()
+
+	// cgroup 不存在
+	assert.Nil(t, readCgroupUsage("ann", "api"))
+
+	dir := cgroupDir("ann", "api")
+	os.MkdirAll(dir, 0755)
+	for file, content := range map[string]string{
+		"cgroup.procs":   "123\n",
+		"memory.current": "10485760\n",
+		"memory.max":     "max\n",
+		"memory.events":  "low 0\nhigh 0\nmax 4\noom 2\noom_kill 1\n",
+		"cpu.stat":       "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\nnr_throttled 3\nthrottled_usec 42000\n",
+		"pids.current":   "7\n",
+		"pids.max":       "128\n",
+	} {
+		os.WriteFile(filepath.Join(dir, file), []byte(content), 0644)
+	}
+
+	assert.Equal(t, &ResourceUsage{
+		MemoryCurrent: 10 << 20,
+		MemoryMax:     0, // max 表示不限
+		OOMKills:      1,
+		CPUUsageUsec:  2500000,
+		ThrottledUsec: 42000,
+		PidsCurrent:   7,
+		PidsMax:       128,
+	}, readCgroupUsage("ann", "api"))
+
+	// 未配置 cgroup 根目录时不读取
+	config.CgroupRoot = ""
+	assert.Nil(t, readCgroupUsage("ann", "api"))
+}
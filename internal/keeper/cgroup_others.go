@@ -0,0 +1,25 @@
+//go:build !linux
+
+package keeper
+
+import (
+	"log"
+	"os/exec"
+
+	"potstack/internal/models"
+)
+
+// setupCgroup 在非 Linux 平台上只校验配置，资源限制不生效
+func setupCgroup(org, name string, res *models.Resources, cmd *exec.Cmd) (func(), error) {
+	if _, err := cgroupSettings(res); err != nil {
+		return nil, err
+	}
+	if res != nil {
+		log.Printf("Resource limits of %s/%s ignored: cgroup v2 is only available on Linux", org, name)
+	}
+	return func() {}, nil
+}
+
+func killCgroup(org, name string) {}
+
+func readCgroupUsage(org, name string) *ResourceUsage { return nil }
@@ -0,0 +1,104 @@
+package keeper
+
+import (
+	"errors"
+	"testing"
+
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBytes(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"1048576", 1 << 20},
+		{"512K", 512 << 10},
+		{"512k", 512 << 10},
+		{"512M", 512 << 20},
+		{"512MB", 512 << 20},
+		{"512Mi", 512 << 20},
+		{"1G", 1 << 30},
+		{"1.5Gi", 3 << 29},
+		{"2T", 2 << 40},
+		{" 64M ", 64 << 20},
+	} {
+		got, err := parseBytes(tc.in)
+		if assert.NoError(t, err, tc.in) {
+			assert.Equal(t, tc.want, got, tc.in)
+		}
+	}
+
+	for _, in := range []string{"", "lots", "M", "1X", "1.2.3G"} {
+		_, err := parseBytes(in)
+		assert.Error(t, err, in)
+	}
+}
+
+// settingValues 把设置转换为 文件名 -> 值
+func settingValues(settings []cgroupSetting) map[string]string {
+	values := make(map[string]string, len(settings))
+	for _, st := range settings {
+		values[st.file] = st.value
+	}
+	return values
+}
+
+func TestCgroupSettings(t *testing.T) {
+	// 未配置 resources：写入默认值，都不是必需的
+	settings, err := cgroupSettings(nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{
+			"memory.max": "max",
+			"cpu.max":    "max 100000",
+			"cpu.weight": "100",
+			"pids.max":   "max",
+			"io.weight":  "default 100",
+		}, settingValues(settings))
+		for _, st := range settings {
+			assert.False(t, st.required, st.file)
+		}
+	}
+
+	// 配置的项转换为对应的接口文件，写入失败时不能启动
+	settings, err = cgroupSettings(&models.Resources{Memory: "256M", CPUs: 1.5, Pids: 64})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{
+			"memory.max": "268435456",
+			"cpu.max":    "150000 100000",
+			"cpu.weight": "100",
+			"pids.max":   "64",
+			"io.weight":  "default 100",
+		}, settingValues(settings))
+		for _, st := range settings {
+			required := st.file == "memory.max" || st.file == "cpu.max" || st.file == "pids.max"
+			assert.Equal(t, required, st.required, st.file)
+		}
+	}
+
+	settings, err = cgroupSettings(&models.Resources{CPUWeight: 200, IOWeight: 50})
+	if assert.NoError(t, err) {
+		values := settingValues(settings)
+		assert.Equal(t, "200", values["cpu.weight"])
+		assert.Equal(t, "default 50", values["io.weight"])
+	}
+
+	for _, tc := range []struct {
+		res models.Resources
+		err string
+	}{
+		{models.Resources{Memory: "lots"}, `invalid resources: invalid memory "lots"`},
+		{models.Resources{Memory: "0"}, `invalid resources: invalid memory "0"`},
+		{models.Resources{CPUs: -1}, "invalid resources: invalid cpus -1"},
+		{models.Resources{CPUs: 0.001}, "invalid resources: cpus 0.001 is too small"},
+		{models.Resources{CPUWeight: 10001}, "invalid resources: cpu_weight must be between 1 and 10000"},
+		{models.Resources{Pids: -1}, "invalid resources: invalid pids -1"},
+		{models.Resources{IOWeight: -5}, "invalid resources: io_weight must be between 1 and 10000"},
+	} {
+		_, err := cgroupSettings(&tc.res)
+		assert.EqualError(t, err, tc.err)
+		assert.True(t, errors.Is(err, ErrInvalidResources), tc.err)
+	}
+}
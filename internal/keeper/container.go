@@ -0,0 +1,261 @@
+package keeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"potstack/config"
+	"potstack/internal/docker"
+	"potstack/internal/models"
+)
+
+// 容器内的目录
+const (
+	containerProgramDir = "/program" // 只读
+	containerDataDir    = "/data"
+	containerLogDir     = "/log"
+)
+
+// containerHost 容器内访问宿主机（PotStack 内部端口）使用的主机名
+const containerHost = "host.docker.internal"
+
+const (
+	containerAPITimeout = 30 * time.Second // 单次 Engine API 调用（创建、启动、删除等）的超时
+	containerLogDrain   = 5 * time.Second  // 容器退出后等待日志读完的时间
+	containerPullLimit  = 10 * time.Minute // 拉取镜像的超时
+)
+
+// containerImage 返回 Loader / Redeploy 拉取后打的本地 tag
+func containerImage(org, name string) string {
+	return fmt.Sprintf("potstack/%s/%s:latest", org, name)
+}
+
+// containerName 返回实例的容器名：potstack-{org}-{name}，第二个及之后的实例加上序号 potstack-{org}-{name}-{replica}
+func containerName(org, name string, replica int) string {
+	if replica > 0 {
+		return fmt.Sprintf("potstack-%s-%s-%d", org, name, replica)
+	}
+	return fmt.Sprintf("potstack-%s-%s", org, name)
+}
+
+// newContainerConfig 返回 Docker pot 的容器配置
+// 端口只发布到 127.0.0.1，pot 在容器内监听 0.0.0.0 的同一端口；环境变量与原生进程一致，路径为容器内路径
+// userEnv 为解析后的用户环境变量，secretsDir 不为空时只读挂载到 /secrets
+func newContainerConfig(org, name string, potCfg *models.PotConfig, replica, port int, programDir, dataDir, logDir, secretsDir string, userEnv []models.EnvVar) (*docker.ContainerConfig, error) {
+	if potCfg.Isolation != nil && potCfg.Isolation.Enabled {
+		return nil, fmt.Errorf("isolation is not supported for docker pots")
+	}
+
+	portKey := fmt.Sprintf("%d/tcp", port)
+	cfg := &docker.ContainerConfig{
+		Image:        containerImage(org, name),
+		Labels:       map[string]string{"potstack.repository": org + "/" + name},
+		ExposedPorts: map[string]struct{}{portKey: {}},
+		HostConfig: docker.HostConfig{
+			PortBindings: map[string][]docker.PortBinding{
+				portKey: {{HostIP: "127.0.0.1", HostPort: strconv.Itoa(port)}},
+			},
+			ExtraHosts: []string{containerHost + ":host-gateway"},
+		},
+	}
+	type mount struct {
+		host, target, mode string
+	}
+	mounts := []mount{
+		{programDir, containerProgramDir, ":ro"},
+		{dataDir, containerDataDir, ""},
+		{logDir, containerLogDir, ""},
+	}
+	if secretsDir != "" {
+		mounts = append(mounts, mount{secretsDir, secretsMountDir, ":ro"})
+	}
+	for _, m := range mounts {
+		abs, err := filepath.Abs(m.host)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(abs, 0755); err != nil {
+			return nil, err
+		}
+		cfg.HostConfig.Binds = append(cfg.HostConfig.Binds, abs+":"+m.target+m.mode)
+	}
+
+	if err := containerResources(potCfg.Resources, &cfg.HostConfig); err != nil {
+		return nil, err
+	}
+
+	cfg.Env = []string{
+		"DATA_PATH=" + containerDataDir,
+		"PROGRAM_PATH=" + containerProgramDir,
+		"LOG_PATH=" + containerLogDir,
+		fmt.Sprintf("POTSTACK_BASE_URL=http://%s:%s", containerHost, config.InternalPort),
+		fmt.Sprintf("POTSTACK_REPLICA=%d", replica),
+		fmt.Sprintf("SU_SERVER_ADDR=0.0.0.0:%d", port),
+	}
+	for _, e := range userEnv {
+		if e.Name == "SU_SERVER_ADDR" {
+			continue // 端口已按 SU_SERVER_ADDR 分配，容器内始终监听 0.0.0.0
+		}
+		cfg.Env = append(cfg.Env, fmt.Sprintf("%s=%s", e.Name, e.Value))
+	}
+	return cfg, nil
+}
+
+// containerResources 把 pot.yml 的 resources 转换为容器的资源限制（容器由 Docker 管理 cgroup）
+func containerResources(res *models.Resources, hc *docker.HostConfig) error {
+	if _, err := cgroupSettings(res); err != nil {
+		return err
+	}
+	if res == nil {
+		return nil
+	}
+	if res.Memory != "" {
+		hc.Memory, _ = parseBytes(res.Memory)
+	}
+	if res.CPUs > 0 {
+		hc.NanoCPUs = int64(res.CPUs * 1e9)
+	}
+	if res.CPUWeight > 0 {
+		// cpu.weight 100 对应 cpu-shares 1024
+		hc.CPUShares = int64(res.CPUWeight) * 1024 / 100
+	}
+	if res.Pids > 0 {
+		hc.PidsLimit = int64(res.Pids)
+	}
+	if res.IOWeight > 0 {
+		log.Printf("io_weight is not supported for docker pots, ignored")
+	}
+	return nil
+}
+
+// containerProc 是通过 Engine API 运行的 Docker pot
+type containerProc struct {
+	client   *docker.Client
+	id       string
+	pidNum   int           // 容器主进程在宿主机上的 pid
+	logsDone chan struct{} // 日志流结束时关闭
+	stopLogs context.CancelFunc
+}
+
+// startContainer 删除上次残留的同名容器（如 PotStack 异常退出），创建并启动容器，stdout/stderr 写入 l
+func startContainer(org, name string, replica int, cfg *docker.ContainerConfig, l *potLog) (*containerProc, error) {
+	client := docker.Default()
+	ctx, cancel := context.WithTimeout(context.Background(), containerAPITimeout)
+	defer cancel()
+
+	cname := containerName(org, name, replica)
+	if err := client.ContainerRemove(ctx, cname, true); err != nil && !errors.Is(err, docker.ErrNotFound) {
+		return nil, err
+	}
+	id, err := client.ContainerCreate(ctx, cname, cfg)
+	if err != nil {
+		return nil, err
+	}
+	info, err := func() (*docker.ContainerInspect, error) {
+		if err := client.ContainerStart(ctx, id); err != nil {
+			return nil, err
+		}
+		return client.ContainerInspect(ctx, id)
+	}()
+	if err != nil {
+		removeContainer(client, id)
+		return nil, err
+	}
+
+	logCtx, stopLogs := context.WithCancel(context.Background())
+	p := &containerProc{
+		client:   client,
+		id:       id,
+		pidNum:   info.State.Pid,
+		logsDone: make(chan struct{}),
+		stopLogs: stopLogs,
+	}
+	stdout, stderr := l.pipe("stdout"), l.pipe("stderr")
+	go func() {
+		defer close(p.logsDone)
+		err := client.ContainerLogs(logCtx, id, true, stdout, stderr)
+		stdout.Close()
+		stderr.Close()
+		if err != nil && logCtx.Err() == nil {
+			log.Printf("Sandbox %s/%s: container logs: %v", org, name, err)
+		}
+	}()
+	return p, nil
+}
+
+func (p *containerProc) pid() int {
+	return p.pidNum
+}
+
+// wait 等待容器停止，读完日志后删除容器
+// 被信号终止时退出码为 Docker 报告的 128+信号值
+func (p *containerProc) wait() (int, string, error) {
+	code, err := p.client.ContainerWait(context.Background(), p.id)
+
+	t := time.NewTimer(containerLogDrain)
+	select {
+	case <-p.logsDone:
+	case <-t.C:
+	}
+	t.Stop()
+	p.stopLogs()
+	<-p.logsDone
+
+	removeContainer(p.client, p.id)
+	if err != nil {
+		return -1, "", err
+	}
+	return code, fmt.Sprintf("exit status %d", code), nil
+}
+
+// Terminate 向容器主进程发送 SIGTERM
+func (p *containerProc) Terminate() error {
+	return p.signal("SIGTERM")
+}
+
+// Kill 向容器主进程发送 SIGKILL
+func (p *containerProc) Kill() error {
+	return p.signal("SIGKILL")
+}
+
+func (p *containerProc) signal(sig string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), containerAPITimeout)
+	defer cancel()
+	err := p.client.ContainerKill(ctx, p.id, sig)
+	if errors.Is(err, docker.ErrConflict) || errors.Is(err, docker.ErrNotFound) {
+		return nil // 已停止
+	}
+	return err
+}
+
+// removeContainer 强制删除容器，容器不存在时忽略
+func removeContainer(client *docker.Client, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerAPITimeout)
+	defer cancel()
+	if err := client.ContainerRemove(ctx, id, true); err != nil && !errors.Is(err, docker.ErrNotFound) {
+		log.Printf("Failed to remove container %s: %v", id, err)
+	}
+}
+
+// pullImage 拉取 pot.yml 中的镜像并打本地 tag，拉取进度写入控制台日志
+func pullImage(org, name, image string, l *potLog) error {
+	progress := func(p docker.PullProgress) {
+		if p.Progress != "" {
+			return // 下载 / 解压进度条，只记录状态变化
+		}
+		msg := p.Status
+		if p.ID != "" {
+			msg = p.ID + ": " + msg
+		}
+		l.writeLine("docker", []byte(msg))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), containerPullLimit)
+	defer cancel()
+	return docker.Default().PullAndTag(ctx, image, containerImage(org, name), progress)
+}
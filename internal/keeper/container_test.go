@@ -0,0 +1,147 @@
+package keeper
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"potstack/config"
+	"potstack/internal/docker"
+	"potstack/internal/docker/dockertest"
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useFakeDocker 把 POTSTACK_DOCKER_SOCKET 指向假 Engine
+func useFakeDocker(t *testing.T) *dockertest.Engine {
+	old := config.DockerSocket
+	config.DockerSocket = filepath.Join(t.TempDir(), "docker.sock")
+	t.Cleanup(func() { config.DockerSocket = old })
+	return dockertest.NewEngine(t, config.DockerSocket)
+}
+
+// waitLine 等待日志中出现包含 want 的行
+func waitLine(l *potLog, want string) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		lines, _ := l.tail(50)
+		if strings.Contains(strings.Join(lines, "\n"), want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNewContainerConfig(t *testing.T) {
+	dir := t.TempDir()
+	potCfg := &models.PotConfig{Type: "exe", Resources: &models.Resources{Memory: "64M", CPUs: 0.5, CPUWeight: 50, Pids: 100}}
+	env := []models.EnvVar{{Name: "GREETING", Value: "hi"}, {Name: "SU_SERVER_ADDR", Value: "127.0.0.1:9"}}
+	cfg, err := newContainerConfig("ann", "web", potCfg, 1, 61001,
+		filepath.Join(dir, "program"), filepath.Join(dir, "data"), filepath.Join(dir, "log"), filepath.Join(dir, "secrets"), env)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "potstack/ann/web:latest", cfg.Image)
+	assert.Equal(t, "ann/web", cfg.Labels["potstack.repository"])
+	assert.Contains(t, cfg.ExposedPorts, "61001/tcp")
+	assert.Equal(t, []docker.PortBinding{{HostIP: "127.0.0.1", HostPort: "61001"}}, cfg.HostConfig.PortBindings["61001/tcp"])
+
+	// 程序与密钥只读挂载，宿主机目录不存在时创建
+	assert.Equal(t, []string{
+		filepath.Join(dir, "program") + ":/program:ro",
+		filepath.Join(dir, "data") + ":/data",
+		filepath.Join(dir, "log") + ":/log",
+		filepath.Join(dir, "secrets") + ":" + secretsMountDir + ":ro",
+	}, cfg.HostConfig.Binds)
+	assert.DirExists(t, filepath.Join(dir, "secrets"))
+
+	// 容器内路径；SU_SERVER_ADDR 始终监听 0.0.0.0 的分配端口
+	assert.Contains(t, cfg.Env, "DATA_PATH=/data")
+	assert.Contains(t, cfg.Env, "POTSTACK_REPLICA=1")
+	assert.Contains(t, cfg.Env, "SU_SERVER_ADDR=0.0.0.0:61001")
+	assert.NotContains(t, cfg.Env, "SU_SERVER_ADDR=127.0.0.1:9")
+	assert.Contains(t, cfg.Env, "GREETING=hi")
+
+	assert.Equal(t, int64(64<<20), cfg.HostConfig.Memory)
+	assert.Equal(t, int64(5e8), cfg.HostConfig.NanoCPUs)
+	assert.Equal(t, int64(512), cfg.HostConfig.CPUShares)
+	assert.Equal(t, int64(100), cfg.HostConfig.PidsLimit)
+
+	// 容器不支持 isolation
+	potCfg.Isolation = &models.Isolation{Enabled: true}
+	_, err = newContainerConfig("ann", "web", potCfg, 0, 61000, dir, dir, dir, "", nil)
+	assert.EqualError(t, err, "isolation is not supported for docker pots")
+}
+
+func TestPullImage(t *testing.T) {
+	engine := useFakeDocker(t)
+	l := newPotLog(t.TempDir())
+
+	// 拉取后打本地 tag，只记录状态变化，不记录进度条
+	assert.NoError(t, pullImage("ann", "web", "nginx:1.25", l))
+	assert.Equal(t, "POST /images/create?fromImage=nginx&tag=1.25\n"+
+		"POST /images/nginx:1.25/tag?repo=potstack%2Fann%2Fweb&tag=latest\n", engine.Calls())
+	lines, _ := l.tail(10)
+	if assert.Len(t, lines, 3) {
+		assert.Contains(t, lines[0], "[docker] 1.25: Pulling from library/nginx")
+		assert.Contains(t, lines[1], "[docker] layer1: Pull complete")
+		assert.Contains(t, lines[2], "[docker] Status: Downloaded newer image for nginx:1.25")
+	}
+
+	// 拉取失败时不打 tag
+	err := pullImage("ann", "web", "missing", l)
+	assert.EqualError(t, err, "docker pull missing failed: docker: pull missing: manifest unknown")
+	assert.NotContains(t, engine.Calls(), "/images/missing/tag")
+}
+
+func TestStartContainer(t *testing.T) {
+	engine := useFakeDocker(t)
+	l := newPotLog(t.TempDir())
+	cfg := &docker.ContainerConfig{Image: containerImage("ann", "web")}
+
+	// 删除上次残留的同名容器
+	stale, err := docker.Default().ContainerCreate(context.Background(), "potstack-ann-web-1", cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	p, err := startContainer("ann", "web", 1, cfg, l)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, engine.Container(stale))
+	c := engine.Container("potstack-ann-web-1")
+	if assert.NotNil(t, c) {
+		assert.Equal(t, p.id, c.ID)
+		assert.Equal(t, "potstack/ann/web:latest", c.Config["Image"])
+	}
+	assert.Equal(t, 4242, p.pid())
+	assert.True(t, waitLine(l, "[stdout] container up"))
+
+	// SIGTERM 后正常退出，wait 读完日志后删除容器
+	assert.NoError(t, p.Terminate())
+	code, reason, err := p.wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "exit status 0", reason)
+	assert.True(t, waitLine(l, "[stdout] container stopped"))
+	assert.Nil(t, engine.Container(p.id))
+	assert.Contains(t, engine.Calls(), fmt.Sprintf("DELETE /containers/%s?force=true\n", p.id))
+	// 已删除的容器再发送信号不报错
+	assert.NoError(t, p.Kill())
+
+	// 容器自行退出时返回它的退出码
+	p, err = startContainer("ann", "web", 0, cfg, l)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, engine.Crash("potstack-ann-web", 3))
+	code, reason, err = p.wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "exit status 3", reason)
+	assert.True(t, waitLine(l, "[stderr] container crashed"))
+	assert.Nil(t, engine.Container("potstack-ann-web"))
+}
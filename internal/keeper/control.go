@@ -0,0 +1,109 @@
+package keeper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"potstack/internal/git"
+	"potstack/internal/models"
+)
+
+var (
+	ErrAlreadyRunning   = errors.New("sandbox is already running")
+	ErrNotExe           = errors.New("not an exe type sandbox")
+	ErrNotReady         = errors.New("keeper is not ready")
+	ErrNotJob           = errors.New("not a job type sandbox")
+	ErrJobRunning       = errors.New("job is already running")
+	ErrJobRunNotFound   = errors.New("job run not found")
+	ErrInvalidResources = errors.New("invalid resources")
+)
+
+// installedPots 返回 PotProvider 提供的仓库列表，Loader 尚未完成初始化时 ok 为 false
+func (s *SandboxManager) installedPots() (list []PotURI, ok bool) {
+	s.mu.RLock()
+	p := s.PotProvider
+	s.mu.RUnlock()
+	if p == nil {
+		return nil, false
+	}
+	return p.GetInstalledPots(), true
+}
+
+// List 返回所有已安装 pot（有 pot.yml 的仓库）的状态
+func (s *SandboxManager) List() ([]*SandboxStatus, error) {
+	pots, ok := s.installedPots()
+	if !ok {
+		return nil, ErrNotReady
+	}
+
+	list := []*SandboxStatus{}
+	for _, p := range pots {
+		st, err := s.Status(p.Org, p.Name)
+		if err != nil {
+			return nil, err
+		}
+		if st != nil {
+			list = append(list, st)
+		}
+	}
+	return list, nil
+}
+
+// Restart 停止并重新启动 exe 沙箱（不更新代码）
+func (s *SandboxManager) Restart(org, name string) error {
+	var potCfg models.PotConfig
+	if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err != nil {
+		return fmt.Errorf("pot.yml not found: %w", err)
+	}
+	if potCfg.Type != "exe" {
+		return ErrNotExe
+	}
+
+	s.Stop(org, name)
+	return s.Start(org, name)
+}
+
+// Redeploy 按 Git 中的最新代码重新部署：exe 克隆新版本并切换（见 rollout），job 克隆新版本供下次执行，static 重新注册路由
+func (s *SandboxManager) Redeploy(org, name string) error {
+	var potCfg models.PotConfig
+	if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err != nil {
+		return fmt.Errorf("pot.yml not found: %w", err)
+	}
+
+	switch potCfg.Type {
+	case "static":
+		if s.Router == nil {
+			s.refreshRoute(org, name)
+			return nil
+		}
+		return s.Router.RegisterStatic(org, name, &potCfg)
+
+	case "exe":
+		lock := s.deployLock(org, name)
+		lock.Lock()
+		defer lock.Unlock()
+
+		release, err := s.cloneRelease(org, name)
+		if err != nil {
+			return err
+		}
+		if potCfg.Docker != "" {
+			// 重新拉取镜像，pot.yml 中的 docker 可能已变化
+			if err := pullImage(org, name, potCfg.Docker, s.potLog(org, name)); err != nil {
+				os.RemoveAll(s.programDir(org, name, release))
+				return err
+			}
+		}
+		return s.rollout(org, name, &potCfg, release)
+
+	case "job":
+		lock := s.deployLock(org, name)
+		lock.Lock()
+		defer lock.Unlock()
+		return s.deployJob(org, name)
+
+	default:
+		return fmt.Errorf("unsupported pot type %q", potCfg.Type)
+	}
+}
@@ -0,0 +1,228 @@
+package keeper
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"potstack/internal/git"
+	"potstack/internal/models"
+)
+
+// depends_on：reconcile 按依赖顺序启动 exe pot（依赖在前），依赖未启动或未就绪时推迟启动；
+// Shutdown 按相反的顺序停止（依赖它的 pot 都停止后才停止）
+
+// dependencyPollInterval 等待依赖时的检查间隔
+const dependencyPollInterval = 100 * time.Millisecond
+
+// dependencies 返回 pot.yml 中的 depends_on，忽略格式不是 org/name 的项
+func dependencies(key string, potCfg *models.PotConfig) []models.Dependency {
+	if potCfg.Type != "exe" {
+		return nil
+	}
+	var deps []models.Dependency
+	for _, d := range potCfg.DependsOn {
+		org, name, ok := strings.Cut(d.Pot, "/")
+		if !ok || org == "" || name == "" || strings.Contains(name, "/") {
+			log.Printf("Sandbox %s: invalid depends_on entry %q, expected org/name", key, d.Pot)
+			continue
+		}
+		deps = append(deps, d)
+	}
+	return deps
+}
+
+// dependencyKeys 返回依赖的 org/name
+func dependencyKeys(key string, potCfg *models.PotConfig) []string {
+	var keys []string
+	for _, d := range dependencies(key, potCfg) {
+		keys = append(keys, d.Pot)
+	}
+	return keys
+}
+
+// startOrder 按依赖关系排序，依赖在前，没有依赖关系的 pot 保持原有顺序
+// 未安装的依赖不影响顺序（启动时等待）；成环的 pot 及依赖它们的 pot 放入 cyclic
+func startOrder(pots []PotURI, deps map[string][]string) (order, cyclic []PotURI) {
+	installed := make(map[string]bool, len(pots))
+	for _, p := range pots {
+		installed[fmt.Sprintf("%s/%s", p.Org, p.Name)] = true
+	}
+	placed := make(map[string]bool, len(pots))
+	remaining := pots
+	for len(remaining) > 0 {
+		var next []PotURI
+		for _, p := range remaining {
+			key := fmt.Sprintf("%s/%s", p.Org, p.Name)
+			ready := true
+			for _, d := range deps[key] {
+				if installed[d] && !placed[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				order = append(order, p)
+				placed[key] = true
+			} else {
+				next = append(next, p)
+			}
+		}
+		if len(next) == len(remaining) {
+			return order, next
+		}
+		remaining = next
+	}
+	return order, nil
+}
+
+// cyclePath 从 start 出发沿未能排序的依赖找到环，返回 "a -> b -> a"
+func cyclePath(start string, deps map[string][]string, blocked map[string]bool) string {
+	seen := make(map[string]int)
+	var path []string
+	for cur := start; cur != ""; {
+		if i, ok := seen[cur]; ok {
+			return strings.Join(append(path[i:], cur), " -> ")
+		}
+		seen[cur] = len(path)
+		path = append(path, cur)
+		next := ""
+		for _, d := range deps[cur] {
+			if blocked[d] {
+				next = d
+				break
+			}
+		}
+		cur = next
+	}
+	return strings.Join(path, " -> ")
+}
+
+// stopOrder 把要停止的沙箱分批，依赖它的沙箱都停止后才停止（与启动顺序相反），成环时剩余的一起停止
+func stopOrder(keys []string, deps map[string][]string) [][]string {
+	var waves [][]string
+	remaining := keys
+	for len(remaining) > 0 {
+		needed := make(map[string]bool) // 仍被其他沙箱依赖
+		for _, key := range remaining {
+			for _, d := range deps[key] {
+				if d != key {
+					needed[d] = true
+				}
+			}
+		}
+		var wave, rest []string
+		for _, key := range remaining {
+			if needed[key] {
+				rest = append(rest, key)
+			} else {
+				wave = append(wave, key)
+			}
+		}
+		if len(wave) == 0 {
+			wave, rest = remaining, nil
+		}
+		waves = append(waves, wave)
+		remaining = rest
+	}
+	return waves
+}
+
+// startAfterDependencies 依赖都已满足时立即启动；否则把 state 记为 waiting，在后台等待依赖满足后启动
+func (s *SandboxManager) startAfterDependencies(org, name string, potCfg *models.PotConfig) {
+	key := fmt.Sprintf("%s/%s", org, name)
+	deps := dependencies(key, potCfg)
+	pending := s.pendingDependency(deps)
+	if pending == "" {
+		if err := s.Start(org, name); err != nil {
+			log.Printf("Failed to start sandbox %s: %v", key, err)
+		}
+		return
+	}
+
+	log.Printf("Sandbox %s is waiting for %s", key, pending)
+	s.mu.Lock()
+	rc, _ := s.loadRunConfig(org, name)
+	if rc == nil {
+		rc = &models.RunConfig{}
+	}
+	rc.TargetStatus = models.RunStatusRunning
+	rc.State = models.RunStateWaiting
+	s.saveRunConfig(org, name, rc)
+	s.mu.Unlock()
+
+	go func() {
+		t := time.NewTicker(dependencyPollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-s.stopChan:
+				return
+			}
+
+			// 等待期间可能已被手动启动或停止
+			s.mu.RLock()
+			_, running := s.runningInstances[key]
+			rc, _ := s.loadRunConfig(org, name)
+			s.mu.RUnlock()
+			if running || rc == nil || rc.TargetStatus != models.RunStatusRunning || rc.State != models.RunStateWaiting {
+				return
+			}
+			if s.pendingDependency(deps) != "" {
+				continue
+			}
+
+			log.Printf("Dependencies of %s are ready, starting", key)
+			if err := s.Start(org, name); err != nil && !errors.Is(err, ErrAlreadyRunning) {
+				log.Printf("Failed to start sandbox %s: %v", key, err)
+			}
+			return
+		}
+	}()
+}
+
+// pendingDependency 返回第一个未满足的依赖及原因，都已满足时为空
+func (s *SandboxManager) pendingDependency(deps []models.Dependency) string {
+	for _, d := range deps {
+		if reason := s.dependencyPending(d); reason != "" {
+			return fmt.Sprintf("%s (%s)", d.Pot, reason)
+		}
+	}
+	return ""
+}
+
+// dependencyPending 返回依赖未满足的原因，满足时为空：
+// static / job 已安装即可；exe 需已启动（ready 时需有实例就绪），空闲停止的 pot 收到请求时启动，也视为满足
+func (s *SandboxManager) dependencyPending(d models.Dependency) string {
+	org, name, _ := strings.Cut(d.Pot, "/")
+	var potCfg models.PotConfig
+	if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err != nil {
+		return "not installed"
+	}
+	if potCfg.Type != "exe" {
+		return ""
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	insts, running := s.runningInstances[d.Pot]
+	if !running {
+		rc, _ := s.loadRunConfig(org, name)
+		if rc != nil && rc.TargetStatus == models.RunStatusRunning && rc.State == models.RunStateIdle && potCfg.IdleTimeout > 0 {
+			return ""
+		}
+		return "not running"
+	}
+	if !d.Ready {
+		return ""
+	}
+	for _, inst := range insts {
+		if inst != nil && inst.Ready {
+			return ""
+		}
+	}
+	return "not ready"
+}
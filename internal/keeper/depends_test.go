@@ -0,0 +1,219 @@
+package keeper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pots 把 org/name 转换为 PotURI
+func pots(keys ...string) []PotURI {
+	var list []PotURI
+	for _, key := range keys {
+		org, name, _ := strings.Cut(key, "/")
+		list = append(list, PotURI{Org: org, Name: name})
+	}
+	return list
+}
+
+func TestDependencyKeys(t *testing.T) {
+	cfg := &models.PotConfig{Type: "exe", DependsOn: []models.Dependency{
+		{Pot: "ann/db", Ready: true},
+		{Pot: "cache"},
+		{Pot: "/db"},
+		{Pot: "ann/"},
+		{Pot: "ann/db/x"},
+		{Pot: "ann/cache"},
+	}}
+	// 格式不是 org/name 的项被忽略
+	assert.Equal(t, []string{"ann/db", "ann/cache"}, dependencyKeys("ann/api", cfg))
+
+	// 只有 exe 的 depends_on 生效
+	cfg.Type = "static"
+	assert.Nil(t, dependencyKeys("ann/web", cfg))
+}
+
+func TestStartOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		pots          []PotURI
+		deps          map[string][]string
+		order, cyclic []PotURI
+	}{
+		{
+			name:  "没有依赖时保持原有顺序",
+			pots:  pots("a/web", "a/api", "a/db"),
+			order: pots("a/web", "a/api", "a/db"),
+		},
+		{
+			name:  "依赖在前",
+			pots:  pots("a/web", "a/api", "a/db", "a/tool"),
+			deps:  map[string][]string{"a/web": {"a/api"}, "a/api": {"a/db"}},
+			order: pots("a/db", "a/tool", "a/api", "a/web"),
+		},
+		{
+			name:  "多个依赖都在前",
+			pots:  pots("a/web", "a/api", "a/db"),
+			deps:  map[string][]string{"a/web": {"a/db", "a/api"}},
+			order: pots("a/api", "a/db", "a/web"),
+		},
+		{
+			name:  "未安装的依赖不影响顺序",
+			pots:  pots("a/web", "a/api"),
+			deps:  map[string][]string{"a/web": {"b/gone"}, "a/api": {"a/web"}},
+			order: pots("a/web", "a/api"),
+		},
+		{
+			name:   "成环的 pot 及依赖它们的 pot 不排序",
+			pots:   pots("a/x", "a/y", "a/web", "a/tool"),
+			deps:   map[string][]string{"a/x": {"a/y"}, "a/y": {"a/x"}, "a/web": {"a/x"}},
+			order:  pots("a/tool"),
+			cyclic: pots("a/x", "a/y", "a/web"),
+		},
+		{
+			name:   "依赖自己",
+			pots:   pots("a/x", "a/tool"),
+			deps:   map[string][]string{"a/x": {"a/x"}},
+			order:  pots("a/tool"),
+			cyclic: pots("a/x"),
+		},
+	} {
+		order, cyclic := startOrder(tc.pots, tc.deps)
+		assert.Equal(t, tc.order, order, tc.name)
+		assert.Equal(t, tc.cyclic, cyclic, tc.name)
+	}
+}
+
+func TestCyclePath(t *testing.T) {
+	deps := map[string][]string{
+		"a/x":   {"a/y"},
+		"a/y":   {"a/db", "a/z"},
+		"a/z":   {"a/x"},
+		"a/web": {"a/x"},
+		"a/s":   {"a/s"},
+	}
+	blocked := map[string]bool{"a/x": true, "a/y": true, "a/z": true, "a/web": true, "a/s": true}
+
+	// 只沿未能排序的依赖查找（a/db 已排序）
+	assert.Equal(t, "a/x -> a/y -> a/z -> a/x", cyclePath("a/x", deps, blocked))
+	assert.Equal(t, "a/z -> a/x -> a/y -> a/z", cyclePath("a/z", deps, blocked))
+	// 依赖环的 pot 返回它依赖的环
+	assert.Equal(t, "a/x -> a/y -> a/z -> a/x", cyclePath("a/web", deps, blocked))
+	assert.Equal(t, "a/s -> a/s", cyclePath("a/s", deps, blocked))
+	// 没有未排序的依赖时只有自己
+	assert.Equal(t, "a/db", cyclePath("a/db", deps, blocked))
+}
+
+func TestStopOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		keys  []string
+		deps  map[string][]string
+		waves [][]string
+	}{
+		{
+			name:  "没有依赖时一起停止",
+			keys:  []string{"a/web", "a/api"},
+			waves: [][]string{{"a/web", "a/api"}},
+		},
+		{
+			name:  "依赖它的沙箱先停止",
+			keys:  []string{"a/db", "a/api", "a/web", "a/tool"},
+			deps:  map[string][]string{"a/web": {"a/api"}, "a/api": {"a/db"}},
+			waves: [][]string{{"a/web", "a/tool"}, {"a/api"}, {"a/db"}},
+		},
+		{
+			name:  "不在停止列表中的依赖被忽略，依赖自己不影响顺序",
+			keys:  []string{"a/api", "a/db"},
+			deps:  map[string][]string{"a/api": {"b/gone", "a/db"}, "a/db": {"a/db"}},
+			waves: [][]string{{"a/api"}, {"a/db"}},
+		},
+		{
+			name:  "成环时剩余的一起停止",
+			keys:  []string{"a/x", "a/y", "a/web"},
+			deps:  map[string][]string{"a/x": {"a/y"}, "a/y": {"a/x"}, "a/web": {"a/x"}},
+			waves: [][]string{{"a/web"}, {"a/x", "a/y"}},
+		},
+	} {
+		assert.Equal(t, tc.waves, stopOrder(tc.keys, tc.deps), tc.name)
+	}
+}
+
+func TestDependencyStartup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe 是 shell 脚本")
+	}
+	root := t.TempDir()
+	s := NewManager(root, nil)
+	defer s.Shutdown()
+
+	// 每个 pot 启动与停止时在 START_LOG 中记一行
+	startLog := filepath.Join(t.TempDir(), "order.log")
+	deploy := func(name, extra, init string) {
+		commitPot(t, root, "ann", name, map[string]string{
+			"pot.yml": fmt.Sprintf("type: exe\nstop_timeout: 2s\nenv:\n  - name: START_LOG\n    value: %s\n%s", startLog, extra),
+			"pot.exe": fmt.Sprintf("#!/bin/sh\ntrap 'echo \"stop %s\" >> \"$START_LOG\"; exit 0' TERM\necho \"start %s\" >> \"$START_LOG\"\n%swhile true; do sleep 0.05; done\n", name, name, init),
+		})
+	}
+	logLines := func() []string {
+		data, _ := os.ReadFile(startLog)
+		return strings.Fields(strings.ReplaceAll(string(data), " ", "_"))
+	}
+
+	// db 启动 0.5s 后才就绪；api 要求 db 就绪，app 只要求 api 已启动；c1 与 c2 互相依赖
+	deploy("db", "readiness:\n  type: exec\n  command: [\"sh\", \"-c\", \"test -f \\\"$DATA_PATH/db.ready\\\"\"]\n  interval: 20ms\n",
+		"sleep 0.5\necho \"ready db\" >> \"$START_LOG\"\ntouch \"$DATA_PATH/db.ready\"\n")
+	deploy("api", "depends_on:\n  - pot: ann/db\n    ready: true\n", "")
+	deploy("app", "depends_on:\n  - ann/api\n", "")
+	deploy("c1", "depends_on:\n  - ann/c2\n", "")
+	deploy("c2", "depends_on:\n  - ann/c1\n", "")
+
+	// 目录顺序与依赖顺序相反
+	s.SetPotProvider(potList(pots("ann/app", "ann/api", "ann/db", "ann/c1", "ann/c2")))
+	go s.StartKeeper()
+
+	// 1. 依赖未就绪时 api 处于 waiting
+	waiting := false
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		st, err := s.Status("ann", "api")
+		if err != nil || (st.State != models.RunStateWaiting && st.State != models.RunStateRunning) {
+			continue // 尚未初始化
+		}
+		waiting = st.State == models.RunStateWaiting
+		break
+	}
+	assert.True(t, waiting, "api should wait for db to become ready")
+
+	// 2. 按依赖顺序启动
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if len(logLines()) >= 4 {
+			break
+		}
+	}
+	assert.Equal(t, []string{"start_db", "ready_db", "start_api", "start_app"}, logLines())
+	st, err := s.Status("ann", "api")
+	if assert.NoError(t, err) {
+		assert.Equal(t, models.RunStateRunning, st.State)
+	}
+
+	// 3. 循环依赖的 pot 不启动
+	time.Sleep(200 * time.Millisecond)
+	for _, name := range []string{"c1", "c2"} {
+		if st, err := s.Status("ann", name); err == nil {
+			assert.Zero(t, st.Pid, name)
+		}
+	}
+	assert.Len(t, logLines(), 4)
+
+	// 4. 退出时逆序停止
+	s.Shutdown()
+	assert.Equal(t, []string{"start_db", "ready_db", "start_api", "start_app", "stop_app", "stop_api", "stop_db"}, logLines())
+}
@@ -0,0 +1,67 @@
+package keeper
+
+import (
+	"fmt"
+	"log"
+
+	"potstack/internal/git"
+	"potstack/internal/models"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// DeployHook 返回内置的 post-receive 钩子：默认分支（HEAD 指向的分支）更新后重新部署
+//
+//	static：重新注册路由（pot.yml 中的 root 可能已变化）
+//	exe：   异步调用 SignalUpdate，重新克隆代码并重启进程
+//	job：   异步调用 SignalUpdate，重新克隆代码，下次执行时使用
+func (s *SandboxManager) DeployHook() git.PostReceiveHook {
+	return func(hc *git.HookContext, updates []git.RefUpdate) {
+		if !defaultBranchMoved(hc, updates) {
+			return
+		}
+
+		var potCfg models.PotConfig
+		if err := git.ReadPotYml(s.RepoRoot, hc.Owner, hc.Repo, &potCfg); err != nil {
+			return // 没有 pot.yml 的普通仓库不需要部署
+		}
+
+		switch potCfg.Type {
+		case "static":
+			if s.Router == nil {
+				s.refreshRoute(hc.Owner, hc.Repo)
+			} else if err := s.Router.RegisterStatic(hc.Owner, hc.Repo, &potCfg); err != nil {
+				log.Printf("Deploy of static pot %s/%s failed: %v", hc.Owner, hc.Repo, err)
+				fmt.Fprintf(hc.Output, "potstack: deploy failed: %v\n", err)
+				return
+			}
+			log.Printf("Deployed static pot %s/%s (pushed by %q)", hc.Owner, hc.Repo, hc.Pusher)
+			fmt.Fprintf(hc.Output, "potstack: static pot %s/%s deployed\n", hc.Owner, hc.Repo)
+
+		case "exe":
+			// 重新克隆与重启耗时较长，不阻塞推送
+			log.Printf("Redeploying exe pot %s/%s (pushed by %q)", hc.Owner, hc.Repo, hc.Pusher)
+			fmt.Fprintf(hc.Output, "potstack: redeploying exe pot %s/%s\n", hc.Owner, hc.Repo)
+			go s.SignalUpdate(hc.Owner, hc.Repo)
+
+		case "job":
+			log.Printf("Redeploying job pot %s/%s (pushed by %q)", hc.Owner, hc.Repo, hc.Pusher)
+			fmt.Fprintf(hc.Output, "potstack: redeploying job pot %s/%s\n", hc.Owner, hc.Repo)
+			go s.SignalUpdate(hc.Owner, hc.Repo)
+		}
+	}
+}
+
+// defaultBranchMoved 判断本次推送是否更新了 HEAD 指向的分支（删除不算）
+func defaultBranchMoved(hc *git.HookContext, updates []git.RefUpdate) bool {
+	head, err := hc.Repository.Storer.Reference(plumbing.HEAD)
+	if err != nil || head.Type() != plumbing.SymbolicReference {
+		return false
+	}
+	for _, u := range updates {
+		if u.Name == head.Target() && !u.IsDelete() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package keeper
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"potstack/internal/git"
+	"potstack/internal/models"
+)
+
+// maxIdleCheckInterval 检查空闲的最长间隔（idle_timeout 较短时按 1/4 检查）
+const maxIdleCheckInterval = 10 * time.Second
+
+// watchIdle 沙箱配置了 idle_timeout 时启动空闲检查（每个沙箱一个），调用方持有 s.mu
+// 空闲时间由 Router 记录的最近一次请求计算，没有 Router 时不检查
+func (s *SandboxManager) watchIdle(org, name string, potCfg *models.PotConfig) {
+	key := fmt.Sprintf("%s/%s", org, name)
+	if potCfg.IdleTimeout <= 0 || s.Router == nil || s.idleWatchers[key] {
+		return
+	}
+	s.idleWatchers[key] = true
+	go s.idleLoop(org, name)
+}
+
+// idleLoop 定期检查沙箱是否空闲，超过 idle_timeout 没有请求（且没有在途请求）时停止沙箱（state: idle）
+// 沙箱停止或 pot.yml 去掉 idle_timeout 后退出
+func (s *SandboxManager) idleLoop(org, name string) {
+	key := fmt.Sprintf("%s/%s", org, name)
+	for {
+		var potCfg models.PotConfig
+		_ = git.ReadPotYml(s.RepoRoot, org, name, &potCfg)
+		timeout := potCfg.IdleTimeout
+
+		s.mu.Lock()
+		insts, running := s.runningInstances[key]
+		if !running || timeout <= 0 {
+			delete(s.idleWatchers, key)
+			s.mu.Unlock()
+			return
+		}
+		// 从最近启动的实例开始计算，刚启动（包括收到请求后启动）的沙箱不会立即停止
+		var started time.Time
+		for _, inst := range insts {
+			if inst != nil && inst.started.After(started) {
+				started = inst.started
+			}
+		}
+		s.mu.Unlock()
+
+		if last, ok := s.Router.IdleSince(org, name); ok {
+			if last.Before(started) {
+				last = started
+			}
+			if idle := time.Since(last); idle >= timeout {
+				log.Printf("Sandbox %s has been idle for %v, stopping", key, idle.Round(time.Millisecond))
+				s.stop(org, name, models.RunStateIdle)
+				continue
+			}
+		}
+
+		t := time.NewTimer(min(max(timeout/4, 10*time.Millisecond), maxIdleCheckInterval))
+		select {
+		case <-t.C:
+		case <-s.stopChan:
+			t.Stop()
+			s.mu.Lock()
+			delete(s.idleWatchers, key)
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Activate 启动空闲停止的沙箱并等待任一实例就绪，由 Router 在收到请求时调用（见 Router.SetActivator）
+// 多个请求同时到达时只启动一次；最多等待 deploy_timeout（默认 60s）
+func (s *SandboxManager) Activate(org, name string) error {
+	key := fmt.Sprintf("%s/%s", org, name)
+
+	var potCfg models.PotConfig
+	if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err != nil {
+		return fmt.Errorf("pot.yml not found: %w", err)
+	}
+	timeout := potCfg.DeployTimeout
+	if timeout <= 0 {
+		timeout = defaultDeployTimeout
+	}
+
+	s.mu.RLock()
+	rc, err := s.loadRunConfig(org, name)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if rc.TargetStatus != models.RunStatusRunning {
+		return fmt.Errorf("sandbox is stopped")
+	}
+	if rc.State == models.RunStateIdle {
+		log.Printf("Activating idle sandbox %s", key)
+		if err := s.Start(org, name); err != nil && !errors.Is(err, ErrAlreadyRunning) {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		s.mu.RLock()
+		insts := s.runningInstances[key]
+		running := s.active(key) // 其他请求触发的启动可能尚未完成
+		ready := false
+		for _, inst := range insts {
+			ready = ready || (inst != nil && inst.Ready)
+		}
+		s.mu.RUnlock()
+
+		switch {
+		case ready:
+			// 就绪探针可能刚刚通过，确保路由已指向就绪的实例
+			s.refreshRoute(org, name)
+			return nil
+		case !running:
+			return fmt.Errorf("sandbox exited before becoming ready")
+		case time.Now().After(deadline):
+			return fmt.Errorf("sandbox not ready within %v", timeout)
+		}
+
+		t := time.NewTimer(20 * time.Millisecond)
+		select {
+		case <-t.C:
+		case <-s.stopChan:
+			t.Stop()
+			return fmt.Errorf("keeper is shutting down")
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package keeper
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleToZero(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe 是 shell 脚本")
+	}
+	root := t.TempDir()
+	rt, internal := serveInternal(t, root)
+	s := NewManager(root, rt)
+	rt.SetActivator(s.Activate)
+	s.SetPotProvider(potList(pots("ann/tool")))
+	defer s.Shutdown()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(internal + "/pot/ann/tool" + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	status := func() SandboxStatus {
+		st, _ := s.Status("ann", "tool")
+		return *st
+	}
+	env, exe := servePot()
+	commitPot(t, root, "ann", "tool", map[string]string{
+		"pot.yml": "type: exe\nidle_timeout: 300ms\nstop_timeout: 1s\n" + env + "readiness:\n  type: http\n  interval: 20ms\n",
+		"pot.exe": exe,
+	})
+
+	// 1. 部署后正常转发
+	if !assert.NoError(t, s.Redeploy("ann", "tool")) {
+		return
+	}
+	code, body := get("/hello")
+	if !assert.Equal(t, http.StatusOK, code, body) {
+		return
+	}
+	first := status()
+	assert.Equal(t, fmt.Sprintf("%d /hello", first.Pid), body)
+
+	// 2. 超过 idle_timeout 没有请求：停止进程，state 为 idle，target_status 仍为 running
+	var st SandboxStatus
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if st = status(); st.State == models.RunStateIdle {
+			break
+		}
+	}
+	assert.Equal(t, models.RunStateIdle, st.State)
+	assert.Equal(t, models.RunStatusRunning, st.TargetStatus)
+	assert.Zero(t, st.Pid)
+	for deadline := time.Now().Add(3 * time.Second); alive(first.Pid) && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.False(t, alive(first.Pid), "idle pot should be stopped")
+
+	// 3. 收到请求时启动，等待就绪后转发
+	code, body = get("/again")
+	assert.Equal(t, http.StatusOK, code, body)
+	second := status()
+	assert.Equal(t, models.RunStateRunning, second.State)
+	assert.NotEqual(t, first.Pid, second.Pid)
+	assert.Equal(t, fmt.Sprintf("%d /again", second.Pid), body)
+
+	// 4. 持续有请求时不停止
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		code, body = get("/")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, fmt.Sprintf("%d /", second.Pid), body)
+	}
+
+	// 5. 手动停止的 pot 不会被请求启动
+	assert.NoError(t, s.Stop("ann", "tool"))
+	code, _ = get("/")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, models.RunStateStopped, status().State)
+}
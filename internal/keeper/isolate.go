@@ -0,0 +1,73 @@
+package keeper
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"potstack/internal/models"
+)
+
+// 隔离模式下沙箱内看到的目录
+const (
+	isolatedProgramDir = "/program" // 只读
+	isolatedDataDir    = "/data"
+	isolatedLogDir     = "/log"
+)
+
+// isolatedPath 隔离模式下沙箱进程的 PATH
+const isolatedPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Isolation 描述隔离模式下挂载进沙箱的宿主机目录
+type Isolation struct {
+	ProgramDir string // 只读挂载到 /program
+	DataDir    string // 可写挂载到 /data
+	LogDir     string // 可写挂载到 /log
+	SecretsDir string // 只读挂载到 /secrets，没有文件形式的密钥时为空
+	Network    bool   // 共享宿主机网络，否则使用独立的网络命名空间
+}
+
+// newIsolation 校验 pot.yml 的 isolation 配置，未启用时返回 nil
+func newIsolation(potCfg *models.PotConfig, programDir, dataDir, logDir, secretsDir string) (*Isolation, error) {
+	if potCfg.Isolation == nil || !potCfg.Isolation.Enabled {
+		return nil, nil
+	}
+
+	iso := &Isolation{ProgramDir: programDir, DataDir: dataDir, LogDir: logDir, SecretsDir: secretsDir}
+	switch potCfg.Isolation.Network {
+	case "", models.NetworkHost:
+		iso.Network = true
+	case models.NetworkNone:
+	default:
+		return nil, fmt.Errorf("unknown isolation network %q", potCfg.Isolation.Network)
+	}
+
+	// exec 探针在宿主机上执行，隔离的 pot 不能借此绕过隔离
+	for _, p := range []*models.Probe{potCfg.Liveness, potCfg.Readiness} {
+		if p != nil && p.Type == models.ProbeExec {
+			return nil, fmt.Errorf("exec probes are not supported with isolation")
+		}
+	}
+
+	for _, dir := range []*string{&iso.ProgramDir, &iso.DataDir, &iso.LogDir, &iso.SecretsDir} {
+		if *dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			return nil, err
+		}
+		*dir = abs
+	}
+	return iso, nil
+}
+
+// env 返回隔离模式下的内置环境变量（不继承 PotStack 的环境变量）
+func (iso *Isolation) env() []string {
+	return []string{
+		"PATH=" + isolatedPath,
+		"HOME=" + isolatedDataDir,
+		"DATA_PATH=" + isolatedDataDir,
+		"PROGRAM_PATH=" + isolatedProgramDir,
+		"LOG_PATH=" + isolatedLogDir,
+	}
+}
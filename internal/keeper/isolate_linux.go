@@ -0,0 +1,450 @@
+//go:build linux
+
+package keeper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"potstack/config"
+)
+
+// 隔离模式通过重新执行 PotStack 自身（/proc/self/exe）在新的命名空间中运行一个 init：
+// init 以命名空间内的 root 身份搭建根目录，pivot_root 后丢弃全部 capability 再启动 pot
+const (
+	isolationInitArg = "potstack-sandbox-init"
+	isolationSpecEnv = "_POTSTACK_ISOLATION"
+
+	// isolationRootMount 在 init 的挂载命名空间中挂载新根目录的位置，不影响宿主机
+	isolationRootMount = "/tmp"
+)
+
+// isolationSystemDirs 只读挂载进沙箱的系统目录（存在时），用于运行动态链接的程序和脚本
+var isolationSystemDirs = []string{"/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32", "/usr", "/etc"}
+
+// isolationDevices 绑定挂载进沙箱 /dev 的设备
+var isolationDevices = []string{"null", "zero", "full", "random", "urandom"}
+
+// isolationSpec 由 PotStack 传给 init
+type isolationSpec struct {
+	Path    string   `json:"path"` // 沙箱内路径
+	Args    []string `json:"args"`
+	Dir     string   `json:"dir"`
+	Program string   `json:"program"` // 以下为宿主机路径
+	Data    string   `json:"data"`
+	Log     string   `json:"log"`
+	Secrets string   `json:"secrets,omitempty"`
+	Network bool     `json:"network"`
+}
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == isolationInitArg {
+		os.Exit(runIsolationInit())
+	}
+}
+
+// prepareIsolation 把 cmd 改写为在新的 user / mount / pid（可选 network）命名空间中启动 init
+func prepareIsolation(cmd *exec.Cmd, iso *Isolation) error {
+	uid, gid, err := sandboxIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{iso.DataDir, iso.LogDir, iso.SecretsDir} {
+		if dir == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if os.Geteuid() == 0 {
+			if err := chownTree(dir, uid, gid); err != nil {
+				return fmt.Errorf("failed to chown %s: %w", dir, err)
+			}
+		}
+	}
+
+	spec := isolationSpec{Program: iso.ProgramDir, Data: iso.DataDir, Log: iso.LogDir, Secrets: iso.SecretsDir, Network: iso.Network}
+	if spec.Path, err = insideProgramDir(iso.ProgramDir, cmd.Path); err != nil {
+		return err
+	}
+	spec.Dir = isolatedProgramDir
+	if cmd.Dir != "" {
+		if spec.Dir, err = insideProgramDir(iso.ProgramDir, cmd.Dir); err != nil {
+			return err
+		}
+	}
+	spec.Args = append([]string{spec.Path}, cmd.Args[1:]...)
+
+	data, err := json.Marshal(&spec)
+	if err != nil {
+		return err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append([]string{isolationSpecEnv + "=" + string(data)}, env...)
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = []string{isolationInitArg}
+	cmd.Dir = "/"
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC
+	if !iso.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	// 命名空间内的 root 对应宿主机上的沙箱 uid
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+	attr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	if os.Geteuid() == 0 {
+		// 清空从 PotStack 继承的附加组
+		attr.GidMappingsEnableSetgroups = true
+	} else {
+		// 非特权用户命名空间不允许 setgroups
+		attr.Credential.NoSetGroups = true
+	}
+	return nil
+}
+
+// sandboxIDs 返回沙箱在宿主机上的 uid/gid，非 root 只能把自己映射进用户命名空间
+func sandboxIDs() (int, int, error) {
+	if os.Geteuid() != 0 {
+		return os.Geteuid(), os.Getegid(), nil
+	}
+	id, err := strconv.Atoi(config.SandboxUID)
+	if err != nil || id <= 0 {
+		return 0, 0, fmt.Errorf("invalid POTSTACK_SANDBOX_UID %q", config.SandboxUID)
+	}
+	return id, id, nil
+}
+
+// chownTree 把目录树交给沙箱用户
+func chownTree(root string, uid, gid int) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) == uid && int(st.Gid) == gid {
+			return nil
+		}
+		return os.Lchown(p, uid, gid)
+	})
+}
+
+// insideProgramDir 把 program 目录下的宿主机路径转换为沙箱内路径
+func insideProgramDir(programDir, p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(programDir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the program directory", p)
+	}
+	return path.Join(isolatedProgramDir, filepath.ToSlash(rel)), nil
+}
+
+// runIsolationInit 是 init 的入口，返回值作为退出码
+func runIsolationInit() int {
+	var spec isolationSpec
+	if err := json.Unmarshal([]byte(os.Getenv(isolationSpecEnv)), &spec); err != nil {
+		fmt.Fprintf(os.Stderr, "potstack: invalid isolation spec: %v\n", err)
+		return 125
+	}
+	os.Unsetenv(isolationSpecEnv)
+
+	if err := setupIsolatedRoot(&spec); err != nil {
+		fmt.Fprintf(os.Stderr, "potstack: failed to set up sandbox: %v\n", err)
+		return 125
+	}
+	code, err := runIsolated(&spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "potstack: failed to start %s: %v\n", spec.Path, err)
+		return 126
+	}
+	return code
+}
+
+// setupIsolatedRoot 搭建沙箱的根目录并 pivot_root 进去
+//
+//	/program          只读
+//	/data /log        可写
+//	/secrets          只读（有文件形式的密钥时）
+//	/usr /etc ...     宿主机系统目录，只读
+//	/dev /proc /tmp   最小的 /dev、本 pid 命名空间的 /proc、tmpfs
+func setupIsolatedRoot(spec *isolationSpec) error {
+	// 之后的挂载都不传播回宿主机
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make / private: %w", err)
+	}
+
+	// 先打开要绑定的目录（它们可能位于 isolationRootMount 之下），init 以沙箱 uid 运行，需要能访问这些路径
+	type bind struct {
+		src      string
+		target   string
+		readonly bool
+		fd       int
+	}
+	binds := []bind{
+		{spec.Program, isolatedProgramDir, true, -1},
+		{spec.Data, isolatedDataDir, false, -1},
+		{spec.Log, isolatedLogDir, false, -1},
+	}
+	if spec.Secrets != "" {
+		binds = append(binds, bind{spec.Secrets, secretsMountDir, true, -1})
+	}
+	for i := range binds {
+		fd, err := syscall.Open(binds[i].src, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", binds[i].src, err)
+		}
+		binds[i].fd = fd
+	}
+
+	root := isolationRootMount
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("mount root: %w", err)
+	}
+
+	for _, dir := range isolationSystemDirs {
+		info, err := os.Lstat(dir)
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			// 如 merged /usr 的 /bin -> usr/bin
+			target, err := os.Readlink(dir)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, root+dir); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := bindMount(dir, root+dir, true); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range binds {
+		if err := bindMount(fmt.Sprintf("/proc/self/fd/%d", b.fd), root+b.target, b.readonly); err != nil {
+			return err
+		}
+		syscall.Close(b.fd)
+	}
+
+	if err := setupIsolatedDev(root + "/dev"); err != nil {
+		return err
+	}
+	if err := os.Mkdir(root+"/proc", 0555); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", root+"/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mount /proc: %w", err)
+	}
+	if err := os.Mkdir(root+"/tmp", 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", root+"/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+
+	oldRoot := root + "/.oldroot"
+	if err := os.Mkdir(oldRoot, 0700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(root, oldRoot); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.oldroot", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root: %w", err)
+	}
+	os.Remove("/.oldroot")
+	if err := syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remount / read-only: %w", err)
+	}
+
+	if !spec.Network {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("bring up lo: %w", err)
+		}
+	}
+	return os.Chdir(spec.Dir)
+}
+
+// setupIsolatedDev 创建只包含常用设备的 /dev
+func setupIsolatedDev(dev string) error {
+	if err := os.Mkdir(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=0755"); err != nil {
+		return fmt.Errorf("mount /dev: %w", err)
+	}
+	for _, name := range isolationDevices {
+		target := filepath.Join(dev, name)
+		if err := os.WriteFile(target, nil, 0644); err != nil {
+			return err
+		}
+		if err := syscall.Mount("/dev/"+name, target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("bind /dev/%s: %w", name, err)
+		}
+	}
+	links := map[string]string{"fd": "/proc/self/fd", "stdin": "/proc/self/fd/0", "stdout": "/proc/self/fd/1", "stderr": "/proc/self/fd/2"}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dev, "shm"), 01777); err != nil {
+		return err
+	}
+	return syscall.Mount("tmpfs", filepath.Join(dev, "shm"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777")
+}
+
+// statfs 返回的挂载标志（ST_*），只读重新挂载时已包含 RDONLY / NOSUID / NODEV
+const (
+	stNoexec     = 0x8
+	stNoatime    = 0x400
+	stNodiratime = 0x800
+	stRelatime   = 0x1000
+)
+
+// bindMount 把 src 绑定挂载到 target，readonly 时重新挂载为只读
+func bindMount(src, target string, readonly bool) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(src, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", target, err)
+	}
+	if !readonly {
+		return nil
+	}
+
+	// 用户命名空间中重新挂载时必须保留原挂载被锁定的标志
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(target, &st); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV)
+	locked := []struct {
+		st int64
+		ms uintptr
+	}{
+		{stNoexec, syscall.MS_NOEXEC},
+		{stNoatime, syscall.MS_NOATIME},
+		{stNodiratime, syscall.MS_NODIRATIME},
+		{stRelatime, syscall.MS_RELATIME},
+	}
+	for _, l := range locked {
+		if int64(st.Flags)&l.st != 0 {
+			flags |= l.ms
+		}
+	}
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only: %w", target, err)
+	}
+	return nil
+}
+
+// loopbackUp 启用独立网络命名空间中的回环接口
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq
+	var req struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(req.name[:], "lo")
+	req.flags = syscall.IFF_UP | syscall.IFF_RUNNING
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// prctl 选项
+const (
+	prCapbsetDrop   = 24
+	prSetNoNewPrivs = 38
+)
+
+// runIsolated 丢弃全部 capability 后启动 pot，作为 pid 1 回收孤儿进程，返回 pot 的退出码
+func runIsolated(spec *isolationSpec) (int, error) {
+	// bounding set 与 no_new_privs 是线程属性，fork 必须在同一线程上进行
+	runtime.LockOSThread()
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return 0, fmt.Errorf("set no_new_privs: %w", errno)
+	}
+	for c := 0; ; c++ {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(c), 0); errno != 0 {
+			if errno == syscall.EINVAL && c > 0 {
+				break // 超过 CAP_LAST_CAP
+			}
+			return 0, fmt.Errorf("drop capability %d: %w", c, errno)
+		}
+	}
+
+	// pid 1 只会收到设置了处理函数的信号；Stop 向整个进程组发送信号，pot 会直接收到
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	cmd := &exec.Cmd{
+		Path:        spec.Path,
+		Args:        spec.Args,
+		Dir:         spec.Dir,
+		Env:         os.Environ(),
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+		SysProcAttr: &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL},
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if pid != cmd.Process.Pid {
+			continue
+		}
+		if ws.Signaled() {
+			return 128 + int(ws.Signal()), nil
+		}
+		return ws.ExitStatus(), nil
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package keeper
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// prepareIsolation 隔离模式依赖 Linux 命名空间，其他平台拒绝启动
+func prepareIsolation(cmd *exec.Cmd, iso *Isolation) error {
+	return fmt.Errorf("isolation is only supported on Linux")
+}
@@ -0,0 +1,638 @@
+package keeper
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"potstack/internal/git"
+	"potstack/internal/models"
+	"potstack/internal/webhook"
+
+	"gopkg.in/yaml.v3"
+)
+
+// job pot（type: job）按 schedule 定时或通过 API 手动运行 pot.exe 直到退出，没有路由
+// 每次执行记录在 data/faaspot/jobs/{id}.yml，输出（stdout/stderr）写入 {id}.log，同时写入控制台日志
+const (
+	jobsDir         = "jobs"
+	jobHistoryLimit = 20              // 保留最近的执行记录数
+	maxJobOutput    = 1 << 20         // 单次执行保存的输出上限，超出的行只写入控制台日志
+	jobOutputDrain  = 5 * time.Second // 进程退出后等待输出读完的时间（脱离进程组的子孙进程可能仍持有管道）
+)
+
+// 触发方式
+const (
+	JobTriggerSchedule = "schedule"
+	JobTriggerManual   = "manual"
+)
+
+// 执行状态
+const (
+	JobStateRunning   = "running"
+	JobStateSucceeded = "succeeded" // 退出码为 0
+	JobStateFailed    = "failed"    // 退出码非 0、被信号终止、超时或被 PotStack 结束
+)
+
+// JobRun 是 job 的一次执行
+type JobRun struct {
+	ID        string  `yaml:"id" json:"id"`
+	Trigger   string  `yaml:"trigger" json:"trigger"` // schedule / manual
+	State     string  `yaml:"state" json:"state"`     // running / succeeded / failed
+	Release   string  `yaml:"release,omitempty" json:"release,omitempty"`
+	Pid       int     `yaml:"pid,omitempty" json:"pid,omitempty"` // 仅执行中
+	StartTime string  `yaml:"start_time" json:"start_time"`
+	EndTime   string  `yaml:"end_time,omitempty" json:"end_time,omitempty"`
+	Duration  float64 `yaml:"duration,omitempty" json:"duration"` // 秒
+	ExitCode  *int    `yaml:"exit_code,omitempty" json:"exit_code,omitempty"`
+	Error     string  `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// activeJob 是正在执行的 job，run、cmd 与 reason 由 s.mu 保护；cmd 在进程启动前为 nil
+type activeJob struct {
+	run    *JobRun
+	cmd    *JobCmd
+	reason string        // 被 PotStack 结束（超时、关闭）的原因
+	done   chan struct{} // 执行结束并已写入记录时关闭
+}
+
+// jobScheduler 是一个 job 的计划协程，next 由 s.mu 保护
+type jobScheduler struct {
+	wake chan struct{} // 重新部署后通知重新读取 pot.yml
+	next time.Time
+}
+
+// jobsPath 返回执行记录目录
+func (s *SandboxManager) jobsPath(org, name string) string {
+	return filepath.Join(s.sandboxRoot(org, name), jobsDir)
+}
+
+// deployJob 克隆新版本并设为当前版本，下次执行时使用；由 exe 改为 job 时先停止原有进程
+func (s *SandboxManager) deployJob(org, name string) error {
+	key := fmt.Sprintf("%s/%s", org, name)
+
+	s.mu.RLock()
+	_, running := s.runningInstances[key]
+	s.mu.RUnlock()
+	if running {
+		s.Stop(org, name)
+	}
+
+	release, err := s.cloneRelease(org, name)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	rc, _ := s.loadRunConfig(org, name)
+	if rc == nil {
+		rc = &models.RunConfig{}
+	}
+	keep := []string{release, rc.Release}
+	if job := s.jobs[key]; job != nil {
+		keep = append(keep, job.run.Release) // 正在执行的版本
+	}
+	rc.Release = release
+	s.saveRunConfig(org, name, rc)
+	s.mu.Unlock()
+
+	s.pruneReleases(org, name, keep...)
+	log.Printf("Deployed job %s (release %s)", key, release)
+	s.scheduleJob(org, name)
+	return nil
+}
+
+// scheduleJob 启动 job 的计划协程（每个 job 一个），已在运行时通知它重新读取 pot.yml
+func (s *SandboxManager) scheduleJob(org, name string) {
+	key := fmt.Sprintf("%s/%s", org, name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sc, ok := s.schedulers[key]; ok {
+		select {
+		case sc.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+	sc := &jobScheduler{wake: make(chan struct{}, 1)}
+	s.schedulers[key] = sc
+	go s.scheduleLoop(org, name, sc)
+}
+
+// scheduleLoop 按 pot.yml 的 schedule 执行 job，上一次仍在执行时跳过本次
+// pot.yml 不再是 job 或没有 schedule 时退出
+func (s *SandboxManager) scheduleLoop(org, name string, sc *jobScheduler) {
+	key := fmt.Sprintf("%s/%s", org, name)
+
+	// exit 在没有新的通知时注销协程
+	exit := func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-sc.wake:
+			return false
+		default:
+		}
+		delete(s.schedulers, key)
+		return true
+	}
+
+	var spec string
+	var plan *schedule
+	var next time.Time
+	for {
+		var potCfg models.PotConfig
+		if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err != nil || potCfg.Type != "job" || potCfg.Schedule == "" {
+			if exit() {
+				return
+			}
+			spec = ""
+			continue
+		}
+		if potCfg.Schedule != spec {
+			p, err := parseSchedule(potCfg.Schedule)
+			if err != nil {
+				log.Printf("Job %s has an invalid schedule %q: %v", key, potCfg.Schedule, err)
+				if exit() {
+					return
+				}
+				continue
+			}
+			spec, plan = potCfg.Schedule, p
+			next = plan.next(time.Now())
+			log.Printf("Job %s scheduled (%s), next run at %s", key, spec, next.Format(time.RFC3339))
+		}
+		if next.IsZero() {
+			if exit() {
+				return
+			}
+			spec = ""
+			continue
+		}
+		s.mu.Lock()
+		sc.next = next
+		s.mu.Unlock()
+
+		if time.Until(next) <= 0 {
+			if _, err := s.RunJob(org, name, JobTriggerSchedule); err != nil {
+				log.Printf("Scheduled run of job %s skipped: %v", key, err)
+			}
+			next = plan.next(time.Now())
+			continue
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-t.C:
+		case <-sc.wake:
+			t.Stop()
+		case <-s.stopChan:
+			t.Stop()
+			return
+		}
+	}
+}
+
+// RunJob 开始执行一次 job，立即返回执行记录；上一次仍在执行时返回 ErrJobRunning
+func (s *SandboxManager) RunJob(org, name, trigger string) (*JobRun, error) {
+	key := fmt.Sprintf("%s/%s", org, name)
+
+	var potCfg models.PotConfig
+	if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err != nil {
+		return nil, fmt.Errorf("pot.yml not found: %w", err)
+	}
+	if potCfg.Type != "job" {
+		return nil, ErrNotJob
+	}
+	if potCfg.Docker != "" {
+		return nil, fmt.Errorf("docker is not supported for job pots")
+	}
+
+	// 锁内只登记执行，准备（解密 secret、创建 cgroup 等）与启动进程在锁外，失败时撤销登记
+	s.mu.Lock()
+	select {
+	case <-s.stopChan:
+		s.mu.Unlock()
+		return nil, fmt.Errorf("keeper is shutting down")
+	default:
+	}
+	if _, ok := s.jobs[key]; ok {
+		s.mu.Unlock()
+		return nil, ErrJobRunning
+	}
+	rc, err := s.loadRunConfig(org, name)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("job has not been deployed: %w", err)
+	}
+	run := &JobRun{
+		ID:      time.Now().Format("20060102-150405.000000"),
+		Trigger: trigger,
+		State:   JobStateRunning,
+		Release: rc.Release,
+	}
+	job := &activeJob{run: run, done: make(chan struct{})}
+	s.jobs[key] = job
+	s.mu.Unlock()
+
+	jobCmd, out, copied, started, err := s.startJob(org, name, &potCfg, run)
+	if err != nil {
+		s.mu.Lock()
+		delete(s.jobs, key)
+		s.mu.Unlock()
+		close(job.done)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	job.cmd = jobCmd
+	run.Pid = jobCmd.pid()
+	run.StartTime = started.Format(time.RFC3339)
+	s.saveJobRun(org, name, run)
+	stopped := job.reason != ""
+	cp := *run
+	s.mu.Unlock()
+	if stopped {
+		// 启动期间已被结束（如 PotStack 关闭），进程尚未开始工作，直接结束
+		jobCmd.Kill()
+	}
+	go s.waitJob(org, name, job, out, copied, started, potCfg.JobTimeout)
+
+	log.Printf("Started job %s run %s (%s, pid %d)", key, cp.ID, trigger, cp.Pid)
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventSandbox,
+		Action:     "job_started",
+		Repository: webhook.NewRepository(org, name),
+		Data:       map[string]interface{}{"id": cp.ID, "trigger": trigger, "pid": cp.Pid},
+	})
+	return &cp, nil
+}
+
+// startJob 准备环境并启动 run 的进程，输出写入 jobs/{id}.log（调用方不持有 s.mu）
+func (s *SandboxManager) startJob(org, name string, potCfg *models.PotConfig, run *JobRun) (*JobCmd, *os.File, <-chan struct{}, time.Time, error) {
+	var started time.Time
+	sandboxRoot := s.sandboxRoot(org, name)
+	programDir := s.programDir(org, name, run.Release)
+	dataPath := filepath.Join(sandboxRoot, "data")
+	logPath := filepath.Join(sandboxRoot, "log")
+	cmdPath, err := filepath.Abs(filepath.Join(programDir, "pot.exe"))
+	if err != nil {
+		return nil, nil, nil, started, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if _, err := os.Stat(cmdPath); os.IsNotExist(err) {
+		return nil, nil, nil, started, fmt.Errorf("pot.exe not found at %s", cmdPath)
+	}
+	mountDir, err := s.secretsMount(org, name, potCfg)
+	if err != nil {
+		return nil, nil, nil, started, err
+	}
+	userEnv, secretFiles, err := s.resolveEnv(org, name, potCfg, mountDir)
+	if err != nil {
+		return nil, nil, nil, started, err
+	}
+	secretsPath := ""
+	if secretFiles {
+		secretsPath = s.secretsDir(org, name)
+	}
+	iso, err := newIsolation(potCfg, programDir, dataPath, logPath, secretsPath)
+	if err != nil {
+		return nil, nil, nil, started, fmt.Errorf("invalid isolation: %w", err)
+	}
+
+	jobCmd := NewJobCmd(cmdPath)
+	jobCmd.Dir = programDir
+	jobCmd.Isolation = iso
+	env := potEnv(iso, programDir, dataPath, logPath)
+	env = append(env, "POTSTACK_JOB_ID="+run.ID, "POTSTACK_JOB_TRIGGER="+run.Trigger)
+	for _, e := range userEnv {
+		env = append(env, fmt.Sprintf("%s=%s", e.Name, e.Value))
+	}
+	jobCmd.Env = env
+
+	// 输出写入 jobs/{id}.log
+	if err := os.MkdirAll(s.jobsPath(org, name), 0755); err != nil {
+		return nil, nil, nil, started, err
+	}
+	outPath := filepath.Join(s.jobsPath(org, name), run.ID+".log")
+	out, err := os.Create(outPath)
+	if err != nil {
+		return nil, nil, nil, started, err
+	}
+	fail := func(err error) (*JobCmd, *os.File, <-chan struct{}, time.Time, error) {
+		out.Close()
+		os.Remove(outPath)
+		return nil, nil, nil, started, err
+	}
+
+	releaseCgroup, err := setupCgroup(org, name, potCfg.Resources, jobCmd.Cmd)
+	if err != nil {
+		return fail(fmt.Errorf("failed to set up cgroup: %w", err))
+	}
+	defer releaseCgroup()
+	closePipes, copied, err := s.potLog(org, name).attachTee(jobCmd.Cmd, &limitWriter{w: out, n: maxJobOutput})
+	if err != nil {
+		return fail(fmt.Errorf("failed to capture output: %w", err))
+	}
+	started = time.Now()
+	err = jobCmd.Start()
+	closePipes()
+	if err != nil {
+		return fail(fmt.Errorf("failed to start pot.exe: %w", err))
+	}
+	return jobCmd, out, copied, started, nil
+}
+
+// waitJob 等待执行结束，记录退出码、耗时与输出
+func (s *SandboxManager) waitJob(org, name string, job *activeJob, out *os.File, copied <-chan struct{}, started time.Time, timeout time.Duration) {
+	key := fmt.Sprintf("%s/%s", org, name)
+	if timeout > 0 {
+		t := time.AfterFunc(timeout, func() {
+			log.Printf("Job %s run %s did not finish within %v, stopping", key, job.run.ID, timeout)
+			s.stopJob(job, fmt.Sprintf("timed out after %v", timeout), s.stopTimeout(org, name))
+		})
+		defer t.Stop()
+	}
+
+	exitCode, status, err := job.cmd.wait()
+	ended := time.Now()
+	killCgroup(org, name) // 脱离进程组的子孙进程
+
+	t := time.NewTimer(jobOutputDrain)
+	select {
+	case <-copied:
+	case <-t.C:
+	}
+	t.Stop()
+	out.Close()
+
+	s.mu.Lock()
+	run := job.run
+	run.Pid = 0
+	run.EndTime = ended.Format(time.RFC3339)
+	run.Duration = ended.Sub(started).Seconds()
+	run.ExitCode = &exitCode
+	run.State = JobStateSucceeded
+	switch {
+	case job.reason != "":
+		run.State = JobStateFailed
+		run.Error = job.reason
+	case err != nil:
+		run.State = JobStateFailed
+		run.Error = err.Error()
+	case exitCode != 0:
+		run.State = JobStateFailed
+	}
+	s.saveJobRun(org, name, run)
+	delete(s.jobs, key)
+	cp := *run
+	s.mu.Unlock()
+	close(job.done)
+
+	s.pruneJobRuns(org, name)
+	log.Printf("Job %s run %s %s: %s (%.1fs)", key, cp.ID, cp.State, status, cp.Duration)
+
+	data := map[string]interface{}{"id": cp.ID, "trigger": cp.Trigger, "exit_code": exitCode, "duration": cp.Duration}
+	if cp.Error != "" {
+		data["error"] = cp.Error
+	}
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventSandbox,
+		Action:     "job_" + cp.State,
+		Repository: webhook.NewRepository(org, name),
+		Data:       data,
+	})
+}
+
+// stopJob 结束正在执行的 job：向进程组发送 SIGTERM，grace 内未退出则 SIGKILL，等待记录写入
+func (s *SandboxManager) stopJob(job *activeJob, reason string, grace time.Duration) {
+	select {
+	case <-job.done:
+		return
+	default:
+	}
+	s.mu.Lock()
+	if job.reason == "" {
+		job.reason = reason
+	}
+	cmd := job.cmd // 仍在启动时为 nil，RunJob 启动进程后发现 reason 会结束它
+	s.mu.Unlock()
+
+	if cmd != nil {
+		cmd.Terminate()
+	}
+	t := time.NewTimer(grace)
+	defer t.Stop()
+	select {
+	case <-job.done:
+	case <-t.C:
+		s.mu.RLock()
+		cmd = job.cmd
+		s.mu.RUnlock()
+		if cmd != nil {
+			cmd.Kill()
+		}
+		<-job.done
+	}
+}
+
+// stopJobs 在 PotStack 退出时结束所有正在执行的 job
+func (s *SandboxManager) stopJobs() {
+	s.mu.RLock()
+	jobs := make(map[string]*activeJob, len(s.jobs))
+	for key, job := range s.jobs {
+		jobs[key] = job
+	}
+	s.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for key, job := range jobs {
+		wg.Add(1)
+		go func(key string, job *activeJob) {
+			defer wg.Done()
+			org, name, _ := strings.Cut(key, "/")
+			s.stopJob(job, "interrupted by shutdown", s.stopTimeout(org, name))
+		}(key, job)
+	}
+	wg.Wait()
+}
+
+// recoverJobRuns PotStack 启动时把上次退出前未结束的执行标记为失败
+func (s *SandboxManager) recoverJobRuns(org, name string) {
+	key := fmt.Sprintf("%s/%s", org, name)
+	runs, err := s.JobRuns(org, name)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range runs {
+		if run.State != JobStateRunning {
+			continue
+		}
+		if job := s.jobs[key]; job != nil && job.run.ID == run.ID {
+			continue
+		}
+		run.State = JobStateFailed
+		run.Pid = 0
+		run.Error = "interrupted: potstack exited during the run"
+		s.saveJobRun(org, name, run)
+	}
+}
+
+func (s *SandboxManager) saveJobRun(org, name string, run *JobRun) error {
+	data, err := yaml.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.jobsPath(org, name), run.ID+".yml"), data, 0644)
+}
+
+// JobRuns 返回 job 的执行记录，最近的在前
+func (s *SandboxManager) JobRuns(org, name string) ([]*JobRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := os.ReadDir(s.jobsPath(org, name))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	runs := []*JobRun{}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".yml")
+		if !ok {
+			continue
+		}
+		run, err := s.loadJobRun(org, name, id)
+		if err != nil {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	// ID 为开始时间，按字典序即按时间排序
+	slices.Reverse(runs)
+	return runs, nil
+}
+
+// GetJobRun 返回一次执行记录，不存在时返回 ErrJobRunNotFound
+func (s *SandboxManager) GetJobRun(org, name, id string) (*JobRun, error) {
+	if !validJobRunID(id) {
+		return nil, ErrJobRunNotFound
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	run, err := s.loadJobRun(org, name, id)
+	if os.IsNotExist(err) {
+		return nil, ErrJobRunNotFound
+	}
+	return run, err
+}
+
+// JobOutput 返回一次执行的输出（stdout/stderr，带时间戳和流标记），不存在时返回 ErrJobRunNotFound
+func (s *SandboxManager) JobOutput(org, name, id string) ([]string, error) {
+	if _, err := s.GetJobRun(org, name, id); err != nil {
+		return nil, err
+	}
+	lines, err := lastLines(filepath.Join(s.jobsPath(org, name), id+".log"), maxJobOutput)
+	if err != nil {
+		return nil, err
+	}
+	if lines == nil {
+		lines = []string{}
+	}
+	return lines, nil
+}
+
+func (s *SandboxManager) loadJobRun(org, name, id string) (*JobRun, error) {
+	data, err := os.ReadFile(filepath.Join(s.jobsPath(org, name), id+".yml"))
+	if err != nil {
+		return nil, err
+	}
+	var run JobRun
+	if err := yaml.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// validJobRunID 执行记录 ID 只能是文件名
+func validJobRunID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\`) && !strings.Contains(id, "..")
+}
+
+// pruneJobRuns 只保留最近 jobHistoryLimit 次执行的记录与输出
+func (s *SandboxManager) pruneJobRuns(org, name string) {
+	runs, err := s.JobRuns(org, name)
+	if err != nil || len(runs) <= jobHistoryLimit {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range runs[jobHistoryLimit:] {
+		if run.State == JobStateRunning {
+			continue
+		}
+		os.Remove(filepath.Join(s.jobsPath(org, name), run.ID+".yml"))
+		os.Remove(filepath.Join(s.jobsPath(org, name), run.ID+".log"))
+	}
+}
+
+// jobStatus 填充 job 的状态：正在执行时为 running（pid 为执行中的进程），否则为 stopped
+func (s *SandboxManager) jobStatus(st *SandboxStatus, potCfg *models.PotConfig) {
+	org, name := st.Owner, st.Name
+	key := fmt.Sprintf("%s/%s", org, name)
+	st.Schedule = potCfg.Schedule
+	st.State = models.RunStateStopped
+
+	s.mu.RLock()
+	rc, _ := s.loadRunConfig(org, name)
+	if job := s.jobs[key]; job != nil {
+		st.State = models.RunStateRunning
+		st.Pid = job.run.Pid
+		st.StartTime = job.run.StartTime
+	}
+	if sc := s.schedulers[key]; sc != nil && !sc.next.IsZero() {
+		st.NextRun = sc.next.Format(time.RFC3339)
+	}
+	s.mu.RUnlock()
+
+	if rc != nil {
+		st.Release = rc.Release
+		st.Commit = headCommit(s.programDir(org, name, rc.Release))
+	}
+	if runs, err := s.JobRuns(org, name); err == nil && len(runs) > 0 {
+		st.LastRun = runs[0]
+	}
+	if st.Pid != 0 {
+		if started, err := time.Parse(time.RFC3339, st.StartTime); err == nil {
+			st.Uptime = int64(time.Since(started).Seconds())
+		}
+		st.Resources = readCgroupUsage(org, name)
+	}
+}
+
+// limitWriter 写入完整的行，累计超过 n 字节后丢弃之后的行并记录一次截断
+type limitWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	n         int
+	truncated bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(p) > l.n {
+		if !l.truncated {
+			l.truncated = true
+			io.WriteString(l.w, "... output truncated\n")
+		}
+		return len(p), nil
+	}
+	l.n -= len(p)
+	return l.w.Write(p)
+}
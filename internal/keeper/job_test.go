@@ -0,0 +1,153 @@
+package keeper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"potstack/config"
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunJob(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("job 使用 shell 脚本")
+	}
+	root := t.TempDir()
+	s := NewManager(root, nil)
+	defer s.Shutdown()
+	commitPot(t, root, "ann", "task", map[string]string{
+		"pot.yml": "type: job\nenv:\n  - name: TOKEN\n    value: secret:TOKEN\n",
+		"pot.exe": "#!/bin/sh\necho \"token=$TOKEN\"\nsleep 0.3\n",
+	})
+	if !assert.NoError(t, s.deployJob("ann", "task")) {
+		return
+	}
+
+	// 1. 准备失败（secret 未设置）时撤销登记，不留下执行记录，可以再次执行
+	_, err := s.RunJob("ann", "task", JobTriggerManual)
+	assert.EqualError(t, err, "env TOKEN: secret TOKEN is not set")
+	st, _ := s.Status("ann", "task")
+	assert.Equal(t, models.RunStateStopped, st.State)
+	runs, _ := s.JobRuns("ann", "task")
+	assert.Empty(t, runs)
+
+	// 2. 启动后登记为执行中，不能重复执行
+	s.secrets.Set("ann", "task", "TOKEN", "t0k3n")
+	run, err := s.RunJob("ann", "task", JobTriggerManual)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, JobStateRunning, run.State)
+	assert.NotZero(t, run.Pid)
+	_, err = s.RunJob("ann", "task", JobTriggerSchedule)
+	assert.ErrorIs(t, err, ErrJobRunning)
+	st, _ = s.Status("ann", "task")
+	assert.Equal(t, models.RunStateRunning, st.State)
+	assert.Equal(t, run.Pid, st.Pid)
+
+	// 3. 执行结束后记录结果与输出
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if runs, _ = s.JobRuns("ann", "task"); len(runs) == 1 && runs[0].State != JobStateRunning {
+			break
+		}
+	}
+	if assert.Len(t, runs, 1) {
+		assert.Equal(t, run.ID, runs[0].ID)
+		assert.Equal(t, JobStateSucceeded, runs[0].State)
+	}
+	out, _ := os.ReadFile(filepath.Join(s.jobsPath("ann", "task"), run.ID+".log"))
+	assert.Contains(t, string(out), "token=t0k3n")
+}
+
+func TestJobSchedule(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("job 使用 shell 脚本")
+	}
+	root := t.TempDir()
+	s := NewManager(root, nil)
+	defer s.Shutdown()
+	// waitRun 等待执行结束
+	waitRun := func(id string) *JobRun {
+		var run *JobRun
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if run, _ = s.GetJobRun("ann", "backup", id); run != nil && run.State != JobStateRunning {
+				break
+			}
+		}
+		return run
+	}
+
+	// 1. 没有 schedule 的 job 部署后不运行，手动执行时注入环境变量并记录退出码、耗时与输出
+	commitPot(t, root, "ann", "backup", map[string]string{
+		"pot.yml": "type: job\nenv:\n  - name: TARGET\n    value: s3\n",
+		"pot.exe": "#!/bin/sh\necho \"data=$DATA_PATH log=$LOG_PATH base=$POTSTACK_BASE_URL target=$TARGET\"\necho \"job=$POTSTACK_JOB_ID $POTSTACK_JOB_TRIGGER\"\nsleep 0.3\n",
+	})
+	if !assert.NoError(t, s.Redeploy("ann", "backup")) {
+		return
+	}
+	st, _ := s.Status("ann", "backup")
+	assert.Equal(t, models.RunStateStopped, st.State)
+	assert.Nil(t, st.LastRun)
+	assert.NotEmpty(t, st.Release)
+
+	run, err := s.RunJob("ann", "backup", JobTriggerManual)
+	if !assert.NoError(t, err) {
+		return
+	}
+	done := waitRun(run.ID)
+	assert.Equal(t, JobStateSucceeded, done.State)
+	if assert.NotNil(t, done.ExitCode) {
+		assert.Equal(t, 0, *done.ExitCode)
+	}
+	assert.GreaterOrEqual(t, done.Duration, 0.3)
+	assert.NotEmpty(t, done.EndTime)
+	assert.Zero(t, done.Pid)
+	lines, _ := s.JobOutput("ann", "backup", run.ID)
+	all := strings.Join(lines, "\n")
+	sandboxRoot := s.sandboxRoot("ann", "backup")
+	assert.Contains(t, all, fmt.Sprintf("[stdout] data=%s log=%s base=http://localhost:%s target=s3",
+		filepath.Join(sandboxRoot, "data"), filepath.Join(sandboxRoot, "log"), config.InternalPort))
+	assert.Contains(t, all, "[stdout] job="+run.ID+" manual")
+	st, _ = s.Status("ann", "backup")
+	if assert.NotNil(t, st.LastRun) {
+		assert.Equal(t, run.ID, st.LastRun.ID)
+	}
+
+	// 2. 定时执行：上一次仍在执行时跳过
+	commitPot(t, root, "ann", "backup", map[string]string{
+		"pot.yml": "type: job\nschedule: \"@every 50ms\"\n",
+		"pot.exe": "#!/bin/sh\nsleep 0.3\nexit 2\n",
+	})
+	if !assert.NoError(t, s.Redeploy("ann", "backup")) {
+		return
+	}
+	time.Sleep(1200 * time.Millisecond)
+	st, _ = s.Status("ann", "backup")
+	assert.Equal(t, "@every 50ms", st.Schedule)
+	assert.NotEmpty(t, st.NextRun)
+
+	runs, _ := s.JobRuns("ann", "backup")
+	scheduled := 0
+	for _, r := range runs {
+		if r.Trigger == JobTriggerSchedule {
+			scheduled++
+		}
+	}
+	assert.GreaterOrEqual(t, scheduled, 2)
+	assert.LessOrEqual(t, scheduled, 5, "overlapping runs should be skipped")
+	if assert.NotEmpty(t, runs) {
+		assert.Equal(t, run.ID, runs[len(runs)-1].ID, "oldest run comes last")
+		failed := waitRun(runs[1].ID)
+		assert.Equal(t, JobStateFailed, failed.State)
+		if assert.NotNil(t, failed.ExitCode) {
+			assert.Equal(t, 2, *failed.ExitCode)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package keeper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 控制台日志：pot 的 stdout/stderr 写入 log/console.log，按大小和时间轮转
+const (
+	consoleLogName = "console.log"
+	maxLogSize     = 10 << 20       // 超过 10MB 轮转
+	maxLogAge      = 24 * time.Hour // 文件创建超过 24 小时轮转
+	maxLogBackups  = 5              // 保留 console.log.1 ~ console.log.5
+	maxLineLength  = 64 << 10       // 超长的行按此长度拆分
+	followBuffer   = 256            // 订阅者缓冲，跟不上时丢弃
+)
+
+// potLog 是一个沙箱的控制台日志，进程重启后继续追加到同一文件
+type potLog struct {
+	dir string
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+	subs    map[chan string]struct{}
+}
+
+func newPotLog(dir string) *potLog {
+	return &potLog{dir: dir, subs: make(map[chan string]struct{})}
+}
+
+// potLog 返回沙箱的控制台日志（不存在时创建）
+func (s *SandboxManager) potLog(org, name string) *potLog {
+	key := fmt.Sprintf("%s/%s", org, name)
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	l, ok := s.logs[key]
+	if !ok {
+		l = newPotLog(filepath.Join(s.RepoRoot, org, fmt.Sprintf("%s.git", name), "data", "faaspot", "log"))
+		s.logs[key] = l
+	}
+	return l
+}
+
+// attach 把 cmd 的 stdout/stderr 接到日志，返回的函数在 cmd.Start 之后（无论成功与否）调用
+func (l *potLog) attach(cmd *exec.Cmd) (func(), error) {
+	closePipes, _, err := l.attachTee(cmd, nil)
+	return closePipes, err
+}
+
+// attachTee 同 attach，每行同时写入 tee（如 job 单次执行的输出）；
+// stdout 与 stderr 都读完时关闭 copied
+func (l *potLog) attachTee(cmd *exec.Cmd, tee io.Writer) (func(), <-chan struct{}, error) {
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		outR.Close()
+		outW.Close()
+		return nil, nil, err
+	}
+	cmd.Stdout = outW
+	cmd.Stderr = errW
+
+	copied := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		l.copyLines("stdout", outR, tee)
+	}()
+	go func() {
+		defer wg.Done()
+		l.copyLines("stderr", errR, tee)
+	}()
+	go func() {
+		wg.Wait()
+		close(copied)
+	}()
+
+	// 父进程关闭写端后，子进程（及其子孙进程）全部退出时读端收到 EOF
+	return func() {
+		outW.Close()
+		errW.Close()
+	}, copied, nil
+}
+
+// pipe 返回写入 stream 的 writer（如容器日志流），Close 后结束
+func (l *potLog) pipe(stream string) io.WriteCloser {
+	r, w := io.Pipe()
+	go l.copyLines(stream, r, nil)
+	return w
+}
+
+// copyLines 逐行读取 r 并加上时间戳和流标记写入日志，tee 非 nil 时同时写入 tee
+func (l *potLog) copyLines(stream string, r io.ReadCloser, tee io.Writer) {
+	defer r.Close()
+	br := bufio.NewReaderSize(r, maxLineLength)
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			formatted := l.writeLine(stream, bytes.TrimRight(line, "\r\n"))
+			if tee != nil {
+				io.WriteString(tee, formatted+"\n")
+			}
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+// writeLine 写入一行并通知订阅者，返回加上时间戳和流标记的行
+func (l *potLog) writeLine(stream string, msg []byte) string {
+	line := fmt.Sprintf("%s [%s] %s", time.Now().Format(time.RFC3339Nano), stream, msg)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.rotateIfNeeded(); err == nil {
+		n, _ := l.file.WriteString(line + "\n")
+		l.size += int64(n)
+	}
+	for ch := range l.subs {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+	return line
+}
+
+// rotateIfNeeded 打开日志文件，超过大小或时间限制时先轮转（调用方持有 l.mu）
+func (l *potLog) rotateIfNeeded() error {
+	if l.file != nil && l.size < maxLogSize && time.Since(l.created) < maxLogAge {
+		return nil
+	}
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+		l.rotate()
+	}
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(l.dir, consoleLogName)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size, l.created = f, info.Size(), time.Now()
+	if info.Size() > 0 {
+		// 沿用已有文件（如 PotStack 重启后），以最后修改时间近似创建时间
+		l.created = info.ModTime()
+	}
+	return nil
+}
+
+// rotate console.log -> console.log.1 -> ... -> console.log.N，超出的删除
+func (l *potLog) rotate() {
+	path := filepath.Join(l.dir, consoleLogName)
+	os.Remove(fmt.Sprintf("%s.%d", path, maxLogBackups))
+	for i := maxLogBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	os.Rename(path, path+".1")
+}
+
+// subscribe 订阅新写入的日志行，返回的函数取消订阅并关闭通道
+func (l *potLog) subscribe() (<-chan string, func()) {
+	ch := make(chan string, followBuffer)
+	l.mu.Lock()
+	l.subs[ch] = struct{}{}
+	l.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			l.mu.Lock()
+			delete(l.subs, ch)
+			l.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// tail 返回最后 n 行（当前文件不足时从 console.log.1 补充）
+func (l *potLog) tail(n int) ([]string, error) {
+	path := filepath.Join(l.dir, consoleLogName)
+	lines, err := lastLines(path, n)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) < n {
+		older, err := lastLines(path+".1", n-len(lines))
+		if err != nil {
+			return nil, err
+		}
+		lines = append(older, lines...)
+	}
+	return lines, nil
+}
+
+// lastLines 读取文件的最后 n 行，文件不存在时返回空
+func lastLines(path string, n int) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	all := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(all) == 1 && all[0] == "" {
+		return nil, nil
+	}
+	if len(all) > n {
+		all = all[len(all)-n:]
+	}
+	return all, nil
+}
+
+// TailLog 返回沙箱控制台日志的最后 n 行
+func (s *SandboxManager) TailLog(org, name string, n int) ([]string, error) {
+	return s.potLog(org, name).tail(n)
+}
+
+// FollowLog 订阅沙箱控制台日志，调用返回的函数取消订阅
+// 订阅者处理过慢时会丢弃日志行
+func (s *SandboxManager) FollowLog(org, name string) (<-chan string, func()) {
+	return s.potLog(org, name).subscribe()
+}
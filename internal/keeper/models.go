@@ -1,10 +1,47 @@
 package keeper
 
+import (
+	"sync"
+	"time"
+)
+
 // Instance represents a running sandbox process
 type Instance struct {
 	Org         string
 	Name        string // Repo Name
 	IngressName string // From potfiles.ingress[].name
 	Port        int
-	Cmd         *JobCmd // Wrapper for creating process in a Job
+	Replica     int    // 实例序号（pot.yml 的 replicas），从 0 开始
+	Release     string // 代码版本（data/faaspot/releases 下的目录名，为空时为旧版的 program/）
+	StartTime   string
+	started     time.Time // 启动时间（精确到纳秒，用于空闲检查）
+	Cmd         runner    // JobCmd（进程在 Job 中运行）或 Docker 容器
+	Ready       bool      // 就绪探针已通过（未配置就绪探针时启动即就绪）
+
+	done       chan struct{} // 进程退出时关闭，探针随之停止
+	ready      chan struct{} // 首次就绪时关闭，蓝绿发布据此切换流量
+	readyOnce  sync.Once
+	placed     chan struct{} // 调用方把实例放入运行表（或放弃）后关闭，watchProcess 与探针等待它
+	placedOnce sync.Once
+}
+
+// markReady 标记实例已首次就绪
+func (inst *Instance) markReady() {
+	inst.readyOnce.Do(func() { close(inst.ready) })
+}
+
+// place 标记实例已由调用方处理：launch 在锁外执行，放入运行表前退出或状态变化的实例
+// 需要等放入后再处理，否则会被当作已替换的实例忽略
+func (inst *Instance) place() {
+	inst.placedOnce.Do(func() { close(inst.placed) })
+}
+
+// waitPlaced 等待 place，进程先退出时返回 false
+func (inst *Instance) waitPlaced() bool {
+	select {
+	case <-inst.placed:
+		return true
+	case <-inst.done:
+		return false
+	}
 }
@@ -0,0 +1,171 @@
+package keeper
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"potstack/internal/models"
+)
+
+// 探针默认值
+const (
+	defaultProbeInterval    = 10 * time.Second
+	defaultProbeTimeout     = time.Second
+	defaultFailureThreshold = 3
+	defaultSuccessThreshold = 1
+)
+
+// prober 对一个进程执行 pot.yml 中声明的探针
+type prober struct {
+	probe models.Probe
+	port  int      // pot 的监听端口（探针未指定端口时使用）
+	dir   string   // exec 探针的工作目录
+	env   []string // exec 探针的环境变量（与进程相同）
+}
+
+// newProber 校验探针并填充默认值
+func newProber(p *models.Probe, port int, dir string, env []string) (*prober, error) {
+	probe := *p
+	switch probe.Type {
+	case models.ProbeHTTP:
+		if probe.Path == "" {
+			probe.Path = "/"
+		}
+	case models.ProbeTCP:
+	case models.ProbeExec:
+		if len(probe.Command) == 0 {
+			return nil, fmt.Errorf("exec probe requires a command")
+		}
+	default:
+		return nil, fmt.Errorf("unknown probe type %q", probe.Type)
+	}
+	if probe.Port == 0 {
+		probe.Port = port
+	}
+	if probe.Type != models.ProbeExec && probe.Port == 0 {
+		return nil, fmt.Errorf("%s probe requires a port", probe.Type)
+	}
+	if probe.Interval <= 0 {
+		probe.Interval = defaultProbeInterval
+	}
+	if probe.Timeout <= 0 {
+		probe.Timeout = defaultProbeTimeout
+	}
+	if probe.FailureThreshold <= 0 {
+		probe.FailureThreshold = defaultFailureThreshold
+	}
+	if probe.SuccessThreshold <= 0 {
+		probe.SuccessThreshold = defaultSuccessThreshold
+	}
+	return &prober{probe: probe, port: port, dir: dir, env: env}, nil
+}
+
+// check 执行一次探测
+func (p *prober) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.probe.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(p.probe.Port))
+	switch p.probe.Type {
+	case models.ProbeHTTP:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+p.probe.Path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", "PotStack-Probe")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("http status %d", resp.StatusCode)
+		}
+		return nil
+
+	case models.ProbeTCP:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	default:
+		cmd := exec.CommandContext(ctx, p.probe.Command[0], p.probe.Command[1:]...)
+		cmd.Dir = p.dir
+		cmd.Env = p.env
+		// 超时结束进程后，不再等待仍持有输出管道的子进程（如 sh -c 中的 sleep）
+		cmd.WaitDelay = p.probe.Timeout
+		if out, err := cmd.CombinedOutput(); err != nil {
+			if len(out) > 0 {
+				return fmt.Errorf("%v: %s", err, truncate(out, 200))
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// run 按间隔循环探测，直到 done 关闭
+// 状态从 healthy 开始，连续失败达到阈值时变为不健康，连续成功达到阈值时恢复，
+// 每次状态变化调用一次 onChange（err 为最近一次失败的原因）
+func (p *prober) run(done <-chan struct{}, healthy bool, onChange func(healthy bool, err error)) {
+	if !p.wait(done, p.probe.InitialDelay) {
+		return
+	}
+
+	successes, failures := 0, 0
+	for {
+		err := p.check()
+		if err == nil {
+			successes, failures = successes+1, 0
+			if !healthy && successes >= p.probe.SuccessThreshold {
+				healthy = true
+				onChange(true, nil)
+			}
+		} else {
+			successes, failures = 0, failures+1
+			if healthy && failures >= p.probe.FailureThreshold {
+				healthy = false
+				onChange(false, err)
+			}
+		}
+
+		if !p.wait(done, p.probe.Interval) {
+			return
+		}
+	}
+}
+
+// wait 等待 d，done 关闭时返回 false
+func (p *prober) wait(done <-chan struct{}, d time.Duration) bool {
+	if d <= 0 {
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-done:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func truncate(b []byte, n int) string {
+	if len(b) > n {
+		return string(b[:n]) + "..."
+	}
+	return string(b)
+}
@@ -0,0 +1,310 @@
+package keeper
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serverPort 返回测试服务器的端口
+func serverPort(srv *httptest.Server) int {
+	return srv.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestNewProber(t *testing.T) {
+	p, err := newProber(&models.Probe{Type: models.ProbeHTTP}, 8080, "", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/", p.probe.Path)
+		assert.Equal(t, 8080, p.probe.Port)
+		assert.Equal(t, defaultProbeInterval, p.probe.Interval)
+		assert.Equal(t, defaultProbeTimeout, p.probe.Timeout)
+		assert.Equal(t, defaultFailureThreshold, p.probe.FailureThreshold)
+		assert.Equal(t, defaultSuccessThreshold, p.probe.SuccessThreshold)
+	}
+
+	p, err = newProber(&models.Probe{Type: models.ProbeTCP, Port: 9000, FailureThreshold: 5}, 8080, "", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 9000, p.probe.Port)
+		assert.Equal(t, 5, p.probe.FailureThreshold)
+	}
+
+	for _, tc := range []struct {
+		probe models.Probe
+		port  int
+		err   string
+	}{
+		{models.Probe{Type: "grpc"}, 8080, `unknown probe type "grpc"`},
+		{models.Probe{Type: models.ProbeExec}, 8080, "exec probe requires a command"},
+		{models.Probe{Type: models.ProbeHTTP}, 0, "http probe requires a port"},
+		{models.Probe{Type: models.ProbeTCP}, 0, "tcp probe requires a port"},
+	} {
+		_, err := newProber(&tc.probe, tc.port, "", nil)
+		assert.EqualError(t, err, tc.err)
+	}
+
+	// exec 探针不需要端口
+	_, err = newProber(&models.Probe{Type: models.ProbeExec, Command: []string{"true"}}, 0, "", nil)
+	assert.NoError(t, err)
+}
+
+func TestProberHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusNoContent)
+		case "/moved":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	check := func(path string) error {
+		p, err := newProber(&models.Probe{Type: models.ProbeHTTP, Path: path}, serverPort(srv), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.check()
+	}
+	assert.NoError(t, check("/healthz"))
+	assert.NoError(t, check("/moved")) // 3xx 视为成功
+	assert.EqualError(t, check("/broken"), "http status 500")
+}
+
+func TestProberTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	p, _ := newProber(&models.Probe{Type: models.ProbeTCP}, port, "", nil)
+	assert.NoError(t, p.check())
+
+	ln.Close()
+	assert.Error(t, p.check())
+}
+
+func TestProberExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exec probe uses sh")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "marker"), nil, 0644)
+
+	check := func(script string, timeout time.Duration) error {
+		p, err := newProber(&models.Probe{
+			Type:    models.ProbeExec,
+			Command: []string{"sh", "-c", script},
+			Timeout: timeout,
+		}, 0, dir, []string{"PROBE_VAR=yes"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.check()
+	}
+	// 在 program 目录执行，环境变量与进程相同
+	assert.NoError(t, check(`test "$PROBE_VAR" = yes && test -f marker`, 0))
+	// 失败时附带输出
+	assert.EqualError(t, check("echo boom; exit 2", 0), "exit status 2: boom\n")
+	// 超时视为失败，不等待子进程退出
+	started := time.Now()
+	assert.Error(t, check("sleep 5", 50*time.Millisecond))
+	assert.Less(t, time.Since(started), time.Second)
+}
+
+// scriptedServer 按 results 依次返回成功（true）或失败，用完后一直返回成功；calls 为已收到的探测次数
+func scriptedServer(results ...bool) (*httptest.Server, *atomic.Int64) {
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if int(n) <= len(results) && !results[n-1] {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	return srv, &calls
+}
+
+type transition struct {
+	healthy bool
+	check   int64 // 第几次探测时发生
+}
+
+// runProber 运行探针直到记录到 want 次状态变化（或超时），返回记录的变化
+func runProber(t *testing.T, p *prober, calls *atomic.Int64, healthy bool, want int) []transition {
+	var mu sync.Mutex
+	var got []transition
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		p.run(done, healthy, func(healthy bool, err error) {
+			if !healthy {
+				assert.Error(t, err)
+			}
+			mu.Lock()
+			got = append(got, transition{healthy, calls.Load()})
+			mu.Unlock()
+		})
+	}()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) >= want
+	}, 5*time.Second, 5*time.Millisecond)
+	close(done)
+	<-stopped
+
+	mu.Lock()
+	defer mu.Unlock()
+	return got
+}
+
+func TestProberThresholds(t *testing.T) {
+	// 存活探针从健康开始：连续 3 次失败才变为不健康，之后连续 2 次成功才恢复
+	srv, calls := scriptedServer(true, false, false, true, false, false, false, true, false, true, true)
+	defer srv.Close()
+	p, _ := newProber(&models.Probe{
+		Type:             models.ProbeHTTP,
+		Interval:         time.Millisecond,
+		FailureThreshold: 3,
+		SuccessThreshold: 2,
+	}, serverPort(srv), "", nil)
+
+	got := runProber(t, p, calls, true, 2)
+	assert.Equal(t, []transition{{false, 7}, {true, 11}}, got)
+}
+
+func TestProberReadiness(t *testing.T) {
+	// 就绪探针从未就绪开始，失败不会重复通知，第一次成功即就绪
+	srv, calls := scriptedServer(false, false, false, false, true)
+	defer srv.Close()
+	p, _ := newProber(&models.Probe{
+		Type:             models.ProbeHTTP,
+		Interval:         time.Millisecond,
+		FailureThreshold: 1,
+	}, serverPort(srv), "", nil)
+
+	got := runProber(t, p, calls, false, 1)
+	assert.Equal(t, []transition{{true, 5}}, got)
+}
+
+func TestProberInitialDelayAndStop(t *testing.T) {
+	srv, calls := scriptedServer()
+	defer srv.Close()
+	p, _ := newProber(&models.Probe{
+		Type:         models.ProbeHTTP,
+		InitialDelay: time.Hour,
+	}, serverPort(srv), "", nil)
+
+	// initial_delay 内不探测，done 关闭后立即返回
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		p.run(done, true, func(bool, error) { t.Error("unexpected state change") })
+		close(stopped)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("prober did not stop")
+	}
+	assert.Zero(t, calls.Load())
+}
+
+// TestProbes 就绪探针通过前不注册路由，存活探针连续失败后重启进程
+func TestProbes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe 是 shell 脚本")
+	}
+	root := t.TempDir()
+	rt, internal := serveInternal(t, root)
+	s := NewManager(root, rt)
+	s.SetPotProvider(potList(pots("ann/api")))
+	defer s.Shutdown()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(internal + "/pot/ann/api" + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	status := func() SandboxStatus {
+		st, _ := s.Status("ann", "api")
+		return *st
+	}
+	// 就绪条件：data 目录下存在 ready；存活条件：data 目录下不存在 sick
+	env, exe := servePot()
+	commitPot(t, root, "ann", "api", map[string]string{
+		"pot.yml": "type: exe\nstop_timeout: 1s\nrestart:\n  backoff: 10ms\n" + env +
+			"readiness:\n  type: exec\n  command: [\"sh\", \"-c\", \"test -f \\\"$DATA_PATH/ready\\\"\"]\n  interval: 20ms\n" +
+			"liveness:\n  type: exec\n  command: [\"sh\", \"-c\", \"test ! -f \\\"$DATA_PATH/sick\\\"\"]\n" +
+			"  initial_delay: 300ms\n  interval: 20ms\n  failure_threshold: 2\n",
+		"pot.exe": exe,
+	})
+	dataDir := filepath.Join(s.sandboxRoot("ann", "api"), "data")
+
+	// 1. 进程已启动但未就绪：不注册路由
+	assert.NoError(t, s.Redeploy("ann", "api"))
+	var first SandboxStatus
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if first = status(); first.Pid != 0 {
+			break
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, models.RunStateRunning, first.State)
+	assert.NotZero(t, first.Pid)
+	assert.False(t, status().Ready)
+	code, _ := get("/")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// 2. 就绪后注册路由并转发
+	os.MkdirAll(dataDir, 0755)
+	os.WriteFile(filepath.Join(dataDir, "ready"), nil, 0644)
+	var body string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if code, body = get("/hello"); code == http.StatusOK {
+			break
+		}
+	}
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, fmt.Sprintf("%d /hello", first.Pid), body)
+	assert.True(t, status().Ready)
+
+	// 3. 存活探针连续失败：结束进程并按重启策略重启
+	os.WriteFile(filepath.Join(dataDir, "sick"), nil, 0644)
+	var st SandboxStatus
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if st = status(); st.Pid != 0 && st.Pid != first.Pid && st.Restarts > 0 {
+			break
+		}
+	}
+	os.Remove(filepath.Join(dataDir, "sick"))
+	assert.NotEqual(t, first.Pid, st.Pid)
+	assert.Equal(t, 1, st.Restarts)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if code, body = get("/again"); code == http.StatusOK {
+			break
+		}
+	}
+	assert.Equal(t, fmt.Sprintf("%d /again", st.Pid), body)
+}
@@ -0,0 +1,22 @@
+package keeper
+
+// runner 是沙箱中运行的 pot：原生进程（JobCmd）或 Docker 容器（containerProc）
+type runner interface {
+	pid() int
+	// wait 等待退出，返回退出码（被信号终止或无法获取时为 -1）和状态描述
+	wait() (exitCode int, status string, err error)
+	Terminate() error
+	Kill() error
+}
+
+func (j *JobCmd) pid() int {
+	return j.Cmd.Process.Pid
+}
+
+func (j *JobCmd) wait() (int, string, error) {
+	state, err := j.Cmd.Process.Wait()
+	if state == nil {
+		return -1, "", err
+	}
+	return state.ExitCode(), state.String(), err
+}
@@ -10,6 +10,7 @@ import (
 // JobCmd wraps exec.Cmd to ensure it runs with parent-death signal
 type JobCmd struct {
 	*exec.Cmd
+	Isolation *Isolation // 非 nil 时在隔离模式下启动（仅 Linux）
 }
 
 func NewJobCmd(name string, arg ...string) *JobCmd {
@@ -27,8 +28,24 @@ func (j *JobCmd) Start() error {
 	// This mimics Windows Job Object "KILL_ON_JOB_CLOSE" behavior
 	j.Cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
 	
-	// Create new Process Group (useful if we want to kill group manually later)
+	// Create new Process Group (Terminate / Kill signal the whole group)
 	j.Cmd.SysProcAttr.Setpgid = true
 
+	if j.Isolation != nil {
+		if err := prepareIsolation(j.Cmd, j.Isolation); err != nil {
+			return err
+		}
+	}
+
 	return j.Cmd.Start()
 }
+
+// Terminate sends SIGTERM to the process group
+func (j *JobCmd) Terminate() error {
+	return syscall.Kill(-j.Cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// Kill sends SIGKILL to the process group
+func (j *JobCmd) Kill() error {
+	return syscall.Kill(-j.Cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -12,6 +12,7 @@ import (
 // JobCmd wraps exec.Cmd to ensure it runs in a Job Object
 type JobCmd struct {
 	*exec.Cmd
+	Isolation *Isolation // 隔离模式仅支持 Linux
 	jobHandle syscall.Handle
 }
 
@@ -22,6 +23,12 @@ func NewJobCmd(name string, arg ...string) *JobCmd {
 }
 
 func (j *JobCmd) Start() error {
+	if j.Isolation != nil {
+		if err := prepareIsolation(j.Cmd, j.Isolation); err != nil {
+			return err
+		}
+	}
+
 	// Create a Job Object
 	// For simplicity, we create a new anonymous job or named one unique to this process
 	// Actually, creating a new unnamed job object is cleaner
@@ -123,6 +130,22 @@ func (j *JobCmd) Start() error {
 	return nil
 }
 
+// Terminate asks the process to exit. Windows has no SIGTERM for console-less
+// processes, so this is the same as Kill.
+func (j *JobCmd) Terminate() error {
+	return j.Kill()
+}
+
+// Kill terminates the process and everything in its Job Object
+func (j *JobCmd) Kill() error {
+	if j.jobHandle != 0 {
+		// KILL_ON_JOB_CLOSE：关闭句柄即结束 Job 内所有进程
+		syscall.CloseHandle(j.jobHandle)
+		j.jobHandle = 0
+	}
+	return j.Cmd.Process.Kill()
+}
+
 // Windows API definitions
 var (
 	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
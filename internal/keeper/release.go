@@ -0,0 +1,290 @@
+package keeper
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"potstack/config"
+	"potstack/internal/models"
+	"potstack/internal/webhook"
+
+	gitlib "github.com/go-git/go-git/v5"
+)
+
+// 每次部署把代码克隆到 data/faaspot/releases/{release}，运行中的实例始终使用自己的目录，
+// 新代码不会覆盖正在运行的程序；当前版本记录在 run.yml 的 release 中
+const (
+	releasesDir          = "releases"
+	legacyProgramDir     = "program"        // 旧版本的运行目录（release 为空）
+	defaultDeployTimeout = 60 * time.Second // 等待新实例就绪的默认时间
+)
+
+// sandboxRoot 返回沙箱运行目录：{repo}.git/data/faaspot
+func (s *SandboxManager) sandboxRoot(org, name string) string {
+	return filepath.Join(s.RepoRoot, org, fmt.Sprintf("%s.git", name), "data", "faaspot")
+}
+
+// programDir 返回 release 的代码目录
+func (s *SandboxManager) programDir(org, name, release string) string {
+	if release == "" {
+		return filepath.Join(s.sandboxRoot(org, name), legacyProgramDir)
+	}
+	return filepath.Join(s.sandboxRoot(org, name), releasesDir, release)
+}
+
+// createRuntime prepares the sandbox environment (Clones from bare repo)
+// 克隆一个新版本并设为当前版本，用于尚未运行的沙箱
+func (s *SandboxManager) createRuntime(org, name string) error {
+	release, err := s.cloneRelease(org, name)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	rc, _ := s.loadRunConfig(org, name)
+	if rc == nil {
+		rc = &models.RunConfig{}
+	}
+	prev := rc.Release
+	rc.Release = release
+	s.saveRunConfig(org, name, rc)
+	s.mu.Unlock()
+
+	s.pruneReleases(org, name, release, prev)
+	return nil
+}
+
+// cloneRelease 把默认分支的代码克隆到新的 release 目录，返回目录名
+func (s *SandboxManager) cloneRelease(org, name string) (string, error) {
+	bareRepoPath := filepath.Join(s.RepoRoot, org, fmt.Sprintf("%s.git", name))
+	sandboxRoot := s.sandboxRoot(org, name)
+
+	// Verify bare repo exists
+	if _, err := os.Stat(bareRepoPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("git repo does not exist at %s", bareRepoPath)
+	}
+
+	// Create directories
+	dirs := []string{filepath.Join(sandboxRoot, "data"), filepath.Join(sandboxRoot, "log"), filepath.Join(sandboxRoot, releasesDir)}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return "", fmt.Errorf("failed to create dir %s: %w", d, err)
+		}
+	}
+
+	release := time.Now().Format("20060102-150405.000000")
+	programDir := s.programDir(org, name, release)
+
+	// Clone to programDir
+	// 优先通过内部端口浅克隆（depth=1），运行目录不需要历史；内部服务不可用时退回本地完整克隆
+	cloneURL := fmt.Sprintf("http://localhost:%s/repo/%s/%s.git", config.InternalPort, org, name)
+	_, err := gitlib.PlainClone(programDir, false, &gitlib.CloneOptions{
+		URL:   cloneURL,
+		Depth: 1,
+	})
+	if err != nil {
+		log.Printf("Shallow clone of %s/%s failed, falling back to local clone: %v", org, name, err)
+		os.RemoveAll(programDir)
+		_, err = gitlib.PlainClone(programDir, false, &gitlib.CloneOptions{
+			URL: bareRepoPath,
+		})
+	}
+	if err != nil {
+		os.RemoveAll(programDir)
+		return "", fmt.Errorf("failed to clone code to sandbox: %w", err)
+	}
+	return release, nil
+}
+
+// pruneReleases 删除 keep 以外的版本目录，部署后保留当前与上一个版本
+// keep 中的空字符串表示旧版的 program/ 仍在使用
+func (s *SandboxManager) pruneReleases(org, name string, keep ...string) {
+	kept := make(map[string]bool)
+	for _, r := range keep {
+		kept[r] = true
+	}
+	if !kept[""] {
+		os.RemoveAll(s.programDir(org, name, ""))
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.sandboxRoot(org, name), releasesDir))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !kept[e.Name()] {
+			if err := os.RemoveAll(s.programDir(org, name, e.Name())); err != nil {
+				log.Printf("Failed to remove release %s of %s/%s: %v", e.Name(), org, name, err)
+			}
+		}
+	}
+}
+
+// deployLock 返回沙箱的部署锁，同一沙箱的重新部署串行执行
+func (s *SandboxManager) deployLock(org, name string) *sync.Mutex {
+	key := fmt.Sprintf("%s/%s", org, name)
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+	l, ok := s.deploys[key]
+	if !ok {
+		l = new(sync.Mutex)
+		s.deploys[key] = l
+	}
+	return l
+}
+
+// blueGreen 判断能否同时运行新旧两个实例：固定端口（SU_SERVER_ADDR）与 Docker pot（固定容器名）不能
+func blueGreen(potCfg *models.PotConfig) bool {
+	return fixedAddr(potCfg) == "" && potCfg.Docker == ""
+}
+
+// rollout 把沙箱切换到新版本 release：
+// 运行中且支持蓝绿发布时，在新端口启动全部新实例，都就绪后切换路由，再等待旧实例的在途请求并停止旧实例；
+// 新实例在 deploy_timeout 内未全部就绪（或提前退出）时停止新实例，旧实例继续运行
+// 否则停止旧实例后用新版本启动
+func (s *SandboxManager) rollout(org, name string, potCfg *models.PotConfig, release string) error {
+	key := fmt.Sprintf("%s/%s", org, name)
+
+	s.mu.RLock()
+	_, running := s.runningInstances[key]
+	s.mu.RUnlock()
+
+	if !running || !blueGreen(potCfg) {
+		// 空闲停止的沙箱只切换版本，收到请求时用新版本启动
+		idle := false
+		if !running {
+			s.mu.RLock()
+			rc, _ := s.loadRunConfig(org, name)
+			s.mu.RUnlock()
+			idle = rc != nil && rc.TargetStatus == models.RunStatusRunning && rc.State == models.RunStateIdle && potCfg.IdleTimeout > 0
+		}
+		if !idle {
+			s.Stop(org, name)
+		}
+		s.mu.Lock()
+		rc, _ := s.loadRunConfig(org, name)
+		if rc == nil {
+			rc = &models.RunConfig{}
+		}
+		prev := rc.Release
+		rc.Release = release
+		s.saveRunConfig(org, name, rc)
+		s.mu.Unlock()
+		s.pruneReleases(org, name, release, prev)
+		if idle {
+			log.Printf("Deployed %s while idle, will start on request", key)
+			return nil
+		}
+		return s.Start(org, name)
+	}
+
+	// 1. 启动新实例（不放入运行表，不接收流量）
+	insts := make([]*Instance, replicaCount(potCfg))
+	ports := make([]int, len(insts))
+	for i := range insts {
+		inst, err := s.launch(org, name, potCfg, release, i)
+		if err != nil {
+			s.terminateAll(org, name, insts[:i], s.stopTimeout(org, name))
+			os.RemoveAll(s.programDir(org, name, release))
+			return err
+		}
+		inst.place() // 新实例的退出与就绪由下面的等待处理，探针立即开始
+		insts[i] = inst
+		ports[i] = inst.Port
+	}
+	log.Printf("Deploying %s: new instances started (ports %v), waiting for readiness", key, ports)
+
+	// 2. 等待全部就绪
+	timeout := potCfg.DeployTimeout
+	if timeout <= 0 {
+		timeout = defaultDeployTimeout
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	var failure error
+wait:
+	for _, inst := range insts {
+		select {
+		case <-inst.ready:
+		case <-inst.done:
+			failure = fmt.Errorf("new instance exited before becoming ready")
+			break wait
+		case <-t.C:
+			failure = fmt.Errorf("new instance not ready within %v", timeout)
+			break wait
+		case <-s.stopChan:
+			failure = fmt.Errorf("keeper is shutting down")
+			break wait
+		}
+	}
+
+	// 3. 切换：新实例替换运行表中的实例（旧实例可能已被重启或停止）
+	s.mu.Lock()
+	rc, _ := s.loadRunConfig(org, name)
+	if failure == nil && (rc == nil || rc.TargetStatus != models.RunStatusRunning) {
+		failure = fmt.Errorf("sandbox was stopped during deploy")
+	}
+	for _, inst := range insts {
+		select {
+		case <-inst.done:
+			if failure == nil {
+				failure = fmt.Errorf("new instance exited before becoming ready")
+			}
+		default:
+		}
+	}
+	if failure != nil {
+		s.mu.Unlock()
+		s.rollback(org, name, release, insts, failure)
+		return fmt.Errorf("deploy rolled back: %w", failure)
+	}
+	current := s.runningInstances[key]
+	s.runningInstances[key] = insts
+	for _, inst := range insts {
+		inst.Ready = true
+	}
+	prev := rc.Release
+	rc.Release = release
+	rc.State = models.RunStateRunning
+	s.recordRuntime(key, rc)
+	rc.Restarts = 0
+	rc.NextRestart = ""
+	s.saveRunConfig(org, name, rc)
+	s.watchIdle(org, name, potCfg) // 新版本可能配置了 idle_timeout
+	s.mu.Unlock()
+
+	// 路由原子地切换到新端口，之后的请求不再发往旧实例
+	s.refreshRoute(org, name)
+	log.Printf("Deploying %s: traffic switched to ports %v", key, ports)
+
+	// 4. 等待旧实例的在途请求并停止
+	s.terminateAll(org, name, current, s.stopTimeout(org, name))
+	s.pruneReleases(org, name, release, prev)
+
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventSandbox,
+		Action:     "deployed",
+		Repository: webhook.NewRepository(org, name),
+		Data:       map[string]interface{}{"pid": rc.Runtime.Pid, "port": rc.Runtime.Port, "replicas": len(insts), "release": release},
+	})
+	return nil
+}
+
+// rollback 停止未接管流量的新实例并删除其版本目录
+func (s *SandboxManager) rollback(org, name, release string, insts []*Instance, reason error) {
+	key := fmt.Sprintf("%s/%s", org, name)
+	log.Printf("Deploying %s failed: %v, rolling back", key, reason)
+	s.terminateAll(org, name, insts, s.stopTimeout(org, name))
+	os.RemoveAll(s.programDir(org, name, release))
+
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventSandbox,
+		Action:     "rolled_back",
+		Repository: webhook.NewRepository(org, name),
+		Data:       map[string]interface{}{"release": release, "error": reason.Error()},
+	})
+}
@@ -0,0 +1,100 @@
+package keeper
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlueGreenDeploy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe 是 shell 脚本")
+	}
+	root := t.TempDir()
+	s := NewManager(root, nil)
+	defer s.Shutdown()
+	s.SetPotProvider(potList(pots("ann/app")))
+
+	// 就绪条件：data 目录下存在 ready-{VERSION}
+	potYml := "type: exe\nstop_timeout: 1s\ndeploy_timeout: 500ms\n" +
+		"readiness:\n  type: exec\n  command: [\"sh\", \"-c\", \"test -f \\\"$DATA_PATH/ready-$(cat VERSION)\\\"\"]\n  interval: 20ms\n"
+	serve := "#!/bin/sh\ntrap 'exit 0' TERM\nwhile true; do sleep 0.05; done\n"
+	push := func(version, potExe string) string {
+		return commitPot(t, root, "ann", "app", map[string]string{"pot.yml": potYml, "VERSION": version, "pot.exe": potExe})
+	}
+	sandboxDir := s.sandboxRoot("ann", "app")
+	markReady := func(version string) {
+		os.MkdirAll(filepath.Join(sandboxDir, "data"), 0755)
+		os.WriteFile(filepath.Join(sandboxDir, "data", "ready-"+version), nil, 0644)
+	}
+	releases := func() int {
+		entries, _ := os.ReadDir(filepath.Join(sandboxDir, "releases"))
+		return len(entries)
+	}
+	status := func() SandboxStatus {
+		st, _ := s.Status("ann", "app")
+		return *st
+	}
+
+	// 1. 首次部署：未运行时直接启动，代码位于 releases/ 下
+	v1 := push("v1", serve)
+	markReady("v1")
+	if !assert.NoError(t, s.Redeploy("ann", "app")) {
+		return
+	}
+	for deadline := time.Now().Add(5 * time.Second); !status().Ready && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+	}
+	first := status()
+	assert.Equal(t, v1, first.Commit)
+	assert.True(t, first.Ready)
+	assert.FileExists(t, filepath.Join(sandboxDir, "releases", first.Release, "pot.exe"))
+	assert.NoDirExists(t, filepath.Join(sandboxDir, "program"))
+
+	// 2. 新版本就绪前旧实例继续运行，就绪后切换并停止旧实例
+	v2 := push("v2", serve)
+	done := make(chan error, 1)
+	go func() { done <- s.Redeploy("ann", "app") }()
+	time.Sleep(200 * time.Millisecond)
+	cur := status()
+	assert.Equal(t, first.Pid, cur.Pid)
+	assert.Equal(t, v1, cur.Commit)
+	assert.True(t, cur.Ready)
+	assert.Equal(t, 2, releases())
+	markReady("v2")
+	if !assert.NoError(t, <-done) {
+		return
+	}
+	second := status()
+	assert.Equal(t, v2, second.Commit)
+	assert.Equal(t, models.RunStateRunning, second.State)
+	assert.True(t, second.Ready)
+	assert.NotEqual(t, first.Pid, second.Pid)
+	assert.NotEqual(t, first.Port, second.Port)
+	assert.False(t, alive(first.Pid))
+	assert.Equal(t, 2, releases()) // 保留上一个版本
+
+	// 3. 新版本超时未就绪：回滚，旧实例不受影响
+	push("v3", serve)
+	assert.ErrorContains(t, s.Redeploy("ann", "app"), "not ready within 500ms")
+	cur = status()
+	assert.Equal(t, v2, cur.Commit)
+	assert.Equal(t, second.Pid, cur.Pid)
+	assert.Equal(t, second.Release, cur.Release)
+	assert.True(t, alive(second.Pid))
+	assert.Equal(t, 2, releases())
+
+	// 4. 新版本启动后立即退出：回滚
+	push("v4", "#!/bin/sh\nexit 1\n")
+	assert.ErrorContains(t, s.Redeploy("ann", "app"), "exited before becoming ready")
+	cur = status()
+	assert.Equal(t, second.Pid, cur.Pid)
+	assert.Equal(t, models.RunStateRunning, cur.State)
+	assert.Zero(t, cur.Restarts)
+}
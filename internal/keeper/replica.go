@@ -0,0 +1,128 @@
+package keeper
+
+import (
+	"fmt"
+	"log"
+
+	"potstack/internal/git"
+	"potstack/internal/models"
+	"potstack/internal/webhook"
+)
+
+// replicaCount 返回 pot.yml 中的实例数，未配置时为 1
+func replicaCount(potCfg *models.PotConfig) int {
+	if potCfg.Replicas < 1 {
+		return 1
+	}
+	return potCfg.Replicas
+}
+
+// isCurrent 判断实例是否仍在运行表中（未被 Stop 或新版本替换），调用方持有 s.mu
+func (s *SandboxManager) isCurrent(inst *Instance) bool {
+	insts := s.runningInstances[fmt.Sprintf("%s/%s", inst.Org, inst.Name)]
+	return inst.Replica < len(insts) && insts[inst.Replica] == inst
+}
+
+// active 判断沙箱在运行表中或正在启动（Start 在锁外启动实例），调用方持有 s.mu
+func (s *SandboxManager) active(key string) bool {
+	_, running := s.runningInstances[key]
+	_, starting := s.starting[key]
+	return running || starting
+}
+
+// recordRuntime 把运行表中的实例写入 rc.Runtime，调用方持有 s.mu
+// pid / port / start_time 取第一个运行中的实例，ready 为任一实例就绪
+func (s *SandboxManager) recordRuntime(key string, rc *models.RunConfig) {
+	rt := &rc.Runtime
+	rt.Pid, rt.Port, rt.StartTime, rt.Ready = 0, 0, "", false
+	rt.Replicas = nil
+	for _, inst := range s.runningInstances[key] {
+		if inst == nil {
+			continue
+		}
+		if rt.Replicas == nil {
+			rt.Pid = inst.Cmd.pid()
+			rt.Port = inst.Port
+			rt.StartTime = inst.StartTime
+		}
+		rt.Ready = rt.Ready || inst.Ready
+		rt.Replicas = append(rt.Replicas, models.ReplicaRuntime{
+			Replica:   inst.Replica,
+			Pid:       inst.Cmd.pid(),
+			Port:      inst.Port,
+			StartTime: inst.StartTime,
+			Ready:     inst.Ready,
+		})
+	}
+}
+
+// restartReplica 退避结束后重启退出的实例
+// 其他实例仍在运行时只启动这一个实例；所有实例都已退出时重新启动整个沙箱
+func (s *SandboxManager) restartReplica(org, name string, replica int) error {
+	key := fmt.Sprintf("%s/%s", org, name)
+
+	s.mu.Lock()
+	insts, running := s.runningInstances[key]
+	if !running {
+		s.mu.Unlock()
+		// 等待期间可能已被手动停止、启动或重新部署
+		cur, _ := s.loadRunConfig(org, name)
+		if cur == nil || cur.TargetStatus != models.RunStatusRunning || cur.State != models.RunStateBackoff {
+			return nil
+		}
+		return s.Start(org, name)
+	}
+	// 已被重新部署替换，或实例数已减少
+	if replica >= len(insts) || insts[replica] != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	rc, _ := s.loadRunConfig(org, name)
+	if rc == nil || rc.TargetStatus != models.RunStatusRunning {
+		s.mu.Unlock()
+		return nil
+	}
+	var potCfg models.PotConfig
+	if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("pot.yml not found: %w", err)
+	}
+	s.mu.Unlock()
+
+	// 锁外启动，启动期间沙箱可能被停止、重新部署，或该实例已被重启
+	inst, err := s.launch(org, name, &potCfg, rc.Release, replica)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	cur := s.runningInstances[key]
+	rc, _ = s.loadRunConfig(org, name)
+	if len(cur) != len(insts) || &cur[0] != &insts[0] || cur[replica] != nil ||
+		rc == nil || rc.TargetStatus != models.RunStatusRunning {
+		s.mu.Unlock()
+		inst.Cmd.Kill()
+		<-inst.done
+		inst.place()
+		return nil
+	}
+	insts[replica] = inst
+	inst.place()
+	rc.State = models.RunStateRunning
+	rc.NextRestart = ""
+	s.recordRuntime(key, rc)
+	s.saveRunConfig(org, name, rc)
+	s.mu.Unlock()
+
+	log.Printf("Restarted sandbox %s replica %d (port %d)", key, replica, inst.Port)
+	if inst.Ready {
+		s.refreshRoute(org, name)
+	}
+
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventSandbox,
+		Action:     "started",
+		Repository: webhook.NewRepository(org, name),
+		Data:       map[string]interface{}{"pid": inst.Cmd.pid(), "port": inst.Port, "replica": replica},
+	})
+	return nil
+}
@@ -0,0 +1,87 @@
+package keeper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestReplicas(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe 是 shell 脚本")
+	}
+	root := t.TempDir()
+	s := NewManager(root, nil)
+	defer s.Shutdown()
+	commitPot(t, root, "ann", "pool", map[string]string{
+		"pot.yml": "type: exe\nreplicas: 3\nstop_timeout: 1s\nrestart:\n  backoff: 50ms\n",
+		"pot.exe": "#!/bin/sh\ntouch \"$DATA_PATH/replica-$POTSTACK_REPLICA\"\ntrap 'exit 0' TERM\nwhile true; do sleep 0.05; done\n",
+	})
+	s.SetPotProvider(potList(pots("ann/pool")))
+
+	// 1. 启动 3 个实例，端口各不相同，run.yml 记录全部实例
+	if !assert.NoError(t, s.Redeploy("ann", "pool")) {
+		return
+	}
+	st, _ := s.Status("ann", "pool")
+	assert.Equal(t, models.RunStateRunning, st.State)
+	if !assert.Len(t, st.Replicas, 3) {
+		return
+	}
+	ports := map[int]bool{}
+	for i, r := range st.Replicas {
+		assert.Equal(t, i, r.Replica)
+		assert.NotZero(t, r.Pid)
+		assert.True(t, r.Ready)
+		ports[r.Port] = true
+	}
+	assert.Len(t, ports, 3)
+	assert.Equal(t, st.Replicas[0].Pid, st.Pid)
+
+	var rc models.RunConfig
+	data, _ := os.ReadFile(filepath.Join(s.sandboxRoot("ann", "pool"), "run.yml"))
+	yaml.Unmarshal(data, &rc)
+	if assert.Len(t, rc.Runtime.Replicas, 3) {
+		assert.Equal(t, st.Replicas[2].Port, rc.Runtime.Replicas[2].Port)
+	}
+	for i := 0; i < 3; i++ {
+		assert.True(t, waitFile(dataFile(s, "ann", "pool", fmt.Sprintf("replica-%d", i))), "replica %d", i)
+	}
+
+	// 2. 一个实例退出：单独重启，其他实例不受影响，沙箱保持 running
+	before := st.Replicas
+	proc, _ := os.FindProcess(before[1].Pid)
+	proc.Kill()
+	var cur *SandboxStatus
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if cur, _ = s.Status("ann", "pool"); cur.Restarts == 1 && len(cur.Replicas) == 3 {
+			break
+		}
+	}
+	assert.Equal(t, models.RunStateRunning, cur.State)
+	if assert.Len(t, cur.Replicas, 3) {
+		assert.Equal(t, before[0].Pid, cur.Replicas[0].Pid)
+		assert.NotEqual(t, before[1].Pid, cur.Replicas[1].Pid)
+		assert.Equal(t, before[2].Pid, cur.Replicas[2].Pid)
+	}
+	assert.Equal(t, 1, cur.Restarts)
+	if assert.NotNil(t, cur.LastExitCode) {
+		assert.Equal(t, -1, *cur.LastExitCode)
+	}
+
+	// 3. 停止时结束所有实例
+	assert.NoError(t, s.Stop("ann", "pool"))
+	for _, r := range cur.Replicas {
+		assert.False(t, alive(r.Pid), "replica %d should be gone", r.Replica)
+	}
+	st, _ = s.Status("ann", "pool")
+	assert.Empty(t, st.Replicas)
+}
@@ -0,0 +1,67 @@
+package keeper
+
+import (
+	"log"
+	"time"
+
+	"potstack/internal/models"
+)
+
+// 重启策略默认值
+const (
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = 5 * time.Minute
+
+	// backoffResetAfter 进程连续运行超过该时长后再退出，视为恢复正常，重启计数清零
+	backoffResetAfter = 10 * time.Minute
+)
+
+// restartPolicy 返回 pot.yml 中的重启策略并填充默认值
+func restartPolicy(key string, potCfg *models.PotConfig) models.Restart {
+	var p models.Restart
+	if potCfg.Restart != nil {
+		p = *potCfg.Restart
+	}
+	switch p.Policy {
+	case models.RestartAlways, models.RestartOnFailure, models.RestartNever:
+	case "":
+		p.Policy = models.RestartAlways
+	default:
+		log.Printf("Unknown restart policy %q for %s, using %s", p.Policy, key, models.RestartAlways)
+		p.Policy = models.RestartAlways
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = defaultBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+	if p.MaxBackoff < p.Backoff {
+		p.MaxBackoff = p.Backoff
+	}
+	return p
+}
+
+// shouldRestart 判断以 exitCode 退出的进程是否需要按策略重启
+func shouldRestart(p models.Restart, exitCode int) bool {
+	switch p.Policy {
+	case models.RestartNever:
+		return false
+	case models.RestartOnFailure:
+		return exitCode != 0
+	default:
+		return true
+	}
+}
+
+// backoffDelay 第 restarts+1 次重启前的等待时间：backoff * 2^restarts，不超过 max_backoff
+func backoffDelay(p models.Restart, restarts int) time.Duration {
+	d := p.Backoff
+	for i := 0; i < restarts && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
@@ -0,0 +1,40 @@
+package keeper
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrashLoop(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe 是 shell 脚本")
+	}
+	root := t.TempDir()
+	s := NewManager(root, nil)
+	defer s.Shutdown()
+
+	// 启动即以退出码 3 退出：首次启动加 2 次重启后进入 crashloop，不再自动重启
+	commitPot(t, root, "ann", "flaky", map[string]string{
+		"pot.yml": "type: exe\nrestart:\n  policy: on-failure\n  max_retries: 2\n  backoff: 10ms\n",
+		"pot.exe": "#!/bin/sh\nexit 3\n",
+	})
+	assert.NoError(t, s.Redeploy("ann", "flaky"))
+	var st *SandboxStatus
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if st, _ = s.Status("ann", "flaky"); st.State == models.RunStateCrashLoop {
+			break
+		}
+	}
+	assert.Equal(t, models.RunStateCrashLoop, st.State)
+	assert.Equal(t, 2, st.Restarts)
+	if assert.NotNil(t, st.LastExitCode) {
+		assert.Equal(t, 3, *st.LastExitCode)
+	}
+	assert.NotEmpty(t, st.LastExitTime)
+	assert.Zero(t, st.Pid)
+}
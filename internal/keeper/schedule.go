@@ -0,0 +1,164 @@
+package keeper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule 是 pot.yml 中 job 的执行计划，支持：
+//
+//	标准 cron 表达式（分 时 日 月 周，本地时间）："30 2 * * *"、"*/15 9-18 * * 1-5"
+//	预定义：@yearly / @monthly / @weekly / @daily / @hourly
+//	固定间隔：@every 10m
+type schedule struct {
+	minute, hour, dom, month, dow uint64 // 每个字段允许的取值（位图）
+	domAny, dowAny                bool   // 日 / 周为 *（两者都有限制时满足其一即可）
+	every                         time.Duration
+}
+
+// cron 字段的取值范围
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 和 7 都是星期日
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseSchedule 解析 pot.yml 的 schedule
+func parseSchedule(spec string) (*schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("invalid interval %q", d)
+		}
+		return &schedule{every: every}, nil
+	}
+	if m, ok := cronMacros[spec]; ok {
+		spec = m
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("expected %d fields (minute hour day-of-month month day-of-week), got %d", len(cronFields), len(fields))
+	}
+	var bits [5]uint64
+	for i, f := range fields {
+		b, err := parseCronField(f, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", cronFields[i].name, f, err)
+		}
+		bits[i] = b
+	}
+	sc := &schedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}
+	if sc.dow&(1<<7) != 0 {
+		sc.dow |= 1 // 7 与 0 同为星期日
+	}
+	return sc, nil
+}
+
+// parseCronField 解析一个字段：* / n / a-b，可带步长 /s，多个用逗号分隔
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if hasStep {
+				hi = max // "5/15" 表示从 5 开始每 15
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("out of range %d-%d", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// next 返回 t 之后的下一次执行时间，五年内没有匹配的时间（如 2 月 30 日）时返回零值
+func (sc *schedule) next(t time.Time) time.Time {
+	if sc.every > 0 {
+		return t.Add(sc.every)
+	}
+
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case sc.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !sc.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case sc.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case sc.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches 日与周都有限制时满足其一即可（与 cron 一致）
+func (sc *schedule) dayMatches(t time.Time) bool {
+	dom := sc.dom&(1<<uint(t.Day())) != 0
+	dow := sc.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case sc.domAny && sc.dowAny:
+		return true
+	case sc.domAny:
+		return dow
+	case sc.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,94 @@
+package keeper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// 2026-03-14 是星期六
+	from := time.Date(2026, 3, 14, 10, 7, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	for _, tc := range []struct {
+		spec string
+		want time.Time
+	}{
+		// 列表、范围与步长
+		{"* * * * *", at(3, 14, 10, 8)},
+		{"0,10,50 * * * *", at(3, 14, 10, 10)},
+		{"*/15 * * * *", at(3, 14, 10, 15)},
+		{"5/15 * * * *", at(3, 14, 10, 20)},
+		{"0 9-18 * * *", at(3, 14, 11, 0)},
+		{"0 20-23/2 * * *", at(3, 14, 20, 0)},
+		{"30 2 * * *", at(3, 15, 2, 30)},
+		{"0 0 1 */6 *", at(7, 1, 0, 0)},
+
+		// 星期：0 和 7 都是星期日
+		{"0 0 * * 0", at(3, 15, 0, 0)},
+		{"0 0 * * 7", at(3, 15, 0, 0)},
+		{"0 0 * * 1-5", at(3, 16, 0, 0)},
+
+		// 日与周：只限制一个时按该字段，都限制时满足其一即可
+		{"0 0 20 * *", at(3, 20, 0, 0)},
+		{"0 0 * * 5", at(3, 20, 0, 0)},
+		{"0 0 20 * 1", at(3, 16, 0, 0)},
+		{"0 0 15 * 5", at(3, 15, 0, 0)},
+
+		// 预定义
+		{"@yearly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@annually", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", at(4, 1, 0, 0)},
+		{"@weekly", at(3, 15, 0, 0)},
+		{"@daily", at(3, 15, 0, 0)},
+		{"@midnight", at(3, 15, 0, 0)},
+		{"@hourly", at(3, 14, 11, 0)},
+		{"@every 10m", from.Add(10 * time.Minute)},
+		{" @every 90s ", from.Add(90 * time.Second)},
+
+		// 不存在的日期
+		{"0 0 30 2 *", time.Time{}},
+		{"0 0 31 4 *", time.Time{}},
+	} {
+		sc, err := parseSchedule(tc.spec)
+		if assert.NoError(t, err, tc.spec) {
+			assert.Equal(t, tc.want, sc.next(from), tc.spec)
+		}
+	}
+
+	// 下一次执行严格晚于 t
+	sc, _ := parseSchedule("*/15 * * * *")
+	assert.Equal(t, at(3, 14, 10, 30), sc.next(at(3, 14, 10, 15)))
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		err  string
+	}{
+		{"", "expected 5 fields (minute hour day-of-month month day-of-week), got 0"},
+		{"* * * *", "expected 5 fields (minute hour day-of-month month day-of-week), got 4"},
+		{"* * * * * *", "expected 5 fields (minute hour day-of-month month day-of-week), got 6"},
+		{"@reboot", "expected 5 fields (minute hour day-of-month month day-of-week), got 1"},
+		{"60 * * * *", `invalid minute "60": out of range 0-59`},
+		{"* 24 * * *", `invalid hour "24": out of range 0-23`},
+		{"* * 0 * *", `invalid day of month "0": out of range 1-31`},
+		{"* * * 13 *", `invalid month "13": out of range 1-12`},
+		{"* * * * 8", `invalid day of week "8": out of range 0-7`},
+		{"5-1 * * * *", `invalid minute "5-1": out of range 0-59`},
+		{"*/0 * * * *", `invalid minute "*/0": invalid step "0"`},
+		{"*/x * * * *", `invalid minute "*/x": invalid step "x"`},
+		{"a * * * *", `invalid minute "a": invalid value "a"`},
+		{"1-x * * * *", `invalid minute "1-x": invalid value "x"`},
+		{"1,,2 * * * *", `invalid minute "1,,2": invalid value ""`},
+		{"@every 0s", `invalid interval "0s"`},
+		{"@every soon", `invalid interval "soon"`},
+	} {
+		_, err := parseSchedule(tc.spec)
+		assert.EqualError(t, err, tc.err, tc.spec)
+	}
+}
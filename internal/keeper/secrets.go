@@ -0,0 +1,103 @@
+package keeper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"potstack/internal/models"
+	"potstack/internal/secret"
+)
+
+// pot.yml 的 env 可以引用密钥库中的密钥（value: secret:NAME），启动时解密后传给 pot；
+// file: true 的变量写入 data/faaspot/secrets 下的 0600 文件，隔离模式与 Docker pot 中只读挂载到 /secrets
+const secretsMountDir = "/secrets"
+
+// Secrets 返回密钥库（管理 API 使用）
+func (s *SandboxManager) Secrets() *secret.Store {
+	return s.secrets
+}
+
+// secretsDir 返回文件形式密钥的目录
+func (s *SandboxManager) secretsDir(org, name string) string {
+	return filepath.Join(s.sandboxRoot(org, name), "secrets")
+}
+
+// secretsMount 返回 pot 看到的密钥文件目录：原生进程为宿主机上的绝对路径，隔离模式与 Docker pot 为 /secrets
+func (s *SandboxManager) secretsMount(org, name string, potCfg *models.PotConfig) (string, error) {
+	if potCfg.Docker != "" || (potCfg.Isolation != nil && potCfg.Isolation.Enabled) {
+		return secretsMountDir, nil
+	}
+	return filepath.Abs(s.secretsDir(org, name))
+}
+
+// resolveEnv 返回 pot.yml 中的环境变量，secret:NAME 替换为密钥库中的值
+// file: true 的变量写入以变量名命名的文件，改为 {name}_FILE={mountDir}/{name}；不再使用的文件被删除
+// 返回是否有文件形式的密钥
+func (s *SandboxManager) resolveEnv(org, name string, potCfg *models.PotConfig, mountDir string) ([]models.EnvVar, bool, error) {
+	dir := s.secretsDir(org, name)
+	env := make([]models.EnvVar, 0, len(potCfg.Env))
+	files := make(map[string]bool)
+	for _, e := range potCfg.Env {
+		value := e.Value
+		if ref, ok := secret.Ref(value); ok {
+			v, err := s.secrets.Get(org, name, ref)
+			if errors.Is(err, secret.ErrNotFound) {
+				return nil, false, fmt.Errorf("env %s: secret %s is not set", e.Name, ref)
+			}
+			if err != nil {
+				return nil, false, fmt.Errorf("env %s: %w", e.Name, err)
+			}
+			value = v
+		}
+		if !e.File {
+			env = append(env, models.EnvVar{Name: e.Name, Value: value})
+			continue
+		}
+
+		// 文件名即变量名，不能包含路径
+		if !secret.ValidName(e.Name) {
+			return nil, false, fmt.Errorf("env %s: invalid name for a file", e.Name)
+		}
+		if err := writeSecretFile(dir, e.Name, value); err != nil {
+			return nil, false, fmt.Errorf("env %s: %w", e.Name, err)
+		}
+		files[e.Name] = true
+		env = append(env, models.EnvVar{Name: e.Name + "_FILE", Value: filepath.Join(mountDir, e.Name)})
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, de := range entries {
+		if !files[de.Name()] && !strings.HasPrefix(de.Name(), ".") {
+			os.Remove(filepath.Join(dir, de.Name()))
+		}
+	}
+	return env, len(files) > 0, nil
+}
+
+// writeSecretFile 写入临时文件后替换，运行中的实例不会读到写了一半的文件
+func writeSecretFile(dir, name, value string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+name+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
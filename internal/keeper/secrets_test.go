@@ -0,0 +1,60 @@
+package keeper
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretInjection(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe 是 shell 脚本")
+	}
+	root := t.TempDir()
+	s := NewManager(root, nil)
+	defer s.Shutdown()
+	commitPot(t, root, "ann", "vault", map[string]string{
+		"pot.yml": "type: exe\nenv:\n  - name: PASSWORD\n    value: secret:DB_PASSWORD\n" +
+			"  - name: TOKEN\n    value: secret:API_TOKEN\n    file: true\n",
+		"pot.exe": "#!/bin/sh\necho \"$PASSWORD\" > \"$DATA_PATH/password\"\necho \"$TOKEN\" > \"$DATA_PATH/token_env\"\n" +
+			"echo \"$TOKEN_FILE\" > \"$DATA_PATH/token_path.tmp\"\nmv \"$DATA_PATH/token_path.tmp\" \"$DATA_PATH/token_path\"\nwhile true; do sleep 0.05; done\n",
+	})
+	s.secrets.Set("ann", "vault", "DB_PASSWORD", "s3cret")
+	s.secrets.Set("ann", "vault", "API_TOKEN", "tok-1")
+	// waitStart 等待 pot 写出 token_path，返回其中的文件路径
+	waitStart := func() string {
+		if !waitFile(dataFile(s, "ann", "vault", "token_path")) {
+			t.Fatal("pot did not start")
+		}
+		data, _ := os.ReadFile(dataFile(s, "ann", "vault", "token_path"))
+		return strings.TrimSpace(string(data))
+	}
+
+	// 1. 启动时注入环境变量与 0600 文件，file 密钥不通过环境变量传递
+	if !assert.NoError(t, s.Redeploy("ann", "vault")) {
+		return
+	}
+	tokenPath := waitStart()
+	password, _ := os.ReadFile(dataFile(s, "ann", "vault", "password"))
+	assert.Equal(t, "s3cret\n", string(password))
+	tokenEnv, _ := os.ReadFile(dataFile(s, "ann", "vault", "token_env"))
+	assert.Equal(t, "\n", string(tokenEnv), "file secrets are not passed as env")
+	tokenValue, err := os.ReadFile(tokenPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "tok-1", string(tokenValue))
+	if info, err := os.Stat(tokenPath); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// 2. 引用的密钥不存在时不能启动；重新设置后重启使用新值
+	os.Remove(dataFile(s, "ann", "vault", "token_path"))
+	s.secrets.Delete("ann", "vault", "API_TOKEN")
+	assert.ErrorContains(t, s.Restart("ann", "vault"), "secret API_TOKEN is not set")
+	s.secrets.Set("ann", "vault", "API_TOKEN", "tok-2")
+	assert.NoError(t, s.Start("ann", "vault"))
+	tokenValue, _ = os.ReadFile(waitStart())
+	assert.Equal(t, "tok-2", string(tokenValue))
+}
@@ -10,16 +10,19 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"potstack/config"
+	"potstack/internal/docker"
 	"potstack/internal/git"
 	"potstack/internal/models"
 	"potstack/internal/router"
+	"potstack/internal/secret"
+	"potstack/internal/webhook"
 
-	gitlib "github.com/go-git/go-git/v5"
 	"gopkg.in/yaml.v3"
 )
 
@@ -39,23 +42,59 @@ type SandboxManager struct {
 	PotProvider PotProvider
 	Router      *router.Router
 
-	// Key: org/repo
-	runningInstances map[string]*Instance
+	// Key: org/repo，按实例序号排列，已退出（等待重启）的实例为 nil，所有实例都退出后删除
+	runningInstances map[string][]*Instance
 	mu               sync.RWMutex
 	stopChan         chan struct{}
+	stopOnce         sync.Once
+
+	// 控制台日志，Key: org/repo
+	logs  map[string]*potLog
+	logMu sync.Mutex
+
+	// 重新部署按沙箱串行执行，Key: org/repo
+	deploys  map[string]*sync.Mutex
+	deployMu sync.Mutex
+
+	// 正在启动的沙箱（Start 在锁外启动实例），Key: org/repo，值为启动序号，由 s.mu 保护
+	// Stop 时删除，Start 放入运行表前发现序号不同则放弃启动
+	starting map[string]uint64
+	startSeq uint64
+
+	// 正在检查空闲的沙箱（配置了 idle_timeout），Key: org/repo，由 s.mu 保护
+	idleWatchers map[string]bool
+
+	// job pot 正在执行的 run 与计划协程，Key: org/repo，由 s.mu 保护
+	jobs       map[string]*activeJob
+	schedulers map[string]*jobScheduler
+
+	// pot.yml 中 secret:NAME 引用的密钥
+	secrets *secret.Store
 }
 
+// defaultStopTimeout 停止沙箱时等待在途请求与进程退出的默认时间
+const defaultStopTimeout = 10 * time.Second
+
 func NewManager(repoRoot string, r *router.Router) *SandboxManager {
 	return &SandboxManager{
 		RepoRoot:         repoRoot,
 		Router:           r,
-		runningInstances: make(map[string]*Instance),
+		runningInstances: make(map[string][]*Instance),
+		starting:         make(map[string]uint64),
 		stopChan:         make(chan struct{}),
+		logs:             make(map[string]*potLog),
+		deploys:          make(map[string]*sync.Mutex),
+		idleWatchers:     make(map[string]bool),
+		jobs:             make(map[string]*activeJob),
+		schedulers:       make(map[string]*jobScheduler),
+		secrets:          secret.NewStore(repoRoot),
 	}
 }
 
 func (s *SandboxManager) SetPotProvider(p PotProvider) {
+	s.mu.Lock()
 	s.PotProvider = p
+	s.mu.Unlock()
 }
 
 // KeeperMode 定义 Keeper 运行模式
@@ -96,19 +135,9 @@ func (s *SandboxManager) runBuiltinKeeper() {
 	// Initial Scan
 	s.reconcile()
 
-	// Monitor Loop
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			s.monitor()
-		case <-s.stopChan:
-			log.Println("Keeper stopped.")
-			return
-		}
-	}
+	// 进程退出由 watchProcess 处理，健康状况由各实例的探针处理
+	<-s.stopChan
+	log.Println("Keeper stopped.")
 }
 
 // runExternalKeeper 模式二：只管理 keeper pot
@@ -141,6 +170,12 @@ func (s *SandboxManager) ensureKeeperPotRunning() {
 	_, running := s.runningInstances[key]
 	s.mu.RUnlock()
 
+	// 等待退避重启或已进入 crashloop 时不干预
+	if rc, err := s.loadRunConfig("potstack", "keeper"); err == nil &&
+		(rc.State == models.RunStateBackoff || rc.State == models.RunStateCrashLoop) {
+		return
+	}
+
 	if !running {
 		log.Println("Starting keeper pot...")
 		if err := s.createRuntime("potstack", "keeper"); err != nil {
@@ -155,25 +190,63 @@ func (s *SandboxManager) ensureKeeperPotRunning() {
 
 // reconcile ensures all sandboxes are in desired state
 func (s *SandboxManager) reconcile() {
-	if s.PotProvider == nil {
+	list, ok := s.installedPots()
+	if !ok {
 		return
 	}
 
-	list := s.PotProvider.GetInstalledPots()
+	// 1. 从 Git 读取 pot.yml，没有 pot.yml 的跳过
+	configs := make(map[string]*models.PotConfig, len(list))
+	deps := make(map[string][]string)
+	var pots []PotURI
 	for _, sb := range list {
-		// 1. 从 Git 读取 pot.yml
+		key := fmt.Sprintf("%s/%s", sb.Org, sb.Name)
 		var potCfg models.PotConfig
 		if err := git.ReadPotYml(s.RepoRoot, sb.Org, sb.Name, &potCfg); err != nil {
-			continue // 没有 pot.yml，跳过
+			continue
+		}
+		configs[key] = &potCfg
+		deps[key] = dependencyKeys(key, &potCfg)
+		pots = append(pots, sb)
+	}
+
+	// 2. 按 depends_on 排序，依赖先启动；成环的沙箱不启动
+	order, cyclic := startOrder(pots, deps)
+	if len(cyclic) > 0 {
+		blocked := make(map[string]bool, len(cyclic))
+		for _, sb := range cyclic {
+			blocked[fmt.Sprintf("%s/%s", sb.Org, sb.Name)] = true
+		}
+		for _, sb := range cyclic {
+			key := fmt.Sprintf("%s/%s", sb.Org, sb.Name)
+			log.Printf("Sandbox %s has a dependency cycle (%s), not starting", key, cyclePath(key, deps, blocked))
 		}
+	}
+
+	for _, sb := range order {
+		potCfg := configs[fmt.Sprintf("%s/%s", sb.Org, sb.Name)]
 
-		// 2. 根据类型处理
+		// 3. 根据类型处理
 		if potCfg.Type == "static" {
 			// Static 类型：直接刷新路由即可
 			s.refreshRoute(sb.Org, sb.Name)
 			continue
 		}
 
+		if potCfg.Type == "job" {
+			// Job 类型：没有常驻进程，按 schedule 执行
+			if _, err := s.loadRunConfig(sb.Org, sb.Name); err != nil {
+				log.Printf("Initializing job %s/%s", sb.Org, sb.Name)
+				if err := s.createRuntime(sb.Org, sb.Name); err != nil {
+					log.Printf("Failed to create runtime: %v", err)
+					continue
+				}
+			}
+			s.recoverJobRuns(sb.Org, sb.Name)
+			s.scheduleJob(sb.Org, sb.Name)
+			continue
+		}
+
 		if potCfg.Type == "exe" {
 			// Exe 类型：需要管理进程
 			run, err := s.loadRunConfig(sb.Org, sb.Name)
@@ -184,22 +257,21 @@ func (s *SandboxManager) reconcile() {
 					log.Printf("Failed to create runtime: %v", err)
 					continue
 				}
-				if err := s.Start(sb.Org, sb.Name); err != nil {
-					log.Printf("Failed to start sandbox %s/%s: %v", sb.Org, sb.Name, err)
-				}
+				s.startAfterDependencies(sb.Org, sb.Name, potCfg)
 				continue
 			}
 
 			// 根据 TargetStatus 处理
 			if run.TargetStatus == models.RunStatusRunning {
 				s.mu.RLock()
-				_, running := s.runningInstances[fmt.Sprintf("%s/%s", sb.Org, sb.Name)]
+				running := s.active(fmt.Sprintf("%s/%s", sb.Org, sb.Name))
 				s.mu.RUnlock()
 
-				if !running {
-					if err := s.Start(sb.Org, sb.Name); err != nil {
-						log.Printf("Failed to start sandbox %s/%s: %v", sb.Org, sb.Name, err)
-					}
+				if !running && run.State == models.RunStateIdle && potCfg.IdleTimeout > 0 {
+					// 空闲停止的沙箱收到请求时再启动，只注册启动入口
+					s.refreshRoute(sb.Org, sb.Name)
+				} else if !running {
+					s.startAfterDependencies(sb.Org, sb.Name, potCfg)
 				} else {
 					// 已经在运行，确保路由是最新的
 					s.refreshRoute(sb.Org, sb.Name)
@@ -207,7 +279,7 @@ func (s *SandboxManager) reconcile() {
 			} else {
 				// TargetStatus 是 stopped，确保进程已停止
 				s.mu.RLock()
-				_, running := s.runningInstances[fmt.Sprintf("%s/%s", sb.Org, sb.Name)]
+				running := s.active(fmt.Sprintf("%s/%s", sb.Org, sb.Name))
 				s.mu.RUnlock()
 
 				if running {
@@ -218,46 +290,13 @@ func (s *SandboxManager) reconcile() {
 	}
 }
 
-// createRuntime prepares the sandbox environment (Clones from bare repo)
-func (s *SandboxManager) createRuntime(org, name string) error {
-	bareRepoPath := filepath.Join(s.RepoRoot, org, fmt.Sprintf("%s.git", name))
-
-	sandboxRoot := filepath.Join(bareRepoPath, "data", "faaspot")
-	programDir := filepath.Join(sandboxRoot, "program")
-	dataDir := filepath.Join(sandboxRoot, "data")
-	logDir := filepath.Join(sandboxRoot, "log")
-
-	// Verify bare repo exists
-	if _, err := os.Stat(bareRepoPath); os.IsNotExist(err) {
-		return fmt.Errorf("git repo does not exist at %s", bareRepoPath)
-	}
-
-	// Create directories
-	dirs := []string{dataDir, logDir}
-	for _, d := range dirs {
-		if err := os.MkdirAll(d, 0755); err != nil {
-			return fmt.Errorf("failed to create dir %s: %w", d, err)
-		}
-	}
-
-	// Clean program dir
-	os.RemoveAll(programDir)
-
-	// Clone to programDir
-	_, err := gitlib.PlainClone(programDir, false, &gitlib.CloneOptions{
-		URL: bareRepoPath,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to clone code to sandbox: %w", err)
-	}
-
-	return nil
-}
-
 // GetSandboxConfig reads pot.yml from an installed sandbox
 func (s *SandboxManager) GetSandboxConfig(org, name string) (*models.PotConfig, error) {
-	bareRepoPath := filepath.Join(s.RepoRoot, org, fmt.Sprintf("%s.git", name))
-	configFile := filepath.Join(bareRepoPath, "data", "faaspot", "program", "pot.yml")
+	var release string
+	if rc, err := s.loadRunConfig(org, name); err == nil {
+		release = rc.Release
+	}
+	configFile := filepath.Join(s.programDir(org, name, release), "pot.yml")
 
 	data, err := os.ReadFile(configFile)
 	if err != nil {
@@ -270,18 +309,6 @@ func (s *SandboxManager) GetSandboxConfig(org, name string) (*models.PotConfig,
 	return &pct, nil
 }
 
-// monitor checks process health
-func (s *SandboxManager) monitor() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for _, inst := range s.runningInstances {
-		if inst.Cmd != nil && inst.Cmd.Process != nil {
-			// Monitoring logic is handled by watchProcess mostly.
-		}
-	}
-}
-
 // refreshRoute 调用 Router 刷新接口更新路由
 func (s *SandboxManager) refreshRoute(org, name string) {
 	url := fmt.Sprintf("http://localhost:%s/pot/potstack/router/refresh", config.InternalPort)
@@ -304,62 +331,139 @@ func (s *SandboxManager) refreshRoute(org, name string) {
 	}
 }
 
-// SignalUpdate is called by Loader
+// SignalUpdate is called by Loader and the post-receive deploy hook
 func (s *SandboxManager) SignalUpdate(org, name string) {
 	log.Printf("Received update signal for %s/%s", org, name)
-
-	// Update Runtime code
-	if err := s.createRuntime(org, name); err != nil {
-		log.Printf("Failed to update runtime: %v", err)
-		return
+	if err := s.Redeploy(org, name); err != nil {
+		log.Printf("Failed to redeploy %s/%s: %v", org, name, err)
 	}
-
-	// Restart if running
-	s.Stop(org, name)
-	s.Start(org, name)
 }
 
 // Start launches the sandbox (exe type only)
+// 实例在锁外启动（容器的创建与启动可能较慢），只在检查状态与放入运行表时持有 s.mu
 func (s *SandboxManager) Start(org, name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	key := fmt.Sprintf("%s/%s", org, name)
 
+	s.mu.Lock()
+	if _, ok := s.runningInstances[key]; ok {
+		s.mu.Unlock()
+		return ErrAlreadyRunning
+	}
+	if _, ok := s.starting[key]; ok {
+		s.mu.Unlock()
+		return ErrAlreadyRunning
+	}
+
 	// 1. 从 Git 读取 pot.yml 判断类型
 	var potCfg models.PotConfig
 	if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err != nil {
+		s.mu.Unlock()
 		return fmt.Errorf("pot.yml not found: %w", err)
 	}
 
 	// Only exe type needs to start a process
 	if potCfg.Type != "exe" {
-		return fmt.Errorf("not an exe type sandbox")
+		s.mu.Unlock()
+		return ErrNotExe
+	}
+	replicas := replicaCount(&potCfg)
+	if replicas > 1 && fixedAddr(&potCfg) != "" {
+		s.mu.Unlock()
+		return fmt.Errorf("replicas require random ports, remove SU_SERVER_ADDR from pot.yml")
 	}
 
-	// 2. Path Calculation
-	bareRepoPath := filepath.Join(s.RepoRoot, org, fmt.Sprintf("%s.git", name))
-	sandboxRoot := filepath.Join(bareRepoPath, "data", "faaspot")
-	programDir := filepath.Join(sandboxRoot, "program")
-
-	// 3. Prepare Run Config
+	// 2. Prepare Run Config（保留当前代码版本与上次的退出、重启记录）
 	rc := models.RunConfig{
 		TargetStatus: models.RunStatusRunning,
+		State:        models.RunStateRunning,
+	}
+	if prev, err := s.loadRunConfig(org, name); err == nil {
+		rc.Release = prev.Release
+		rc.Restarts = prev.Restarts
+		rc.LastExitCode = prev.LastExitCode
+		rc.LastExitTime = prev.LastExitTime
+	}
+	s.startSeq++
+	seq := s.startSeq
+	s.starting[key] = seq
+	s.mu.Unlock()
+
+	// 3. Launch（每个实例使用独立的端口）
+	insts := make([]*Instance, replicas)
+	ports := make([]int, replicas)
+	var err error
+	for i := range insts {
+		if insts[i], err = s.launch(org, name, &potCfg, rc.Release, i); err != nil {
+			break
+		}
+		ports[i] = insts[i].Port
+	}
+
+	// 4. 放入运行表；启动期间被 Stop（或 Shutdown）时放弃
+	s.mu.Lock()
+	if err == nil && s.starting[key] != seq {
+		err = fmt.Errorf("sandbox %s was stopped while starting", key)
+	}
+	if s.starting[key] == seq {
+		delete(s.starting, key)
 	}
-	rc.Runtime.StartTime = time.Now().Format(time.RFC3339)
+	if err != nil {
+		_, shared := s.runningInstances[key]
+		_, restarting := s.starting[key]
+		s.mu.Unlock()
+		// 已启动的实例尚未放入运行表，也没有路由，直接结束
+		for _, started := range insts {
+			if started != nil {
+				started.Cmd.Kill()
+				<-started.done
+				started.place()
+			}
+		}
+		if !shared && !restarting {
+			killCgroup(org, name)
+		}
+		return err
+	}
+	s.runningInstances[key] = insts
+	for _, inst := range insts {
+		inst.place()
+	}
+
+	// 5. Save Run Config（未配置就绪探针时保持原行为：启动即就绪）
+	s.recordRuntime(key, &rc)
+	s.saveRunConfig(org, name, &rc)
+	log.Printf("Started sandbox %s (ports %v)", key, ports)
+	s.watchIdle(org, name, &potCfg)
+	s.mu.Unlock()
+
+	// 解锁后刷新路由（未就绪时只清理旧路由）
+	s.refreshRoute(org, name)
+
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventSandbox,
+		Action:     "started",
+		Repository: webhook.NewRepository(org, name),
+		Data:       map[string]interface{}{"pid": rc.Runtime.Pid, "port": rc.Runtime.Port, "replicas": replicas},
+	})
+	return nil
+}
 
-	// 4. Get port
+// launch 用 release 的代码启动序号为 replica 的实例（进程或容器），并启动 watchProcess 与探针
+// 实例由调用方放入运行表：Start 直接放入，蓝绿发布在新实例就绪后替换旧实例；放入（或放弃）后调用 inst.place()
+// 启动容器可能需要较长时间，调用方不持有 s.mu
+func (s *SandboxManager) launch(org, name string, potCfg *models.PotConfig, release string, replica int) (*Instance, error) {
+	key := fmt.Sprintf("%s/%s", org, name)
+
+	// 1. Path Calculation
+	sandboxRoot := s.sandboxRoot(org, name)
+	programDir := s.programDir(org, name, release)
+
+	// 2. Get port
 	var port int
 	var addr string
 
 	// Check env for SU_SERVER_ADDR
-	customAddr := ""
-	for _, e := range potCfg.Env {
-		if e.Name == "SU_SERVER_ADDR" {
-			customAddr = e.Value
-			break
-		}
-	}
+	customAddr := fixedAddr(potCfg)
 
 	if customAddr != "" {
 		addr = customAddr
@@ -371,85 +475,206 @@ func (s *SandboxManager) Start(org, name string) error {
 		// Random Port
 		p, err := GetFreePort()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		port = p
 		addr = fmt.Sprintf("127.0.0.1:%d", port)
 	}
 
-	rc.Runtime.Port = port
+	dataPath := filepath.Join(sandboxRoot, "data")
+	logPath := filepath.Join(sandboxRoot, "log")
 
-	// 5. Launch pot.exe
-	cmdPath := filepath.Join(programDir, "pot.exe")
-	// 转换为绝对路径
-	absCmdPath, err := filepath.Abs(cmdPath)
+	// 用户自定义环境变量，secret:NAME 在此解密
+	mountDir, err := s.secretsMount(org, name, potCfg)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return nil, err
 	}
-	cmdPath = absCmdPath
-
-	if _, err := os.Stat(cmdPath); os.IsNotExist(err) {
-		return fmt.Errorf("pot.exe not found at %s", cmdPath)
+	userEnv, secretFiles, err := s.resolveEnv(org, name, potCfg, mountDir)
+	if err != nil {
+		return nil, err
+	}
+	secretsPath := ""
+	if secretFiles {
+		secretsPath = s.secretsDir(org, name)
 	}
 
-	jobCmd := NewJobCmd(cmdPath)
-	jobCmd.Dir = programDir
+	// 3. Launch pot.exe（pot.yml 配置了 docker 时运行容器）
+	var jobCmd *JobCmd
+	var containerCfg *docker.ContainerConfig
+	var env []string
+	if potCfg.Docker != "" {
+		if containerCfg, err = newContainerConfig(org, name, potCfg, replica, port, programDir, dataPath, logPath, secretsPath, userEnv); err != nil {
+			return nil, fmt.Errorf("invalid docker pot: %w", err)
+		}
+		// exec 探针在宿主机上执行
+		env = append(os.Environ(), containerCfg.Env...)
+	} else {
+		cmdPath := filepath.Join(programDir, "pot.exe")
+		// 转换为绝对路径
+		absCmdPath, err := filepath.Abs(cmdPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		cmdPath = absCmdPath
+
+		if _, err := os.Stat(cmdPath); os.IsNotExist(err) {
+			return nil, fmt.Errorf("pot.exe not found at %s", cmdPath)
+		}
 
-	// Env
-	env := os.Environ()
-	// 内置环境变量
-	dataPath := filepath.Join(sandboxRoot, "data")
-	env = append(env, fmt.Sprintf("DATA_PATH=%s", dataPath))
-	env = append(env, fmt.Sprintf("PROGRAM_PATH=%s", programDir))
-	env = append(env, fmt.Sprintf("LOG_PATH=%s", filepath.Join(sandboxRoot, "log")))
-	env = append(env, fmt.Sprintf("POTSTACK_BASE_URL=http://localhost:%s", config.InternalPort))
-	env = append(env, fmt.Sprintf("SU_SERVER_ADDR=%s", addr))
-	// 用户自定义环境变量
-	for _, e := range potCfg.Env {
-		env = append(env, fmt.Sprintf("%s=%s", e.Name, e.Value))
-	}
-	jobCmd.Env = env
+		jobCmd = NewJobCmd(cmdPath)
+		jobCmd.Dir = programDir
 
-	if err := jobCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start pot.exe: %w", err)
+		// 隔离模式（仅 Linux）：独立的命名空间，只能看到 program / data / log（以及 secrets）
+		iso, err := newIsolation(potCfg, programDir, dataPath, logPath, secretsPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid isolation: %w", err)
+		}
+		jobCmd.Isolation = iso
+
+		// Env
+		env = potEnv(iso, programDir, dataPath, logPath)
+		env = append(env, fmt.Sprintf("SU_SERVER_ADDR=%s", addr))
+		env = append(env, fmt.Sprintf("POTSTACK_REPLICA=%d", replica))
+		for _, e := range userEnv {
+			env = append(env, fmt.Sprintf("%s=%s", e.Name, e.Value))
+		}
+		jobCmd.Env = env
 	}
 
-	rc.Runtime.Pid = jobCmd.Process.Pid
-
-	// 6. Save Run Config
-	s.saveRunConfig(org, name, &rc)
+	// 探针在启动进程前校验，配置错误时不启动
+	var liveness, readiness *prober
+	if potCfg.Liveness != nil {
+		if liveness, err = newProber(potCfg.Liveness, port, programDir, env); err != nil {
+			return nil, fmt.Errorf("invalid liveness probe: %w", err)
+		}
+	}
+	if potCfg.Readiness != nil {
+		if readiness, err = newProber(potCfg.Readiness, port, programDir, env); err != nil {
+			return nil, fmt.Errorf("invalid readiness probe: %w", err)
+		}
+	}
 
+	started := time.Now()
+	startTime := started.Format(time.RFC3339)
+	var proc runner
+	if containerCfg != nil {
+		// 容器的资源限制由 Docker 设置，stdout/stderr 从 Engine API 读取
+		c, err := startContainer(org, name, replica, containerCfg, s.potLog(org, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to start container: %w", err)
+		}
+		proc = c
+	} else {
+		// 资源限制：每个沙箱一个 cgroup v2 子树（仅 Linux），同一沙箱的所有实例共用
+		releaseCgroup, err := setupCgroup(org, name, potCfg.Resources, jobCmd.Cmd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up cgroup: %w", err)
+		}
+		defer releaseCgroup()
 
+		// stdout/stderr 写入 log/console.log
+		closePipes, err := s.potLog(org, name).attach(jobCmd.Cmd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to capture output: %w", err)
+		}
+		err = jobCmd.Start()
+		closePipes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to start pot.exe: %w", err)
+		}
+		proc = jobCmd
+	}
 
-	s.runningInstances[key] = &Instance{
-		Org:  org,
-		Name: name,
-		Cmd:  jobCmd,
+	inst := &Instance{
+		Org:       org,
+		Name:      name,
+		Port:      port,
+		Replica:   replica,
+		Release:   release,
+		StartTime: startTime,
+		started:   started,
+		Cmd:       proc,
+		Ready:     readiness == nil,
+		done:      make(chan struct{}),
+		ready:     make(chan struct{}),
+		placed:    make(chan struct{}),
+	}
+	if readiness == nil {
+		inst.markReady()
 	}
-	log.Printf("Started sandbox %s (port %d)", key, port)
 
 	// Monitor death for restart
-	go s.watchProcess(key, jobCmd)
+	go s.watchProcess(key, inst)
+
+	// 探针在实例放入运行表后开始，状态变化不会因为实例还不在运行表中而丢失
+	if readiness != nil {
+		go func() {
+			if inst.waitPlaced() {
+				readiness.run(inst.done, false, func(ready bool, err error) {
+					s.setReady(inst, ready, err)
+				})
+			}
+		}()
+	}
+	if liveness != nil {
+		go func() {
+			if inst.waitPlaced() {
+				liveness.run(inst.done, true, func(alive bool, err error) {
+					if !alive {
+						s.restartUnhealthy(inst, err)
+					}
+				})
+			}
+		}()
+	}
+	return inst, nil
+}
 
-	// 解锁后刷新路由
-	s.mu.Unlock()
-	s.refreshRoute(org, name)
-	s.mu.Lock() // 重新加锁以配合 defer Unlock
+// potEnv 返回原生进程的内置环境变量（exe 与 job 相同）
+func potEnv(iso *Isolation, programDir, dataPath, logPath string) []string {
+	var env []string
+	if iso != nil {
+		// 不继承 PotStack 的环境变量，路径为沙箱内路径
+		env = iso.env()
+	} else {
+		env = os.Environ()
+		env = append(env, fmt.Sprintf("DATA_PATH=%s", dataPath))
+		env = append(env, fmt.Sprintf("PROGRAM_PATH=%s", programDir))
+		env = append(env, fmt.Sprintf("LOG_PATH=%s", logPath))
+	}
+	return append(env, fmt.Sprintf("POTSTACK_BASE_URL=http://localhost:%s", config.InternalPort))
+}
 
-	return nil
+// fixedAddr 返回 pot.yml 中通过 SU_SERVER_ADDR 指定的监听地址，未指定时为空（使用随机端口）
+func fixedAddr(potCfg *models.PotConfig) string {
+	for _, e := range potCfg.Env {
+		if e.Name == "SU_SERVER_ADDR" {
+			return e.Value
+		}
+	}
+	return ""
 }
 
+// Stop 优雅停止沙箱：先摘除路由并等待在途请求，再 SIGTERM，超时后 SIGKILL
 func (s *SandboxManager) Stop(org, name string) error {
+	return s.stop(org, name, models.RunStateStopped)
+}
+
+// stop 停止沙箱并把 state 写入 run.yml
+// state 为 idle 时是空闲停止：只停止运行中的沙箱，target_status 保持 running，路由改为启动入口
+func (s *SandboxManager) stop(org, name string, state models.RunState) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	key := fmt.Sprintf("%s/%s", org, name)
 
-	// Kill Process
-	if inst, ok := s.runningInstances[key]; ok {
-		if inst.Cmd != nil && inst.Cmd.Process != nil {
-			inst.Cmd.Process.Kill()
-		}
+	// 先从运行表移除，进程退出时 watchProcess 不会当作崩溃；正在启动的放弃启动
+	insts, wasRunning := s.runningInstances[key]
+	if !wasRunning && state == models.RunStateIdle {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.starting, key)
+	if wasRunning {
 		delete(s.runningInstances, key)
 	}
 
@@ -458,38 +683,325 @@ func (s *SandboxManager) Stop(org, name string) error {
 	if rc == nil {
 		rc = &models.RunConfig{}
 	}
-	rc.TargetStatus = models.RunStatusStopped
+	if state != models.RunStateIdle {
+		rc.TargetStatus = models.RunStatusStopped
+	}
+	rc.State = state
+	s.recordRuntime(key, rc)
+	rc.Restarts = 0
+	rc.NextRestart = ""
 	s.saveRunConfig(org, name, rc)
-
-
-
-	// 解锁后刷新路由
 	s.mu.Unlock()
+
+	// 摘除路由后再结束进程
 	s.refreshRoute(org, name)
-	s.mu.Lock() // 重新加锁以配合 defer Unlock
+	if wasRunning {
+		s.terminateAll(org, name, insts, s.stopTimeout(org, name))
+	}
 
-	log.Printf("Stopped sandbox %s", key)
+	log.Printf("Stopped sandbox %s (%s)", key, state)
+	if wasRunning {
+		action := "stopped"
+		if state == models.RunStateIdle {
+			action = "idle"
+		}
+		webhook.Emit(&webhook.Payload{
+			Event:      webhook.EventSandbox,
+			Action:     action,
+			Repository: webhook.NewRepository(org, name),
+		})
+	}
 	return nil
 }
 
-func (s *SandboxManager) watchProcess(key string, cmd *JobCmd) {
-	state, err := cmd.Process.Wait()
-	log.Printf("Sandbox %s exited: %v %v", key, state, err)
+// Shutdown 在 PotStack 退出时优雅停止所有沙箱
+// 与 Stop 不同，run.yml 的 target_status 保持不变，下次启动时由 reconcile 恢复
+func (s *SandboxManager) Shutdown() {
+	s.stopOnce.Do(func() { close(s.stopChan) }) // 停止 Keeper 循环与等待中的退避重启
 
 	s.mu.Lock()
-	delete(s.runningInstances, key)
+	clear(s.starting) // 正在启动的沙箱放弃启动
+	sandboxes := make(map[string][]*Instance, len(s.runningInstances))
+	for key, insts := range s.runningInstances {
+		sandboxes[key] = insts
+		delete(s.runningInstances, key)
+	}
+	for key := range sandboxes {
+		org, name, _ := strings.Cut(key, "/")
+		if rc, err := s.loadRunConfig(org, name); err == nil {
+			rc.State = models.RunStateStopped
+			s.recordRuntime(key, rc)
+			s.saveRunConfig(org, name, rc)
+		}
+	}
 	s.mu.Unlock()
 
-	// Check if we should restart
-	parts := strings.Split(key, "/")
-	if len(parts) >= 2 {
-		org, name := parts[0], parts[1]
-		rc, _ := s.loadRunConfig(org, name)
-		if rc != nil && rc.TargetStatus == models.RunStatusRunning {
-			log.Printf("Auto-restarting %s...", key)
-			time.Sleep(1 * time.Second) // backoff
-			s.Start(org, name)
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		s.stopJobs()
+	}()
+
+	// 按启动顺序的相反顺序分批停止：依赖它的沙箱都停止后再停止，同一批并行
+	keys := make([]string, 0, len(sandboxes))
+	deps := make(map[string][]string, len(sandboxes))
+	for key := range sandboxes {
+		org, name, _ := strings.Cut(key, "/")
+		var potCfg models.PotConfig
+		if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err == nil {
+			deps[key] = dependencyKeys(key, &potCfg)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, wave := range stopOrder(keys, deps) {
+		var wg sync.WaitGroup
+		for _, key := range wave {
+			wg.Add(1)
+			go func(key string, insts []*Instance) {
+				defer wg.Done()
+				org, name, _ := strings.Cut(key, "/")
+				if s.Router != nil {
+					s.Router.RemoveRoutes(org, name)
+				}
+				s.terminateAll(org, name, insts, s.stopTimeout(org, name))
+				log.Printf("Stopped sandbox %s", key)
+			}(key, sandboxes[key])
 		}
+		wg.Wait()
+	}
+	jobs.Wait()
+}
+
+// stopTimeout 返回 pot.yml 中的 stop_timeout
+func (s *SandboxManager) stopTimeout(org, name string) time.Duration {
+	var potCfg models.PotConfig
+	if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err == nil && potCfg.StopTimeout > 0 {
+		return potCfg.StopTimeout
+	}
+	return defaultStopTimeout
+}
+
+// terminateAll 同时结束一组已从运行表移除、路由已摘除的实例，全部退出后清理 cgroup
+func (s *SandboxManager) terminateAll(org, name string, insts []*Instance, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, inst := range insts {
+		if inst == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(inst *Instance) {
+			defer wg.Done()
+			s.terminate(inst, timeout)
+		}(inst)
+	}
+	wg.Wait()
+
+	// 脱离进程组的子孙进程仍在 cgroup 中；蓝绿发布时新旧实例共用 cgroup，沙箱仍有实例运行时不清理
+	s.mu.RLock()
+	_, shared := s.runningInstances[fmt.Sprintf("%s/%s", org, name)]
+	s.mu.RUnlock()
+	if !shared {
+		killCgroup(org, name)
+	}
+}
+
+// terminate 结束一个已从运行表移除、路由已摘除的实例：
+// 等待在途请求（最多 timeout），向进程组发送 SIGTERM，timeout 内未退出则 SIGKILL
+func (s *SandboxManager) terminate(inst *Instance, timeout time.Duration) {
+	key := fmt.Sprintf("%s/%s", inst.Org, inst.Name)
+	if inst.Cmd == nil {
+		return
+	}
+
+	if s.Router != nil && !s.Router.Drain(inst.Org, inst.Name, inst.Port, timeout) {
+		log.Printf("Sandbox %s: in-flight requests not drained after %v", key, timeout)
+	}
+
+	if err := inst.Cmd.Terminate(); err != nil {
+		log.Printf("Sandbox %s: SIGTERM failed: %v", key, err)
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-inst.done:
+	case <-t.C:
+		log.Printf("Sandbox %s did not exit within %v, killing", key, timeout)
+		inst.Cmd.Kill()
+		<-inst.done
+	}
+}
+
+func (s *SandboxManager) watchProcess(key string, inst *Instance) {
+	exitCode, status, err := inst.Cmd.wait()
+	log.Printf("Sandbox %s replica %d exited: %s %v", key, inst.Replica, status, err)
+	close(inst.done)
+	<-inst.placed // 启动期间退出的实例等调用方放入运行表后按崩溃处理
+
+	// 已被 Stop 或新实例替换（如 SignalUpdate 重启）时，不是崩溃
+	s.mu.Lock()
+	if !s.isCurrent(inst) {
+		s.mu.Unlock()
+		return
+	}
+	wasReady := inst.Ready // Ready 由探针在锁内修改，解锁后不能再读
+	insts := s.runningInstances[key]
+	insts[inst.Replica] = nil
+	running := false // 是否还有其他实例在运行
+	for _, other := range insts {
+		running = running || other != nil
+	}
+	if !running {
+		delete(s.runningInstances, key)
+	}
+
+	org, name := inst.Org, inst.Name
+	rc, _ := s.loadRunConfig(org, name)
+	if rc == nil || rc.TargetStatus != models.RunStatusRunning {
+		s.mu.Unlock()
+		return
+	}
+	// 目标状态仍为 running 说明不是 Stop 导致的退出
+
+	var potCfg models.PotConfig
+	_ = git.ReadPotYml(s.RepoRoot, org, name, &potCfg)
+	policy := restartPolicy(key, &potCfg)
+
+	now := time.Now()
+	// 长时间正常运行后再退出，不计入连续重启
+	if now.Sub(inst.started) >= backoffResetAfter {
+		rc.Restarts = 0
+	}
+
+	// 退出的实例从 runtime 中移除
+	s.recordRuntime(key, rc)
+	rc.LastExitCode = &exitCode
+	rc.LastExitTime = now.Format(time.RFC3339)
+	rc.NextRestart = ""
+
+	// 实例按重启策略单独重启（连续重启次数按沙箱累计），其他实例仍在运行时沙箱保持 running
+	var outcome models.RunState
+	var delay time.Duration
+	switch {
+	case !shouldRestart(policy, exitCode):
+		outcome = models.RunStateExited
+	case policy.MaxRetries > 0 && rc.Restarts >= policy.MaxRetries:
+		outcome = models.RunStateCrashLoop
+	default:
+		delay = backoffDelay(policy, rc.Restarts)
+		outcome = models.RunStateBackoff
+		rc.Restarts++
+		rc.NextRestart = now.Add(delay).Format(time.RFC3339)
+	}
+	rc.State = outcome
+	if running {
+		rc.State = models.RunStateRunning
+	}
+	s.saveRunConfig(org, name, rc)
+	s.mu.Unlock()
+
+	if wasReady {
+		// 实例已退出，从路由中摘除
+		s.refreshRoute(org, name)
+	}
+
+	data := map[string]interface{}{"exit_code": exitCode, "restarts": rc.Restarts, "replica": inst.Replica}
+	if status != "" {
+		data["status"] = status
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	action := "crashed"
+	if exitCode == 0 {
+		action = "exited"
+	}
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventSandbox,
+		Action:     action,
+		Repository: webhook.NewRepository(org, name),
+		Data:       data,
+	})
+
+	switch outcome {
+	case models.RunStateExited:
+		log.Printf("Sandbox %s replica %d exited with code %d, restart policy %s: not restarting", key, inst.Replica, exitCode, policy.Policy)
+		return
+	case models.RunStateCrashLoop:
+		log.Printf("Sandbox %s is crash looping (%d restarts), giving up", key, rc.Restarts)
+		webhook.Emit(&webhook.Payload{
+			Event:      webhook.EventSandbox,
+			Action:     "crashloop",
+			Repository: webhook.NewRepository(org, name),
+			Data:       data,
+		})
+		return
+	}
+
+	log.Printf("Auto-restarting %s replica %d in %v (restart #%d)...", key, inst.Replica, delay, rc.Restarts)
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-s.stopChan:
+		return
+	}
+
+	if err := s.restartReplica(org, name, inst.Replica); err != nil {
+		log.Printf("Failed to restart sandbox %s: %v", key, err)
+	}
+}
+
+// setReady 就绪探针状态变化：更新 run.yml 并刷新路由（就绪时加入负载均衡，否则摘除）
+func (s *SandboxManager) setReady(inst *Instance, ready bool, err error) {
+	key := fmt.Sprintf("%s/%s", inst.Org, inst.Name)
+	if ready {
+		// 蓝绿发布中尚未接管流量的新实例只通知 rollout
+		inst.markReady()
+	}
+
+	s.mu.Lock()
+	if !s.isCurrent(inst) {
+		s.mu.Unlock()
+		return
+	}
+	inst.Ready = ready
+	rc, _ := s.loadRunConfig(inst.Org, inst.Name)
+	if rc != nil {
+		s.recordRuntime(key, rc)
+		s.saveRunConfig(inst.Org, inst.Name, rc)
+	}
+	s.mu.Unlock()
+
+	if ready {
+		log.Printf("Sandbox %s replica %d is ready", key, inst.Replica)
+	} else {
+		log.Printf("Sandbox %s replica %d is not ready: %v", key, inst.Replica, err)
+	}
+	s.refreshRoute(inst.Org, inst.Name)
+}
+
+// restartUnhealthy 存活探针连续失败：结束进程，由 watchProcess 按崩溃处理并重启
+func (s *SandboxManager) restartUnhealthy(inst *Instance, err error) {
+	key := fmt.Sprintf("%s/%s", inst.Org, inst.Name)
+
+	s.mu.RLock()
+	current := s.isCurrent(inst)
+	s.mu.RUnlock()
+	if !current {
+		return
+	}
+
+	log.Printf("Sandbox %s replica %d failed liveness probe: %v, restarting", key, inst.Replica, err)
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventSandbox,
+		Action:     "unhealthy",
+		Repository: webhook.NewRepository(inst.Org, inst.Name),
+		Data:       map[string]interface{}{"probe": "liveness", "error": err.Error(), "replica": inst.Replica},
+	})
+	if inst.Cmd != nil {
+		inst.Cmd.Kill()
 	}
 }
 
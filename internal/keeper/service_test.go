@@ -0,0 +1,236 @@
+package keeper
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"potstack/config"
+	"potstack/internal/git"
+	"potstack/internal/models"
+	"potstack/internal/router"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain 在环境变量 POTSTACK_TEST_POT=serve 时作为 exe pot 运行：在 SU_SERVER_ADDR 上提供 HTTP 服务，
+// 响应 "{pid} {path}"，供需要真实流量的测试使用（pot.exe 为 exec 测试程序的脚本）
+func TestMain(m *testing.M) {
+	if os.Getenv("POTSTACK_TEST_POT") == "serve" {
+		err := http.ListenAndServe(os.Getenv("SU_SERVER_ADDR"), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprintf(w, "%d %s", os.Getpid(), req.URL.Path)
+		}))
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// servePot 返回运行测试程序 HTTP 服务的 pot.yml 片段与 pot.exe
+func servePot() (env, exe string) {
+	self, _ := os.Executable()
+	return "env:\n  - name: POTSTACK_TEST_POT\n    value: serve\n", fmt.Sprintf("#!/bin/sh\nexec '%s'\n", self)
+}
+
+// serveInternal 启动内部端口上的路由刷新与 /pot 转发（与 main.go 相同），返回 Router 与服务地址
+func serveInternal(t *testing.T, repoRoot string) (*router.Router, string) {
+	repoDir, internalPort := config.RepoDir, config.InternalPort
+	config.RepoDir = repoRoot
+	rt := router.NewRouter(repoRoot)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/pot/potstack/router/refresh", router.RefreshHandler(rt))
+	r.Any("/pot/:org/:name/*path", func(c *gin.Context) {
+		rt.ServeHTTP(c.Writer, c.Request)
+	})
+	srv := httptest.NewServer(r)
+	config.InternalPort = fmt.Sprint(srv.Listener.Addr().(*net.TCPAddr).Port)
+	t.Cleanup(func() {
+		srv.Close()
+		config.RepoDir, config.InternalPort = repoDir, internalPort
+	})
+	return rt, srv.URL
+}
+
+// dataFile 返回沙箱 data 目录下的文件路径
+func dataFile(s *SandboxManager, org, name, file string) string {
+	return filepath.Join(s.sandboxRoot(org, name), "data", file)
+}
+
+// waitFile 等待文件出现
+func waitFile(path string) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// alive 判断进程是否存在
+func alive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	return err == nil && proc.Signal(syscall.Signal(0)) == nil
+}
+
+// commitPot 把 files 提交到 repoRoot 下 org/name.git 的 main 分支（仓库不存在时创建），返回提交哈希
+func commitPot(t *testing.T, repoRoot, org, name string, files map[string]string) string {
+	bare := filepath.Join(repoRoot, org, name+".git")
+	if _, err := os.Stat(bare); os.IsNotExist(err) {
+		if _, err := git.InitBare(bare); err != nil {
+			t.Fatalf("init %s failed: %v", bare, err)
+		}
+	}
+	r, err := gogit.Open(filesystem.NewStorage(osfs.New(bare), cache.NewObjectLRUDefault()), osfs.New(t.TempDir()))
+	if err != nil {
+		t.Fatalf("open %s failed: %v", bare, err)
+	}
+	w, _ := r.Worktree()
+	if err := w.Reset(&gogit.ResetOptions{Mode: gogit.HardReset}); err != nil {
+		t.Fatalf("checkout %s failed: %v", bare, err)
+	}
+	for path, content := range files {
+		full := filepath.Join(w.Filesystem.Root(), path)
+		os.MkdirAll(filepath.Dir(full), 0755)
+		os.WriteFile(full, []byte(content), 0755)
+		w.Add(path)
+	}
+	hash, err := w.Commit("update", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("commit to %s failed: %v", bare, err)
+	}
+	return hash.String()
+}
+
+// potList 是固定的已安装 pot 列表
+type potList []PotURI
+
+func (l potList) GetInstalledPots() []PotURI { return l }
+
+// waitCalls 等待假 Engine 收到 n 次 call 请求
+func waitCalls(t *testing.T, calls func() string, call string, n int) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if strings.Count(calls(), call) >= n {
+			return
+		}
+	}
+	t.Fatalf("%q not received %d times", call, n)
+}
+
+func TestStartOutsideLock(t *testing.T) {
+	engine := useFakeDocker(t)
+	root := t.TempDir()
+	s := NewManager(root, nil)
+	potYml := "type: exe\ndocker: nginx\nstop_timeout: 1s\n"
+	commitPot(t, root, "ann", "web", map[string]string{"pot.yml": potYml})
+	commitPot(t, root, "ann", "api", map[string]string{"pot.yml": potYml})
+	s.SetPotProvider(potList(pots("ann/web", "ann/api")))
+	defer s.Shutdown()
+
+	// 1. 容器创建较慢时，其他沙箱的查询与启动不被阻塞，同一沙箱不能重复启动
+	release := engine.HoldCreate()
+	defer release()
+	started := make(chan error, 1)
+	go func() { started <- s.Start("ann", "web") }()
+	waitCalls(t, engine.Calls, "POST /containers/create?name=potstack-ann-web\n", 1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		st, err := s.Status("ann", "api")
+		assert.NoError(t, err)
+		assert.Equal(t, models.RunStateStopped, st.State)
+		list, err := s.List()
+		assert.NoError(t, err)
+		assert.Len(t, list, 2)
+		assert.ErrorIs(t, s.Start("ann", "web"), ErrAlreadyRunning)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("manager blocked while a container is being created")
+	}
+	release()
+	if !assert.NoError(t, <-started) {
+		return
+	}
+	st, _ := s.Status("ann", "web")
+	assert.Equal(t, models.RunStateRunning, st.State)
+	assert.Equal(t, 4242, st.Pid)
+	assert.NoError(t, s.Stop("ann", "web"))
+
+	// 2. 启动期间停止时放弃启动，已创建的容器被删除
+	release = engine.HoldCreate()
+	go func() { started <- s.Start("ann", "web") }()
+	waitCalls(t, engine.Calls, "POST /containers/create?name=potstack-ann-web\n", 2)
+	assert.NoError(t, s.Stop("ann", "web"))
+	release()
+	assert.EqualError(t, <-started, "sandbox ann/web was stopped while starting")
+	st, _ = s.Status("ann", "web")
+	assert.Equal(t, models.RunStateStopped, st.State)
+	assert.Zero(t, st.Pid)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && engine.Container("potstack-ann-web") != nil; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, engine.Container("potstack-ann-web"))
+}
+
+func TestGracefulStop(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pot.exe 是 shell 脚本")
+	}
+	root := t.TempDir()
+	s := NewManager(root, nil)
+	defer s.Shutdown()
+
+	// 1. SIGTERM 让 pot 有机会在 DATA_PATH 中保存状态后退出
+	commitPot(t, root, "ann", "polite", map[string]string{
+		"pot.yml": "type: exe\n",
+		"pot.exe": "#!/bin/sh\ntrap 'echo saved > \"$DATA_PATH/state\"; exit 0' TERM\ntouch \"$DATA_PATH/up\"\nwhile true; do sleep 0.05; done\n",
+	})
+	assert.NoError(t, s.Redeploy("ann", "polite"))
+	if !waitFile(dataFile(s, "ann", "polite", "up")) {
+		t.Fatal("pot did not start")
+	}
+	start := time.Now()
+	assert.NoError(t, s.Stop("ann", "polite"))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	state, err := os.ReadFile(dataFile(s, "ann", "polite", "state"))
+	assert.NoError(t, err)
+	assert.Equal(t, "saved\n", string(state))
+
+	// 2. 忽略 SIGTERM 的 pot 在 stop_timeout 后被 SIGKILL（整个进程组）
+	commitPot(t, root, "ann", "stubborn", map[string]string{
+		"pot.yml": "type: exe\nstop_timeout: 300ms\n",
+		"pot.exe": "#!/bin/sh\ntrap '' TERM\ntouch \"$DATA_PATH/up\"\nwhile true; do sleep 0.05; done\n",
+	})
+	assert.NoError(t, s.Redeploy("ann", "stubborn"))
+	if !waitFile(dataFile(s, "ann", "stubborn", "up")) {
+		t.Fatal("pot did not start")
+	}
+	st, _ := s.Status("ann", "stubborn")
+	start = time.Now()
+	assert.NoError(t, s.Stop("ann", "stubborn"))
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 300*time.Millisecond)
+	assert.Less(t, elapsed, 5*time.Second)
+	assert.False(t, alive(st.Pid), "process should be gone")
+	st, _ = s.Status("ann", "stubborn")
+	assert.Equal(t, models.RunStateStopped, st.State)
+}
@@ -0,0 +1,151 @@
+package keeper
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"potstack/internal/git"
+	"potstack/internal/models"
+
+	gitlib "github.com/go-git/go-git/v5"
+)
+
+// SandboxStatus 是一个 sandbox 的当前状态（来自 run.yml 与运行中的实例）
+type SandboxStatus struct {
+	Owner        string           `json:"owner"`
+	Name         string           `json:"name"`
+	Type         string           `json:"type"`
+	TargetStatus models.RunStatus `json:"target_status,omitempty"`
+	State        models.RunState  `json:"state"`
+	Pid          int              `json:"pid,omitempty"`
+	Port         int              `json:"port,omitempty"`
+	Ready        bool             `json:"ready"`
+	StartTime    string           `json:"start_time,omitempty"`
+	Uptime       int64            `json:"uptime,omitempty"`    // 秒
+	Commit       string           `json:"commit,omitempty"`    // 正在运行的代码版本
+	Release      string           `json:"release,omitempty"`   // 代码目录 data/faaspot/releases/{release}
+	Container    string           `json:"container,omitempty"` // Docker pot 的容器名
+	Restarts     int              `json:"restarts"`
+	LastExitCode *int             `json:"last_exit_code,omitempty"`
+	LastExitTime string           `json:"last_exit_time,omitempty"`
+	NextRestart  string           `json:"next_restart,omitempty"`
+	Resources    *ResourceUsage   `json:"resources,omitempty"` // cgroup 资源使用（仅 Linux）
+	Replicas     []ReplicaStatus  `json:"replicas,omitempty"`  // 运行中的实例，pid / port 为第一个实例
+	Schedule     string           `json:"schedule,omitempty"`  // job：pot.yml 中的 schedule
+	NextRun      string           `json:"next_run,omitempty"`  // job：下一次定时执行的时间
+	LastRun      *JobRun          `json:"last_run,omitempty"`  // job：最近一次执行（可能仍在执行）
+}
+
+// ReplicaStatus 是一个运行中实例的状态
+type ReplicaStatus struct {
+	Replica   int    `json:"replica"`
+	Pid       int    `json:"pid"`
+	Port      int    `json:"port"`
+	Ready     bool   `json:"ready"`
+	StartTime string `json:"start_time"`
+	Container string `json:"container,omitempty"`
+}
+
+// Status 返回 sandbox 状态，仓库没有 pot.yml 时返回 nil, nil
+func (s *SandboxManager) Status(org, name string) (*SandboxStatus, error) {
+	var potCfg models.PotConfig
+	if err := git.ReadPotYml(s.RepoRoot, org, name, &potCfg); err != nil {
+		return nil, nil
+	}
+
+	bareRepoPath := filepath.Join(s.RepoRoot, org, fmt.Sprintf("%s.git", name))
+	st := &SandboxStatus{Owner: org, Name: name, Type: potCfg.Type}
+	if potCfg.Type == "job" {
+		s.jobStatus(st, &potCfg)
+		return st, nil
+	}
+	if potCfg.Type != "exe" {
+		// static 类型没有进程，由路由直接从 Git 提供服务
+		st.State = models.RunStateRunning
+		st.Ready = true
+		st.Commit = headCommit(bareRepoPath)
+		return st, nil
+	}
+	if potCfg.Docker != "" {
+		st.Container = containerName(org, name, 0)
+	}
+
+	// run.yml 由持有 s.mu 的一方重写（如就绪探针），锁外读取可能读到写了一半的文件
+	s.mu.RLock()
+	rc, err := s.loadRunConfig(org, name)
+	s.mu.RUnlock()
+	if err != nil {
+		// 尚未启动过
+		st.State = models.RunStateStopped
+		return st, nil
+	}
+	st.Release = rc.Release
+	st.Commit = headCommit(s.programDir(org, name, rc.Release))
+	st.TargetStatus = rc.TargetStatus
+	st.State = rc.State
+	st.Restarts = rc.Restarts
+	st.LastExitCode = rc.LastExitCode
+	st.LastExitTime = rc.LastExitTime
+	st.NextRestart = rc.NextRestart
+
+	s.mu.RLock()
+	insts, running := s.runningInstances[fmt.Sprintf("%s/%s", org, name)]
+	for _, inst := range insts {
+		if inst == nil {
+			continue
+		}
+		rs := ReplicaStatus{
+			Replica:   inst.Replica,
+			Pid:       inst.Cmd.pid(),
+			Port:      inst.Port,
+			Ready:     inst.Ready,
+			StartTime: inst.StartTime,
+		}
+		if potCfg.Docker != "" {
+			rs.Container = containerName(org, name, inst.Replica)
+		}
+		if st.Pid == 0 {
+			st.Pid, st.Port, st.StartTime = rs.Pid, rs.Port, rs.StartTime
+		}
+		st.Ready = st.Ready || rs.Ready
+		st.Replicas = append(st.Replicas, rs)
+	}
+	s.mu.RUnlock()
+	if !running && (rc.State == "" || rc.State == models.RunStateRunning) {
+		// 其他进程（或旧版本）写入的 run.yml，以记录的 pid 为准
+		st.Pid = rc.Runtime.Pid
+		st.Port = rc.Runtime.Port
+		st.Ready = rc.Runtime.Ready
+		st.StartTime = rc.Runtime.StartTime
+		if st.State == "" {
+			st.State = models.RunStateStopped
+			if rc.TargetStatus == models.RunStatusRunning {
+				st.State = models.RunStateRunning
+			}
+		}
+	}
+	if st.Pid != 0 {
+		if started, err := time.Parse(time.RFC3339, st.StartTime); err == nil {
+			st.Uptime = int64(time.Since(started).Seconds())
+		}
+		if potCfg.Docker == "" {
+			// 容器的 cgroup 由 Docker 管理
+			st.Resources = readCgroupUsage(org, name)
+		}
+	}
+	return st, nil
+}
+
+// headCommit 返回仓库 HEAD 指向的提交，失败时返回空字符串
+func headCommit(path string) string {
+	repo, err := gitlib.PlainOpen(path)
+	if err != nil {
+		return ""
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+	return head.Hash().String()
+}
@@ -64,6 +64,18 @@ func (m *MockUserService) SetUserPublicKey(ctx context.Context, username, public
 	return service.ErrUserNotFound
 }
 
+func (m *MockUserService) AddSSHKey(ctx context.Context, username, title, content string) (*db.SSHKey, error) {
+	return nil, nil
+}
+
+func (m *MockUserService) ListSSHKeys(ctx context.Context, username string) ([]*db.SSHKey, error) {
+	return nil, nil
+}
+
+func (m *MockUserService) DeleteSSHKey(ctx context.Context, username string, id int64) error {
+	return nil
+}
+
 // MockRepoService 模拟仓库服务 (我们主要测试 Key Pinning，仓库部分可以简化)
 type MockRepoService struct{}
 
@@ -91,6 +103,9 @@ func (m *MockRepoService) IsCollaborator(ctx context.Context, owner, repo, usern
 func (m *MockRepoService) ListCollaborators(ctx context.Context, owner, repo string) ([]*db.CollaboratorResponse, error) {
 	return nil, nil
 }
+func (m *MockRepoService) GetPermission(ctx context.Context, owner, repo, username string) (string, error) {
+	return "admin", nil
+}
 
 // Helper: 生成测试用的 PPK 文件
 func generateTestPPK(t *testing.T, owner string, pub ed25519.PublicKey, priv ed25519.PrivateKey) string {
@@ -1,22 +1,162 @@
 package models
 
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 // PotConfig represents the structure of pot.yml
 type PotConfig struct {
-	Title   string   `yaml:"title"`
-	Version string   `yaml:"version"`
-	Owner   string   `yaml:"owner"`
-	PotName string   `yaml:"potname"`
-	Type    string   `yaml:"type"`             // "exe" or "static"
-	Root    string   `yaml:"root,omitempty"`   // static 类型专用
-	Env     []EnvVar `yaml:"env,omitempty"`    // exe 类型专用
-	Docker  string   `yaml:"docker,omitempty"` // 远程 Docker 镜像地址
+	Title     string   `yaml:"title"`
+	Version   string   `yaml:"version"`
+	Owner     string   `yaml:"owner"`
+	PotName   string   `yaml:"potname"`
+	Type      string   `yaml:"type"`                // "exe", "static" or "job"
+	Root      string   `yaml:"root,omitempty"`      // static 类型专用
+	Env       []EnvVar `yaml:"env,omitempty"`       // exe / job 类型专用
+	Docker    string   `yaml:"docker,omitempty"`    // 远程 Docker 镜像地址
+	Liveness  *Probe   `yaml:"liveness,omitempty"`  // exe 类型专用，连续失败后重启进程
+	Readiness *Probe   `yaml:"readiness,omitempty"` // exe 类型专用，就绪后才注册路由
+	Restart   *Restart `yaml:"restart,omitempty"`   // exe 类型专用，进程退出后的重启策略
+
+	// exe 类型专用，停止时等待在途请求与进程退出（SIGTERM 后）的最长时间，默认 10s
+	StopTimeout time.Duration `yaml:"stop_timeout,omitempty"`
+	// exe 类型专用，重新部署时等待新实例就绪的最长时间，超时则回滚，默认 60s
+	DeployTimeout time.Duration `yaml:"deploy_timeout,omitempty"`
+	// exe 类型专用，超过该时长没有请求时停止进程（state: idle），收到请求时再启动，0 表示一直运行
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty"`
+
+	// exe 类型专用，启动前需要先启动（或就绪）的其他 pot，Keeper 按依赖顺序启动、逆序停止
+	DependsOn []Dependency `yaml:"depends_on,omitempty"`
+
+	Replicas    int    `yaml:"replicas,omitempty"`     // exe 类型专用，同时运行的实例数，默认 1
+	LoadBalance string `yaml:"load_balance,omitempty"` // exe 类型专用，多个实例间的负载均衡策略，默认 round_robin
+
+	// job 类型专用，定时执行的 cron 表达式（分 时 日 月 周）、@daily 等或 @every 10m，为空时只能手动执行
+	Schedule string `yaml:"schedule,omitempty"`
+	// job 类型专用，单次执行的最长时间，超时后结束进程（SIGTERM，stop_timeout 后 SIGKILL），0 表示不限
+	JobTimeout time.Duration `yaml:"job_timeout,omitempty"`
+
+	Resources *Resources `yaml:"resources,omitempty"` // exe / job 类型专用，资源限制（Linux cgroup v2）
+	Isolation *Isolation `yaml:"isolation,omitempty"` // exe / job 类型专用，命名空间隔离（Linux）
+}
+
+// Dependency is another pot listed in depends_on
+//
+//	depends_on:
+//	  - potstack/auth     # 已启动即可
+//	  - pot: acme/db
+//	    ready: true       # 等待就绪探针通过
+type Dependency struct {
+	Pot   string `yaml:"pot"` // org/name
+	Ready bool   `yaml:"ready,omitempty"`
+}
+
+// UnmarshalYAML 支持只写 org/name 的简写
+func (d *Dependency) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*d = Dependency{}
+		return value.Decode(&d.Pot)
+	}
+	type plain Dependency
+	return value.Decode((*plain)(d))
+}
+
+// Load balancing strategies
+const (
+	LoadBalanceRoundRobin = "round_robin" // 依次轮流（默认）
+	LoadBalanceLeastConn  = "least_conn"  // 在途请求最少的实例
+)
+
+// Isolation network modes
+const (
+	NetworkHost = "host" // 共享宿主机网络（默认，路由需要）
+	NetworkNone = "none" // 独立的网络命名空间，只有回环接口
+)
+
+// Isolation runs an exe pot in its own user, mount and pid namespaces
+//
+//	isolation:
+//	  enabled: true
+//	  network: none   # host（默认）/ none
+type Isolation struct {
+	Enabled bool   `yaml:"enabled"`
+	Network string `yaml:"network,omitempty"`
+}
+
+// Resources defines cgroup v2 limits for an exe pot, unset fields are unlimited
+//
+//	resources:
+//	  memory: 512M      # memory.max，支持 K/M/G/T 后缀（1024 进制）
+//	  cpus: 1.5         # cpu.max，最多使用 1.5 个核
+//	  cpu_weight: 100   # cpu.weight，1-10000
+//	  pids: 256         # pids.max
+//	  io_weight: 100    # io.weight，1-10000
+type Resources struct {
+	Memory    string  `yaml:"memory,omitempty"`
+	CPUs      float64 `yaml:"cpus,omitempty"`
+	CPUWeight int     `yaml:"cpu_weight,omitempty"`
+	Pids      int     `yaml:"pids,omitempty"`
+	IOWeight  int     `yaml:"io_weight,omitempty"`
+}
+
+// Restart policies
+const (
+	RestartAlways    = "always"     // 任何退出都重启（默认）
+	RestartOnFailure = "on-failure" // 仅非 0 退出（含被信号终止）时重启
+	RestartNever     = "never"      // 从不自动重启
+)
+
+// Restart defines how the keeper restarts an exited pot
+//
+//	restart:
+//	  policy: on-failure
+//	  max_retries: 5      # 连续重启次数上限，超过后进入 crashloop，0 表示不限
+//	  backoff: 1s         # 首次重启前的等待时间，之后每次翻倍
+//	  max_backoff: 5m
+type Restart struct {
+	Policy     string        `yaml:"policy,omitempty"`
+	MaxRetries int           `yaml:"max_retries,omitempty"`
+	Backoff    time.Duration `yaml:"backoff,omitempty"`     // 默认 1s
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"` // 默认 5m
+}
+
+// Probe types
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+	ProbeExec = "exec"
+)
+
+// Probe defines a liveness or readiness check
+//
+//	readiness:
+//	  type: http          # http / tcp / exec
+//	  path: /healthz      # http：返回 2xx/3xx 视为成功
+//	  interval: 5s
+//	liveness:
+//	  type: exec
+//	  command: ["./check.sh"]   # exec：在 program 目录执行，退出码 0 视为成功
+type Probe struct {
+	Type             string        `yaml:"type"`
+	Path             string        `yaml:"path,omitempty"`              // http
+	Port             int           `yaml:"port,omitempty"`              // http / tcp，默认为 pot 的监听端口
+	Command          []string      `yaml:"command,omitempty"`           // exec
+	InitialDelay     time.Duration `yaml:"initial_delay,omitempty"`     // 进程启动后首次检查前的等待时间
+	Interval         time.Duration `yaml:"interval,omitempty"`          // 默认 10s
+	Timeout          time.Duration `yaml:"timeout,omitempty"`           // 默认 1s
+	FailureThreshold int           `yaml:"failure_threshold,omitempty"` // 连续失败多少次视为失败，默认 3
+	SuccessThreshold int           `yaml:"success_threshold,omitempty"` // 连续成功多少次视为成功，默认 1
 }
 
 // EnvVar definition
+// value 为 secret:NAME 时启动前从密钥库读取
 type EnvVar struct {
 	Name  string `yaml:"name"`
 	Value string `yaml:"value"`
 	Tips  string `yaml:"tips,omitempty"`
+	File  bool   `yaml:"file,omitempty"` // 值写入 0600 文件，环境变量 {name}_FILE 为文件路径（exe / job 类型）
 }
 
 // RunStatus defines the desired state of a sandbox
@@ -27,12 +167,46 @@ const (
 	RunStatusStopped RunStatus = "stopped"
 )
 
+// RunState is the observed state of a sandbox process
+type RunState string
+
+const (
+	RunStateRunning   RunState = "running"   // 进程运行中
+	RunStateBackoff   RunState = "backoff"   // 进程已退出，等待重启
+	RunStateCrashLoop RunState = "crashloop" // 连续重启次数超过上限，不再自动重启
+	RunStateExited    RunState = "exited"    // 进程已退出，重启策略不要求重启
+	RunStateStopped   RunState = "stopped"   // 已手动停止
+	RunStateIdle      RunState = "idle"      // 空闲超时后停止，收到请求时自动启动
+	RunStateWaiting   RunState = "waiting"   // 等待 depends_on 中的 pot 启动或就绪
+)
+
 // RunConfig represents the runtime state in run.yml
 type RunConfig struct {
 	TargetStatus RunStatus `yaml:"target_status"`
+	State        RunState  `yaml:"state,omitempty"`
+	Release      string    `yaml:"release,omitempty"` // 当前代码版本：data/faaspot/releases/{release}，为空时为旧版的 program/
 	Runtime      struct {
+		// 第一个运行中的实例（兼容旧版本），Ready 为任一实例就绪
 		Pid       int    `yaml:"pid"`
 		Port      int    `yaml:"port"`
 		StartTime string `yaml:"start_time"`
+		Ready     bool   `yaml:"ready"` // 就绪探针通过（未配置时启动即就绪），路由只指向就绪的进程
+
+		Replicas []ReplicaRuntime `yaml:"replicas,omitempty"` // 所有运行中的实例，路由在其中就绪的实例间负载均衡
 	} `yaml:"runtime"`
+
+	// 崩溃与重启记录（手动停止时 Restarts 清零）
+	Restarts     int    `yaml:"restarts,omitempty"`       // 连续自动重启次数
+	LastExitCode *int   `yaml:"last_exit_code,omitempty"` // 最近一次退出码，被信号终止时为 -1
+	LastExitTime string `yaml:"last_exit_time,omitempty"`
+	NextRestart  string `yaml:"next_restart,omitempty"` // backoff 状态下的计划重启时间
+}
+
+// ReplicaRuntime is one running instance of a sandbox in run.yml
+type ReplicaRuntime struct {
+	Replica   int    `yaml:"replica"` // 实例序号，从 0 开始
+	Pid       int    `yaml:"pid"`
+	Port      int    `yaml:"port"`
+	StartTime string `yaml:"start_time"`
+	Ready     bool   `yaml:"ready"`
 }
@@ -39,10 +39,13 @@ func RefreshHandler(dynamicRouter *Router) gin.HandlerFunc {
 			}
 		} else if potCfg.Type == "exe" {
 			// Exe 类型需要检查运行状态
-			if err := dynamicRouter.RegisterExe(req.Org, req.Name); err != nil {
+			if err := dynamicRouter.RegisterExe(req.Org, req.Name, &potCfg); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+		} else if potCfg.Type == "job" {
+			// Job 类型没有路由（由 exe 改为 job 时清理原有路由）
+			dynamicRouter.RemoveRoutes(req.Org, req.Name)
 		} else {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported pot type"})
 			return
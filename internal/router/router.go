@@ -10,8 +10,11 @@ import (
 	"path/filepath"
 	"potstack/internal/models"
 	"potstack/internal/resource"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -20,47 +23,151 @@ import (
 type Router struct {
 	RepoRoot string
 
-	// pathRoutes: "/pot/org/name" -> Handler
-	pathRoutes map[string]http.Handler
+	// pathRoutes: "/pot/org/name" -> route
+	pathRoutes map[string]*route
 
 	// Track which sandbox owns which routes
 	// Key: org/name -> []string (e.g. "PATH:/pot/org/name")
 	sandboxRoutes map[string][]string
 
+	// 每个后端正在处理的请求数，用于 Drain 与 least_conn。Key: org/name（static）或 org/name:port（exe）
+	// 同一后端重新注册后保留；蓝绿发布时新旧实例端口不同，可以单独等待旧实例的在途请求
+	active map[string]*atomic.Int64
+
+	// 沙箱当前路由指向的后端（Key: org/name），exe 的每个就绪实例是一个后端
+	backends map[string][]string
+
+	// 沙箱最近一次请求的时间（UnixNano，Key: org/name），Keeper 据此判断空闲
+	lastRequest map[string]*atomic.Int64
+
+	// 启动空闲停止的 exe 沙箱（由 Keeper 设置）
+	activator Activator
+
 	mu sync.RWMutex
 }
 
+// Activator 启动空闲停止（state: idle）的 exe 沙箱并等待就绪，返回后路由应已指向就绪的实例
+type Activator func(org, name string) error
+
+// route 是一个已注册的路由前缀
+type route struct {
+	pool  *pool
+	strip func(http.Handler) http.Handler // 去掉路径前缀
+}
+
+// backend 是路由转发的目标：static 的 Git 文件服务或 exe 的一个实例
+type backend struct {
+	key     string
+	handler http.Handler
+	active  *atomic.Int64 // 在途请求计数
+}
+
+// pool 是沙箱的一组后端，同一沙箱的四个路由前缀共用
+type pool struct {
+	backends    []*backend
+	strategy    string // models.LoadBalance*
+	next        atomic.Uint64
+	lastRequest *atomic.Int64
+
+	activate func() error // 空闲停止的沙箱没有后端，收到请求时先启动
+}
+
+// touch 记录请求时间
+func (p *pool) touch() {
+	p.lastRequest.Store(time.Now().UnixNano())
+}
+
+// pick 按负载均衡策略选择后端：round_robin 依次轮流，least_conn 选在途请求最少的（相同时轮流）
+func (p *pool) pick() *backend {
+	n := uint64(len(p.backends))
+	if n == 1 {
+		return p.backends[0]
+	}
+	start := p.next.Add(1) - 1
+	best := p.backends[start%n]
+	if p.strategy == models.LoadBalanceLeastConn {
+		for i := uint64(1); i < n; i++ {
+			if b := p.backends[(start+i)%n]; b.active.Load() < best.active.Load() {
+				best = b
+			}
+		}
+	}
+	return best
+}
+
 func NewRouter(repoRoot string) *Router {
 	return &Router{
 		RepoRoot:      repoRoot,
-		pathRoutes:    make(map[string]http.Handler),
+		pathRoutes:    make(map[string]*route),
 		sandboxRoutes: make(map[string][]string),
+		active:        make(map[string]*atomic.Int64),
+		backends:      make(map[string][]string),
+		lastRequest:   make(map[string]*atomic.Int64),
 	}
 }
 
+// SetActivator 设置空闲停止的沙箱收到请求时的启动方法，未设置时这些沙箱没有路由
+func (r *Router) SetActivator(a Activator) {
+	r.mu.Lock()
+	r.activator = a
+	r.mu.Unlock()
+}
+
 // ServeHTTP implements http.Handler with longest prefix matching
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.serve(w, req, true)
+}
+
+// serve 匹配路由并转发；匹配到空闲停止的沙箱时，activate 为 true 则启动沙箱后重新匹配一次
+func (r *Router) serve(w http.ResponseWriter, req *http.Request, activate bool) {
 	r.mu.RLock()
-	defer r.mu.RUnlock()
 
 	log.Printf("[Router] ServeHTTP: path=%s, registered routes count=%d", req.URL.Path, len(r.pathRoutes))
 
 	// Find longest matching prefix
 	var bestMatch string
-	var bestHandler http.Handler
+	var best *route
 
-	for prefix, handler := range r.pathRoutes {
+	for prefix, rt := range r.pathRoutes {
 		if strings.HasPrefix(req.URL.Path, prefix) {
 			if len(prefix) > len(bestMatch) {
 				bestMatch = prefix
-				bestHandler = handler
+				best = rt
 			}
 		}
 	}
 
-	if bestHandler != nil {
-		log.Printf("[Router] Matched prefix: %s", bestMatch)
-		bestHandler.ServeHTTP(w, req)
+	// 持锁选择后端并计数，保证 Drain 在摘除路由后不会漏掉已匹配的请求；转发时不持锁
+	var b *backend
+	if best != nil && best.pool.activate == nil {
+		b = best.pool.pick()
+		b.active.Add(1)
+	}
+	r.mu.RUnlock()
+
+	if b != nil {
+		defer b.active.Add(-1)
+		best.pool.touch()
+		defer best.pool.touch()
+		log.Printf("[Router] Matched prefix: %s -> %s", bestMatch, b.key)
+		best.strip(b.handler).ServeHTTP(w, req)
+		return
+	}
+
+	if best != nil {
+		// 空闲停止的沙箱：保持请求，启动并等待就绪后转发
+		if !activate {
+			http.Error(w, "sandbox is not ready", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("[Router] Matched prefix: %s, activating idle sandbox", bestMatch)
+		best.pool.touch()
+		if err := best.pool.activate(); err != nil {
+			log.Printf("[Router] Failed to activate sandbox for %s: %v", bestMatch, err)
+			http.Error(w, "sandbox failed to start", http.StatusServiceUnavailable)
+			return
+		}
+		r.serve(w, req, false)
 		return
 	}
 
@@ -80,12 +187,14 @@ func (r *Router) RegisterStatic(org, name string, potCfg *models.PotConfig) erro
 	handler := resource.NewStaticHandler(r.RepoRoot, org, name, potCfg.Root)
 
 	// 3. 注册三个路由
-	r.registerThreeRoutesInternal(org, name, handler)
+	p := &pool{backends: []*backend{r.backendInternal(fmt.Sprintf("%s/%s", org, name), handler)}}
+	r.registerThreeRoutesInternal(org, name, p)
 	return nil
 }
 
-// RegisterExe 注册 exe 类型路由（需要读取 run.yml）
-func (r *Router) RegisterExe(org, name string) error {
+// RegisterExe 注册 exe 类型路由（需要读取 run.yml，进程就绪后才注册）
+// 有多个就绪实例时按 pot.yml 的 load_balance 在实例间分发请求
+func (r *Router) RegisterExe(org, name string, potCfg *models.PotConfig) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -106,48 +215,123 @@ func (r *Router) RegisterExe(org, name string) error {
 		return err
 	}
 
-	if rc.Runtime.Port == 0 {
-		return fmt.Errorf("no port assigned")
+	// 未运行或未就绪的进程不接收流量（旧路由已在上面清理）
+	ports, err := readyPorts(&rc)
+	if err != nil {
+		return err
+	}
+
+	// 配置了 idle_timeout 的沙箱空闲停止或正在启动时注册启动入口：请求等待沙箱启动并就绪后转发
+	if len(ports) == 0 && potCfg.IdleTimeout > 0 && r.activator != nil && rc.TargetStatus == models.RunStatusRunning &&
+		(rc.State == models.RunStateIdle || rc.State == models.RunStateRunning) {
+		activator := r.activator
+		r.registerThreeRoutesInternal(org, name, &pool{
+			activate: func() error { return activator(org, name) },
+		})
+		log.Printf("[Router] %s/%s is %s, requests will wait for it to start", org, name, rc.State)
+		return nil
 	}
 
-	// 3. 创建 Reverse Proxy Handler
-	target, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", rc.Runtime.Port))
-	handler := httputil.NewSingleHostReverseProxy(target)
+	if rc.TargetStatus != models.RunStatusRunning || len(ports) == 0 {
+		log.Printf("[Router] %s/%s is not ready, routes removed", org, name)
+		return nil
+	}
 
-	// 4. 注册三个路由
-	r.registerThreeRoutesInternal(org, name, handler)
+	// 3. 每个就绪实例创建一个 Reverse Proxy Handler
+	p := &pool{strategy: potCfg.LoadBalance}
+	for _, port := range ports {
+		target, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", port))
+		handler := httputil.NewSingleHostReverseProxy(target)
+		p.backends = append(p.backends, r.backendInternal(exeBackend(org, name, port), handler))
+	}
+
+	// 4. 注册三个路由（清理与注册在同一把锁内，切换到新端口对请求是原子的）
+	r.registerThreeRoutesInternal(org, name, p)
 	return nil
 }
 
+// readyPorts 返回 run.yml 中就绪实例的端口；旧版本的 run.yml 没有 replicas，只有一个实例
+func readyPorts(rc *models.RunConfig) ([]int, error) {
+	if len(rc.Runtime.Replicas) == 0 {
+		if !rc.Runtime.Ready {
+			return nil, nil
+		}
+		if rc.Runtime.Port == 0 {
+			return nil, fmt.Errorf("no port assigned")
+		}
+		return []int{rc.Runtime.Port}, nil
+	}
+
+	var ports []int
+	for _, rep := range rc.Runtime.Replicas {
+		if rep.Ready && rep.Port != 0 {
+			ports = append(ports, rep.Port)
+		}
+	}
+	return ports, nil
+}
+
+// exeBackend 返回 exe 沙箱后端的在途请求计数 Key
+func exeBackend(org, name string, port int) string {
+	return fmt.Sprintf("%s/%s:%d", org, name, port)
+}
+
+// backendInternal 创建后端，沿用同一 Key 已有的在途请求计数
+func (r *Router) backendInternal(key string, handler http.Handler) *backend {
+	active, ok := r.active[key]
+	if !ok {
+		active = new(atomic.Int64)
+		r.active[key] = active
+	}
+	return &backend{key: key, handler: handler, active: active}
+}
+
 // registerThreeRoutesInternal 注册 /pot、/api、/web、/admin 四个前缀路由
-func (r *Router) registerThreeRoutesInternal(org, name string, handler http.Handler) {
+func (r *Router) registerThreeRoutesInternal(org, name string, p *pool) {
 	var registeredKeys []string
 
+	key := fmt.Sprintf("%s/%s", org, name)
+	last, ok := r.lastRequest[key]
+	if !ok {
+		last = new(atomic.Int64)
+		r.lastRequest[key] = last
+	}
+	p.lastRequest = last
+	r.backends[key] = nil
+	for _, b := range p.backends {
+		r.backends[key] = append(r.backends[key], b.key)
+	}
+	add := func(prefix string, strip func(http.Handler) http.Handler) {
+		r.pathRoutes[prefix] = &route{pool: p, strip: strip}
+	}
+
 	// 1. /pot/{org}/{name}/* -> 去掉 /pot/{org}/{name}
 	potPrefix := fmt.Sprintf("/pot/%s/%s", org, name)
-	r.pathRoutes[potPrefix] = stripPrefixHandler(potPrefix, handler)
+	add(potPrefix, func(h http.Handler) http.Handler { return stripPrefixHandler(potPrefix, h) })
 	registeredKeys = append(registeredKeys, "PATH:"+potPrefix)
 	log.Printf("[Router] Registered route: %s", potPrefix)
 
+	stripOrgName := func(h http.Handler) http.Handler { return stripOrgNameHandler(org, name, h) }
+
 	// 2. /api/{org}/{name}/* -> 去掉 /{org}/{name}
 	apiPrefix := fmt.Sprintf("/api/%s/%s", org, name)
-	r.pathRoutes[apiPrefix] = stripOrgNameHandler(org, name, handler)
+	add(apiPrefix, stripOrgName)
 	registeredKeys = append(registeredKeys, "PATH:"+apiPrefix)
 	log.Printf("[Router] Registered route: %s", apiPrefix)
 
 	// 3. /web/{org}/{name}/* -> 去掉 /{org}/{name}
 	webPrefix := fmt.Sprintf("/web/%s/%s", org, name)
-	r.pathRoutes[webPrefix] = stripOrgNameHandler(org, name, handler)
+	add(webPrefix, stripOrgName)
 	registeredKeys = append(registeredKeys, "PATH:"+webPrefix)
 	log.Printf("[Router] Registered route: %s", webPrefix)
 
 	// 4. /admin/{org}/{name}/* -> 去掉 /{org}/{name}
 	adminPrefix := fmt.Sprintf("/admin/%s/%s", org, name)
-	r.pathRoutes[adminPrefix] = stripOrgNameHandler(org, name, handler)
+	add(adminPrefix, stripOrgName)
 	registeredKeys = append(registeredKeys, "PATH:"+adminPrefix)
 	log.Printf("[Router] Registered route: %s", adminPrefix)
 
-	r.sandboxRoutes[fmt.Sprintf("%s/%s", org, name)] = registeredKeys
+	r.sandboxRoutes[key] = registeredKeys
 }
 
 // stripPrefixHandler removes the entire prefix from the path
@@ -189,6 +373,52 @@ func (r *Router) RemoveRoutes(org, name string) {
 	r.removeRoutesInternal(org, name)
 }
 
+// IdleSince 返回沙箱最近一次请求（开始或结束）的时间，没有请求过时为零值；有在途请求时 ok 为 false
+func (r *Router) IdleSince(org, name string) (last time.Time, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key := fmt.Sprintf("%s/%s", org, name)
+	for _, b := range r.backends[key] {
+		if active := r.active[b]; active != nil && active.Load() > 0 {
+			return time.Time{}, false
+		}
+	}
+	if t := r.lastRequest[key]; t != nil && t.Load() != 0 {
+		last = time.Unix(0, t.Load())
+	}
+	return last, true
+}
+
+// Drain 等待转发到 exe 沙箱端口 port 的在途请求处理完毕（应先摘除路由或切换到新端口），超时返回 false
+func (r *Router) Drain(org, name string, port int, timeout time.Duration) bool {
+	backend := exeBackend(org, name, port)
+	r.mu.RLock()
+	active := r.active[backend]
+	r.mu.RUnlock()
+	if active == nil {
+		return true
+	}
+
+	deadline := time.Now().Add(timeout)
+	drained := true
+	for active.Load() > 0 {
+		if time.Now().After(deadline) {
+			drained = false
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// 不再被路由使用的后端不需要保留计数
+	r.mu.Lock()
+	if !slices.Contains(r.backends[fmt.Sprintf("%s/%s", org, name)], backend) && active.Load() == 0 {
+		delete(r.active, backend)
+	}
+	r.mu.Unlock()
+	return drained
+}
+
 func (r *Router) removeRoutesInternal(org, name string) {
 	key := fmt.Sprintf("%s/%s", org, name)
 	if keys, ok := r.sandboxRoutes[key]; ok {
@@ -199,4 +429,11 @@ func (r *Router) removeRoutesInternal(org, name string) {
 		}
 		delete(r.sandboxRoutes, key)
 	}
+	// 没有在途请求的后端不再计数（如已退出的实例）；仍有请求的由 Drain 清理
+	for _, b := range r.backends[key] {
+		if active, ok := r.active[b]; ok && active.Load() == 0 {
+			delete(r.active, b)
+		}
+	}
+	delete(r.backends, key)
 }
@@ -0,0 +1,196 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"potstack/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// testPool 创建有 n 个后端的 pool，后端 Key 为序号
+func testPool(strategy string, n int) *pool {
+	p := &pool{strategy: strategy}
+	for i := 0; i < n; i++ {
+		p.backends = append(p.backends, &backend{key: fmt.Sprint(i), active: new(atomic.Int64)})
+	}
+	return p
+}
+
+// picks 连续选择 n 次，返回选中的后端 Key
+func picks(p *pool, n int) []string {
+	var keys []string
+	for i := 0; i < n; i++ {
+		keys = append(keys, p.pick().key)
+	}
+	return keys
+}
+
+func TestPoolPick(t *testing.T) {
+	// 只有一个后端时总是选它
+	p := testPool(models.LoadBalanceRoundRobin, 1)
+	assert.Equal(t, []string{"0", "0", "0"}, picks(p, 3))
+
+	// round_robin 依次轮流，不看在途请求
+	p = testPool(models.LoadBalanceRoundRobin, 3)
+	p.backends[0].active.Store(5)
+	assert.Equal(t, []string{"0", "1", "2", "0", "1", "2"}, picks(p, 6))
+
+	// 未配置策略时按 round_robin
+	p = testPool("", 3)
+	assert.Equal(t, []string{"0", "1", "2", "0"}, picks(p, 4))
+
+	// least_conn 选在途请求最少的
+	p = testPool(models.LoadBalanceLeastConn, 3)
+	p.backends[0].active.Store(2)
+	p.backends[1].active.Store(1)
+	p.backends[2].active.Store(3)
+	assert.Equal(t, []string{"1", "1", "1"}, picks(p, 3))
+
+	// 在途请求相同时轮流
+	p.backends[0].active.Store(1)
+	p.backends[2].active.Store(1)
+	assert.Equal(t, []string{"0", "1", "2", "0"}, picks(p, 4))
+}
+
+func TestDrain(t *testing.T) {
+	r := NewRouter(t.TempDir())
+
+	// 没有请求过的后端不需要等待
+	assert.True(t, r.Drain("wes", "api", 8080, 0))
+
+	backend := exeBackend("wes", "api", 8080)
+	r.mu.Lock()
+	active := r.backendInternal(backend, nil).active
+	r.mu.Unlock()
+
+	// 有在途请求时等待到超时
+	active.Store(1)
+	started := time.Now()
+	assert.False(t, r.Drain("wes", "api", 8080, 50*time.Millisecond))
+	assert.GreaterOrEqual(t, time.Since(started), 50*time.Millisecond)
+
+	// 在途请求结束后返回，不再被路由使用的后端计数被清理
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		active.Store(0)
+	}()
+	assert.True(t, r.Drain("wes", "api", 8080, time.Second))
+	r.mu.RLock()
+	_, ok := r.active[backend]
+	r.mu.RUnlock()
+	assert.False(t, ok)
+
+	// 仍被路由使用的后端保留计数
+	r.mu.Lock()
+	r.backendInternal(backend, nil)
+	r.backends["wes/api"] = []string{backend}
+	r.mu.Unlock()
+	assert.True(t, r.Drain("wes", "api", 8080, 0))
+	r.mu.RLock()
+	_, ok = r.active[backend]
+	r.mu.RUnlock()
+	assert.True(t, ok)
+}
+
+func TestRouterLoadBalancing(t *testing.T) {
+	repoRoot, _ := os.MkdirTemp("", "potstack_test_lb_*")
+	defer os.RemoveAll(repoRoot)
+
+	// 3 个后端，响应自己的序号；release 关闭前 /slow 不返回
+	release := make(chan struct{})
+	var ports []int
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprint(i)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/slow" {
+				<-release
+			}
+			io.WriteString(w, id)
+		}))
+		defer srv.Close()
+		ports = append(ports, srv.Listener.Addr().(*net.TCPAddr).Port)
+	}
+
+	runDir := filepath.Join(repoRoot, "wes", "lb.git", "data", "faaspot")
+	os.MkdirAll(runDir, 0755)
+	writeRun := func(ready ...bool) {
+		rc := models.RunConfig{TargetStatus: models.RunStatusRunning, State: models.RunStateRunning}
+		for i, port := range ports {
+			rc.Runtime.Replicas = append(rc.Runtime.Replicas, models.ReplicaRuntime{Replica: i, Port: port, Ready: ready[i]})
+		}
+		data, _ := yaml.Marshal(&rc)
+		os.WriteFile(filepath.Join(runDir, "run.yml"), data, 0644)
+	}
+
+	r := NewRouter(repoRoot)
+	front := httptest.NewServer(r)
+	defer front.Close()
+	get := func(path string) string {
+		resp, err := http.Get(front.URL + "/pot/wes/lb" + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	count := func(n int) map[string]int {
+		hits := map[string]int{}
+		for i := 0; i < n; i++ {
+			hits[get("/")]++
+		}
+		return hits
+	}
+
+	// 1. round_robin：未就绪的实例不接收请求，就绪的实例轮流处理
+	writeRun(true, false, true)
+	assert.NoError(t, r.RegisterExe("wes", "lb", &models.PotConfig{Type: "exe"}))
+	assert.Equal(t, map[string]int{"0": 3, "2": 3}, count(6))
+	t.Log("✅ round_robin")
+
+	// 2. 实例退出（run.yml 中不再就绪）后从轮询中摘除
+	writeRun(true, false, false)
+	assert.NoError(t, r.RegisterExe("wes", "lb", &models.PotConfig{Type: "exe"}))
+	assert.Equal(t, map[string]int{"0": 4}, count(4))
+	t.Log("✅ 摘除退出的实例")
+
+	// 3. least_conn：请求发往在途请求最少的实例
+	writeRun(true, true, true)
+	assert.NoError(t, r.RegisterExe("wes", "lb", &models.PotConfig{Type: "exe", LoadBalance: models.LoadBalanceLeastConn}))
+	slow := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() { slow <- get("/slow") }()
+	}
+	busy := func() int {
+		n := 0
+		for _, port := range ports {
+			if !r.Drain("wes", "lb", port, 0) {
+				n++
+			}
+		}
+		return n
+	}
+	for deadline := time.Now().Add(2 * time.Second); busy() < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	hits := count(3)
+	assert.Equal(t, 1, len(hits))
+	close(release)
+	held := map[string]bool{<-slow: true, <-slow: true}
+	assert.Len(t, held, 2)
+	for id := range hits {
+		assert.False(t, held[id])
+	}
+	t.Log("✅ least_conn")
+}
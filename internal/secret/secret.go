@@ -0,0 +1,271 @@
+// Package secret 保存 pot 的密钥（数据库密码等），pot.yml 的 env 通过 secret:NAME 引用，明文不进入 Git
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"potstack/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RefPrefix 是 pot.yml 中引用密钥的前缀：value: secret:DB_PASSWORD
+const RefPrefix = "secret:"
+
+var (
+	ErrNotFound    = errors.New("secret not found")
+	ErrInvalidName = errors.New("invalid secret name")
+)
+
+// validName 密钥名：字母或下划线开头，只含字母、数字、下划线（与环境变量名相同）
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+// Secret 是一个密钥的元数据，值只保存在文件中，不通过 API 返回
+type Secret struct {
+	Name      string `yaml:"-" json:"name"`
+	Version   int    `yaml:"version" json:"version"` // 每次设置加 1
+	CreatedAt string `yaml:"created_at" json:"created_at"`
+	UpdatedAt string `yaml:"updated_at" json:"updated_at"`
+	Value     string `yaml:"value" json:"-"` // base64(nonce + AES-256-GCM 密文)
+}
+
+// Store 是按 pot 保存的密钥库：
+//
+//	{repo}.git/data/secrets.yml                 pot 的密钥（加密），随仓库删除
+//	potstack/repo.git/data/secrets.key          主密钥（首次使用时生成，0600；设置了 POTSTACK_SECRET_KEY 时不使用）
+type Store struct {
+	repoDir string
+	mu      sync.Mutex
+	aead    cipher.AEAD // 首次使用时加载
+}
+
+// NewStore 创建密钥库，repoDir 为仓库根目录
+func NewStore(repoDir string) *Store {
+	return &Store{repoDir: repoDir}
+}
+
+// KeyPath 返回主密钥文件路径（与数据库位于同一目录）
+func KeyPath(repoDir string) string {
+	return filepath.Join(repoDir, "potstack", "repo.git", "data", "secrets.key")
+}
+
+// ValidName 判断密钥名是否合法
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Ref 解析 pot.yml 中的 secret:NAME 引用，不是引用时返回 false
+func Ref(value string) (string, bool) {
+	return strings.CutPrefix(value, RefPrefix)
+}
+
+// List 返回 pot 的所有密钥（按名称排序，不含值）
+func (s *Store) List(org, name string) ([]*Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load(org, name)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Secret, 0, len(secrets))
+	for _, sec := range secrets {
+		list = append(list, sec.meta())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Set 创建或更新（轮换）密钥，返回元数据与是否新建
+func (s *Store) Set(org, name, key, value string) (*Secret, bool, error) {
+	if !ValidName(key) {
+		return nil, false, ErrInvalidName
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	aead, err := s.cipher()
+	if err != nil {
+		return nil, false, err
+	}
+	secrets, err := s.load(org, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, false, err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), additionalData(org, name, key))
+
+	now := time.Now().Format(time.RFC3339)
+	sec, exists := secrets[key]
+	if !exists {
+		sec = &Secret{Name: key, CreatedAt: now}
+		secrets[key] = sec
+	}
+	sec.Version++
+	sec.UpdatedAt = now
+	sec.Value = base64.StdEncoding.EncodeToString(sealed)
+	if err := s.save(org, name, secrets); err != nil {
+		return nil, false, err
+	}
+	return sec.meta(), !exists, nil
+}
+
+// Delete 删除密钥
+func (s *Store) Delete(org, name, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load(org, name)
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[key]; !ok {
+		return ErrNotFound
+	}
+	delete(secrets, key)
+	return s.save(org, name, secrets)
+}
+
+// Get 返回解密后的值，只在启动沙箱时使用
+func (s *Store) Get(org, name, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load(org, name)
+	if err != nil {
+		return "", err
+	}
+	sec, ok := secrets[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	aead, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(sec.Value)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("secret %s is corrupted", key)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData(org, name, key))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret %s (wrong key?)", key)
+	}
+	return string(plain), nil
+}
+
+// path 返回 pot 的密钥文件
+func (s *Store) path(org, name string) string {
+	return filepath.Join(s.repoDir, org, fmt.Sprintf("%s.git", name), "data", "secrets.yml")
+}
+
+// load 读取 pot 的密钥，文件不存在时返回空表（调用方持有 s.mu）
+func (s *Store) load(org, name string) (map[string]*Secret, error) {
+	secrets := make(map[string]*Secret)
+	data, err := os.ReadFile(s.path(org, name))
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path(org, name), err)
+	}
+	for key, sec := range secrets {
+		sec.Name = key
+	}
+	return secrets, nil
+}
+
+// save 写入临时文件后替换，读取方不会看到写了一半的文件（调用方持有 s.mu）
+func (s *Store) save(org, name string, secrets map[string]*Secret) error {
+	path := s.path(org, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// cipher 返回主密钥的 AES-256-GCM，优先使用 POTSTACK_SECRET_KEY，否则读取（或生成）密钥文件（调用方持有 s.mu）
+func (s *Store) cipher() (cipher.AEAD, error) {
+	if s.aead != nil {
+		return s.aead, nil
+	}
+
+	var key []byte
+	if config.SecretKey != "" {
+		k, err := base64.StdEncoding.DecodeString(config.SecretKey)
+		if err != nil || len(k) != 32 {
+			return nil, fmt.Errorf("POTSTACK_SECRET_KEY must be 32 bytes, base64 encoded")
+		}
+		key = k
+	} else {
+		path := KeyPath(s.repoDir)
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err != nil || len(key) != 32 {
+				return nil, fmt.Errorf("invalid secret key file %s", path)
+			}
+		case os.IsNotExist(err):
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+				return nil, err
+			}
+			log.Printf("Generated secret key: %s", path)
+		default:
+			return nil, err
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s.aead = aead
+	return aead, nil
+}
+
+// additionalData 把密文绑定到 pot 与密钥名，复制到其他 pot 或改名后无法解密
+func additionalData(org, name, key string) []byte {
+	return []byte(org + "/" + name + "/" + key)
+}
+
+// meta 返回不含值的副本
+func (sec *Secret) meta() *Secret {
+	cp := *sec
+	cp.Value = ""
+	return &cp
+}
@@ -0,0 +1,173 @@
+package secret
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"potstack/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useSecretKey 临时设置 POTSTACK_SECRET_KEY
+func useSecretKey(t *testing.T, key string) {
+	old := config.SecretKey
+	config.SecretKey = key
+	t.Cleanup(func() { config.SecretKey = old })
+}
+
+func TestValidNameAndRef(t *testing.T) {
+	for _, name := range []string{"DB_PASSWORD", "_token", "a", "K8S_TOKEN_2", strings.Repeat("A", 128)} {
+		assert.True(t, ValidName(name), name)
+	}
+	for _, name := range []string{"", "2FA", "DB-PASSWORD", "db.password", "a b", "密码", strings.Repeat("A", 129)} {
+		assert.False(t, ValidName(name), name)
+	}
+
+	name, ok := Ref("secret:DB_PASSWORD")
+	assert.True(t, ok)
+	assert.Equal(t, "DB_PASSWORD", name)
+	_, ok = Ref("postgres://db")
+	assert.False(t, ok)
+}
+
+func TestStore(t *testing.T) {
+	useSecretKey(t, "")
+	repoDir := t.TempDir()
+	s := NewStore(repoDir)
+
+	// 空库
+	list, err := s.List("ann", "api")
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+	_, err = s.Get("ann", "api", "DB_PASSWORD")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// 创建
+	sec, created, err := s.Set("ann", "api", "DB_PASSWORD", "hunter2")
+	if assert.NoError(t, err) {
+		assert.True(t, created)
+		assert.Equal(t, "DB_PASSWORD", sec.Name)
+		assert.Equal(t, 1, sec.Version)
+		assert.NotEmpty(t, sec.CreatedAt)
+		assert.Empty(t, sec.Value) // 不返回密文
+	}
+	value, err := s.Get("ann", "api", "DB_PASSWORD")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	// 轮换：版本加 1，不是新建
+	sec, created, err = s.Set("ann", "api", "DB_PASSWORD", "correct horse")
+	if assert.NoError(t, err) {
+		assert.False(t, created)
+		assert.Equal(t, 2, sec.Version)
+	}
+	value, _ = s.Get("ann", "api", "DB_PASSWORD")
+	assert.Equal(t, "correct horse", value)
+
+	// 列表按名称排序，不含值
+	s.Set("ann", "api", "API_TOKEN", "t0k3n")
+	list, err = s.List("ann", "api")
+	if assert.NoError(t, err) && assert.Len(t, list, 2) {
+		assert.Equal(t, "API_TOKEN", list[0].Name)
+		assert.Equal(t, "DB_PASSWORD", list[1].Name)
+		for _, sec := range list {
+			assert.Empty(t, sec.Value)
+		}
+	}
+
+	// 文件中只有密文，权限为 0600
+	path := filepath.Join(repoDir, "ann", "api.git", "data", "secrets.yml")
+	data, err := os.ReadFile(path)
+	if assert.NoError(t, err) {
+		assert.NotContains(t, string(data), "correct horse")
+		assert.NotContains(t, string(data), "t0k3n")
+	}
+	if runtime.GOOS != "windows" {
+		info, _ := os.Stat(path)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// 其他 pot 看不到
+	list, _ = s.List("ann", "web")
+	assert.Empty(t, list)
+
+	// 删除
+	assert.NoError(t, s.Delete("ann", "api", "API_TOKEN"))
+	assert.ErrorIs(t, s.Delete("ann", "api", "API_TOKEN"), ErrNotFound)
+	_, err = s.Get("ann", "api", "API_TOKEN")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// 非法名称
+	_, _, err = s.Set("ann", "api", "DB-PASSWORD", "x")
+	assert.ErrorIs(t, err, ErrInvalidName)
+}
+
+func TestStoreAdditionalData(t *testing.T) {
+	useSecretKey(t, "")
+	repoDir := t.TempDir()
+	s := NewStore(repoDir)
+	s.Set("ann", "api", "DB_PASSWORD", "hunter2")
+
+	// 密文复制到其他 pot 或改名后无法解密
+	data, _ := os.ReadFile(filepath.Join(repoDir, "ann", "api.git", "data", "secrets.yml"))
+	other := filepath.Join(repoDir, "bob", "api.git", "data", "secrets.yml")
+	os.MkdirAll(filepath.Dir(other), 0755)
+	os.WriteFile(other, data, 0600)
+	_, err := s.Get("bob", "api", "DB_PASSWORD")
+	assert.EqualError(t, err, "failed to decrypt secret DB_PASSWORD (wrong key?)")
+
+	renamed := filepath.Join(repoDir, "ann", "web.git", "data", "secrets.yml")
+	os.MkdirAll(filepath.Dir(renamed), 0755)
+	os.WriteFile(renamed, []byte(strings.Replace(string(data), "DB_PASSWORD", "DB_PASS", 1)), 0600)
+	_, err = s.Get("ann", "web", "DB_PASS")
+	assert.Error(t, err)
+
+	// 损坏的密文
+	os.WriteFile(other, []byte("DB_PASSWORD:\n  version: 1\n  value: \"!!\"\n"), 0600)
+	_, err = s.Get("bob", "api", "DB_PASSWORD")
+	assert.EqualError(t, err, "secret DB_PASSWORD is corrupted")
+}
+
+func TestStoreKey(t *testing.T) {
+	// 未设置 POTSTACK_SECRET_KEY 时首次使用生成密钥文件，之后的 Store 沿用
+	useSecretKey(t, "")
+	repoDir := t.TempDir()
+	NewStore(repoDir).Set("ann", "api", "DB_PASSWORD", "hunter2")
+
+	info, err := os.Stat(KeyPath(repoDir))
+	if assert.NoError(t, err) && runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	value, err := NewStore(repoDir).Get("ann", "api", "DB_PASSWORD")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	// 设置了 POTSTACK_SECRET_KEY 时不使用密钥文件
+	useSecretKey(t, base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	_, err = NewStore(repoDir).Get("ann", "api", "DB_PASSWORD")
+	assert.EqualError(t, err, "failed to decrypt secret DB_PASSWORD (wrong key?)")
+
+	otherDir := t.TempDir()
+	s := NewStore(otherDir)
+	s.Set("ann", "api", "DB_PASSWORD", "hunter2")
+	_, err = os.Stat(KeyPath(otherDir))
+	assert.True(t, os.IsNotExist(err))
+	value, err = NewStore(otherDir).Get("ann", "api", "DB_PASSWORD")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	// 长度不对的密钥与损坏的密钥文件
+	useSecretKey(t, base64.StdEncoding.EncodeToString([]byte("short")))
+	_, _, err = NewStore(t.TempDir()).Set("ann", "api", "DB_PASSWORD", "x")
+	assert.EqualError(t, err, "POTSTACK_SECRET_KEY must be 32 bytes, base64 encoded")
+
+	useSecretKey(t, "")
+	os.WriteFile(KeyPath(repoDir), []byte("not a key\n"), 0600)
+	_, _, err = NewStore(repoDir).Set("ann", "api", "DB_PASSWORD", "x")
+	assert.EqualError(t, err, "invalid secret key file "+KeyPath(repoDir))
+}
@@ -10,5 +10,16 @@ var (
 	ErrPermissionDenied   = errors.New("permission denied")
 	ErrInvalidParam       = errors.New("invalid parameter")
 	ErrCollaboratorExists = errors.New("collaborator already exists")
+	ErrTokenNotFound      = errors.New("access token not found")
+	ErrSSHKeyExists       = errors.New("ssh key already in use")
+	ErrSSHKeyNotFound     = errors.New("ssh key not found")
+	ErrWebhookNotFound    = errors.New("webhook not found")
+	ErrDeliveryNotFound   = errors.New("webhook delivery not found")
+	ErrSandboxNotFound    = errors.New("sandbox not found")
+	ErrSandboxRunning     = errors.New("sandbox is already running")
+	ErrSandboxUnavailable = errors.New("keeper is not ready")
+	ErrJobRunning         = errors.New("job is already running")
+	ErrJobRunNotFound     = errors.New("job run not found")
+	ErrSecretNotFound     = errors.New("secret not found")
 	ErrInternal           = errors.New("internal error")
 )
@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"potstack/internal/db"
+	"potstack/internal/keeper"
+	"potstack/internal/secret"
 )
 
 // IUserService 定义用户服务接口
@@ -12,6 +14,11 @@ type IUserService interface {
 	DeleteUser(ctx context.Context, username string) error
 	GetUser(ctx context.Context, username string) (*db.User, error)
 	SetUserPublicKey(ctx context.Context, username, publicKey string) error
+
+	// SSH 公钥（用于 SSH Git 传输）
+	AddSSHKey(ctx context.Context, username, title, content string) (*db.SSHKey, error)
+	ListSSHKeys(ctx context.Context, username string) ([]*db.SSHKey, error)
+	DeleteSSHKey(ctx context.Context, username string, id int64) error
 }
 
 // IRepoService 定义仓库服务接口
@@ -27,4 +34,54 @@ type IRepoService interface {
 	RemoveCollaborator(ctx context.Context, owner, repo, collaborator string) error
 	ListCollaborators(ctx context.Context, owner, repo string) ([]*db.CollaboratorResponse, error)
 	IsCollaborator(ctx context.Context, owner, repo, user string) (bool, error)
+
+	// GetPermission 返回用户对仓库的权限：所有者为 admin，协作者为其权限，其他为空字符串
+	GetPermission(ctx context.Context, owner, repo, user string) (string, error)
+}
+
+// ITokenService 定义个人访问令牌服务接口
+type ITokenService interface {
+	// CreateToken 创建令牌，返回令牌记录和仅此一次可见的明文
+	CreateToken(ctx context.Context, username, name string, scopes []string) (*db.AccessToken, string, error)
+	ListTokens(ctx context.Context, username string) ([]*db.AccessToken, error)
+	DeleteToken(ctx context.Context, username string, id int64) error
+}
+
+// IWebhookService 定义 webhook 服务接口，owner 和 repo 为空时操作全局 webhook
+type IWebhookService interface {
+	CreateWebhook(ctx context.Context, owner, repo, url, secret string, events []string, active bool) (*db.Webhook, error)
+	ListWebhooks(ctx context.Context, owner, repo string) ([]*db.Webhook, error)
+	GetWebhook(ctx context.Context, owner, repo string, id int64) (*db.Webhook, error)
+	DeleteWebhook(ctx context.Context, owner, repo string, id int64) error
+
+	// 投递记录
+	ListDeliveries(ctx context.Context, owner, repo string, id int64) ([]*db.WebhookDelivery, error)
+	Redeliver(ctx context.Context, owner, repo string, id, deliveryID int64) (*db.WebhookDelivery, error)
+}
+
+// ISandboxService 定义 sandbox（pot 运行实例）服务接口
+type ISandboxService interface {
+	ListSandboxes(ctx context.Context) ([]*keeper.SandboxStatus, error)
+	GetSandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error)
+
+	// 生命周期控制，返回操作后的状态
+	StartSandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error)
+	StopSandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error)
+	RestartSandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error)
+	RedeploySandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error)
+
+	// 控制台日志（stdout/stderr）
+	TailLogs(ctx context.Context, owner, repo string, lines int) ([]string, error)
+	FollowLogs(ctx context.Context, owner, repo string) (<-chan string, error)
+
+	// job pot 的执行与记录
+	RunJob(ctx context.Context, owner, repo string) (*keeper.JobRun, error)
+	ListJobRuns(ctx context.Context, owner, repo string) ([]*keeper.JobRun, error)
+	GetJobRun(ctx context.Context, owner, repo, id string) (*keeper.JobRun, error)
+	JobOutput(ctx context.Context, owner, repo, id string) ([]string, error)
+
+	// 密钥（pot.yml 中的 secret:NAME），只能设置与删除，不返回值
+	ListSecrets(ctx context.Context, owner, repo string) ([]*secret.Secret, error)
+	SetSecret(ctx context.Context, owner, repo, name, value string) (*secret.Secret, bool, error)
+	DeleteSecret(ctx context.Context, owner, repo, name string) error
 }
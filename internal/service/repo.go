@@ -9,6 +9,7 @@ import (
 	"potstack/config"
 	"potstack/internal/db"
 	"potstack/internal/git"
+	"potstack/internal/webhook"
 )
 
 type RepoService struct{}
@@ -55,6 +56,11 @@ func (s *RepoService) CreateRepo(ctx context.Context, owner, name string) (*db.R
 		return nil, fmt.Errorf("%w: db create failed: %v", ErrInternal, err)
 	}
 
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventRepository,
+		Action:     "created",
+		Repository: webhook.NewRepository(owner, name),
+	})
 	return repo, nil
 }
 
@@ -71,6 +77,12 @@ func (s *RepoService) DeleteRepo(ctx context.Context, owner, name string) error
 		return fmt.Errorf("%w: failed to delete repo directory: %v", ErrInternal, err)
 	}
 
+	// 仓库自身的 webhook 已随仓库删除，只有全局 webhook 能收到
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventRepository,
+		Action:     "deleted",
+		Repository: webhook.NewRepository(owner, name),
+	})
 	return nil
 }
 
@@ -114,6 +126,12 @@ func (s *RepoService) AddCollaborator(ctx context.Context, owner, repoName, coll
 		return fmt.Errorf("%w: %v", ErrInternal, err)
 	}
 
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventCollaborator,
+		Action:     "added",
+		Repository: webhook.NewRepository(owner, repoName),
+		Data:       map[string]string{"user": collaboratorName, "permission": permission},
+	})
 	return nil
 }
 
@@ -135,6 +153,13 @@ func (s *RepoService) RemoveCollaborator(ctx context.Context, owner, repoName, c
 	if err := db.RemoveCollaborator(repo.ID, user.ID); err != nil {
 		return fmt.Errorf("%w: %v", ErrInternal, err)
 	}
+
+	webhook.Emit(&webhook.Payload{
+		Event:      webhook.EventCollaborator,
+		Action:     "removed",
+		Repository: webhook.NewRepository(owner, repoName),
+		Data:       map[string]string{"user": collaboratorName},
+	})
 	return nil
 }
 
@@ -179,3 +204,33 @@ func (s *RepoService) IsCollaborator(ctx context.Context, owner, repoName, usern
 
 	return db.IsCollaborator(repo.ID, user.ID)
 }
+
+// GetPermission 获取用户对仓库的权限
+func (s *RepoService) GetPermission(ctx context.Context, owner, repoName, username string) (string, error) {
+	repo, err := s.GetRepo(ctx, owner, repoName)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return "", nil
+	}
+
+	// 所有者隐含 admin 权限
+	if user.ID == repo.OwnerID {
+		return "admin", nil
+	}
+
+	collab, err := db.GetCollaborator(repo.ID, user.ID)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if collab == nil {
+		return "", nil
+	}
+	return collab.Permission, nil
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"potstack/internal/db"
+	"potstack/internal/keeper"
+	"potstack/internal/secret"
+)
+
+// maxLogLines 一次最多返回的日志行数
+const maxLogLines = 10000
+
+type SandboxService struct {
+	manager *keeper.SandboxManager
+}
+
+func NewSandboxService(m *keeper.SandboxManager) *SandboxService {
+	return &SandboxService{manager: m}
+}
+
+// GetSandbox 返回仓库对应 sandbox 的运行状态
+func (s *SandboxService) GetSandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error) {
+	st, err := s.manager.Status(owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if st == nil {
+		return nil, ErrSandboxNotFound
+	}
+	return st, nil
+}
+
+// ListSandboxes 返回所有已安装 pot 的状态
+func (s *SandboxService) ListSandboxes(ctx context.Context) ([]*keeper.SandboxStatus, error) {
+	list, err := s.manager.List()
+	if err != nil {
+		if errors.Is(err, keeper.ErrNotReady) {
+			return nil, ErrSandboxUnavailable
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return list, nil
+}
+
+// StartSandbox 启动 exe sandbox（run.yml 的 target_status 变为 running）
+func (s *SandboxService) StartSandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error) {
+	return s.control(ctx, owner, repo, true, s.manager.Start)
+}
+
+// StopSandbox 优雅停止 exe sandbox（target_status 变为 stopped）
+func (s *SandboxService) StopSandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error) {
+	return s.control(ctx, owner, repo, true, func(org, name string) error {
+		return s.manager.Stop(org, name)
+	})
+}
+
+// RestartSandbox 重启 exe sandbox（不更新代码）
+func (s *SandboxService) RestartSandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error) {
+	return s.control(ctx, owner, repo, true, s.manager.Restart)
+}
+
+// RedeploySandbox 按默认分支的最新代码重新部署
+func (s *SandboxService) RedeploySandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error) {
+	return s.control(ctx, owner, repo, false, s.manager.Redeploy)
+}
+
+// control 执行操作并返回操作后的状态，exeOnly 的操作不适用于没有进程的 static pot
+func (s *SandboxService) control(ctx context.Context, owner, repo string, exeOnly bool, op func(org, name string) error) (*keeper.SandboxStatus, error) {
+	st, err := s.GetSandbox(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	if exeOnly && st.Type != "exe" {
+		return nil, fmt.Errorf("%w: %s pots have no process", ErrInvalidParam, st.Type)
+	}
+
+	if err := op(owner, repo); err != nil {
+		switch {
+		case errors.Is(err, keeper.ErrAlreadyRunning):
+			return nil, ErrSandboxRunning
+		case errors.Is(err, keeper.ErrNotExe), errors.Is(err, keeper.ErrInvalidResources):
+			return nil, fmt.Errorf("%w: %v", ErrInvalidParam, err)
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+	}
+	return s.GetSandbox(ctx, owner, repo)
+}
+
+// TailLogs 返回 sandbox 控制台日志的最后 lines 行
+func (s *SandboxService) TailLogs(ctx context.Context, owner, repo string, lines int) ([]string, error) {
+	if lines <= 0 || lines > maxLogLines {
+		return nil, fmt.Errorf("%w: lines must be between 1 and %d", ErrInvalidParam, maxLogLines)
+	}
+	if _, err := s.GetSandbox(ctx, owner, repo); err != nil {
+		return nil, err
+	}
+
+	out, err := s.manager.TailLog(owner, repo, lines)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if out == nil {
+		out = []string{}
+	}
+	return out, nil
+}
+
+// FollowLogs 订阅 sandbox 控制台日志的新行，ctx 结束时取消订阅并关闭通道
+func (s *SandboxService) FollowLogs(ctx context.Context, owner, repo string) (<-chan string, error) {
+	if _, err := s.GetSandbox(ctx, owner, repo); err != nil {
+		return nil, err
+	}
+
+	ch, cancel := s.manager.FollowLog(owner, repo)
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return ch, nil
+}
+
+// RunJob 立即执行一次 job pot，上一次仍在执行时返回 ErrJobRunning
+func (s *SandboxService) RunJob(ctx context.Context, owner, repo string) (*keeper.JobRun, error) {
+	if _, err := s.jobSandbox(ctx, owner, repo); err != nil {
+		return nil, err
+	}
+	run, err := s.manager.RunJob(owner, repo, keeper.JobTriggerManual)
+	if err != nil {
+		return nil, jobError(err)
+	}
+	return run, nil
+}
+
+// ListJobRuns 返回 job pot 的执行记录，最近的在前
+func (s *SandboxService) ListJobRuns(ctx context.Context, owner, repo string) ([]*keeper.JobRun, error) {
+	if _, err := s.jobSandbox(ctx, owner, repo); err != nil {
+		return nil, err
+	}
+	runs, err := s.manager.JobRuns(owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return runs, nil
+}
+
+// GetJobRun 返回 job pot 的一次执行记录
+func (s *SandboxService) GetJobRun(ctx context.Context, owner, repo, id string) (*keeper.JobRun, error) {
+	if _, err := s.jobSandbox(ctx, owner, repo); err != nil {
+		return nil, err
+	}
+	run, err := s.manager.GetJobRun(owner, repo, id)
+	if err != nil {
+		return nil, jobError(err)
+	}
+	return run, nil
+}
+
+// JobOutput 返回 job pot 一次执行的输出
+func (s *SandboxService) JobOutput(ctx context.Context, owner, repo, id string) ([]string, error) {
+	if _, err := s.jobSandbox(ctx, owner, repo); err != nil {
+		return nil, err
+	}
+	lines, err := s.manager.JobOutput(owner, repo, id)
+	if err != nil {
+		return nil, jobError(err)
+	}
+	return lines, nil
+}
+
+// jobSandbox 返回 job pot 的状态，其他类型返回 ErrInvalidParam
+func (s *SandboxService) jobSandbox(ctx context.Context, owner, repo string) (*keeper.SandboxStatus, error) {
+	st, err := s.GetSandbox(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	if st.Type != "job" {
+		return nil, fmt.Errorf("%w: %s pots have no job runs", ErrInvalidParam, st.Type)
+	}
+	return st, nil
+}
+
+func jobError(err error) error {
+	switch {
+	case errors.Is(err, keeper.ErrJobRunning):
+		return ErrJobRunning
+	case errors.Is(err, keeper.ErrJobRunNotFound):
+		return ErrJobRunNotFound
+	case errors.Is(err, keeper.ErrNotJob):
+		return fmt.Errorf("%w: %v", ErrInvalidParam, err)
+	default:
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+}
+
+// ListSecrets 返回仓库的密钥（不含值）
+func (s *SandboxService) ListSecrets(ctx context.Context, owner, repo string) ([]*secret.Secret, error) {
+	if err := s.checkRepo(owner, repo); err != nil {
+		return nil, err
+	}
+	list, err := s.manager.Secrets().List(owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return list, nil
+}
+
+// SetSecret 创建或轮换密钥，返回是否新建；运行中的 sandbox 在下次启动时使用新值
+func (s *SandboxService) SetSecret(ctx context.Context, owner, repo, name, value string) (*secret.Secret, bool, error) {
+	if err := s.checkRepo(owner, repo); err != nil {
+		return nil, false, err
+	}
+	sec, created, err := s.manager.Secrets().Set(owner, repo, name, value)
+	if err != nil {
+		if errors.Is(err, secret.ErrInvalidName) {
+			return nil, false, fmt.Errorf("%w: secret name must be letters, digits and underscores", ErrInvalidParam)
+		}
+		return nil, false, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return sec, created, nil
+}
+
+// DeleteSecret 删除密钥
+func (s *SandboxService) DeleteSecret(ctx context.Context, owner, repo, name string) error {
+	if err := s.checkRepo(owner, repo); err != nil {
+		return err
+	}
+	if err := s.manager.Secrets().Delete(owner, repo, name); err != nil {
+		if errors.Is(err, secret.ErrNotFound) {
+			return ErrSecretNotFound
+		}
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return nil
+}
+
+// checkRepo 密钥可以在首次部署前设置，只要求仓库存在
+func (s *SandboxService) checkRepo(owner, repo string) error {
+	r, err := db.GetRepositoryByOwnerAndName(owner, repo)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if r == nil {
+		return ErrRepoNotFound
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"potstack/internal/db"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AddSSHKey 为用户添加 SSH 公钥（authorized_keys 格式），title 为空时使用公钥注释
+func (s *UserService) AddSSHKey(ctx context.Context, username, title, content string) (*db.SSHKey, error) {
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ssh public key: %v", ErrInvalidParam, err)
+	}
+	if title == "" {
+		title = comment
+	}
+	if title == "" {
+		return nil, fmt.Errorf("%w: key title is required", ErrInvalidParam)
+	}
+
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	fingerprint := ssh.FingerprintSHA256(pub)
+	existing, err := db.GetSSHKeyByFingerprint(fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if existing != nil {
+		return nil, ErrSSHKeyExists
+	}
+
+	// 只保存 "<type> <base64>"，去掉注释与选项
+	normalized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	key, err := db.CreateSSHKey(user.ID, title, normalized, fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create ssh key in db: %v", ErrInternal, err)
+	}
+	return key, nil
+}
+
+// ListSSHKeys 列出用户的 SSH 公钥
+func (s *UserService) ListSSHKeys(ctx context.Context, username string) ([]*db.SSHKey, error) {
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	keys, err := db.GetSSHKeysByUser(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if keys == nil {
+		keys = []*db.SSHKey{}
+	}
+	return keys, nil
+}
+
+// DeleteSSHKey 删除用户的 SSH 公钥
+func (s *UserService) DeleteSSHKey(ctx context.Context, username string, id int64) error {
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	found, err := db.DeleteSSHKey(user.ID, id)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if !found {
+		return ErrSSHKeyNotFound
+	}
+	return nil
+}
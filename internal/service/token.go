@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"potstack/internal/auth"
+	"potstack/internal/db"
+)
+
+type TokenService struct{}
+
+func NewTokenService() *TokenService {
+	return &TokenService{}
+}
+
+// CreateToken 为用户创建个人访问令牌
+func (s *TokenService) CreateToken(ctx context.Context, username, name string, scopes []string) (*db.AccessToken, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: token name is required", ErrInvalidParam)
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidParam)
+	}
+	for _, scope := range scopes {
+		if !auth.IsValidScope(scope) {
+			return nil, "", fmt.Errorf("%w: unknown scope %q", ErrInvalidParam, scope)
+		}
+	}
+
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil, "", ErrUserNotFound
+	}
+
+	plain, err := auth.GenerateToken()
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to generate token: %v", ErrInternal, err)
+	}
+
+	token, err := db.CreateAccessToken(user.ID, name, auth.HashToken(plain), scopes)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to create token in db: %v", ErrInternal, err)
+	}
+
+	return token, plain, nil
+}
+
+// ListTokens 列出用户的令牌（不包含明文）
+func (s *TokenService) ListTokens(ctx context.Context, username string) ([]*db.AccessToken, error) {
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	tokens, err := db.GetAccessTokensByUser(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if tokens == nil {
+		tokens = []*db.AccessToken{}
+	}
+	return tokens, nil
+}
+
+// DeleteToken 吊销用户的令牌
+func (s *TokenService) DeleteToken(ctx context.Context, username string, id int64) error {
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	found, err := db.DeleteAccessToken(user.ID, id)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if !found {
+		return ErrTokenNotFound
+	}
+	return nil
+}
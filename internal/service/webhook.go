@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"potstack/internal/db"
+	"potstack/internal/webhook"
+)
+
+// maxDeliveries 列出投递记录时返回的最大条数
+const maxDeliveries = 50
+
+type WebhookService struct{}
+
+func NewWebhookService() *WebhookService {
+	return &WebhookService{}
+}
+
+// CreateWebhook 创建 webhook，owner 和 repo 为空时创建全局 webhook；仓库 webhook 不能指向本机或内网地址
+func (s *WebhookService) CreateWebhook(ctx context.Context, owner, repo, hookURL, secret string, events []string, active bool) (*db.Webhook, error) {
+	u, err := url.Parse(hookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: invalid webhook url", ErrInvalidParam)
+	}
+	if owner != "" {
+		if err := webhook.CheckTarget(ctx, u.Hostname()); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidParam, err)
+		}
+	}
+	for _, event := range events {
+		if !webhook.IsValidEvent(event) {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrInvalidParam, event)
+		}
+	}
+
+	repoID, err := s.resolveRepo(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := db.CreateWebhook(repoID, hookURL, secret, events, active)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create webhook in db: %v", ErrInternal, err)
+	}
+	return hook, nil
+}
+
+// ListWebhooks 列出仓库（或全局）的 webhook
+func (s *WebhookService) ListWebhooks(ctx context.Context, owner, repo string) ([]*db.Webhook, error) {
+	repoID, err := s.resolveRepo(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks, err := db.GetWebhooksByRepo(repoID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if hooks == nil {
+		hooks = []*db.Webhook{}
+	}
+	return hooks, nil
+}
+
+// GetWebhook 获取仓库（或全局）的 webhook
+func (s *WebhookService) GetWebhook(ctx context.Context, owner, repo string, id int64) (*db.Webhook, error) {
+	repoID, err := s.resolveRepo(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := db.GetWebhookByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if hook == nil || hook.RepoID != repoID {
+		return nil, ErrWebhookNotFound
+	}
+	return hook, nil
+}
+
+// DeleteWebhook 删除 webhook
+func (s *WebhookService) DeleteWebhook(ctx context.Context, owner, repo string, id int64) error {
+	repoID, err := s.resolveRepo(owner, repo)
+	if err != nil {
+		return err
+	}
+
+	found, err := db.DeleteWebhook(repoID, id)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if !found {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// ListDeliveries 列出 webhook 最近的投递记录
+func (s *WebhookService) ListDeliveries(ctx context.Context, owner, repo string, id int64) ([]*db.WebhookDelivery, error) {
+	hook, err := s.GetWebhook(ctx, owner, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := db.GetWebhookDeliveries(hook.ID, maxDeliveries)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if deliveries == nil {
+		deliveries = []*db.WebhookDelivery{}
+	}
+	if owner != "" {
+		// 仓库只读用户也能查看投递记录，不返回响应体（旧版本保存的记录中可能有）
+		for _, d := range deliveries {
+			d.ResponseBody = ""
+		}
+	}
+	return deliveries, nil
+}
+
+// Redeliver 以原始内容重新投递，返回新的投递记录
+func (s *WebhookService) Redeliver(ctx context.Context, owner, repo string, id, deliveryID int64) (*db.WebhookDelivery, error) {
+	hook, err := s.GetWebhook(ctx, owner, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := db.GetWebhookDelivery(hook.ID, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if delivery == nil {
+		return nil, ErrDeliveryNotFound
+	}
+
+	redelivery, err := webhook.Redeliver(hook, delivery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return redelivery, nil
+}
+
+// resolveRepo 返回仓库 ID，owner 为空时返回 0（全局）
+func (s *WebhookService) resolveRepo(owner, repo string) (int64, error) {
+	if owner == "" {
+		return 0, nil
+	}
+	r, err := db.GetRepositoryByOwnerAndName(owner, repo)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if r == nil {
+		return 0, ErrRepoNotFound
+	}
+	return r.ID, nil
+}
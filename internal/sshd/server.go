@@ -0,0 +1,265 @@
+package sshd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"potstack/internal/db"
+	"potstack/internal/git"
+	"potstack/internal/service"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Git over SSH：
+//
+//	git clone ssh://git@<host>:<port>/<owner>/<repo>.git
+//
+// 通过用户在 /api/v1/users/:username/keys 登记的公钥认证（SSH 用户名被忽略），
+// 按协作者权限授权：git-upload-pack 需要 read，git-receive-pack 需要 write。
+// 只接受这两条命令，不提供 shell。
+
+// extUser 认证通过后在 ssh.Permissions 中记录用户名的键
+const extUser = "potstack-user"
+
+// Server SSH Git 服务
+type Server struct {
+	repoService service.IRepoService
+	config      *ssh.ServerConfig
+}
+
+// NewServer 创建 SSH Git 服务
+func NewServer(rs service.IRepoService, hostKey ssh.Signer) *Server {
+	s := &Server{repoService: rs}
+	s.config = &ssh.ServerConfig{PublicKeyCallback: s.authenticate}
+	s.config.AddHostKey(hostKey)
+	return s
+}
+
+// ListenAndServe 监听 addr 并提供服务
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 在监听器上接受连接，监听器关闭时返回 nil
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// authenticate 按公钥指纹查找用户
+func (s *Server) authenticate(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if !db.IsReady() {
+		return nil, fmt.Errorf("database not ready")
+	}
+
+	k, err := db.GetSSHKeyByFingerprint(ssh.FingerprintSHA256(key))
+	if err != nil {
+		return nil, err
+	}
+	if k == nil || k.Content != strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) {
+		return nil, fmt.Errorf("unknown public key")
+	}
+	user, err := db.GetUserByID(k.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("unknown public key")
+	}
+
+	_ = db.TouchSSHKey(k.ID)
+	return &ssh.Permissions{Extensions: map[string]string{extUser: user.Username}}, nil
+}
+
+func (s *Server) handleConn(nConn net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, s.config)
+	if err != nil {
+		nConn.Close()
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	username := conn.Permissions.Extensions[extUser]
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(username, ch, requests)
+	}
+}
+
+// handleSession 处理一个会话：接受 GIT_PROTOCOL 环境变量，执行一条 exec 命令后关闭
+func (s *Server) handleSession(username string, ch ssh.Channel, requests <-chan *ssh.Request) {
+	defer ch.Close()
+
+	protocolV2 := false
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			var env struct{ Name, Value string }
+			if err := ssh.Unmarshal(req.Payload, &env); err == nil && env.Name == "GIT_PROTOCOL" {
+				protocolV2 = hasVersion2(env.Value)
+			}
+			_ = req.Reply(true, nil)
+
+		case "exec":
+			var cmd struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &cmd); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+			sendExitStatus(ch, s.exec(username, cmd.Command, protocolV2, ch))
+			return
+
+		case "shell":
+			_ = req.Reply(true, nil)
+			fmt.Fprintf(ch.Stderr(), "Hi %s! You've successfully authenticated, but PotStack does not provide shell access.\n", username)
+			sendExitStatus(ch, 1)
+			return
+
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+// exec 执行 git 命令，返回退出码
+func (s *Server) exec(username, command string, protocolV2 bool, ch ssh.Channel) uint32 {
+	ctx := context.Background()
+
+	verb, owner, repoName, err := parseCommand(command)
+	if err == nil {
+		need := "read"
+		if verb == "git-receive-pack" {
+			need = "write"
+		}
+		err = s.authorize(ctx, username, owner, repoName, need)
+	}
+	if err != nil {
+		fmt.Fprintf(ch.Stderr(), "fatal: %s\n", err)
+		return 128
+	}
+
+	repoPath, _ := filepath.Abs(s.repoService.GetRepoPath(owner, repoName))
+	if verb == "git-receive-pack" {
+		err = git.ServeReceivePack(ctx, owner, repoName, repoPath, username, ch, ch)
+	} else {
+		err = git.ServeUploadPack(ctx, repoPath, protocolV2, ch, ch)
+	}
+	if err != nil {
+		log.Printf("ssh %s %s/%s (%s): %v", verb, owner, repoName, username, err)
+		return 1
+	}
+	return 0
+}
+
+// authorize 校验用户对仓库至少拥有 need 权限
+func (s *Server) authorize(ctx context.Context, username, owner, repoName, need string) error {
+	perm, err := s.repoService.GetPermission(ctx, owner, repoName, username)
+	if err != nil {
+		if errors.Is(err, service.ErrRepoNotFound) {
+			return fmt.Errorf("repository '%s/%s' not found", owner, repoName)
+		}
+		log.Printf("ssh: failed to check permission on %s/%s: %v", owner, repoName, err)
+		return fmt.Errorf("internal error")
+	}
+	if !db.PermissionAllows(perm, need) {
+		return fmt.Errorf("permission denied")
+	}
+	return nil
+}
+
+// parseCommand 解析 "git-upload-pack '/owner/repo.git'"，返回命令、所有者和仓库名（不含 .git）
+func parseCommand(command string) (string, string, string, error) {
+	verb, arg, _ := strings.Cut(strings.TrimSpace(command), " ")
+	if verb == "git" {
+		// "git upload-pack ..." 形式
+		sub, rest, _ := strings.Cut(strings.TrimSpace(arg), " ")
+		verb, arg = "git-"+sub, rest
+	}
+	if verb != "git-upload-pack" && verb != "git-receive-pack" {
+		return "", "", "", fmt.Errorf("unsupported command: %s", verb)
+	}
+
+	p := strings.Trim(strings.TrimSpace(arg), "'\"")
+	p = strings.Trim(p, "/")
+	owner, name, ok := strings.Cut(p, "/")
+	name = strings.TrimSuffix(name, ".git")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") ||
+		owner == ".." || name == ".." || strings.HasPrefix(owner, ".") {
+		return "", "", "", fmt.Errorf("invalid repository path: %s", arg)
+	}
+	return verb, owner, name, nil
+}
+
+// hasVersion2 判断 GIT_PROTOCOL 是否请求了协议 v2
+func hasVersion2(value string) bool {
+	for _, param := range strings.Split(value, ":") {
+		if strings.TrimSpace(param) == "version=2" {
+			return true
+		}
+	}
+	return false
+}
+
+func sendExitStatus(ch ssh.Channel, code uint32) {
+	_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{code}))
+}
+
+// LoadHostKey 读取主机私钥，文件不存在时生成 ed25519 私钥并保存
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated SSH host key: %s", path)
+	return ssh.NewSignerFromKey(priv)
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// 仓库 webhook 由有仓库写权限的用户创建，不能用来访问本机与内网（如未鉴权的内部端口）：
+// 创建时检查 URL 解析出的地址，投递时在连接前检查实际连接的地址（重定向与 DNS 重新绑定都无法绕过）。
+// 全局 webhook 由管理员创建，不受限制。
+
+// ErrPrivateTarget 仓库 webhook 的目标是本机或内网地址
+var ErrPrivateTarget = errors.New("webhook target must not be a loopback, link-local, private or unspecified address")
+
+// AllowPrivateTargets 为 true 时仓库 webhook 也可以投递到内网地址（测试用）
+var AllowPrivateTargets = false
+
+// repoClient 投递仓库 webhook，连接前检查地址；不使用代理，否则检查的是代理的地址
+var repoClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: checkDial,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// privateIP 判断是否为本机、链路本地、私有或未指定地址
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// checkDial 在建立连接前检查解析后的地址
+func checkDial(network, address string, _ syscall.RawConn) error {
+	if AllowPrivateTargets {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+		return ErrPrivateTarget
+	}
+	return nil
+}
+
+// CheckTarget 检查仓库 webhook 的主机名，解析出的任一地址为内网地址时返回 ErrPrivateTarget；
+// 解析失败时不拦截，投递时连接前仍会检查
+func CheckTarget(ctx context.Context, host string) error {
+	if AllowPrivateTargets {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if privateIP(ip) {
+			return ErrPrivateTarget
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if privateIP(addr.IP) {
+			return ErrPrivateTarget
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"potstack/internal/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivateIP(t *testing.T) {
+	for _, ip := range []string{
+		"127.0.0.1", "127.8.8.8", "::1", // loopback
+		"169.254.169.254", "fe80::1", // link-local
+		"10.0.0.1", "172.16.5.4", "192.168.1.1", "fd00::1", // private
+		"0.0.0.0", "::", // unspecified
+		"::ffff:127.0.0.1", "::ffff:10.0.0.1", // IPv4-mapped
+	} {
+		assert.True(t, privateIP(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"8.8.8.8", "172.32.0.1", "192.169.0.1", "2001:4860:4860::8888"} {
+		assert.False(t, privateIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	ctx := context.Background()
+	assert.ErrorIs(t, CheckTarget(ctx, "127.0.0.1"), ErrPrivateTarget)
+	assert.ErrorIs(t, CheckTarget(ctx, "::1"), ErrPrivateTarget)
+	assert.ErrorIs(t, CheckTarget(ctx, "169.254.169.254"), ErrPrivateTarget)
+	assert.ErrorIs(t, CheckTarget(ctx, "localhost"), ErrPrivateTarget)
+	assert.NoError(t, CheckTarget(ctx, "8.8.8.8"))
+	// 解析失败时由投递时的检查拦截
+	assert.NoError(t, CheckTarget(ctx, "nonexistent.invalid"))
+
+	AllowPrivateTargets = true
+	defer func() { AllowPrivateTargets = false }()
+	assert.NoError(t, CheckTarget(ctx, "127.0.0.1"))
+}
+
+func TestSendPrivateTarget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal secret"))
+	}))
+	defer srv.Close()
+	d := &db.WebhookDelivery{Event: EventPush, GUID: "guid", Payload: []byte("{}")}
+
+	// 仓库 webhook 在连接前拦截，按主机名访问（DNS 解析到内网地址）也一样
+	byName := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	for _, u := range []string{srv.URL, byName} {
+		_, _, err := send(context.Background(), &db.Webhook{ID: 1, RepoID: 1, URL: u}, d)
+		assert.ErrorIs(t, err, ErrPrivateTarget, u)
+	}
+
+	// 全局 webhook 不受限制，保存响应体
+	status, body, err := send(context.Background(), &db.Webhook{ID: 2, URL: srv.URL}, d)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "internal secret", body)
+
+	// 仓库 webhook 不保存响应体
+	AllowPrivateTargets = true
+	defer func() { AllowPrivateTargets = false }()
+	status, body, err = send(context.Background(), &db.Webhook{ID: 1, RepoID: 1, URL: srv.URL}, d)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, body)
+}
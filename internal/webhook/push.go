@@ -0,0 +1,32 @@
+package webhook
+
+import (
+	"potstack/internal/git"
+)
+
+// PushData push 事件的 data 字段
+type PushData struct {
+	Ref     string `json:"ref"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+	Created bool   `json:"created"`
+	Deleted bool   `json:"deleted"`
+}
+
+// PushHook post-receive 钩子：每个成功更新的引用发送一次 push 事件
+func PushHook(hc *git.HookContext, updates []git.RefUpdate) {
+	for _, u := range updates {
+		Emit(&Payload{
+			Event:      EventPush,
+			Repository: NewRepository(hc.Owner, hc.Repo),
+			Sender:     hc.Pusher,
+			Data: PushData{
+				Ref:     u.Name.String(),
+				Before:  u.Old.String(),
+				After:   u.New.String(),
+				Created: u.Old.IsZero(),
+				Deleted: u.IsDelete(),
+			},
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"potstack/internal/db"
+)
+
+// 事件类型
+const (
+	EventPush         = "push"         // 推送（每个引用一次）
+	EventRepository   = "repository"   // 仓库创建 / 删除
+	EventCollaborator = "collaborator" // 协作者添加 / 移除
+	EventSandbox      = "sandbox"      // 沙箱启动 / 停止 / 崩溃
+	EventCertificate  = "certificate"  // 证书续签（仅全局 webhook）
+)
+
+// Events 所有可订阅的事件
+var Events = []string{EventPush, EventRepository, EventCollaborator, EventSandbox, EventCertificate}
+
+// IsValidEvent 判断是否为可订阅的事件（"*" 表示全部）
+func IsValidEvent(event string) bool {
+	if event == "*" {
+		return true
+	}
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// 请求头
+const (
+	HeaderEvent     = "X-PotStack-Event"
+	HeaderDelivery  = "X-PotStack-Delivery"
+	HeaderSignature = "X-PotStack-Signature-256"
+)
+
+// RetryDelays 投递失败（网络错误或非 2xx）后的重试间隔，用尽后标记为 failed
+var RetryDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute}
+
+// maxResponseBody 投递记录中保存的响应体长度上限（只保存全局 webhook 的响应体）
+const maxResponseBody = 4 << 10
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// 进行中的投递：Stop 取消 ctx 并等待投递协程退出，未完成的记录保持 pending，下次 Start 时继续
+var (
+	mu          sync.Mutex
+	ctx, cancel = context.WithCancel(context.Background())
+	wg          sync.WaitGroup
+)
+
+// Repository 事件关联的仓库
+type Repository struct {
+	Owner    string `json:"owner"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+}
+
+// NewRepository 根据所有者和仓库名构造 Repository
+func NewRepository(owner, name string) *Repository {
+	return &Repository{Owner: owner, Name: name, FullName: owner + "/" + name}
+}
+
+// Payload 投递的 JSON 内容
+type Payload struct {
+	Event      string      `json:"event"`
+	Action     string      `json:"action,omitempty"`
+	Repository *Repository `json:"repository,omitempty"`
+	Sender     string      `json:"sender,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+	Timestamp  time.Time   `json:"timestamp"`
+}
+
+// Emit 将事件异步投递给订阅了它的 webhook
+// 关联仓库的事件投递给该仓库的 webhook 和全局 webhook，其他事件只投递给全局 webhook
+func Emit(p *Payload) {
+	if !db.IsReady() {
+		return
+	}
+	if p.Timestamp.IsZero() {
+		p.Timestamp = time.Now().UTC()
+	}
+
+	var repoID int64
+	if p.Repository != nil {
+		repo, err := db.GetRepositoryByOwnerAndName(p.Repository.Owner, p.Repository.Name)
+		if err != nil {
+			log.Printf("webhook: failed to look up %s: %v", p.Repository.FullName, err)
+			return
+		}
+		if repo != nil {
+			repoID = repo.ID
+		}
+	}
+
+	hooks, err := db.GetActiveWebhooks(repoID)
+	if err != nil {
+		log.Printf("webhook: failed to load webhooks: %v", err)
+		return
+	}
+
+	var body []byte
+	for _, hook := range hooks {
+		if !hook.Subscribes(p.Event) {
+			continue
+		}
+		if body == nil {
+			if body, err = json.Marshal(p); err != nil {
+				log.Printf("webhook: failed to encode %s payload: %v", p.Event, err)
+				return
+			}
+		}
+		if _, err := enqueue(hook, p.Event, body); err != nil {
+			log.Printf("webhook %d: failed to record delivery: %v", hook.ID, err)
+		}
+	}
+}
+
+// Redeliver 以原始内容重新投递一次，生成新的投递记录
+func Redeliver(hook *db.Webhook, d *db.WebhookDelivery) (*db.WebhookDelivery, error) {
+	return enqueue(hook, d.Event, d.Payload)
+}
+
+func enqueue(hook *db.Webhook, event string, body []byte) (*db.WebhookDelivery, error) {
+	guid, err := newGUID()
+	if err != nil {
+		return nil, err
+	}
+	d, err := db.CreateWebhookDelivery(hook.ID, guid, event, body)
+	if err != nil {
+		return nil, err
+	}
+	queued := *d
+	spawn(hook, d, 0)
+	return &queued, nil
+}
+
+// Start 恢复上次退出时未完成的投递：按上次尝试的时间继续退避重试，webhook 已停用的记为 failed
+func Start() {
+	mu.Lock()
+	if ctx.Err() != nil {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	mu.Unlock()
+
+	if !db.IsReady() {
+		return
+	}
+	deliveries, err := db.GetPendingWebhookDeliveries()
+	if err != nil {
+		log.Printf("webhook: failed to load pending deliveries: %v", err)
+		return
+	}
+	for _, d := range deliveries {
+		hook, err := db.GetWebhookByID(d.WebhookID)
+		if err != nil {
+			log.Printf("webhook %d: failed to load webhook: %v", d.WebhookID, err)
+			continue
+		}
+		if hook == nil || !hook.Active {
+			d.Status = db.DeliveryFailed
+			d.Error = "webhook is inactive"
+			if err := db.UpdateWebhookDelivery(d); err != nil {
+				log.Printf("webhook %d: failed to save delivery %d: %v", d.WebhookID, d.ID, err)
+			}
+			continue
+		}
+
+		var delay time.Duration
+		if d.Attempts > 0 && d.DeliveredAt != nil {
+			i := d.Attempts - 1
+			if i >= len(RetryDelays) {
+				i = len(RetryDelays) - 1
+			}
+			delay = time.Until(d.DeliveredAt.Add(RetryDelays[i]))
+		}
+		spawn(hook, d, delay)
+	}
+	if len(deliveries) > 0 {
+		log.Printf("webhook: resumed %d pending deliveries", len(deliveries))
+	}
+}
+
+// Stop 取消进行中的投递并等待其退出，需在关闭数据库之前调用
+func Stop() {
+	mu.Lock()
+	cancel()
+	mu.Unlock()
+	wg.Wait()
+}
+
+// spawn 在 delay 之后开始投递；已 Stop 时不投递，记录保持 pending
+func spawn(hook *db.Webhook, d *db.WebhookDelivery, delay time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	wg.Add(1)
+	go func(ctx context.Context) {
+		defer wg.Done()
+		if sleep(ctx, delay) {
+			deliver(ctx, hook, d)
+		}
+	}(ctx)
+}
+
+// sleep 等待 d，被 Stop 取消时返回 false
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// deliver 投递并按 RetryDelays 退避重试，每次尝试的结果都写回投递记录
+func deliver(ctx context.Context, hook *db.Webhook, d *db.WebhookDelivery) {
+	for {
+		status, body, err := send(ctx, hook, d)
+		if ctx.Err() != nil {
+			return // 被 Stop 中断，不计入尝试次数
+		}
+		d.Attempts++
+		d.Error = ""
+		now := time.Now()
+		d.ResponseStatus, d.ResponseBody, d.DeliveredAt = status, body, &now
+		if err != nil {
+			d.Error = err.Error()
+		}
+
+		ok := err == nil && status >= 200 && status < 300
+		retry := !ok && d.Attempts <= len(RetryDelays)
+		switch {
+		case ok:
+			d.Status = db.DeliverySucceeded
+		case retry:
+			d.Status = db.DeliveryPending
+		default:
+			d.Status = db.DeliveryFailed
+		}
+		if !db.IsReady() {
+			return // 数据库已关闭（进程退出中）
+		}
+		if err := db.UpdateWebhookDelivery(d); err != nil {
+			log.Printf("webhook %d: failed to save delivery %d: %v", hook.ID, d.ID, err)
+		}
+		if !retry {
+			if !ok {
+				log.Printf("webhook %d: delivery %d failed after %d attempts", hook.ID, d.ID, d.Attempts)
+			}
+			return
+		}
+		if !sleep(ctx, RetryDelays[d.Attempts-1]) {
+			return
+		}
+	}
+}
+
+func send(ctx context.Context, hook *db.Webhook, d *db.WebhookDelivery) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PotStack-Hookshot")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, d.GUID)
+	if hook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(hook.Secret, d.Payload))
+	}
+
+	c := client
+	if hook.RepoID != 0 {
+		c = repoClient
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if hook.RepoID != 0 {
+		body = nil // 仓库 webhook 的投递记录对仓库只读用户可见，不保存响应体
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(body), fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// Sign 计算请求体签名："sha256=" + hex(HMAC-SHA256(secret, body))
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newGUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
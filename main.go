@@ -21,6 +21,8 @@ import (
 	"potstack/internal/resource"
 	"potstack/internal/router"
 	"potstack/internal/service"
+	"potstack/internal/sshd"
+	"potstack/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/glebarez/go-sqlite" // 强制注册驱动
@@ -50,12 +52,23 @@ func main() {
 	// 初始化 Services
 	userService := service.NewUserService()
 	repoService := service.NewRepoService()
+	tokenService := service.NewTokenService()
+	webhookService := service.NewWebhookService()
 
 	// 初始化动态路由器
 	dynamicRouter := router.NewRouter(config.RepoDir)
 
 	// 初始化 Keeper（Sandbox 管理器）
 	sandboxManager := keeper.NewManager(config.RepoDir, dynamicRouter)
+	// 空闲停止（idle_timeout）的沙箱收到请求时由 Keeper 启动
+	dynamicRouter.SetActivator(sandboxManager.Activate)
+	sandboxService := service.NewSandboxService(sandboxManager)
+
+	// 推送到默认分支后自动重新部署（git push 即部署）
+	git.RegisterPostReceiveHook(sandboxManager.DeployHook())
+	// 推送事件通知 webhook，继续上次退出时未完成的投递
+	git.RegisterPostReceiveHook(webhook.PushHook)
+	webhook.Start()
 
 	// 创建用于优雅退出的 Context
 	ctx, cancel := context.WithCancel(context.Background())
@@ -64,7 +77,7 @@ func main() {
 	// 启动服务
 	srvErrCh := make(chan error, 1)
 	go func() {
-		if err := runService(ctx, userService, repoService, dynamicRouter); err != nil {
+		if err := runService(ctx, userService, repoService, tokenService, webhookService, sandboxService, dynamicRouter); err != nil {
 			srvErrCh <- err
 		}
 	}()
@@ -89,9 +102,13 @@ func main() {
 		log.Printf("Service error: %v. Shutting down...\n", err)
 	}
 
+	// 先优雅停止沙箱（摘除路由、等待在途请求、SIGTERM），此时代理仍在服务
+	sandboxManager.Shutdown()
+
 	cancel()
 
-	// 关闭数据库
+	// 中断 webhook 投递（未完成的记录下次启动时继续），再关闭数据库
+	webhook.Stop()
 	db.Close()
 
 	// 等待协程清理资源
@@ -147,7 +164,7 @@ func initDatabase() {
 	log.Println("Database initialized")
 }
 
-func runService(ctx context.Context, us service.IUserService, rs service.IRepoService, dynamicRouter *router.Router) error {
+func runService(ctx context.Context, us service.IUserService, rs service.IRepoService, ts service.ITokenService, ws service.IWebhookService, ss service.ISandboxService, dynamicRouter *router.Router) error {
 	// 设置 TLS（业务和管理端口共享）
 	certManager := pothttps.NewManager()
 	tlsConfig, err := certManager.Setup()
@@ -161,16 +178,20 @@ func runService(ctx context.Context, us service.IUserService, rs service.IRepoSe
 		certManager.StartRenewalChecker(1 * time.Minute)
 	}
 
-	// 启动三个端口
-	go runBusinessService(ctx, dynamicRouter, tlsConfig)
-	go runAdminService(ctx, dynamicRouter, tlsConfig)
+	// 管理 API（挂载在管理端口）
+	apiServer := api.NewServer(us, rs, ts, ws, ss)
+
+	// 启动三个端口（以及可选的 SSH 端口）
+	go runBusinessService(ctx, apiServer, dynamicRouter, tlsConfig)
+	go runAdminService(ctx, apiServer, dynamicRouter, tlsConfig)
+	go runSSHService(ctx, rs)
 	runInternalService(ctx, dynamicRouter) // 阻塞
 
 	return nil
 }
 
-// runBusinessService 业务端口 (61080) - /web, /api, /cdn
-func runBusinessService(ctx context.Context, dynamicRouter *router.Router, tlsConfig *tls.Config) {
+// runBusinessService 业务端口 (61080) - /web, /api, /cdn, /repo
+func runBusinessService(ctx context.Context, apiServer *api.Server, dynamicRouter *router.Router, tlsConfig *tls.Config) {
 	r := gin.Default()
 
 	// CDN 静态资源
@@ -186,6 +207,9 @@ func runBusinessService(ctx context.Context, dynamicRouter *router.Router, tlsCo
 		dynamicRouter.ServeHTTP(c.Writer, c.Request)
 	})
 
+	// Git Smart HTTP 协议（Basic Auth + 协作者权限）
+	apiServer.RegisterGitRoutes(r)
+
 	// 健康检查
 	r.GET("/health", api.HealthCheckHandler)
 
@@ -208,13 +232,27 @@ func runBusinessService(ctx context.Context, dynamicRouter *router.Router, tlsCo
 	}
 }
 
-// runAdminService 管理端口 (61081) - /health, /admin
-func runAdminService(ctx context.Context, dynamicRouter *router.Router, tlsConfig *tls.Config) {
+// runAdminService 管理端口 (61081) - /health, /api/v1, /admin, /repo
+func runAdminService(ctx context.Context, apiServer *api.Server, dynamicRouter *router.Router, tlsConfig *tls.Config) {
 	r := gin.Default()
 
 	// 健康检查
 	r.GET("/health", api.HealthCheckHandler)
 
+	// 管理 API：/api/v1/*（系统令牌或个人访问令牌认证）
+	// 个人访问令牌只能通过管理 API 签发，没有系统令牌也没有已签发的令牌时管理 API 无法使用
+	if config.PotStackToken == "" {
+		if n, err := db.CountAccessTokens(); err == nil && n == 0 {
+			log.Println("Warning: POTSTACK_TOKEN is not set and no personal access tokens exist, the management API cannot be used; set POTSTACK_TOKEN to create the first token")
+		} else {
+			log.Println("Warning: POTSTACK_TOKEN is not set, management API only accepts personal access tokens")
+		}
+	}
+	apiServer.RegisterRoutes(r)
+
+	// Git Smart HTTP 协议（Basic Auth + 协作者权限）
+	apiServer.RegisterGitRoutes(r)
+
 	// 动态路由：/admin/{org}/{name}/*
 	r.Any("/admin/:org/:name/*path", func(c *gin.Context) {
 		dynamicRouter.ServeHTTP(c.Writer, c.Request)
@@ -239,6 +277,25 @@ func runAdminService(ctx context.Context, dynamicRouter *router.Router, tlsConfi
 	}
 }
 
+// runSSHService SSH 端口（POTSTACK_SSH_PORT，默认不启用）- Git over SSH（用户 SSH 公钥 + 协作者权限）
+func runSSHService(ctx context.Context, rs service.IRepoService) {
+	if config.SSHPort == "" {
+		log.Println("SSH service disabled (POTSTACK_SSH_PORT is not set)")
+		return
+	}
+
+	hostKey, err := sshd.LoadHostKey(config.SSHHostKey)
+	if err != nil {
+		log.Printf("SSH host key error: %v", err)
+		return
+	}
+
+	log.Printf("SSH listening on :%s", config.SSHPort)
+	if err := sshd.NewServer(rs, hostKey).ListenAndServe(":" + config.SSHPort); err != nil {
+		log.Printf("SSH server error: %v", err)
+	}
+}
+
 // runInternalService 内部端口 (61082) - /pot, /repo, /refresh（HTTP only，无认证）
 func runInternalService(ctx context.Context, dynamicRouter *router.Router) {
 	r := gin.Default()
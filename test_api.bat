@@ -3,7 +3,7 @@ setlocal
 cls
 
 :: --- 配置区 ---
-set "HOST=http://localhost:61080"
+set "HOST=http://localhost:61081"
 set "POTSTACK_TOKEN=MySecretToken"
 set "TEST_USER=testuser-win-batch"
 set "TEST_REPO=testrepo-win-batch"